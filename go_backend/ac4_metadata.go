@@ -113,11 +113,18 @@ func buildITunesUdta(md ac4Metadata, cover []byte) []byte {
 		ilst = append(ilst, itunesCoverTag(cover)...)
 	}
 
-	ilstBox := buildM4AAtom("ilst", ilst)
+	return buildM4AAtom("udta", buildITunesMeta(ilst, 0))
+}
+
+// buildITunesMeta wraps ilst items in a meta>(hdlr+ilst) box, optionally
+// followed by a free atom of the given size for in-place edits later on.
+func buildITunesMeta(ilstItems []byte, padding int) []byte {
 	metaPayload := append([]byte{0, 0, 0, 0}, itunesMetadataHandler()...)
-	metaPayload = append(metaPayload, ilstBox...)
-	meta := buildM4AAtom("meta", metaPayload)
-	return buildM4AAtom("udta", meta)
+	metaPayload = append(metaPayload, buildM4AAtom("ilst", ilstItems)...)
+	if padding >= 8 {
+		metaPayload = append(metaPayload, buildM4AFreeAtom(padding)...)
+	}
+	return buildM4AAtom("meta", metaPayload)
 }
 
 // writeMP4iTunesMetadata replaces (or inserts) a udta>meta>ilst metadata box in
@@ -170,7 +177,7 @@ func WriteAC4MetadataIfApplicable(decryptedPath, metadataJSON, coverPath string)
 	var cover []byte
 	if strings.TrimSpace(coverPath) != "" {
 		if b, err := os.ReadFile(coverPath); err == nil {
-			cover = b
+			cover, _, _ = processCover(coverTargetMP4, "", b)
 		}
 	}
 
@@ -0,0 +1,413 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAlbumConcurrency   = 3
+	maxAlbumConcurrency       = 8
+	albumProgressInterval     = 250 * time.Millisecond
+	albumCoverFileName        = "folder.jpg"
+	albumPlaylistExtension    = ".m3u8"
+	albumTrackItemIDSeparator = ":"
+)
+
+// downloadAlbumTrack and fetchAlbumCover are swapped out by tests.
+var (
+	downloadAlbumTrack = runQueuedDownload
+	fetchAlbumCover    = downloadCoverToMemory
+)
+
+type albumDownloadRequest struct {
+	// Album is the album as returned by any metadata provider, tracks
+	// included.
+	Album ExtAlbumMetadata `json:"album"`
+	// Track holds the settings shared by every track request: service,
+	// output_dir, filename_format, quality, embed flags and so on.
+	Track           DownloadRequest `json:"track"`
+	ItemID          string          `json:"item_id"`
+	Concurrency     int             `json:"concurrency,omitempty"`
+	WriteCover      *bool           `json:"write_cover,omitempty"`
+	WritePlaylist   *bool           `json:"write_playlist,omitempty"`
+	AlbumReplayGain bool            `json:"album_replaygain,omitempty"`
+	Genre           string          `json:"genre,omitempty"`
+	Label           string          `json:"label,omitempty"`
+	Copyright       string          `json:"copyright,omitempty"`
+}
+
+type albumTrackResult struct {
+	ItemID    string `json:"item_id"`
+	Title     string `json:"title"`
+	Success   bool   `json:"success"`
+	FilePath  string `json:"file_path,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorType string `json:"error_type,omitempty"`
+	Skipped   bool   `json:"already_exists,omitempty"`
+}
+
+// albumSharedMetadata is what every track of the album gets in common.
+type albumSharedMetadata struct {
+	AlbumArtist string
+	ReleaseDate string
+	Genre       string
+	Label       string
+	Copyright   string
+	CoverURL    string
+	TotalTracks int
+	TotalDiscs  int
+}
+
+// resolveAlbumSharedMetadata fills the album-level fields from the request,
+// then the album and its tracks, and finally looks up what is still missing
+// by the ISRC of the first track that has one.
+func resolveAlbumSharedMetadata(req *albumDownloadRequest) albumSharedMetadata {
+	album := req.Album
+	shared := albumSharedMetadata{
+		AlbumArtist: firstNonEmptyString(req.Track.AlbumArtist, album.Artists),
+		ReleaseDate: firstNonEmptyString(req.Track.ReleaseDate, album.ReleaseDate),
+		Genre:       firstNonEmptyString(req.Genre, req.Track.Genre),
+		Label:       firstNonEmptyString(req.Label, req.Track.Label),
+		Copyright:   firstNonEmptyString(req.Copyright, req.Track.Copyright),
+		CoverURL:    firstNonEmptyString(req.Track.CoverURL, album.CoverURL),
+		TotalTracks: max(album.TotalTracks, len(album.Tracks)),
+	}
+
+	var isrc string
+	for _, track := range album.Tracks {
+		shared.AlbumArtist = firstNonEmptyString(shared.AlbumArtist, track.AlbumArtist)
+		shared.ReleaseDate = firstNonEmptyString(shared.ReleaseDate, track.ReleaseDate)
+		shared.Genre = firstNonEmptyString(shared.Genre, track.Genre)
+		shared.Label = firstNonEmptyString(shared.Label, track.Label)
+		shared.Copyright = firstNonEmptyString(shared.Copyright, track.Copyright)
+		shared.CoverURL = firstNonEmptyString(shared.CoverURL, track.ResolvedCoverURL())
+		shared.TotalDiscs = max(shared.TotalDiscs, track.DiscNumber, track.TotalDiscs)
+		isrc = firstNonEmptyString(isrc, track.ISRC)
+	}
+	shared.TotalDiscs = max(shared.TotalDiscs, 1)
+
+	if isrc != "" && shared.AlbumArtist == "" {
+		albumArtist, err := fetchMusicBrainzAlbumArtistByISRC(isrc, album.Name)
+		if err != nil {
+			GoLog("[AlbumDownload] Failed to get album artist from MusicBrainz: %v\n", err)
+		}
+		shared.AlbumArtist = strings.TrimSpace(albumArtist)
+	}
+	if isrc != "" && (shared.Genre == "" || shared.Label == "" || shared.Copyright == "") {
+		enrichExtraMetadataByISRC("AlbumDownload", isrc, &shared.Genre, &shared.Label, &shared.Copyright)
+	}
+	return shared
+}
+
+func buildAlbumTrackRequest(req *albumDownloadRequest, shared albumSharedMetadata, index int) DownloadRequest {
+	track := req.Album.Tracks[index]
+	trackReq := req.Track
+	trackReq.ItemID = req.ItemID + albumTrackItemIDSeparator + fmt.Sprint(index+1)
+	trackReq.TrackName = track.Name
+	trackReq.ArtistName = track.Artists
+	trackReq.AlbumName = firstNonEmptyString(req.Album.Name, track.AlbumName)
+	trackReq.AlbumArtist = shared.AlbumArtist
+	trackReq.CoverURL = firstNonEmptyString(shared.CoverURL, track.ResolvedCoverURL())
+	trackReq.ReleaseDate = firstNonEmptyString(shared.ReleaseDate, track.ReleaseDate)
+	trackReq.Genre = shared.Genre
+	trackReq.Label = shared.Label
+	trackReq.Copyright = shared.Copyright
+	trackReq.TotalTracks = shared.TotalTracks
+	trackReq.TotalDiscs = shared.TotalDiscs
+	trackReq.TrackNumber = track.TrackNumber
+	if trackReq.TrackNumber <= 0 {
+		trackReq.TrackNumber = index + 1
+	}
+	trackReq.DiscNumber = max(track.DiscNumber, 1)
+	trackReq.ISRC = track.ISRC
+	trackReq.DurationMS = track.DurationMS
+	trackReq.Composer = firstNonEmptyString(track.Composer, req.Track.Composer)
+	trackReq.SpotifyID = firstNonEmptyString(track.SpotifyID, track.ID)
+	trackReq.TidalID = track.TidalID
+	trackReq.QobuzID = track.QobuzID
+	trackReq.DeezerID = track.DeezerID
+	trackReq.Source = firstNonEmptyString(req.Track.Source, track.ProviderID, req.Album.ProviderID)
+	trackReq.SharedMetadataResolved = true
+	return trackReq
+}
+
+// albumProgress folds the progress of every track into the album item.
+type albumProgress struct {
+	albumID  string
+	trackIDs []string
+
+	mu       sync.Mutex
+	finished []bool
+}
+
+func (p *albumProgress) finish(index int) {
+	p.mu.Lock()
+	p.finished[index] = true
+	p.mu.Unlock()
+	p.report()
+}
+
+func (p *albumProgress) report() {
+	p.mu.Lock()
+	finished := append([]bool(nil), p.finished...)
+	p.mu.Unlock()
+
+	items := snapshotItemProgress(p.trackIDs)
+	var done float64
+	var received int64
+	for i, id := range p.trackIDs {
+		item, ok := items[id]
+		if ok {
+			received += item.BytesReceived
+		}
+		switch {
+		case finished[i]:
+			done++
+		case ok:
+			done += math.Min(math.Max(item.Progress, 0), 1)
+		}
+	}
+	SetItemProgress(p.albumID, done/float64(len(p.trackIDs)), received, 0)
+}
+
+// DownloadAlbumJSON downloads every track of an album as one job. Shared
+// metadata is resolved once and applied to every track, the cover is fetched
+// once, tracks run with bounded parallelism, and folder.jpg plus an .m3u8
+// are written next to the tracks at the end. See albumDownloadRequest for
+// the request fields. The album's item_id gets an aggregate progress entry;
+// track N uses "<item_id>:N". Cancelling the album's item_id cancels all its
+// tracks.
+func DownloadAlbumJSON(requestJSON string) (string, error) {
+	var req albumDownloadRequest
+	if err := json.Unmarshal([]byte(requestJSON), &req); err != nil {
+		return "", fmt.Errorf("failed to parse album request: %w", err)
+	}
+	if len(req.Album.Tracks) == 0 {
+		return "", fmt.Errorf("album has no tracks")
+	}
+	req.ItemID = strings.TrimSpace(req.ItemID)
+	if req.ItemID == "" {
+		req.ItemID = "album" + albumTrackItemIDSeparator + firstNonEmptyString(req.Album.ID, req.Album.Name)
+	}
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultAlbumConcurrency
+	}
+	concurrency = min(concurrency, maxAlbumConcurrency, len(req.Album.Tracks))
+
+	albumID := req.ItemID
+	initDownloadCancel(albumID)
+	defer clearDownloadCancel(albumID)
+	StartItemProgress(albumID)
+
+	shared := resolveAlbumSharedMetadata(&req)
+	GoLog("[AlbumDownload] %s - %s: %d tracks, genre %q\n", shared.AlbumArtist, req.Album.Name, len(req.Album.Tracks), shared.Genre)
+
+	var coverData []byte
+	if shared.CoverURL != "" {
+		data, err := fetchAlbumCover(shared.CoverURL, req.Track.EmbedMaxQualityCover)
+		if err != nil {
+			GoLog("[AlbumDownload] Failed to fetch album cover: %v\n", err)
+		} else {
+			coverData = data
+			defer shareCover(shared.CoverURL, req.Track.EmbedMaxQualityCover, data)()
+		}
+	}
+	if isDownloadCancelled(albumID) {
+		return "", ErrDownloadCancelled
+	}
+
+	requests := make([]DownloadRequest, len(req.Album.Tracks))
+	progress := &albumProgress{albumID: albumID, finished: make([]bool, len(requests))}
+	for i := range requests {
+		requests[i] = buildAlbumTrackRequest(&req, shared, i)
+		progress.trackIDs = append(progress.trackIDs, requests[i].ItemID)
+	}
+	SetItemDownloading(albumID)
+
+	results := make([]albumTrackResult, len(requests))
+	cancelTracks := sync.OnceFunc(func() {
+		for _, trackReq := range requests {
+			cancelDownload(trackReq.ItemID)
+		}
+	})
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				trackReq := requests[i]
+				results[i] = albumTrackResult{ItemID: trackReq.ItemID, Title: trackReq.TrackName}
+				if isDownloadCancelled(albumID) {
+					results[i].Error, results[i].ErrorType = ErrDownloadCancelled.Error(), "cancelled"
+					progress.finish(i)
+					continue
+				}
+				StartItemProgress(trackReq.ItemID)
+				resp := downloadAlbumTrack(trackReq)
+				if resp.Success {
+					CompleteItemProgress(trackReq.ItemID)
+				}
+				results[i].Success = resp.Success
+				results[i].FilePath = resp.FilePath
+				results[i].Skipped = resp.AlreadyExists
+				if !resp.Success {
+					results[i].Error = resp.Error
+					results[i].ErrorType = firstNonEmptyString(resp.ErrorType, classifyDownloadErrorType(resp.Error))
+					GoLog("[AlbumDownload] Track %d (%s) failed: %s\n", i+1, trackReq.TrackName, resp.Error)
+				}
+				progress.finish(i)
+			}
+		}()
+	}
+	finished := make(chan struct{})
+	go func() {
+		for i := range requests {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(finished)
+	}()
+
+	ticker := time.NewTicker(albumProgressInterval)
+wait:
+	for {
+		select {
+		case <-finished:
+			break wait
+		case <-ticker.C:
+			if isDownloadCancelled(albumID) {
+				cancelTracks()
+			}
+			progress.report()
+		}
+	}
+	ticker.Stop()
+	if isDownloadCancelled(albumID) {
+		return "", ErrDownloadCancelled
+	}
+
+	SetItemFinalizing(albumID)
+	var files []string
+	completed := 0
+	for _, result := range results {
+		if result.Success {
+			completed++
+			if result.FilePath != "" {
+				files = append(files, result.FilePath)
+			}
+		}
+	}
+
+	resp := map[string]any{
+		"item_id":      albumID,
+		"album":        req.Album.Name,
+		"album_artist": shared.AlbumArtist,
+		"genre":        shared.Genre,
+		"tracks":       results,
+		"completed":    completed,
+		"failed":       len(results) - completed,
+	}
+
+	albumDir := albumOutputDir(req.Track.OutputDir, files)
+	if albumDir != "" && completed > 0 {
+		if coverData != nil && (req.WriteCover == nil || *req.WriteCover) {
+			coverPath := filepath.Join(albumDir, albumCoverFileName)
+			sidecar, _, _ := processCover(coverTargetSidecar, "", coverData)
+			if err := os.WriteFile(coverPath, sidecar, 0644); err != nil {
+				GoLog("[AlbumDownload] Failed to write %s: %v\n", coverPath, err)
+			} else {
+				resp["cover_path"] = coverPath
+			}
+		}
+		if req.WritePlaylist == nil || *req.WritePlaylist {
+			playlistPath := filepath.Join(albumDir, sanitizeFilename(firstNonEmptyString(req.Album.Name, "album"))+albumPlaylistExtension)
+			if err := writeAlbumPlaylist(playlistPath, requests, results); err != nil {
+				GoLog("[AlbumDownload] Failed to write %s: %v\n", playlistPath, err)
+			} else {
+				resp["playlist_path"] = playlistPath
+			}
+		}
+	}
+
+	// Album gain over a partial album would be wrong for the whole release.
+	if req.AlbumReplayGain && completed == len(results) && len(files) > 0 {
+		gainReq, _ := json.Marshal(replayGainRequest{Files: files, WriteTags: true})
+		gainJSON, err := AnalyzeReplayGainJSON(string(gainReq))
+		if err != nil {
+			GoLog("[AlbumDownload] Album ReplayGain failed: %v\n", err)
+			resp["replaygain_error"] = err.Error()
+		} else {
+			resp["replaygain"] = json.RawMessage(gainJSON)
+		}
+	} else if req.AlbumReplayGain {
+		GoLog("[AlbumDownload] Skipping album ReplayGain: %d of %d tracks downloaded\n", completed, len(results))
+	}
+
+	CompleteItemProgress(albumID)
+	resp["success"] = completed == len(results)
+	GoLog("[AlbumDownload] %s: %d of %d tracks downloaded\n", req.Album.Name, completed, len(results))
+
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// albumOutputDir is the folder the album's tracks ended up in. Tracks
+// written through a content URI or file descriptor have no usable folder,
+// and neither do albums split across several folders by the filename
+// format; those fall back to the output directory.
+func albumOutputDir(outputDir string, files []string) string {
+	dir := ""
+	for _, file := range files {
+		if !filepath.IsAbs(file) {
+			dir = ""
+			break
+		}
+		if fileDir := filepath.Dir(file); dir == "" {
+			dir = fileDir
+		} else if fileDir != dir {
+			dir = ""
+			break
+		}
+	}
+	if dir == "" && filepath.IsAbs(outputDir) {
+		dir = outputDir
+	}
+	return dir
+}
+
+// writeAlbumPlaylist writes an extended M3U in track order, with paths
+// relative to the playlist when the tracks are below it.
+func writeAlbumPlaylist(playlistPath string, requests []DownloadRequest, results []albumTrackResult) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	base := filepath.Dir(playlistPath)
+	for i, result := range results {
+		if !result.Success || result.FilePath == "" {
+			continue
+		}
+		entry := result.FilePath
+		if rel, err := filepath.Rel(base, entry); err == nil && !strings.HasPrefix(rel, "..") {
+			entry = filepath.ToSlash(rel)
+		}
+		seconds := -1
+		if requests[i].DurationMS > 0 {
+			seconds = (requests[i].DurationMS + 500) / 1000
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s - %s\n%s\n", seconds, requests[i].ArtistName, requests[i].TrackName, entry)
+	}
+	return os.WriteFile(playlistPath, []byte(b.String()), 0644)
+}
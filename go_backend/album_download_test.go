@@ -0,0 +1,164 @@
+package gobackend
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDownloadAlbumJSON(t *testing.T) {
+	origDownload, origCover := downloadAlbumTrack, fetchAlbumCover
+	origDeezerFetcher := fetchDeezerExtendedMetadataByISRC
+	origMusicBrainzAlbumArtistFetcher := fetchMusicBrainzAlbumArtistByISRC
+	defer func() {
+		downloadAlbumTrack, fetchAlbumCover = origDownload, origCover
+		fetchDeezerExtendedMetadataByISRC = origDeezerFetcher
+		fetchMusicBrainzAlbumArtistByISRC = origMusicBrainzAlbumArtistFetcher
+	}()
+
+	outputDir := t.TempDir()
+	coverData := []byte("\xff\xd8\xff\xe0cover")
+	var mu sync.Mutex
+	var coverFetches, enrichments, running, maxRunning int
+	var requests []DownloadRequest
+
+	fetchAlbumCover = func(coverURL string, maxQuality bool) ([]byte, error) {
+		mu.Lock()
+		coverFetches++
+		mu.Unlock()
+		return coverData, nil
+	}
+	fetchDeezerExtendedMetadataByISRC = func(ctx context.Context, isrc string) (*AlbumExtendedMetadata, error) {
+		mu.Lock()
+		enrichments++
+		mu.Unlock()
+		return &AlbumExtendedMetadata{Genre: "Shoegaze", Label: "Creation", Copyright: "(P) 1991"}, nil
+	}
+	fetchMusicBrainzAlbumArtistByISRC = func(isrc, albumName string) (string, error) {
+		t.Fatal("album artist is known and should not be looked up")
+		return "", nil
+	}
+	downloadAlbumTrack = func(req DownloadRequest) DownloadResponse {
+		mu.Lock()
+		requests = append(requests, req)
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		// Embedding the cover gets the album cover without a request.
+		if data, err := downloadCoverToMemory(req.CoverURL, req.EmbedMaxQualityCover); err != nil || string(data) != string(coverData) {
+			t.Errorf("track cover = %q, %v", data, err)
+		}
+		if req.TrackNumber == 3 {
+			return DownloadResponse{Error: "track not found", ErrorType: "not_found"}
+		}
+		path := filepath.Join(outputDir, fmt.Sprintf("%02d %s.flac", req.TrackNumber, req.TrackName))
+		os.WriteFile(path, []byte("fLaC"), 0644)
+		return DownloadResponse{Success: true, FilePath: path}
+	}
+
+	album := ExtAlbumMetadata{
+		ID:          "alb1",
+		Name:        "Loveless",
+		Artists:     "My Bloody Valentine",
+		CoverURL:    "https://covers.example.com/loveless.jpg",
+		ReleaseDate: "1991-11-04",
+		ProviderID:  "meta-ext",
+	}
+	for i, name := range []string{"Only Shallow", "Loomer", "Touched", "To Here Knows When"} {
+		album.Tracks = append(album.Tracks, ExtTrackMetadata{
+			ID:          fmt.Sprintf("t%d", i+1),
+			Name:        name,
+			Artists:     "My Bloody Valentine",
+			TrackNumber: i + 1,
+			DurationMS:  200000 + i*1000,
+			ISRC:        fmt.Sprintf("GBAAA910000%d", i+1),
+		})
+	}
+	reqJSON, _ := json.Marshal(map[string]any{
+		"album":       album,
+		"item_id":     "album-test",
+		"concurrency": 2,
+		// Track 3 fails, so album gain must not be computed.
+		"album_replaygain": true,
+		"track": DownloadRequest{
+			Service:              "tidal",
+			OutputDir:            outputDir,
+			EmbedMaxQualityCover: true,
+			UseExtensions:        true,
+		},
+	})
+	defer func() {
+		RemoveItemProgress("album-test")
+		for i := 1; i <= 4; i++ {
+			RemoveItemProgress(fmt.Sprintf("album-test:%d", i))
+		}
+	}()
+
+	respJSON, err := DownloadAlbumJSON(string(reqJSON))
+	if err != nil {
+		t.Fatalf("DownloadAlbumJSON: %v", err)
+	}
+	var resp struct {
+		Success      bool               `json:"success"`
+		Completed    int                `json:"completed"`
+		Failed       int                `json:"failed"`
+		Tracks       []albumTrackResult `json:"tracks"`
+		CoverPath    string             `json:"cover_path"`
+		PlaylistPath string             `json:"playlist_path"`
+		ReplayGain   json.RawMessage    `json:"replaygain"`
+		GainError    string             `json:"replaygain_error"`
+	}
+	if err := json.Unmarshal([]byte(respJSON), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Success || resp.Completed != 3 || resp.Failed != 1 || resp.Tracks[2].ErrorType != "not_found" || resp.Tracks[3].ItemID != "album-test:4" {
+		t.Fatalf("response = %s", respJSON)
+	}
+	if resp.ReplayGain != nil || resp.GainError != "" {
+		t.Fatalf("album gain ran on a partial album: %s", respJSON)
+	}
+
+	if coverFetches != 1 || enrichments != 1 || maxRunning > 2 || len(requests) != 4 {
+		t.Fatalf("cover fetches %d, enrichments %d, max running %d, requests %d", coverFetches, enrichments, maxRunning, len(requests))
+	}
+	for _, req := range requests {
+		if req.AlbumArtist != "My Bloody Valentine" || req.Genre != "Shoegaze" || req.Label != "Creation" ||
+			req.AlbumName != "Loveless" || req.TotalTracks != 4 || req.ReleaseDate != "1991-11-04" ||
+			req.Source != "meta-ext" || req.Service != "tidal" || req.CoverURL != album.CoverURL ||
+			!req.SharedMetadataResolved {
+			t.Fatalf("track request = %+v", req)
+		}
+	}
+
+	if data, _ := os.ReadFile(resp.CoverPath); resp.CoverPath != filepath.Join(outputDir, "folder.jpg") || string(data) != string(coverData) {
+		t.Fatalf("cover written to %q", resp.CoverPath)
+	}
+	playlist, _ := os.ReadFile(resp.PlaylistPath)
+	want := "#EXTM3U\n" +
+		"#EXTINF:200,My Bloody Valentine - Only Shallow\n01 Only Shallow.flac\n" +
+		"#EXTINF:201,My Bloody Valentine - Loomer\n02 Loomer.flac\n" +
+		"#EXTINF:203,My Bloody Valentine - To Here Knows When\n04 To Here Knows When.flac\n"
+	if !strings.HasSuffix(resp.PlaylistPath, "Loveless.m3u8") || string(playlist) != want {
+		t.Fatalf("playlist %s:\n%s", resp.PlaylistPath, playlist)
+	}
+
+	var progress ItemProgress
+	json.Unmarshal([]byte(GetItemProgress("album-test")), &progress)
+	if progress.Status != itemProgressStatusCompleted {
+		t.Fatalf("album progress = %+v", progress)
+	}
+	if lookupSharedCover(album.CoverURL, true) != nil {
+		t.Fatal("shared cover outlived the album download")
+	}
+}
@@ -2,6 +2,7 @@ package gobackend
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -21,6 +22,9 @@ const (
 	apeItemFlagLink   = 2 << 1 // 10: external link
 )
 
+// errNoAPETag is returned when a file carries no APEv2 tag at all.
+var errNoAPETag = errors.New("no APEv2 tag found")
+
 // APETagItem represents a single key-value item in an APEv2 tag.
 type APETagItem struct {
 	Key   string
@@ -53,7 +57,7 @@ func ReadAPETags(filePath string) (*APETag, error) {
 	fileSize := fi.Size()
 
 	if fileSize < apeTagHeaderSize {
-		return nil, fmt.Errorf("file too small for APE tag")
+		return nil, fmt.Errorf("file too small for APE tag: %w", errNoAPETag)
 	}
 
 	// The footer is the last 32 bytes before any ID3v1 tag (128 bytes).
@@ -70,7 +74,7 @@ func ReadAPETags(filePath string) (*APETag, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("no APEv2 tag found")
+	return nil, errNoAPETag
 }
 
 func readAPETagAtOffset(f *os.File, fileSize, footerOffset int64) (*APETag, error) {
@@ -532,7 +536,7 @@ func MergeAPEItems(existing, newItems []APETagItem, overrideKeys map[string]stru
 // This is useful for reading APE tags from files opened via SAF or other abstractions.
 func ReadAPETagsFromReader(r io.ReaderAt, fileSize int64) (*APETag, error) {
 	if fileSize < apeTagHeaderSize {
-		return nil, fmt.Errorf("file too small for APE tag")
+		return nil, fmt.Errorf("file too small for APE tag: %w", errNoAPETag)
 	}
 
 	footer := make([]byte, apeTagHeaderSize)
@@ -560,7 +564,7 @@ func ReadAPETagsFromReader(r io.ReaderAt, fileSize int64) (*APETag, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("no APEv2 tag found")
+	return nil, errNoAPETag
 }
 
 func parseAPETagFromFooter(r io.ReaderAt, fileSize, footerOffset int64, footer []byte) (*APETag, error) {
@@ -0,0 +1,262 @@
+package gobackend
+
+// Heuristic "fake lossless" detection for decodable lossless files.
+//
+// Three things are checked on the first authenticityMaxSeconds of audio:
+//   - low-order bits that are zero in every sample (24-bit padded 16-bit),
+//   - a brick-wall spectral cutoff well below Nyquist, which lossy encoders
+//     leave behind (typically 16-20 kHz),
+//   - a cutoff just below the Nyquist frequency of a lower standard rate,
+//     which is what upsampling from 44.1/48 kHz looks like.
+//
+// The spectral checks are heuristics: genuinely band-limited recordings can
+// be flagged as well, so results are reported rather than acted on here.
+
+import (
+	"io"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	authenticityMaxSeconds = 30
+	authenticityFFTSize    = 4096
+	authenticityBands      = 256
+	// A band counts as content when it is this far above the noise floor.
+	authenticityContentDb = 20.0
+	// How much louder than the content threshold the audio just below a
+	// cutoff must be for it to count as a brick wall.
+	authenticityCutoffDropDb = 20.0
+	// A cutoff at or above this fraction of Nyquist is treated as full band.
+	authenticityFullBandRatio = 0.93
+)
+
+var authenticityStandardRates = []int{44100, 48000, 88200, 96000, 176400, 192000}
+
+// libraryScanAuthenticity toggles authenticity analysis during library
+// scans. It decodes and analyses audio for every lossless file, so it stays
+// off until the app opts in.
+var libraryScanAuthenticity atomic.Bool
+
+// SetLibraryScanAuthenticityEnabled turns fake-lossless analysis of FLAC,
+// WAV and AIFF files during library scans on or off (off by default).
+func SetLibraryScanAuthenticityEnabled(enabled bool) {
+	libraryScanAuthenticity.Store(enabled)
+}
+
+// AudioAuthenticity is the outcome of the fake-lossless analysis.
+type AudioAuthenticity struct {
+	DeclaredBitDepth    int  `json:"declared_bit_depth,omitempty"`
+	EffectiveBitDepth   int  `json:"effective_bit_depth,omitempty"`
+	DeclaredSampleRate  int  `json:"declared_sample_rate"`
+	EffectiveSampleRate int  `json:"effective_sample_rate,omitempty"` // source rate when upsampled
+	CutoffHz            int  `json:"cutoff_hz,omitempty"`             // 0 when the spectrum is full band
+	PaddedBitDepth      bool `json:"padded_bit_depth,omitempty"`
+	Upsampled           bool `json:"upsampled,omitempty"`
+	LossySource         bool `json:"lossy_source,omitempty"`
+	Suspicious          bool `json:"suspicious"`
+	// Inconclusive is set when the audio was too short or too quiet for the
+	// spectral checks.
+	Inconclusive bool `json:"inconclusive,omitempty"`
+}
+
+// canAnalyzeAuthenticity reports whether openPCMSource can decode files with
+// this extension.
+func canAnalyzeAuthenticity(ext string) bool {
+	switch strings.ToLower(ext) {
+	case ".flac", ".wav", ".aiff", ".aif", ".aifc":
+		return true
+	}
+	return false
+}
+
+// applyLibraryScanAuthenticity attaches the analysis to a library scan result
+// when it is enabled. Failures leave the result untouched.
+func applyLibraryScanAuthenticity(filePath, ext string, result *LibraryScanResult) {
+	if !libraryScanAuthenticity.Load() {
+		return
+	}
+	if authenticity, err := analyzeAudioAuthenticity(filePath, ext); err == nil {
+		result.Authenticity = authenticity
+	}
+}
+
+// AnalyzeAudioAuthenticity decodes the start of a FLAC, WAV or AIFF file and
+// checks it for padded bit depth, a lossy-source cutoff and upsampling.
+func AnalyzeAudioAuthenticity(filePath string) (*AudioAuthenticity, error) {
+	return analyzeAudioAuthenticity(filePath, filepath.Ext(filePath))
+}
+
+func analyzeAudioAuthenticity(filePath, ext string) (*AudioAuthenticity, error) {
+	src, err := openPCMSourceAs(filePath, ext)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	sampleRate := src.SampleRate()
+	bitDepth := src.BitsPerSample()
+	result := &AudioAuthenticity{DeclaredBitDepth: bitDepth, DeclaredSampleRate: sampleRate}
+
+	intScale := 0.0
+	if bitDepth > 0 {
+		intScale = float64(int64(1) << (bitDepth - 1))
+	}
+	var usedBits uint64
+
+	window := make([]float64, authenticityFFTSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(authenticityFFTSize-1))
+	}
+	power := make([]float64, authenticityFFTSize/2)
+	frame := make([]complex128, authenticityFFTSize)
+	fill, windows := 0, 0
+	remaining := authenticityMaxSeconds * sampleRate
+
+	for remaining > 0 {
+		block, err := src.ReadBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		frames := min(len(block[0]), remaining)
+		remaining -= frames
+		for i := 0; i < frames; i++ {
+			var mono float64
+			for _, ch := range block {
+				mono += ch[i]
+				if intScale > 0 {
+					usedBits |= uint64(int64(math.Round(ch[i] * intScale)))
+				}
+			}
+			frame[fill] = complex(mono/float64(len(block))*window[fill], 0)
+			fill++
+			if fill == authenticityFFTSize {
+				fftInPlace(frame)
+				for k := range power {
+					power[k] += real(frame[k])*real(frame[k]) + imag(frame[k])*imag(frame[k])
+				}
+				fill = 0
+				windows++
+			}
+		}
+	}
+
+	if bitDepth > 0 && usedBits != 0 {
+		result.EffectiveBitDepth = bitDepth - bits.TrailingZeros64(usedBits)
+		result.PaddedBitDepth = result.EffectiveBitDepth < bitDepth
+	}
+
+	cutoff, ok := spectralCutoffHz(power, windows, sampleRate)
+	if !ok {
+		result.Inconclusive = true
+	} else if cutoff > 0 {
+		result.CutoffHz = cutoff
+		classifySpectralCutoff(result, cutoff)
+	}
+
+	result.Suspicious = result.PaddedBitDepth || result.Upsampled || result.LossySource
+	return result, nil
+}
+
+// classifySpectralCutoff decides whether a brick-wall cutoff comes from
+// upsampling (it sits at a lower standard rate's Nyquist) or a lossy source.
+func classifySpectralCutoff(result *AudioAuthenticity, cutoff int) {
+	for _, rate := range authenticityStandardRates {
+		if rate >= result.DeclaredSampleRate {
+			break
+		}
+		if float64(cutoff) <= float64(rate)/2*1.02 {
+			result.Upsampled = true
+			result.EffectiveSampleRate = rate
+			result.LossySource = float64(cutoff) < float64(rate)/2*authenticityFullBandRatio
+			return
+		}
+	}
+	result.LossySource = true
+}
+
+// spectralCutoffHz finds a brick-wall cutoff in an accumulated power
+// spectrum. It returns 0 for a full-band spectrum and ok=false when there is
+// too little signal to decide.
+func spectralCutoffHz(power []float64, windows, sampleRate int) (int, bool) {
+	if windows < 8 {
+		return 0, false
+	}
+	binsPerBand := len(power) / authenticityBands
+	bandHz := float64(sampleRate) / 2 / authenticityBands
+	levels := make([]float64, authenticityBands)
+	for b := range levels {
+		var sum float64
+		for _, p := range power[b*binsPerBand : (b+1)*binsPerBand] {
+			sum += p
+		}
+		levels[b] = 10 * math.Log10(sum/float64(binsPerBand*windows)+1e-30)
+	}
+
+	// The noise floor is taken from the quietest bands above 1 kHz.
+	first := int(1000/bandHz) + 1
+	sorted := append([]float64(nil), levels[first:]...)
+	sort.Float64s(sorted)
+	floor := sorted[len(sorted)/20]
+
+	top := -1
+	for b := authenticityBands - 1; b >= first; b-- {
+		if levels[b] > floor+authenticityContentDb {
+			top = b
+			break
+		}
+	}
+	if top < 0 {
+		return 0, false
+	}
+	cutoff := float64(top+1) * bandHz
+	if cutoff >= float64(sampleRate)/2*authenticityFullBandRatio {
+		return 0, true
+	}
+
+	// Require a steep drop rather than a gentle natural roll-off: the audio
+	// 1-2 kHz below the cutoff must still be well above the floor.
+	from, to := top-int(2000/bandHz), top-int(1000/bandHz)
+	if from < first {
+		return 0, true
+	}
+	var below float64
+	for _, level := range levels[from:to] {
+		below += level
+	}
+	if below/float64(to-from) < floor+authenticityContentDb+authenticityCutoffDropDb {
+		return 0, true
+	}
+	return int(math.Round(cutoff)), true
+}
+
+// fftInPlace is an iterative radix-2 FFT; len(x) must be a power of two.
+func fftInPlace(x []complex128) {
+	n := len(x)
+	shift := 64 - uint(bits.Len(uint(n))-1)
+	for i := 0; i < n; i++ {
+		j := int(bits.Reverse64(uint64(i)) >> shift)
+		if j > i {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], w*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+				w *= step
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package gobackend
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBandLimitedSignal sums random sines up to maxHz and quantises them to
+// bits with TPDF dither, which leaves a flat noise floor above the content.
+func testBandLimitedSignal(seconds float64, sampleRate, maxHz, bits int) []int32 {
+	rng := rand.New(rand.NewSource(1))
+	type partial struct{ freq, phase float64 }
+	partials := make([]partial, 60)
+	for i := range partials {
+		partials[i] = partial{100 + rng.Float64()*float64(maxHz-100), rng.Float64() * 2 * math.Pi}
+	}
+	scale := float64(int64(1)<<(bits-1)) * 0.5 / float64(len(partials)) * 4
+	out := make([]int32, int(seconds*float64(sampleRate)))
+	for i := range out {
+		var v float64
+		t := float64(i) / float64(sampleRate)
+		for _, p := range partials {
+			v += math.Sin(2*math.Pi*p.freq*t + p.phase)
+		}
+		out[i] = int32(math.Round(v*scale + rng.Float64() - rng.Float64()))
+	}
+	return out
+}
+
+func buildTestPCMWAV24(sampleRate int, samples []int32) []byte {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], wavFormatPCM)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], 1)
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], uint32(sampleRate*3))
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], 3)
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], 24)
+	data := make([]byte, 0, len(samples)*3)
+	for _, s := range samples {
+		data = append(data, byte(s), byte(s>>8), byte(s>>16))
+	}
+	body := append([]byte("WAVE"), buildTestRIFFChunk("fmt ", fmtChunk, false)...)
+	body = append(body, buildTestRIFFChunk("data", data, false)...)
+	return buildTestRIFFChunk("RIFF", body, false)
+}
+
+func TestAnalyzeAudioAuthenticity(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	fullBand := testBandLimitedSignal(3, 44100, 21900, 16)
+	lossy := testBandLimitedSignal(3, 44100, 16000, 16)
+	upsampled := testBandLimitedSignal(3, 96000, 20000, 24)
+	padded := make([]int32, len(fullBand))
+	for i, v := range fullBand {
+		padded[i] = v << 8
+	}
+
+	cases := []struct {
+		name         string
+		path         string
+		check        func(a *AudioAuthenticity) bool
+		wantSuspects bool
+	}{
+		{"full band", write("full.wav", buildTestPCMWAV(44100, [][]int32{fullBand})), func(a *AudioAuthenticity) bool {
+			return a.CutoffHz == 0 && a.EffectiveBitDepth == 16
+		}, false},
+		{"lossy", write("lossy.wav", buildTestPCMWAV(44100, [][]int32{lossy, lossy})), func(a *AudioAuthenticity) bool {
+			return a.LossySource && !a.Upsampled && a.CutoffHz > 15500 && a.CutoffHz < 16600
+		}, true},
+		{"upsampled", write("hires.wav", buildTestPCMWAV24(96000, upsampled)), func(a *AudioAuthenticity) bool {
+			return a.Upsampled && !a.LossySource && a.EffectiveSampleRate == 44100 && a.EffectiveBitDepth == 24
+		}, true},
+		{"padded", write("padded.wav", buildTestPCMWAV24(44100, padded)), func(a *AudioAuthenticity) bool {
+			return a.PaddedBitDepth && a.DeclaredBitDepth == 24 && a.EffectiveBitDepth == 16 && !a.LossySource
+		}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := AnalyzeAudioAuthenticity(tc.path)
+			if err != nil {
+				t.Fatalf("AnalyzeAudioAuthenticity: %v", err)
+			}
+			if !tc.check(a) || a.Suspicious != tc.wantSuspects || a.Inconclusive {
+				t.Fatalf("authenticity = %+v", a)
+			}
+		})
+	}
+
+	if scan, _ := scanAudioFileWithKnownModTime(filepath.Join(dir, "lossy.wav"), "", 0); scan.Authenticity != nil {
+		t.Fatal("analysis ran without opting in")
+	}
+	SetLibraryScanAuthenticityEnabled(true)
+	defer SetLibraryScanAuthenticityEnabled(false)
+	scan, err := scanAudioFileWithKnownModTime(filepath.Join(dir, "lossy.wav"), "", 0)
+	if err != nil || scan.Authenticity == nil || !scan.Authenticity.LossySource {
+		t.Fatalf("library scan = %+v/%v", scan, err)
+	}
+}
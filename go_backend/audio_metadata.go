@@ -143,6 +143,13 @@ func readID3v2(file *os.File) (*AudioMetadata, error) {
 }
 
 func parseID3v22Frames(data []byte, metadata *AudioMetadata, tagUnsync bool) {
+	var syncedLyrics string
+	defer func() {
+		if metadata.Lyrics == "" {
+			metadata.Lyrics = syncedLyrics
+		}
+	}()
+
 	pos := 0
 	for pos+6 < len(data) {
 		frameID := string(data[pos : pos+3])
@@ -188,6 +195,10 @@ func parseID3v22Frames(data []byte, metadata *AudioMetadata, tagUnsync bool) {
 			if v := extractLyricsFrame(frameData); v != "" && metadata.Lyrics == "" {
 				metadata.Lyrics = v
 			}
+		case "SLT":
+			if syncedLyrics == "" {
+				syncedLyrics = extractSyncedLyricsFrame(frameData)
+			}
 		case "TXX":
 			desc, userValue := extractUserTextFrame(frameData)
 			if isLyricsDescription(desc) && userValue != "" && metadata.Lyrics == "" {
@@ -200,6 +211,15 @@ func parseID3v22Frames(data []byte, metadata *AudioMetadata, tagUnsync bool) {
 }
 
 func parseID3v23Frames(data []byte, metadata *AudioMetadata, version byte, tagUnsync bool) {
+	// SYLT is only a fallback for files without USLT (or a lyrics TXXX), so
+	// it is applied once every frame has been seen.
+	var syncedLyrics string
+	defer func() {
+		if metadata.Lyrics == "" {
+			metadata.Lyrics = syncedLyrics
+		}
+	}()
+
 	pos := 0
 	for pos+10 < len(data) {
 		frameID := string(data[pos : pos+4])
@@ -313,6 +333,10 @@ func parseID3v23Frames(data []byte, metadata *AudioMetadata, version byte, tagUn
 			if v := extractLyricsFrame(frameData); v != "" && metadata.Lyrics == "" {
 				metadata.Lyrics = v
 			}
+		case "SYLT":
+			if syncedLyrics == "" {
+				syncedLyrics = extractSyncedLyricsFrame(frameData)
+			}
 		case "TXXX":
 			desc, userValue := extractUserTextFrame(frameData)
 			if isLyricsDescription(desc) && userValue != "" && metadata.Lyrics == "" {
@@ -461,6 +485,39 @@ func extractLyricsFrame(data []byte) string {
 	return extractTextFrame(framed)
 }
 
+// extractSyncedLyricsFrame converts a SYLT frame with millisecond timestamps
+// into LRC text. Frames timed in MPEG frames or holding something other than
+// lyrics/transcription content yield "".
+func extractSyncedLyricsFrame(data []byte) string {
+	if len(data) < 6 {
+		return ""
+	}
+	encoding := data[0]
+	timestampFormat := data[4]
+	contentType := data[5]
+	if timestampFormat != 2 || (contentType != 1 && contentType != 2) {
+		return ""
+	}
+	_, rest, ok := splitID3Terminated(encoding, data[6:])
+	if !ok {
+		return ""
+	}
+
+	var lines []string
+	for len(rest) > 0 {
+		text, tail, ok := splitID3Terminated(encoding, rest)
+		if !ok || len(tail) < 4 {
+			break
+		}
+		ms := int64(binary.BigEndian.Uint32(tail[:4]))
+		rest = tail[4:]
+		if line := decodeID3String(encoding, text); line != "" {
+			lines = append(lines, msToLRCTimestamp(ms)+line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 func extractUserTextFrame(data []byte) (string, string) {
 	if len(data) < 2 {
 		return "", ""
@@ -989,6 +1046,7 @@ func parseVorbisComments(data []byte, metadata *AudioMetadata) {
 	reader := bytes.NewReader(data)
 	artistValues := make([]string, 0, 1)
 	albumArtistValues := make([]string, 0, 1)
+	var r128TrackGain, r128AlbumGain string
 
 	var vendorLen uint32
 	if err := binary.Read(reader, binary.LittleEndian, &vendorLen); err != nil {
@@ -1078,9 +1136,21 @@ func parseVorbisComments(data []byte, metadata *AudioMetadata) {
 			metadata.ReplayGainAlbumGain = value
 		case "REPLAYGAIN_ALBUM_PEAK":
 			metadata.ReplayGainAlbumPeak = value
+		case "R128_TRACK_GAIN":
+			r128TrackGain = value
+		case "R128_ALBUM_GAIN":
+			r128AlbumGain = value
 		}
 	}
 
+	// Opus files may only carry R128 gains; expose them as ReplayGain values.
+	if metadata.ReplayGainTrackGain == "" && r128TrackGain != "" {
+		metadata.ReplayGainTrackGain = r128ToReplayGain(r128TrackGain)
+	}
+	if metadata.ReplayGainAlbumGain == "" && r128AlbumGain != "" {
+		metadata.ReplayGainAlbumGain = r128ToReplayGain(r128AlbumGain)
+	}
+
 	if len(artistValues) > 0 {
 		metadata.Artist = joinVorbisCommentValues(artistValues)
 	}
@@ -1252,70 +1322,13 @@ var id3v1Genres = []string{
 }
 
 func extractMP3CoverArt(filePath string) ([]byte, string, error) {
-	file, err := os.Open(filePath)
+	pictures, err := readMP3Pictures(filePath)
 	if err != nil {
 		return nil, "", err
 	}
-	defer file.Close()
-
-	header := make([]byte, 10)
-	if _, err := io.ReadFull(file, header); err != nil {
-		return nil, "", err
-	}
-
-	if string(header[0:3]) != "ID3" {
-		return nil, "", fmt.Errorf("no ID3v2 header")
-	}
-
-	majorVersion := header[3]
-	size := int(header[6])<<21 | int(header[7])<<14 | int(header[8])<<7 | int(header[9])
-
-	tagData := make([]byte, size)
-	if _, err := io.ReadFull(file, tagData); err != nil {
-		return nil, "", err
-	}
-
-	pos := 0
-	var frameIDLen, headerLen int
-	if majorVersion == 2 {
-		frameIDLen = 3
-		headerLen = 6
-	} else {
-		frameIDLen = 4
-		headerLen = 10
-	}
-
-	for pos+headerLen < len(tagData) {
-		frameID := string(tagData[pos : pos+frameIDLen])
-		if frameID[0] == 0 {
-			break
-		}
-
-		var frameSize int
-		switch majorVersion {
-		case 2:
-			frameSize = int(tagData[pos+3])<<16 | int(tagData[pos+4])<<8 | int(tagData[pos+5])
-		case 4:
-			frameSize = int(tagData[pos+4])<<21 | int(tagData[pos+5])<<14 | int(tagData[pos+6])<<7 | int(tagData[pos+7])
-		default:
-			frameSize = int(tagData[pos+4])<<24 | int(tagData[pos+5])<<16 | int(tagData[pos+6])<<8 | int(tagData[pos+7])
-		}
-
-		if frameSize <= 0 || pos+headerLen+frameSize > len(tagData) {
-			break
-		}
-
-		if (frameIDLen == 4 && frameID == "APIC") || (frameIDLen == 3 && frameID == "PIC") {
-			frameData := tagData[pos+headerLen : pos+headerLen+frameSize]
-			imageData, mimeType := parseAPICFrame(frameData, majorVersion)
-			if len(imageData) > 0 {
-				return imageData, mimeType, nil
-			}
-		}
-
-		pos += headerLen + frameSize
+	if pic, ok := selectCoverPicture(pictures); ok {
+		return pic.Data, pic.MIME, nil
 	}
-
 	return nil, "", fmt.Errorf("no cover art found")
 }
 
@@ -1444,6 +1457,8 @@ func extractPictureFromVorbisComments(data []byte) ([]byte, string) {
 		return nil, ""
 	}
 
+	// Prefer the front cover; booklet pages and back covers can come first.
+	var first *EmbeddedPicture
 	for i := uint32(0); i < commentCount && i < 100; i++ {
 		var commentLen uint32
 		if err := binary.Read(reader, binary.LittleEndian, &commentLen); err != nil {
@@ -1468,13 +1483,20 @@ func extractPictureFromVorbisComments(data []byte) ([]byte, string) {
 			}
 			decoded = decoded[:n]
 
-			imageData, mimeType := parseFLACPictureBlock(decoded)
-			if len(imageData) > 0 {
-				return imageData, mimeType
+			if pic, ok := parseFLACPicture(decoded); ok {
+				if pic.Type == pictureTypeFrontCover {
+					return pic.Data, pic.MIME
+				}
+				if first == nil {
+					first = &pic
+				}
 			}
 		}
 	}
 
+	if first != nil {
+		return first.Data, first.MIME
+	}
 	return nil, ""
 }
 
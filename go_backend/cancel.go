@@ -24,6 +24,10 @@ var (
 	cancelMu  sync.Mutex
 	cancelMap = make(map[string]*cancelEntry)
 
+	// pauseMap holds a channel per paused item that is closed on resume.
+	pauseMu  sync.Mutex
+	pauseMap = make(map[string]chan struct{})
+
 	extensionRequestCancelMu  sync.Mutex
 	extensionRequestCancelMap = make(map[string]*cancelEntry)
 )
@@ -76,6 +80,7 @@ func cancelDownload(itemID string) {
 	}
 	cancelMu.Unlock()
 
+	releaseDownloadPause(itemID)
 	RemoveItemProgress(itemID)
 }
 
@@ -97,13 +102,101 @@ func clearDownloadCancel(itemID string) {
 	}
 
 	cancelMu.Lock()
+	finished := false
 	if entry, ok := cancelMap[itemID]; ok {
 		entry.refs--
 		if entry.refs <= 0 {
 			delete(cancelMap, itemID)
+			finished = true
 		}
 	}
 	cancelMu.Unlock()
+
+	if finished {
+		releaseDownloadPause(itemID)
+	}
+}
+
+// resetDownloadCancel forgets a cancellation of an item that is not running
+// any more, so it can be downloaded again.
+func resetDownloadCancel(itemID string) {
+	if itemID == "" {
+		return
+	}
+
+	cancelMu.Lock()
+	if entry, ok := cancelMap[itemID]; ok && entry.refs <= 0 {
+		delete(cancelMap, itemID)
+	}
+	cancelMu.Unlock()
+}
+
+// pauseDownload suspends the transfer of itemID at its next write. The
+// connection and partial file are kept, so resumeDownload continues where
+// it stopped without resolving the track again.
+func pauseDownload(itemID string) {
+	if itemID == "" || isDownloadCancelled(itemID) {
+		return
+	}
+
+	pauseMu.Lock()
+	if _, ok := pauseMap[itemID]; !ok {
+		pauseMap[itemID] = make(chan struct{})
+	}
+	pauseMu.Unlock()
+
+	SetItemPaused(itemID)
+}
+
+func resumeDownload(itemID string) {
+	if releaseDownloadPause(itemID) {
+		ResumeItemProgress(itemID)
+	}
+}
+
+func releaseDownloadPause(itemID string) bool {
+	pauseMu.Lock()
+	defer pauseMu.Unlock()
+
+	resumed, ok := pauseMap[itemID]
+	if ok {
+		close(resumed)
+		delete(pauseMap, itemID)
+	}
+	return ok
+}
+
+func isDownloadPaused(itemID string) bool {
+	if itemID == "" {
+		return false
+	}
+
+	pauseMu.Lock()
+	_, paused := pauseMap[itemID]
+	pauseMu.Unlock()
+	return paused
+}
+
+// waitWhileDownloadPaused blocks while itemID is paused. It reports whether
+// it had to wait, and ErrDownloadCancelled when the item was cancelled
+// instead of resumed.
+func waitWhileDownloadPaused(itemID string) (bool, error) {
+	if itemID == "" {
+		return false, nil
+	}
+
+	pauseMu.Lock()
+	resumed, paused := pauseMap[itemID]
+	pauseMu.Unlock()
+	if !paused {
+		return false, nil
+	}
+
+	<-resumed
+	if isDownloadCancelled(itemID) {
+		return true, ErrDownloadCancelled
+	}
+	return true, nil
 }
 
 func initExtensionRequestCancel(requestID string) context.Context {
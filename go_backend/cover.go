@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 const (
@@ -28,10 +29,64 @@ func convertSmallToMedium(imageURL string) string {
 	return imageURL
 }
 
+// sharedCovers holds cover art fetched once for a batch of downloads, such
+// as the tracks of an album, so each track does not fetch it again.
+var (
+	sharedCoversMu sync.Mutex
+	sharedCovers   = make(map[string]*sharedCover)
+)
+
+type sharedCover struct {
+	data []byte
+	refs int
+}
+
+func sharedCoverKey(coverURL string, maxQuality bool) string {
+	return fmt.Sprintf("%t|%s", maxQuality, coverURL)
+}
+
+// shareCover makes data the answer for coverURL until release is called.
+func shareCover(coverURL string, maxQuality bool, data []byte) (release func()) {
+	key := sharedCoverKey(coverURL, maxQuality)
+	sharedCoversMu.Lock()
+	if entry, ok := sharedCovers[key]; ok {
+		entry.refs++
+	} else {
+		sharedCovers[key] = &sharedCover{data: data, refs: 1}
+	}
+	sharedCoversMu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			sharedCoversMu.Lock()
+			if entry, ok := sharedCovers[key]; ok {
+				if entry.refs--; entry.refs <= 0 {
+					delete(sharedCovers, key)
+				}
+			}
+			sharedCoversMu.Unlock()
+		})
+	}
+}
+
+func lookupSharedCover(coverURL string, maxQuality bool) []byte {
+	sharedCoversMu.Lock()
+	defer sharedCoversMu.Unlock()
+	if entry, ok := sharedCovers[sharedCoverKey(coverURL, maxQuality)]; ok {
+		return append([]byte(nil), entry.data...)
+	}
+	return nil
+}
+
 func downloadCoverToMemory(coverURL string, maxQuality bool) ([]byte, error) {
 	if coverURL == "" {
 		return nil, fmt.Errorf("no cover URL provided")
 	}
+	if data := lookupSharedCover(coverURL, maxQuality); data != nil {
+		GoLog("[Cover] Using shared cover for %s", coverURL)
+		return data, nil
+	}
 
 	GoLog("[Cover] Original URL: %s", coverURL)
 
@@ -0,0 +1,329 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	stdimage "image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/webp"
+)
+
+const (
+	// CoverProcessingModeProcess runs covers through the policy before they
+	// are embedded or saved.
+	CoverProcessingModeProcess = "process"
+	// CoverProcessingModeKeepOriginal stores covers byte for byte, except
+	// where a container cannot hold them at all.
+	CoverProcessingModeKeepOriginal = "keep_original"
+
+	defaultCoverJPEGQuality = 90
+	minCoverJPEGQuality     = 60
+
+	coverTargetFLAC    = "flac"
+	coverTargetOgg     = "ogg"
+	coverTargetID3     = "id3"
+	coverTargetMP4     = "mp4"
+	coverTargetAPE     = "ape"
+	coverTargetSidecar = "sidecar"
+)
+
+// coverTargetAliases lets a policy name targets by file type as well.
+var coverTargetAliases = map[string]string{
+	"opus": coverTargetOgg,
+	"mp3":  coverTargetID3,
+	"wav":  coverTargetID3,
+	"aiff": coverTargetID3,
+	"m4a":  coverTargetMP4,
+	"aac":  coverTargetMP4,
+}
+
+// coverHardByteLimits apply in every mode: a bigger picture block corrupts
+// the file instead of just bloating it.
+var coverHardByteLimits = map[string]int{
+	coverTargetFLAC: maxFlacPictureBytes,
+	coverTargetOgg:  maxFlacPictureBytes,
+}
+
+// jpegMetadataMarkers are the APP1 (EXIF, XMP), APP2 (ICC), APP13
+// (Photoshop, IPTC) and COM segments. APP0 (JFIF) and APP14 (Adobe, needed
+// to decode CMYK) stay.
+var jpegMetadataMarkers = map[byte]bool{0xE1: true, 0xE2: true, 0xED: true, 0xFE: true}
+
+// pngMetadataChunks are ancillary chunks with no effect on the pixels.
+var pngMetadataChunks = map[string]bool{"iCCP": true, "eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+// CoverFormatLimits bounds the covers written for one target. Zero values
+// are not enforced.
+type CoverFormatLimits struct {
+	MaxDimension int `json:"max_dimension,omitempty"`
+	MaxBytes     int `json:"max_bytes,omitempty"`
+}
+
+// CoverProcessingPolicy controls how covers are prepared before they are
+// embedded or saved. Formats is keyed by target: flac, ogg, id3 (MP3, WAV and
+// AIFF), mp4, ape and sidecar; the "*" key applies to targets without an
+// entry of their own.
+type CoverProcessingPolicy struct {
+	Mode             string                       `json:"mode"`
+	JPEGQuality      int                          `json:"jpeg_quality"`
+	ConvertPNGToJPEG bool                         `json:"convert_png_to_jpeg"`
+	DecodeWebP       bool                         `json:"decode_webp"`
+	StripMetadata    bool                         `json:"strip_metadata"`
+	Formats          map[string]CoverFormatLimits `json:"formats,omitempty"`
+}
+
+var defaultCoverProcessingPolicy = CoverProcessingPolicy{
+	Mode:        CoverProcessingModeProcess,
+	JPEGQuality: defaultCoverJPEGQuality,
+}
+
+var (
+	coverProcessingPolicy   = defaultCoverProcessingPolicy
+	coverProcessingPolicyMu sync.RWMutex
+)
+
+func normalizeCoverTarget(target string) string {
+	target = strings.ToLower(strings.TrimSpace(target))
+	if alias, ok := coverTargetAliases[target]; ok {
+		return alias
+	}
+	return target
+}
+
+func SetCoverProcessingPolicy(policy CoverProcessingPolicy) {
+	policy.Mode = strings.ToLower(strings.TrimSpace(policy.Mode))
+	if policy.Mode != CoverProcessingModeKeepOriginal {
+		policy.Mode = CoverProcessingModeProcess
+	}
+	if policy.JPEGQuality <= 0 || policy.JPEGQuality > 100 {
+		policy.JPEGQuality = defaultCoverJPEGQuality
+	}
+	formats := make(map[string]CoverFormatLimits, len(policy.Formats))
+	for target, limits := range policy.Formats {
+		target = normalizeCoverTarget(target)
+		if target == "" {
+			continue
+		}
+		limits.MaxDimension = max(0, limits.MaxDimension)
+		limits.MaxBytes = max(0, limits.MaxBytes)
+		formats[target] = limits
+	}
+	policy.Formats = formats
+
+	coverProcessingPolicyMu.Lock()
+	coverProcessingPolicy = policy
+	coverProcessingPolicyMu.Unlock()
+	GoLog("[Cover] Processing policy set: mode=%s quality=%d png_to_jpeg=%v webp=%v strip=%v formats=%d\n",
+		policy.Mode, policy.JPEGQuality, policy.ConvertPNGToJPEG, policy.DecodeWebP, policy.StripMetadata, len(formats))
+}
+
+func GetCoverProcessingPolicy() CoverProcessingPolicy {
+	coverProcessingPolicyMu.RLock()
+	defer coverProcessingPolicyMu.RUnlock()
+	return coverProcessingPolicy
+}
+
+// limitsFor returns the limits for a target, tightened to what the container
+// can hold.
+func (p CoverProcessingPolicy) limitsFor(target string) CoverFormatLimits {
+	var limits CoverFormatLimits
+	if p.Mode == CoverProcessingModeProcess {
+		var ok bool
+		if limits, ok = p.Formats[target]; !ok {
+			limits = p.Formats["*"]
+		}
+	}
+	if hard := coverHardByteLimits[target]; hard > 0 && (limits.MaxBytes == 0 || limits.MaxBytes > hard) {
+		limits.MaxBytes = hard
+	}
+	return limits
+}
+
+// processCover prepares a cover for a target under the current policy and
+// returns the bytes to store with their MIME type. mime may be empty. It
+// returns false only when the cover exceeds a hard container limit and
+// cannot be shrunk; other failures keep the cover as it is.
+func processCover(target, mime string, data []byte) ([]byte, string, bool) {
+	return GetCoverProcessingPolicy().process(normalizeCoverTarget(target), mime, data)
+}
+
+func (p CoverProcessingPolicy) process(target, mime string, data []byte) ([]byte, string, bool) {
+	if len(data) == 0 {
+		return data, mime, true
+	}
+	if strings.TrimSpace(mime) == "" {
+		mime = detectCoverMIME("", data)
+	}
+	sourceMIME := detectCoverMIME("", data)
+	limits := p.limitsFor(target)
+	keepOriginal := p.Mode == CoverProcessingModeKeepOriginal
+
+	reencode := !keepOriginal &&
+		((sourceMIME == "image/webp" && p.DecodeWebP) || (sourceMIME == "image/png" && p.ConvertPNGToJPEG))
+	if !reencode && limits.MaxDimension > 0 {
+		if cfg, _, err := stdimage.DecodeConfig(bytes.NewReader(data)); err == nil && max(cfg.Width, cfg.Height) > limits.MaxDimension {
+			reencode = true
+		}
+	}
+	if !reencode {
+		if !keepOriginal && p.StripMetadata {
+			data = stripCoverMetadata(sourceMIME, data)
+		}
+		if limits.MaxBytes == 0 || len(data) <= limits.MaxBytes {
+			return data, mime, true
+		}
+	}
+
+	img, err := p.decodeCover(sourceMIME, data)
+	if err != nil {
+		GoLog("[Cover] Keeping %s cover unprocessed: %v\n", target, err)
+		hard := coverHardByteLimits[target]
+		return data, mime, hard == 0 || len(data) <= hard
+	}
+	encoded, encodedMIME, ok := p.encodeCover(img, sourceMIME, limits)
+	if !ok {
+		GoLog("[Cover] Could not fit %s cover into %d bytes\n", target, limits.MaxBytes)
+		hard := coverHardByteLimits[target]
+		return data, mime, hard == 0 || len(data) <= hard
+	}
+	GoLog("[Cover] Processed %s cover: %s %d KB -> %s %d KB\n",
+		target, sourceMIME, len(data)/1024, encodedMIME, len(encoded)/1024)
+	return encoded, encodedMIME, true
+}
+
+func (p CoverProcessingPolicy) decodeCover(mime string, data []byte) (stdimage.Image, error) {
+	if mime == "image/webp" {
+		if !p.DecodeWebP {
+			return nil, fmt.Errorf("WebP decoding is disabled")
+		}
+		return webp.Decode(bytes.NewReader(data))
+	}
+	img, _, err := stdimage.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// encodeCover downscales to the dimension limit and encodes under the byte
+// budget. PNG stays PNG unless conversion is on or it does not fit; anything
+// else becomes JPEG, trading quality first and then size.
+func (p CoverProcessingPolicy) encodeCover(img stdimage.Image, sourceMIME string, limits CoverFormatLimits) ([]byte, string, bool) {
+	if limits.MaxDimension > 0 {
+		img = downscaleImage(img, limits.MaxDimension)
+	}
+	budget := limits.MaxBytes
+	if budget == 0 {
+		budget = math.MaxInt
+	}
+
+	if sourceMIME == "image/png" && !p.ConvertPNGToJPEG && p.Mode == CoverProcessingModeProcess {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err == nil && buf.Len() <= budget {
+			return buf.Bytes(), "image/png", true
+		}
+	}
+
+	img = flattenCoverImage(img)
+	quality := p.JPEGQuality
+	if quality <= 0 {
+		quality = defaultCoverJPEGQuality
+	}
+	for q := quality; q >= minCoverJPEGQuality; q -= 10 {
+		if encoded, ok := encodeJPEGUnder(img, q, budget); ok {
+			return encoded, "image/jpeg", true
+		}
+	}
+	longest := max(img.Bounds().Dx(), img.Bounds().Dy())
+	for _, maxDim := range []int{1500, 1200, 1000, 800} {
+		if maxDim >= longest {
+			continue
+		}
+		scaled := downscaleImage(img, maxDim)
+		if encoded, ok := encodeJPEGUnder(scaled, min(quality, 85), budget); ok {
+			return encoded, "image/jpeg", true
+		}
+	}
+	return nil, "", false
+}
+
+// flattenCoverImage draws images that may carry alpha onto white, so
+// transparent areas do not turn black in JPEG.
+func flattenCoverImage(img stdimage.Image) stdimage.Image {
+	switch img.(type) {
+	case *stdimage.YCbCr, *stdimage.Gray, *stdimage.CMYK:
+		return img
+	}
+	bounds := img.Bounds()
+	dst := stdimage.NewRGBA(stdimage.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), stdimage.NewUniform(color.White), stdimage.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	return dst
+}
+
+// stripCoverMetadata drops EXIF, XMP, colour profiles and text chunks without
+// touching the image data. Anything it cannot parse is returned unchanged.
+func stripCoverMetadata(mime string, data []byte) []byte {
+	switch mime {
+	case "image/jpeg":
+		return stripJPEGMetadata(data)
+	case "image/png":
+		return stripPNGMetadata(data)
+	}
+	return data
+}
+
+func stripJPEGMetadata(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return data
+		}
+		marker := data[pos+1]
+		if marker == 0xFF {
+			pos++ // fill byte
+			continue
+		}
+		if marker == 0xDA {
+			break // start of scan; the rest is entropy-coded data
+		}
+		end := pos + 2 + int(binary.BigEndian.Uint16(data[pos+2:]))
+		if end < pos+4 || end > len(data) {
+			return data
+		}
+		if !jpegMetadataMarkers[marker] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return append(out, data[pos:]...)
+}
+
+func stripPNGMetadata(data []byte) []byte {
+	if detectCoverMIME("", data) != "image/png" {
+		return data
+	}
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:8]...)
+	pos := 8
+	for pos+12 <= len(data) {
+		end := pos + 12 + int(binary.BigEndian.Uint32(data[pos:]))
+		if end < pos+12 || end > len(data) {
+			return data
+		}
+		if !pngMetadataChunks[string(data[pos+4:pos+8])] {
+			out = append(out, data[pos:end]...)
+		}
+		pos = end
+	}
+	return append(out, data[pos:]...)
+}
@@ -0,0 +1,204 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// testWebP is a 1x1 lossless WebP.
+const testWebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func useCoverProcessingPolicy(t *testing.T, policyJSON string) {
+	t.Helper()
+	original := GetCoverProcessingPolicy()
+	t.Cleanup(func() { SetCoverProcessingPolicy(original) })
+	if err := SetCoverProcessingPolicyJSON(policyJSON); err != nil {
+		t.Fatalf("SetCoverProcessingPolicyJSON: %v", err)
+	}
+}
+
+// insertPNGChunk adds an ancillary chunk right after IHDR.
+func insertPNGChunk(data []byte, chunkType string, payload []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, chunkType...)
+	chunk = append(chunk, payload...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(data[8:]))
+	return append(append(append([]byte{}, data[:ihdrEnd]...), chunk...), data[ihdrEnd:]...)
+}
+
+func testJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func coverSize(t *testing.T, data []byte) (int, int, string) {
+	t.Helper()
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("decode processed cover: %v", err)
+	}
+	return cfg.Width, cfg.Height, format
+}
+
+func TestDefaultCoverPolicyKeepsBytes(t *testing.T) {
+	useCoverProcessingPolicy(t, "")
+
+	cover := insertPNGChunk(testPNG(t, 40, 20), "tEXt", []byte("Comment\x00hello"))
+	got, mime, ok := processCover(coverTargetFLAC, "", cover)
+	if !ok || mime != "image/png" || !bytes.Equal(got, cover) {
+		t.Fatalf("default policy changed the cover: %d bytes, %s", len(got), mime)
+	}
+}
+
+func TestCoverPolicyDownscalesAndConvertsPerTarget(t *testing.T) {
+	useCoverProcessingPolicy(t, `{"convert_png_to_jpeg":true,"jpeg_quality":80,
+		"formats":{"mp3":{"max_dimension":30},"*":{"max_dimension":50}}}`)
+
+	if policy := GetCoverProcessingPolicy(); policy.Formats[coverTargetID3].MaxDimension != 30 {
+		t.Fatalf("mp3 alias not normalized: %+v", policy.Formats)
+	}
+
+	cover := testPNG(t, 120, 60)
+	for target, wantWidth := range map[string]int{coverTargetID3: 30, coverTargetMP4: 50} {
+		got, mime, ok := processCover(target, "image/png", cover)
+		width, height, format := coverSize(t, got)
+		if !ok || mime != "image/jpeg" || format != "jpeg" || width != wantWidth || height != wantWidth/2 {
+			t.Fatalf("%s: got %s %s %dx%d", target, mime, format, width, height)
+		}
+	}
+}
+
+func TestCoverPolicyKeepsPNGWhenOnlyDownscaling(t *testing.T) {
+	useCoverProcessingPolicy(t, `{"formats":{"flac":{"max_dimension":10}}}`)
+
+	got, mime, _ := processCover(coverTargetFLAC, "", testPNG(t, 20, 20))
+	if width, _, format := coverSize(t, got); mime != "image/png" || format != "png" || width != 10 {
+		t.Fatalf("got %s %s %d wide", mime, format, width)
+	}
+}
+
+func TestCoverPolicyByteBudgetLowersQuality(t *testing.T) {
+	noise := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(i * 7919 % 251)
+	}
+	encode := func(quality int) []byte {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, noise, &jpeg.Options{Quality: quality}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	cover, budget := encode(100), len(encode(70))
+	useCoverProcessingPolicy(t, fmt.Sprintf(`{"formats":{"sidecar":{"max_bytes":%d}}}`, budget))
+
+	got, mime, ok := processCover(coverTargetSidecar, "", cover)
+	if !ok || mime != "image/jpeg" || len(got) > budget || bytes.Equal(got, cover) {
+		t.Fatalf("got %s, %d bytes over a %d byte budget", mime, len(got), budget)
+	}
+}
+
+func TestCoverPolicyStripsMetadata(t *testing.T) {
+	useCoverProcessingPolicy(t, `{"strip_metadata":true}`)
+
+	plainPNG := testPNG(t, 8, 8)
+	noisyPNG := insertPNGChunk(insertPNGChunk(plainPNG, "iCCP", bytes.Repeat([]byte{1}, 500)), "tEXt", []byte("k\x00v"))
+	if got, _, _ := processCover(coverTargetMP4, "", noisyPNG); !bytes.Equal(got, plainPNG) {
+		t.Fatalf("PNG not stripped: %d bytes, want %d", len(got), len(plainPNG))
+	}
+
+	plainJPEG := testJPEG(t, 8, 8)
+	exif := append([]byte{0xFF, 0xE1, 0x00, 0x0A}, "Exif\x00\x00ab"...)
+	icc := append([]byte{0xFF, 0xE2, 0x00, 0x06}, "ICC_"...)
+	noisyJPEG := append(append(append([]byte{0xFF, 0xD8}, exif...), icc...), plainJPEG[2:]...)
+	got, _, _ := processCover(coverTargetID3, "", noisyJPEG)
+	if !bytes.Equal(got, plainJPEG) {
+		t.Fatalf("JPEG not stripped: %d bytes, want %d", len(got), len(plainJPEG))
+	}
+	coverSize(t, got)
+}
+
+func TestCoverPolicyKeepOriginalIgnoresLimits(t *testing.T) {
+	useCoverProcessingPolicy(t, `{"mode":"keep_original","strip_metadata":true,"convert_png_to_jpeg":true,
+		"formats":{"*":{"max_dimension":4,"max_bytes":10}}}`)
+
+	cover := insertPNGChunk(testPNG(t, 40, 40), "tEXt", []byte("k\x00v"))
+	if got, _, ok := processCover(coverTargetFLAC, "", cover); !ok || !bytes.Equal(got, cover) {
+		t.Fatal("keep_original changed the cover")
+	}
+}
+
+func TestCoverPolicyDecodesWebPOnlyWhenEnabled(t *testing.T) {
+	webpCover, _ := base64.StdEncoding.DecodeString(testWebP)
+
+	useCoverProcessingPolicy(t, "")
+	if got, mime, _ := processCover(coverTargetID3, "", webpCover); mime != "image/webp" || !bytes.Equal(got, webpCover) {
+		t.Fatalf("WebP converted while disabled: %s", mime)
+	}
+
+	useCoverProcessingPolicy(t, `{"decode_webp":true}`)
+	got, mime, _ := processCover(coverTargetID3, "", webpCover)
+	if width, height, format := coverSize(t, got); mime != "image/jpeg" || format != "jpeg" || width != 1 || height != 1 {
+		t.Fatalf("got %s %s %dx%d", mime, format, width, height)
+	}
+}
+
+func TestCoverPolicyAppliesToWritersAndSidecars(t *testing.T) {
+	useCoverProcessingPolicy(t, `{"convert_png_to_jpeg":true,"formats":{"*":{"max_dimension":16}}}`)
+
+	dir := t.TempDir()
+	coverPath := writeTestPicture(t, dir, "cover.png", testPNG(t, 64, 32))
+	cases := []struct {
+		name  string
+		data  []byte
+		write func(path string) error
+	}{
+		{"song.flac", buildTestFLAC("TITLE=Song"), func(path string) error {
+			return EmbedMetadata(path, Metadata{Title: "New"}, coverPath)
+		}},
+		{"song.mp3", append(buildID3v23Tag(id3TextFrame("TIT2", "Song")), testMP3Audio...), func(path string) error {
+			return WriteMP3Tags(path, map[string]string{"cover_path": coverPath})
+		}},
+		{"song.m4a", buildTestM4AWithChunks(buildM4ATextTag("\xa9nam", "Song"), false), func(path string) error {
+			return WriteM4ATags(path, map[string]string{"cover_path": coverPath})
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestPicture(t, t.TempDir(), tc.name, tc.data)
+			if err := tc.write(path); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			cover, ok := selectCoverPicture(listTestPictures(t, path))
+			if !ok || cover.Width != 16 || cover.Height != 8 || cover.MIME != "image/jpeg" {
+				t.Fatalf("embedded cover = %+v", cover)
+			}
+		})
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testPNG(t, 64, 32))
+	}))
+	defer server.Close()
+	out := filepath.Join(dir, "folder.jpg")
+	if err := DownloadCoverToFile(server.URL+"/cover.png", out, false); err != nil {
+		t.Fatalf("DownloadCoverToFile: %v", err)
+	}
+	if width, _, format := coverSize(t, mustReadFile(t, out)); format != "jpeg" || width != 16 {
+		t.Fatalf("sidecar = %s %d wide", format, width)
+	}
+}
@@ -0,0 +1,203 @@
+package gobackend
+
+import (
+	"bufio"
+	"fmt"
+	stdimage "image"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	coverArtArchiveBase = "https://coverartarchive.org"
+
+	// coverProbeLimit caps how much of a cover is read to find its header.
+	// JPEGs with large EXIF or ICC blocks put the frame header far in.
+	coverProbeLimit = 512 * 1024
+
+	coverSourceRequest         = "request"
+	coverSourceCoverArtArchive = "coverartarchive"
+)
+
+// coverCandidate is one cover URL offered for a track. Width and Height
+// stay zero until probed.
+type coverCandidate struct {
+	URL    string `json:"url"`
+	Source string `json:"source"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// score prefers resolution but discounts non-square images by their aspect
+// ratio, so a 3000x1500 banner ranks below a 1400x1400 front cover.
+func (c coverCandidate) score() float64 {
+	if c.Width <= 0 || c.Height <= 0 {
+		return 0
+	}
+	short, long := min(c.Width, c.Height), max(c.Width, c.Height)
+	return float64(short) * float64(short) / float64(long)
+}
+
+// coverResolver gathers candidate covers from every source that has one and
+// picks the best by probed dimensions.
+type coverResolver struct {
+	maxQuality bool
+	candidates []coverCandidate
+	seen       map[string]bool
+}
+
+func newCoverResolver(maxQuality bool) *coverResolver {
+	return &coverResolver{maxQuality: maxQuality, seen: make(map[string]bool)}
+}
+
+// add offers a cover URL. With maxQuality the CDN upgrade comes first and the
+// original stays as a fallback in case the upgraded size does not exist.
+func (r *coverResolver) add(source, coverURL string) {
+	coverURL = strings.TrimSpace(coverURL)
+	if coverURL == "" {
+		return
+	}
+	medium := convertSmallToMedium(coverURL)
+	if r.maxQuality {
+		r.addURL(source, upgradeToMaxQuality(medium))
+	}
+	r.addURL(source, medium)
+	r.addURL(source, coverURL)
+}
+
+func (r *coverResolver) addURL(source, coverURL string) {
+	if r.seen[coverURL] {
+		return
+	}
+	r.seen[coverURL] = true
+	r.candidates = append(r.candidates, coverCandidate{URL: coverURL, Source: source})
+}
+
+// addCoverArtArchive offers the front image of a MusicBrainz release, and of
+// its release group when the release has none.
+func (r *coverResolver) addCoverArtArchive(releaseID, releaseGroupID string) {
+	if id := strings.TrimSpace(releaseID); id != "" {
+		r.addURL(coverSourceCoverArtArchive, fmt.Sprintf("%s/release/%s/front", coverArtArchiveBase, url.PathEscape(id)))
+	}
+	if id := strings.TrimSpace(releaseGroupID); id != "" {
+		r.addURL(coverSourceCoverArtArchive, fmt.Sprintf("%s/release-group/%s/front", coverArtArchiveBase, url.PathEscape(id)))
+	}
+}
+
+// best probes every candidate and returns the highest scoring one. When none
+// can be probed it falls back to the first candidate, which is the cover the
+// caller would have used anyway.
+func (r *coverResolver) best(logPrefix string) (coverCandidate, bool) {
+	if len(r.candidates) == 0 {
+		return coverCandidate{}, false
+	}
+	if len(r.candidates) == 1 {
+		return r.candidates[0], true
+	}
+
+	var best coverCandidate
+	found := false
+	for i := range r.candidates {
+		candidate := &r.candidates[i]
+		width, height, err := probeCoverResolution(candidate.URL)
+		if err != nil {
+			LogDebug("Cover", "Probe failed for %s cover %s: %v", candidate.Source, candidate.URL, err)
+			continue
+		}
+		candidate.Width, candidate.Height = width, height
+		if !found || candidate.score() > best.score() {
+			best = *candidate
+			found = true
+		}
+	}
+	if !found {
+		return r.candidates[0], true
+	}
+	GoLog("[%s] Selected %dx%d cover from %s out of %d candidates\n", logPrefix, best.Width, best.Height, best.Source, len(r.candidates))
+	return best, true
+}
+
+// probeCoverResolution streams the start of a cover into the image header
+// decoder and stops as soon as the dimensions are known.
+var probeCoverResolution = func(coverURL string) (int, int, error) {
+	req, err := http.NewRequest(http.MethodGet, coverURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", coverProbeLimit-1))
+
+	resp, err := DoRequestWithUserAgent(NewHTTPClientWithTimeout(10*time.Second), req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, 0, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	cfg, _, err := stdimage.DecodeConfig(bufio.NewReader(io.LimitReader(resp.Body, coverProbeLimit)))
+	if err != nil {
+		return 0, 0, err
+	}
+	return cfg.Width, cfg.Height, nil
+}
+
+// resolveCoverURL runs the resolver over the given sources and returns the
+// URL to download. The returned URL is already upgraded, so it should be
+// fetched without maxQuality.
+func resolveCoverURL(logPrefix string, resolver *coverResolver) (string, bool) {
+	best, ok := resolver.best(logPrefix)
+	if !ok {
+		return "", false
+	}
+	return best.URL, true
+}
+
+// applyCoverArbitration picks the best cover for a finished download from the
+// request, the extension result, the metadata providers seen during
+// enrichment and Cover Art Archive, and stores it as the response cover so
+// the embed and a later DownloadCoverToFile both use it. It returns the
+// request to embed with.
+func applyCoverArbitration(resp *DownloadResponse, req *DownloadRequest) DownloadRequest {
+	if !req.CoverArbitration {
+		return *req
+	}
+
+	resolver := newCoverResolver(req.EmbedMaxQualityCover)
+	resolver.add(coverSourceRequest, req.CoverURL)
+	resolver.add(resp.Service, resp.CoverURL)
+	for _, candidate := range req.coverCandidates {
+		resolver.add(candidate.Source, candidate.URL)
+	}
+	isrc := firstNonEmptyTrimmed(resp.ISRC, req.ISRC)
+	if match := lookupRequestMusicBrainzMatch(req, isrc, firstNonEmptyTrimmed(resp.Album, req.AlbumName)); match != nil {
+		resolver.addCoverArtArchive(match.ReleaseID, match.ReleaseGroupID)
+	}
+
+	embedReq := *req
+	if coverURL, ok := resolveCoverURL("DownloadWithExtensionFallback", resolver); ok {
+		resp.CoverURL = coverURL
+		// The chosen URL is final; upgrading it again would undo a fallback.
+		embedReq.EmbedMaxQualityCover = false
+	}
+	return embedReq
+}
+
+// lookupRequestMusicBrainzMatch resolves the ISRC once per request and caches
+// the result, so the MusicBrainz tag embed reuses it.
+func lookupRequestMusicBrainzMatch(req *DownloadRequest, isrc, albumName string) *MusicBrainzMatch {
+	if req.musicBrainzLookupDone || isrc == "" {
+		return req.musicBrainzMatch
+	}
+	req.musicBrainzLookupDone = true
+	match, err := fetchMusicBrainzMatchByISRC(isrc, albumName, req.TotalTracks)
+	if err != nil {
+		GoLog("[DownloadWithExtensionFallback] MusicBrainz lookup for cover failed: %v\n", err)
+		return nil
+	}
+	req.musicBrainzMatch = match
+	return match
+}
@@ -0,0 +1,150 @@
+package gobackend
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func stubCoverProbe(t *testing.T, sizes map[string][2]int) {
+	t.Helper()
+	orig := probeCoverResolution
+	t.Cleanup(func() { probeCoverResolution = orig })
+	probeCoverResolution = func(url string) (int, int, error) {
+		size, ok := sizes[url]
+		if !ok {
+			return 0, 0, fmt.Errorf("HTTP 404")
+		}
+		return size[0], size[1], nil
+	}
+}
+
+func TestCoverResolverPrefersSquareOverLargerBanner(t *testing.T) {
+	stubCoverProbe(t, map[string][2]int{
+		"https://a/cover.jpg":                        {640, 640},
+		"https://b/cover.jpg":                        {3000, 1500},
+		coverArtArchiveBase + "/release/rel-1/front": {1400, 1400},
+	})
+
+	resolver := newCoverResolver(false)
+	resolver.add("ext-a", "https://a/cover.jpg")
+	resolver.add("ext-b", "https://b/cover.jpg")
+	resolver.addCoverArtArchive("rel-1", "")
+
+	best, ok := resolver.best("test")
+	if !ok || best.Source != coverSourceCoverArtArchive || best.Width != 1400 {
+		t.Fatalf("expected Cover Art Archive cover, got %+v", best)
+	}
+}
+
+func TestCoverResolverFallsBackToFirstCandidate(t *testing.T) {
+	stubCoverProbe(t, nil)
+
+	resolver := newCoverResolver(false)
+	resolver.add("ext-a", "https://a/cover.jpg")
+	resolver.addCoverArtArchive("rel-1", "rg-1")
+	resolver.add("ext-a", "https://a/cover.jpg")
+
+	if len(resolver.candidates) != 3 {
+		t.Fatalf("expected duplicates to be dropped, got %+v", resolver.candidates)
+	}
+	best, ok := resolver.best("test")
+	if !ok || best.URL != "https://a/cover.jpg" {
+		t.Fatalf("expected first candidate when nothing probes, got %+v", best)
+	}
+
+	if _, ok := newCoverResolver(true).best("test"); ok {
+		t.Fatal("expected no cover from an empty resolver")
+	}
+}
+
+func TestProbeCoverResolutionDecodesHeader(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 37, 21))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "" {
+			t.Error("expected a ranged request")
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	width, height, err := probeCoverResolution(server.URL + "/cover.png")
+	if err != nil || width != 37 || height != 21 {
+		t.Fatalf("probe = %dx%d, %v", width, height, err)
+	}
+}
+
+func TestApplyCoverArbitrationUsesCoverArtArchive(t *testing.T) {
+	stubCoverProbe(t, map[string][2]int{
+		"https://ext/cover.jpg":                      {1000, 1000},
+		coverArtArchiveBase + "/release/rel-1/front": {1200, 1200},
+	})
+	origFetch := fetchMusicBrainzMatchByISRC
+	defer func() { fetchMusicBrainzMatchByISRC = origFetch }()
+	lookups := 0
+	fetchMusicBrainzMatchByISRC = func(isrc, albumName string, totalTracks int) (*MusicBrainzMatch, error) {
+		lookups++
+		return &MusicBrainzMatch{ReleaseID: "rel-1"}, nil
+	}
+
+	req := DownloadRequest{ISRC: "USRC17607839", CoverArbitration: true, EmbedMaxQualityCover: true}
+	resp := DownloadResponse{Service: "ext", CoverURL: "https://ext/cover.jpg"}
+
+	embedReq := applyCoverArbitration(&resp, &req)
+	if resp.CoverURL != coverArtArchiveBase+"/release/rel-1/front" {
+		t.Fatalf("expected Cover Art Archive cover, got %q", resp.CoverURL)
+	}
+	if embedReq.EmbedMaxQualityCover || !req.EmbedMaxQualityCover {
+		t.Fatal("only the embed request should skip the max quality upgrade")
+	}
+
+	applyCoverArbitration(&resp, &req)
+	if lookups != 1 || req.musicBrainzMatch == nil {
+		t.Fatalf("expected one cached MusicBrainz lookup, got %d", lookups)
+	}
+}
+
+func TestDownloadCoverToFileFallsBackFromMissingUpgrade(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+	original := "/image/" + spotifySize640 + "abc"
+	var upgradeRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != original {
+			upgradeRequests++
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+
+	out := filepath.Join(t.TempDir(), "cover.png")
+	if err := DownloadCoverToFile(server.URL+original, out, true); err != nil {
+		t.Fatalf("DownloadCoverToFile: %v", err)
+	}
+	if data, err := os.ReadFile(out); err != nil || len(data) == 0 || upgradeRequests == 0 {
+		t.Fatalf("saved %d bytes (%v) after %d upgrade requests", len(data), err, upgradeRequests)
+	}
+
+	// A single candidate is downloaded without a probe.
+	stubCoverProbe(t, nil)
+	probeCoverResolution = func(string) (int, int, error) {
+		t.Fatal("single cover candidate should not be probed")
+		return 0, 0, nil
+	}
+	if err := DownloadCoverToFile(server.URL+original, out, false); err != nil {
+		t.Fatalf("DownloadCoverToFile: %v", err)
+	}
+}
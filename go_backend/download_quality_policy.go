@@ -0,0 +1,261 @@
+package gobackend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// DownloadQualityActionDiscard deletes a file that falls short of the
+	// policy before the next provider is tried.
+	DownloadQualityActionDiscard = "discard"
+	// DownloadQualityActionKeep parks the first file that falls short and
+	// returns it when no provider does better.
+	DownloadQualityActionKeep = "keep"
+)
+
+// DownloadQualityRequirement is the minimum a provider has to deliver for a
+// requested quality. Codec is either a codec name such as "flac" or the
+// family "lossless". Zero values are not checked.
+type DownloadQualityRequirement struct {
+	Codec         string `json:"codec,omitempty"`
+	MinBitDepth   int    `json:"min_bit_depth,omitempty"`
+	MinSampleRate int    `json:"min_sample_rate,omitempty"`
+}
+
+// DownloadQualityPolicy maps QualityOption IDs to requirements. The "*" key
+// applies to qualities without an entry of their own.
+type DownloadQualityPolicy struct {
+	Enabled      bool                                  `json:"enabled"`
+	Action       string                                `json:"action,omitempty"`
+	Requirements map[string]DownloadQualityRequirement `json:"requirements,omitempty"`
+}
+
+// DownloadQualityRejection records a provider whose file was turned down.
+type DownloadQualityRejection struct {
+	Service string `json:"service"`
+	Reason  string `json:"reason"`
+	Kept    bool   `json:"kept,omitempty"`
+}
+
+var (
+	downloadQualityPolicy   DownloadQualityPolicy
+	downloadQualityPolicyMu sync.RWMutex
+)
+
+func SetDownloadQualityPolicy(policy DownloadQualityPolicy) {
+	policy.Action = strings.ToLower(strings.TrimSpace(policy.Action))
+	if policy.Action != DownloadQualityActionKeep {
+		policy.Action = DownloadQualityActionDiscard
+	}
+	requirements := make(map[string]DownloadQualityRequirement, len(policy.Requirements))
+	for quality, requirement := range policy.Requirements {
+		quality = strings.ToUpper(strings.TrimSpace(quality))
+		if quality == "" {
+			continue
+		}
+		requirement.Codec = strings.ToLower(strings.TrimSpace(requirement.Codec))
+		requirements[quality] = requirement
+	}
+	policy.Requirements = requirements
+
+	downloadQualityPolicyMu.Lock()
+	downloadQualityPolicy = policy
+	downloadQualityPolicyMu.Unlock()
+	GoLog("[QualityPolicy] Policy set: enabled=%v action=%s requirements=%d\n", policy.Enabled, policy.Action, len(requirements))
+}
+
+func GetDownloadQualityPolicy() DownloadQualityPolicy {
+	downloadQualityPolicyMu.RLock()
+	defer downloadQualityPolicyMu.RUnlock()
+	return downloadQualityPolicy
+}
+
+// requirementFor looks up the requested quality first, then the token that
+// was actually sent to the provider, then the "*" default.
+func (p DownloadQualityPolicy) requirementFor(qualities ...string) (DownloadQualityRequirement, bool) {
+	if !p.Enabled || len(p.Requirements) == 0 {
+		return DownloadQualityRequirement{}, false
+	}
+	for _, quality := range append(qualities, "*") {
+		quality = strings.ToUpper(strings.TrimSpace(quality))
+		if quality == "" {
+			continue
+		}
+		if requirement, ok := p.Requirements[quality]; ok {
+			return requirement, true
+		}
+	}
+	return DownloadQualityRequirement{}, false
+}
+
+// shortfall describes how a delivered file misses the requirement, or
+// returns "" when it meets it. Properties that could not be probed count as
+// met, so an unreadable file is never thrown away on a guess.
+func (r DownloadQualityRequirement) shortfall(codec string, bitDepth, sampleRate int) string {
+	var reasons []string
+	codec = strings.ToLower(strings.TrimSpace(codec))
+	if r.Codec != "" && codec != "" {
+		switch {
+		case r.Codec == "lossless":
+			if containsAudioCodec(lossyAudioCodecs, codec) {
+				reasons = append(reasons, fmt.Sprintf("codec %s is lossy", codec))
+			}
+		case !strings.Contains(codec, r.Codec):
+			reasons = append(reasons, fmt.Sprintf("codec %s instead of %s", codec, r.Codec))
+		}
+	}
+	if r.MinBitDepth > 0 && bitDepth > 0 && bitDepth < r.MinBitDepth {
+		reasons = append(reasons, fmt.Sprintf("%d-bit below %d-bit", bitDepth, r.MinBitDepth))
+	}
+	if r.MinSampleRate > 0 && sampleRate > 0 && sampleRate < r.MinSampleRate {
+		reasons = append(reasons, fmt.Sprintf("%d Hz below %d Hz", sampleRate, r.MinSampleRate))
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// deliveredAudioCodec falls back to the file extension for formats the
+// quality probe does not read.
+func deliveredAudioCodec(resp *DownloadResponse) string {
+	if codec := strings.TrimSpace(resp.AudioCodec); codec != "" {
+		return codec
+	}
+	ext := strings.TrimSpace(resp.ActualExtension)
+	if ext == "" {
+		ext = filepath.Ext(resp.FilePath)
+	}
+	switch strings.ToLower(strings.TrimPrefix(ext, ".")) {
+	case "flac":
+		return "flac"
+	case "mp3":
+		return "mp3"
+	case "opus":
+		return "opus"
+	case "ogg":
+		return "vorbis"
+	}
+	return ""
+}
+
+// downloadQualityGate applies the policy across one fallback run. It
+// remembers which providers were turned down and owns the parked file when
+// the policy keeps a fallback.
+type downloadQualityGate struct {
+	policy     DownloadQualityPolicy
+	qualities  []string
+	rejections []DownloadQualityRejection
+	rejected   map[string]bool
+	kept       *DownloadResponse
+	keptPath   string
+	keptReason string
+}
+
+func newDownloadQualityGate(req DownloadRequest) *downloadQualityGate {
+	return &downloadQualityGate{
+		policy:    GetDownloadQualityPolicy(),
+		qualities: []string{req.Quality},
+		rejected:  make(map[string]bool),
+	}
+}
+
+func (g *downloadQualityGate) skip(providerID string) bool {
+	return g.rejected[providerID]
+}
+
+// accept reports whether resp meets the policy. A file that does not is
+// deleted or parked, and the caller moves on to the next provider.
+func (g *downloadQualityGate) accept(req DownloadRequest, providerID, providerQuality string, resp *DownloadResponse) bool {
+	if resp == nil || resp.AlreadyExists || isFDOutput(req.OutputFD) || shouldSkipQualityProbe(resp.FilePath) {
+		return true
+	}
+	requirement, ok := g.policy.requirementFor(append(g.qualities, providerQuality)...)
+	if !ok {
+		return true
+	}
+	reason := requirement.shortfall(deliveredAudioCodec(resp), resp.ActualBitDepth, resp.ActualSampleRate)
+	if reason == "" {
+		return true
+	}
+
+	GoLog("[QualityPolicy] %s delivered less than requested %q: %s\n", providerID, req.Quality, reason)
+	g.rejected[providerID] = true
+	rejection := DownloadQualityRejection{Service: providerID, Reason: reason}
+
+	if g.policy.Action == DownloadQualityActionKeep && g.kept == nil {
+		parked := resp.FilePath + ".quality-fallback"
+		if err := os.Rename(resp.FilePath, parked); err == nil {
+			kept := *resp
+			g.kept = &kept
+			g.keptPath = parked
+			g.keptReason = reason
+			rejection.Kept = true
+		} else {
+			GoLog("[QualityPolicy] Failed to keep %s as fallback: %v\n", resp.FilePath, err)
+		}
+	}
+	if !rejection.Kept {
+		if err := os.Remove(resp.FilePath); err != nil && !os.IsNotExist(err) {
+			GoLog("[QualityPolicy] Failed to discard %s: %v\n", resp.FilePath, err)
+		}
+	}
+	g.rejections = append(g.rejections, rejection)
+	return false
+}
+
+// finish attaches the rejections to a successful response and drops the
+// parked file, which the new download supersedes.
+func (g *downloadQualityGate) finish(resp *DownloadResponse) {
+	if resp != nil {
+		resp.QualityRejections = g.rejections
+	}
+	g.discardKept()
+}
+
+func (g *downloadQualityGate) discardKept() {
+	if g.kept == nil {
+		return
+	}
+	if err := os.Remove(g.keptPath); err != nil && !os.IsNotExist(err) {
+		GoLog("[QualityPolicy] Failed to remove parked fallback %s: %v\n", g.keptPath, err)
+	}
+	g.kept = nil
+}
+
+// restoreKept moves the parked file back and returns its response, or nil
+// when nothing was kept.
+func (g *downloadQualityGate) restoreKept() *DownloadResponse {
+	if g.kept == nil {
+		return nil
+	}
+	resp := g.kept
+	g.kept = nil
+	if err := os.Rename(g.keptPath, resp.FilePath); err != nil {
+		GoLog("[QualityPolicy] Failed to restore parked fallback %s: %v\n", g.keptPath, err)
+		os.Remove(g.keptPath)
+		return nil
+	}
+	resp.QualityShortfall = g.keptReason
+	resp.QualityRejections = g.rejections
+	resp.Message = "Kept below-policy quality from " + resp.Service + ": " + g.keptReason
+	GoLog("[QualityPolicy] No provider met the policy, keeping %s from %s\n", resp.FilePath, resp.Service)
+	return resp
+}
+
+// failure builds the response returned when every file was discarded.
+func (g *downloadQualityGate) failure() *DownloadResponse {
+	if len(g.rejections) == 0 {
+		return nil
+	}
+	last := g.rejections[len(g.rejections)-1]
+	return &DownloadResponse{
+		Success:           false,
+		Error:             "No provider met the quality policy. Last: " + last.Service + " delivered " + last.Reason,
+		ErrorType:         "quality_below_policy",
+		Service:           last.Service,
+		QualityShortfall:  last.Reason,
+		QualityRejections: g.rejections,
+	}
+}
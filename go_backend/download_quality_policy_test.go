@@ -0,0 +1,151 @@
+package gobackend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDownloadQualityRequirementShortfall(t *testing.T) {
+	hiRes := DownloadQualityRequirement{Codec: "lossless", MinBitDepth: 24, MinSampleRate: 88200}
+
+	if got := hiRes.shortfall("flac", 24, 96000); got != "" {
+		t.Fatalf("24/96 FLAC should meet hi-res, got %q", got)
+	}
+	if got := hiRes.shortfall("flac", 16, 44100); got != "16-bit below 24-bit, 44100 Hz below 88200 Hz" {
+		t.Fatalf("unexpected shortfall for 16/44.1: %q", got)
+	}
+	if got := hiRes.shortfall("aac", 0, 0); got != "codec aac is lossy" {
+		t.Fatalf("unexpected shortfall for AAC: %q", got)
+	}
+	if got := hiRes.shortfall("", 0, 0); got != "" {
+		t.Fatalf("unprobed file should pass, got %q", got)
+	}
+	if got := (DownloadQualityRequirement{Codec: "flac"}).shortfall("alac", 24, 48000); got != "codec alac instead of flac" {
+		t.Fatalf("unexpected codec shortfall: %q", got)
+	}
+}
+
+func TestDownloadQualityPolicyRequirementLookup(t *testing.T) {
+	original := GetDownloadQualityPolicy()
+	defer SetDownloadQualityPolicy(original)
+
+	if err := SetDownloadQualityPolicyJSON(`{"enabled":true,"action":"bogus","requirements":{" hi_res ":{"min_bit_depth":24},"*":{"codec":"LOSSLESS"}}}`); err != nil {
+		t.Fatalf("SetDownloadQualityPolicyJSON: %v", err)
+	}
+	policy := GetDownloadQualityPolicy()
+	if policy.Action != DownloadQualityActionDiscard {
+		t.Fatalf("unknown action should fall back to discard, got %q", policy.Action)
+	}
+	if req, ok := policy.requirementFor("", "HI_RES"); !ok || req.MinBitDepth != 24 {
+		t.Fatalf("provider token lookup failed: %+v %v", req, ok)
+	}
+	if req, ok := policy.requirementFor("LOSSLESS"); !ok || req.Codec != "lossless" {
+		t.Fatalf("default lookup failed: %+v %v", req, ok)
+	}
+
+	policy.Enabled = false
+	if _, ok := policy.requirementFor("HI_RES"); ok {
+		t.Fatal("disabled policy should not return requirements")
+	}
+}
+
+func writeQualityGateFile(t *testing.T, path string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadQualityGateDiscardsShortfall(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "track.m4a")
+	writeQualityGateFile(t, path)
+
+	gate := &downloadQualityGate{
+		policy: DownloadQualityPolicy{
+			Enabled:      true,
+			Action:       DownloadQualityActionDiscard,
+			Requirements: map[string]DownloadQualityRequirement{"LOSSLESS": {Codec: "lossless"}},
+		},
+		qualities: []string{"LOSSLESS"},
+		rejected:  make(map[string]bool),
+	}
+	resp := &DownloadResponse{Success: true, FilePath: path, AudioCodec: "aac", Service: "ext-a"}
+	if gate.accept(DownloadRequest{Quality: "LOSSLESS"}, "ext-a", "", resp) {
+		t.Fatal("AAC should be rejected for LOSSLESS")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("rejected file should be removed, stat err = %v", err)
+	}
+	if !gate.skip("ext-a") {
+		t.Fatal("rejected provider should be skipped afterwards")
+	}
+
+	failure := gate.failure()
+	if failure == nil || failure.ErrorType != "quality_below_policy" || failure.QualityShortfall != "codec aac is lossy" {
+		t.Fatalf("unexpected failure response: %+v", failure)
+	}
+}
+
+func TestDownloadQualityGateKeepsAndRestoresFallback(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "track.flac")
+	writeQualityGateFile(t, path)
+
+	gate := &downloadQualityGate{
+		policy: DownloadQualityPolicy{
+			Enabled:      true,
+			Action:       DownloadQualityActionKeep,
+			Requirements: map[string]DownloadQualityRequirement{"*": {MinBitDepth: 24}},
+		},
+		rejected: make(map[string]bool),
+	}
+	resp := &DownloadResponse{Success: true, FilePath: path, AudioCodec: "flac", ActualBitDepth: 16, Service: "ext-a"}
+	if gate.accept(DownloadRequest{}, "ext-a", "", resp) {
+		t.Fatal("16-bit should be rejected")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("kept file should be parked away from the output path, stat err = %v", err)
+	}
+
+	restored := gate.restoreKept()
+	if restored == nil || restored.FilePath != path || restored.QualityShortfall != "16-bit below 24-bit" {
+		t.Fatalf("unexpected restored response: %+v", restored)
+	}
+	if len(restored.QualityRejections) != 1 || !restored.QualityRejections[0].Kept {
+		t.Fatalf("rejection should be recorded as kept: %+v", restored.QualityRejections)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("restored file missing: %v", err)
+	}
+}
+
+func TestDownloadQualityGateFinishDropsParkedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "track.flac")
+	writeQualityGateFile(t, path)
+
+	gate := &downloadQualityGate{
+		policy: DownloadQualityPolicy{
+			Enabled:      true,
+			Action:       DownloadQualityActionKeep,
+			Requirements: map[string]DownloadQualityRequirement{"*": {MinSampleRate: 96000}},
+		},
+		rejected: make(map[string]bool),
+	}
+	gate.accept(DownloadRequest{}, "ext-a", "", &DownloadResponse{Success: true, FilePath: path, ActualSampleRate: 44100})
+
+	better := &DownloadResponse{Success: true, FilePath: filepath.Join(dir, "better.flac"), ActualSampleRate: 96000}
+	if !gate.accept(DownloadRequest{}, "ext-b", "", better) {
+		t.Fatal("96 kHz should be accepted")
+	}
+	gate.finish(better)
+
+	if len(better.QualityRejections) != 1 || better.QualityRejections[0].Service != "ext-a" {
+		t.Fatalf("final response should record the rejection: %+v", better.QualityRejections)
+	}
+	if _, err := os.Stat(path + ".quality-fallback"); !os.IsNotExist(err) {
+		t.Fatalf("parked file should be removed, stat err = %v", err)
+	}
+}
@@ -0,0 +1,568 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The download queue lets the Go side schedule DownloadByStrategy calls on
+// its own: items are persisted to disk, run under global and per-provider
+// concurrency limits, and retried with backoff. Progress goes through the
+// usual ItemProgress records, so GetAllDownloadProgressDelta covers queued
+// items as well.
+
+const (
+	DownloadQueueStatusQueued    = "queued"
+	DownloadQueueStatusRunning   = "running"
+	DownloadQueueStatusPaused    = "paused"
+	DownloadQueueStatusRetrying  = "retrying"
+	DownloadQueueStatusCompleted = "completed"
+	DownloadQueueStatusFailed    = "failed"
+
+	downloadQueueFileName        = "download_queue.json"
+	defaultQueueMaxConcurrent    = 3
+	defaultQueueMaxAttempts      = 3
+	defaultQueueRetryBaseSeconds = 5
+	maxQueueRetryDelaySeconds    = 300
+	defaultQueueProviderKey      = "default"
+)
+
+// downloadQueueRetryableErrors are the ErrorType values worth another
+// attempt; everything else fails the item straight away.
+var downloadQueueRetryableErrors = map[string]bool{
+	"rate_limit": true,
+	"network":    true,
+	"unknown":    true,
+}
+
+type DownloadQueueItem struct {
+	ItemID        string            `json:"item_id"`
+	Request       DownloadRequest   `json:"request"`
+	Status        string            `json:"status"`
+	Attempts      int               `json:"attempts"`
+	NextAttemptAt int64             `json:"next_attempt_at,omitempty"` // unix ms
+	LastError     string            `json:"last_error,omitempty"`
+	LastErrorType string            `json:"last_error_type,omitempty"`
+	Result        *DownloadResponse `json:"result,omitempty"`
+	AddedAt       int64             `json:"added_at"`
+	UpdatedAt     int64             `json:"updated_at"`
+}
+
+type DownloadQueueLimits struct {
+	MaxConcurrent int            `json:"max_concurrent"`
+	PerProvider   map[string]int `json:"per_provider,omitempty"`
+	MaxAttempts   int            `json:"max_attempts"`
+}
+
+type downloadQueueFile struct {
+	Limits DownloadQueueLimits  `json:"limits"`
+	Items  []*DownloadQueueItem `json:"items"`
+}
+
+type downloadQueue struct {
+	mu      sync.Mutex
+	path    string
+	limits  DownloadQueueLimits
+	items   []*DownloadQueueItem
+	running map[string]int // provider -> running items
+	active  int
+	// inflight holds items whose download call has not returned yet, which
+	// can outlive the running status after a pause or remove.
+	inflight map[string]bool
+	started  bool
+	wake     chan struct{}
+	stop     chan struct{}
+	workers  sync.WaitGroup
+
+	// download and retryUnit are swapped out by tests.
+	download  func(DownloadRequest) DownloadResponse
+	retryUnit time.Duration
+}
+
+var (
+	globalDownloadQueue     *downloadQueue
+	globalDownloadQueueOnce sync.Once
+)
+
+func getDownloadQueue() *downloadQueue {
+	globalDownloadQueueOnce.Do(func() {
+		globalDownloadQueue = newDownloadQueue()
+	})
+	return globalDownloadQueue
+}
+
+func newDownloadQueue() *downloadQueue {
+	return &downloadQueue{
+		limits: DownloadQueueLimits{
+			MaxConcurrent: defaultQueueMaxConcurrent,
+			MaxAttempts:   defaultQueueMaxAttempts,
+		},
+		running:   make(map[string]int),
+		inflight:  make(map[string]bool),
+		wake:      make(chan struct{}, 1),
+		download:  runQueuedDownload,
+		retryUnit: time.Second,
+	}
+}
+
+func runQueuedDownload(req DownloadRequest) DownloadResponse {
+	reqJSON, err := json.Marshal(req)
+	if err != nil {
+		return DownloadResponse{Error: err.Error(), ErrorType: "unknown"}
+	}
+	respJSON, err := DownloadByStrategy(string(reqJSON))
+	if err != nil {
+		return DownloadResponse{Error: err.Error(), ErrorType: classifyDownloadErrorType(err.Error())}
+	}
+	var resp DownloadResponse
+	if err := json.Unmarshal([]byte(respJSON), &resp); err != nil {
+		return DownloadResponse{Error: "invalid download response: " + err.Error(), ErrorType: "unknown"}
+	}
+	return resp
+}
+
+func queueProviderKey(req DownloadRequest) string {
+	if service := strings.ToLower(strings.TrimSpace(req.Service)); service != "" {
+		return service
+	}
+	return defaultQueueProviderKey
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// load restores the queue from dataDir. Items that were running when the
+// process died go back to queued; resumable downloads continue from their
+// partial files.
+func (q *downloadQueue) load(dataDir string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create queue directory: %w", err)
+	}
+	q.path = filepath.Join(dataDir, downloadQueueFileName)
+
+	data, err := os.ReadFile(q.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read download queue: %w", err)
+	}
+	var file downloadQueueFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse download queue: %w", err)
+	}
+
+	if file.Limits.MaxConcurrent > 0 {
+		q.limits = file.Limits
+	}
+	known := make(map[string]bool, len(q.items))
+	for _, item := range q.items {
+		known[item.ItemID] = true
+	}
+	for _, item := range file.Items {
+		if item == nil || item.ItemID == "" || known[item.ItemID] {
+			continue
+		}
+		if item.Status == DownloadQueueStatusRunning {
+			item.Status = DownloadQueueStatusQueued
+		}
+		q.items = append(q.items, item)
+		q.reportStatusLocked(item)
+	}
+	GoLog("[Queue] Restored %d items from %s\n", len(file.Items), q.path)
+	return nil
+}
+
+func (q *downloadQueue) saveLocked() {
+	if q.path == "" {
+		return
+	}
+	data, err := json.Marshal(downloadQueueFile{Limits: q.limits, Items: q.items})
+	if err != nil {
+		GoLog("[Queue] Failed to encode queue: %v\n", err)
+		return
+	}
+	tmpPath := q.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		GoLog("[Queue] Failed to save queue: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmpPath, q.path); err != nil {
+		GoLog("[Queue] Failed to save queue: %v\n", err)
+	}
+}
+
+func (q *downloadQueue) findLocked(itemID string) (int, *DownloadQueueItem) {
+	for i, item := range q.items {
+		if item.ItemID == itemID {
+			return i, item
+		}
+	}
+	return -1, nil
+}
+
+// reportStatusLocked mirrors the queue state into the item's progress
+// record. Running items report their own progress while downloading.
+func (q *downloadQueue) reportStatusLocked(item *DownloadQueueItem) {
+	switch item.Status {
+	case DownloadQueueStatusCompleted:
+		SetItemProgressStatus(item.ItemID, itemProgressStatusCompleted)
+	case DownloadQueueStatusQueued, DownloadQueueStatusPaused, DownloadQueueStatusRetrying, DownloadQueueStatusFailed:
+		SetItemProgressStatus(item.ItemID, item.Status)
+	}
+}
+
+func (q *downloadQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *downloadQueue) enqueue(reqs []DownloadRequest) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	added := 0
+	now := nowMillis()
+	for _, req := range reqs {
+		itemID := strings.TrimSpace(req.ItemID)
+		if itemID == "" {
+			return added, fmt.Errorf("item_id is required for queued downloads")
+		}
+		if _, existing := q.findLocked(itemID); existing != nil {
+			if existing.Status != DownloadQueueStatusCompleted && existing.Status != DownloadQueueStatusFailed {
+				continue
+			}
+			q.removeLocked(itemID)
+		}
+		item := &DownloadQueueItem{
+			ItemID:    itemID,
+			Request:   req,
+			Status:    DownloadQueueStatusQueued,
+			AddedAt:   now,
+			UpdatedAt: now,
+		}
+		q.items = append(q.items, item)
+		q.reportStatusLocked(item)
+		added++
+	}
+	q.saveLocked()
+	q.signal()
+	return added, nil
+}
+
+// reorder moves the listed items to the front in the given order; the rest
+// keep their relative order behind them.
+func (q *downloadQueue) reorder(itemIDs []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	picked := make(map[string]bool, len(itemIDs))
+	ordered := make([]*DownloadQueueItem, 0, len(q.items))
+	for _, id := range itemIDs {
+		if _, item := q.findLocked(id); item != nil && !picked[id] {
+			picked[id] = true
+			ordered = append(ordered, item)
+		}
+	}
+	for _, item := range q.items {
+		if !picked[item.ItemID] {
+			ordered = append(ordered, item)
+		}
+	}
+	q.items = ordered
+	q.saveLocked()
+	q.signal()
+}
+
+// pause holds an item back from scheduling. A running item is suspended in
+// place and keeps its slot, so resuming it does not resolve the track again.
+func (q *downloadQueue) pause(itemID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, item := q.findLocked(itemID)
+	if item == nil {
+		return fmt.Errorf("item %s is not queued", itemID)
+	}
+	switch item.Status {
+	case DownloadQueueStatusCompleted, DownloadQueueStatusFailed, DownloadQueueStatusPaused:
+		return nil
+	case DownloadQueueStatusRunning:
+		pauseDownload(itemID)
+	}
+	item.Status = DownloadQueueStatusPaused
+	item.UpdatedAt = nowMillis()
+	if !q.inflight[itemID] {
+		q.reportStatusLocked(item)
+	}
+	q.saveLocked()
+	return nil
+}
+
+func (q *downloadQueue) resume(itemID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	_, item := q.findLocked(itemID)
+	if item == nil {
+		return fmt.Errorf("item %s is not queued", itemID)
+	}
+	if item.Status != DownloadQueueStatusPaused && item.Status != DownloadQueueStatusFailed {
+		return nil
+	}
+	if q.inflight[itemID] {
+		item.Status = DownloadQueueStatusRunning
+		item.UpdatedAt = nowMillis()
+		resumeDownload(itemID)
+		q.saveLocked()
+		return nil
+	}
+	item.Status = DownloadQueueStatusQueued
+	item.NextAttemptAt = 0
+	if item.Attempts >= q.limits.MaxAttempts {
+		item.Attempts = 0
+	}
+	item.UpdatedAt = nowMillis()
+	q.reportStatusLocked(item)
+	q.saveLocked()
+	q.signal()
+	return nil
+}
+
+func (q *downloadQueue) remove(itemID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.inflight[itemID] {
+		cancelDownload(itemID)
+	}
+	q.removeLocked(itemID)
+	RemoveItemProgress(itemID)
+	q.saveLocked()
+}
+
+func (q *downloadQueue) removeLocked(itemID string) {
+	if i, _ := q.findLocked(itemID); i >= 0 {
+		q.items = append(q.items[:i], q.items[i+1:]...)
+	}
+}
+
+// clearFinished drops completed items, and failed ones too if asked.
+func (q *downloadQueue) clearFinished(includeFailed bool) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	kept := q.items[:0]
+	removed := 0
+	for _, item := range q.items {
+		if item.Status == DownloadQueueStatusCompleted || (includeFailed && item.Status == DownloadQueueStatusFailed) {
+			RemoveItemProgress(item.ItemID)
+			removed++
+			continue
+		}
+		kept = append(kept, item)
+	}
+	q.items = kept
+	q.saveLocked()
+	return removed
+}
+
+func (q *downloadQueue) setLimits(limits DownloadQueueLimits) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if limits.MaxConcurrent <= 0 {
+		limits.MaxConcurrent = defaultQueueMaxConcurrent
+	}
+	if limits.MaxAttempts <= 0 {
+		limits.MaxAttempts = defaultQueueMaxAttempts
+	}
+	normalized := make(map[string]int, len(limits.PerProvider))
+	for provider, limit := range limits.PerProvider {
+		if provider = strings.ToLower(strings.TrimSpace(provider)); provider != "" && limit > 0 {
+			normalized[provider] = limit
+		}
+	}
+	limits.PerProvider = normalized
+	q.limits = limits
+	q.saveLocked()
+	q.signal()
+}
+
+func (q *downloadQueue) snapshot() downloadQueueFile {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]*DownloadQueueItem, len(q.items))
+	for i, item := range q.items {
+		cloned := *item
+		items[i] = &cloned
+	}
+	return downloadQueueFile{Limits: q.limits, Items: items}
+}
+
+func (q *downloadQueue) start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.started {
+		return
+	}
+	q.started = true
+	q.stop = make(chan struct{})
+	q.workers.Add(1)
+	go q.loop(q.stop)
+	GoLog("[Queue] Scheduler started\n")
+}
+
+// halt stops scheduling new items. Running downloads finish normally.
+func (q *downloadQueue) halt() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.started {
+		return
+	}
+	q.started = false
+	close(q.stop)
+	GoLog("[Queue] Scheduler stopped\n")
+}
+
+func (q *downloadQueue) loop(stop chan struct{}) {
+	defer q.workers.Done()
+	for {
+		wait := q.schedule()
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// schedule starts every item allowed by the limits and returns how long to
+// sleep until the next retry becomes due.
+func (q *downloadQueue) schedule() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wait := time.Hour
+	now := nowMillis()
+	started := false
+	for _, item := range q.items {
+		if q.active >= q.limits.MaxConcurrent {
+			break
+		}
+		switch item.Status {
+		case DownloadQueueStatusQueued:
+		case DownloadQueueStatusRetrying:
+			if item.NextAttemptAt > now {
+				wait = min(wait, time.Duration(item.NextAttemptAt-now)*time.Millisecond)
+				continue
+			}
+		default:
+			continue
+		}
+		if q.inflight[item.ItemID] {
+			continue
+		}
+
+		provider := queueProviderKey(item.Request)
+		if limit := q.limits.PerProvider[provider]; limit > 0 && q.running[provider] >= limit {
+			continue
+		}
+
+		item.Status = DownloadQueueStatusRunning
+		item.Attempts++
+		item.UpdatedAt = now
+		q.active++
+		q.running[provider]++
+		q.inflight[item.ItemID] = true
+		resetDownloadCancel(item.ItemID)
+		StartItemProgress(item.ItemID)
+		q.workers.Add(1)
+		go q.run(item.ItemID, item.Request, provider)
+		started = true
+	}
+	if started {
+		q.saveLocked()
+	}
+	return wait
+}
+
+func (q *downloadQueue) run(itemID string, req DownloadRequest, provider string) {
+	defer q.workers.Done()
+	resp := q.download(req)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.active--
+	q.running[provider]--
+	delete(q.inflight, itemID)
+	releaseDownloadPause(itemID)
+	defer q.signal()
+
+	_, item := q.findLocked(itemID)
+	if item == nil {
+		// Removed while it was running.
+		q.saveLocked()
+		return
+	}
+	// A pause that came after the last write does not hold the download
+	// back, so its result still counts.
+	if item.Status != DownloadQueueStatusRunning && item.Status != DownloadQueueStatusPaused {
+		q.saveLocked()
+		return
+	}
+
+	item.UpdatedAt = nowMillis()
+	if resp.Success {
+		item.Status = DownloadQueueStatusCompleted
+		item.Result = &resp
+		item.LastError, item.LastErrorType = "", ""
+		CompleteItemProgress(itemID)
+		q.saveLocked()
+		return
+	}
+
+	errorType := resp.ErrorType
+	if errorType == "" {
+		errorType = classifyDownloadErrorType(resp.Error)
+	}
+	item.LastError, item.LastErrorType = resp.Error, errorType
+	if item.Status == DownloadQueueStatusPaused {
+		// Paused mid-flight: keep the pause and let resume decide whether
+		// to try again.
+		GoLog("[Queue] %s failed while paused: %s\n", itemID, resp.Error)
+	} else if !downloadQueueRetryableErrors[errorType] || item.Attempts >= q.limits.MaxAttempts {
+		item.Status = DownloadQueueStatusFailed
+		item.Result = &resp
+		GoLog("[Queue] %s failed after %d attempts: %s\n", itemID, item.Attempts, resp.Error)
+	} else {
+		delay := resp.RetryAfterSeconds
+		if delay <= 0 {
+			delay = min(defaultQueueRetryBaseSeconds<<(item.Attempts-1), maxQueueRetryDelaySeconds)
+		}
+		item.Status = DownloadQueueStatusRetrying
+		item.NextAttemptAt = item.UpdatedAt + (time.Duration(delay) * q.retryUnit).Milliseconds()
+		GoLog("[Queue] %s attempt %d failed (%s), retrying in %ds\n", itemID, item.Attempts, errorType, delay)
+	}
+	q.reportStatusLocked(item)
+	q.saveLocked()
+}
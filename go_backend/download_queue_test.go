@@ -0,0 +1,292 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+type queueTestDownloader struct {
+	mu      sync.Mutex
+	started []string
+	release map[string]chan DownloadResponse
+}
+
+func newQueueTestDownloader() *queueTestDownloader {
+	return &queueTestDownloader{
+		release: make(map[string]chan DownloadResponse),
+	}
+}
+
+func (d *queueTestDownloader) channel(itemID string) chan DownloadResponse {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ch, ok := d.release[itemID]
+	if !ok {
+		ch = make(chan DownloadResponse, 4)
+		d.release[itemID] = ch
+	}
+	return ch
+}
+
+func (d *queueTestDownloader) download(req DownloadRequest) DownloadResponse {
+	d.mu.Lock()
+	d.started = append(d.started, req.ItemID)
+	d.mu.Unlock()
+	return <-d.channel(req.ItemID)
+}
+
+func (d *queueTestDownloader) startedItems() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.started...)
+}
+
+func newTestDownloadQueue(t *testing.T, dir string, downloader *queueTestDownloader) *downloadQueue {
+	t.Helper()
+	q := newDownloadQueue()
+	q.download = downloader.download
+	q.retryUnit = time.Millisecond
+	if err := q.load(dir); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	t.Cleanup(func() {
+		q.halt()
+		q.workers.Wait()
+	})
+	return q
+}
+
+func waitForQueue(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func queueItemStatus(q *downloadQueue, itemID string) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, item := q.findLocked(itemID); item != nil {
+		return item.Status
+	}
+	return ""
+}
+
+func cleanupQueueTestItems(t *testing.T, itemIDs ...string) {
+	t.Cleanup(func() {
+		for _, id := range itemIDs {
+			RemoveItemProgress(id)
+			cancelMu.Lock()
+			delete(cancelMap, id)
+			cancelMu.Unlock()
+		}
+	})
+}
+
+func TestDownloadQueueSchedulingAndLimits(t *testing.T) {
+	cleanupQueueTestItems(t, "q-a", "q-b", "q-c", "q-d")
+	downloader := newQueueTestDownloader()
+	q := newTestDownloadQueue(t, t.TempDir(), downloader)
+	q.setLimits(DownloadQueueLimits{MaxConcurrent: 2, PerProvider: map[string]int{"Tidal": 1}})
+
+	if _, err := q.enqueue([]DownloadRequest{{ItemID: ""}}); err == nil {
+		t.Fatal("expected error for missing item_id")
+	}
+	_, err := q.enqueue([]DownloadRequest{
+		{ItemID: "q-a", Service: "tidal"},
+		{ItemID: "q-b", Service: "tidal"},
+		{ItemID: "q-c", Service: "qobuz"},
+		{ItemID: "q-d", Service: "qobuz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.reorder([]string{"q-d", "missing"})
+	q.start()
+
+	// q-d goes first, q-a fills the second slot and q-b waits on the
+	// tidal limit, so q-c is next once q-d finishes.
+	waitForQueue(t, "first two downloads", func() bool { return len(downloader.startedItems()) == 2 })
+	if got := downloader.startedItems(); !(got[0] == "q-d" && got[1] == "q-a") && !(got[0] == "q-a" && got[1] == "q-d") {
+		t.Fatalf("started = %v", got)
+	}
+	if status := queueItemStatus(q, "q-b"); status != DownloadQueueStatusQueued {
+		t.Fatalf("q-b status = %s", status)
+	}
+	downloader.channel("q-d") <- DownloadResponse{Success: true, FilePath: "/music/d.flac"}
+	waitForQueue(t, "q-c", func() bool { return len(downloader.startedItems()) == 3 })
+	if got := downloader.startedItems()[2]; got != "q-c" {
+		t.Fatalf("third download = %s", got)
+	}
+	downloader.channel("q-a") <- DownloadResponse{Success: true}
+	downloader.channel("q-b") <- DownloadResponse{Success: true}
+	downloader.channel("q-c") <- DownloadResponse{Success: true}
+	for _, id := range []string{"q-a", "q-b", "q-c", "q-d"} {
+		waitForQueue(t, id+" completed", func() bool { return queueItemStatus(q, id) == DownloadQueueStatusCompleted })
+	}
+
+	var progress ItemProgress
+	if err := json.Unmarshal([]byte(GetItemProgress("q-d")), &progress); err != nil || progress.Status != itemProgressStatusCompleted {
+		t.Fatalf("q-d progress = %+v (%v)", progress, err)
+	}
+	if removed := q.clearFinished(false); removed != 4 || len(q.snapshot().Items) != 0 {
+		t.Fatalf("clearFinished removed %d", removed)
+	}
+}
+
+func TestDownloadQueueRetries(t *testing.T) {
+	cleanupQueueTestItems(t, "q-retry", "q-missing", "q-flaky")
+	downloader := newQueueTestDownloader()
+	q := newTestDownloadQueue(t, t.TempDir(), downloader)
+	q.setLimits(DownloadQueueLimits{MaxConcurrent: 3, MaxAttempts: 2})
+
+	downloader.channel("q-retry") <- DownloadResponse{Error: "rate limited", ErrorType: "rate_limit", RetryAfterSeconds: 20}
+	downloader.channel("q-retry") <- DownloadResponse{Success: true}
+	downloader.channel("q-missing") <- DownloadResponse{Error: "track not found", ErrorType: "not_found"}
+	downloader.channel("q-flaky") <- DownloadResponse{Error: "connection reset"}
+	downloader.channel("q-flaky") <- DownloadResponse{Error: "connection reset"}
+	q.enqueue([]DownloadRequest{{ItemID: "q-retry"}, {ItemID: "q-missing"}, {ItemID: "q-flaky"}})
+	q.start()
+
+	waitForQueue(t, "retry completed", func() bool { return queueItemStatus(q, "q-retry") == DownloadQueueStatusCompleted })
+	waitForQueue(t, "not_found failed", func() bool { return queueItemStatus(q, "q-missing") == DownloadQueueStatusFailed })
+	waitForQueue(t, "flaky failed", func() bool { return queueItemStatus(q, "q-flaky") == DownloadQueueStatusFailed })
+
+	for _, item := range q.snapshot().Items {
+		switch item.ItemID {
+		case "q-retry":
+			if item.Attempts != 2 || item.LastError != "" {
+				t.Fatalf("q-retry = %+v", item)
+			}
+		case "q-missing":
+			if item.Attempts != 1 || item.LastErrorType != "not_found" {
+				t.Fatalf("q-missing = %+v", item)
+			}
+		case "q-flaky":
+			// The error type is classified from the message when missing.
+			if item.Attempts != 2 || item.LastErrorType != "network" {
+				t.Fatalf("q-flaky = %+v", item)
+			}
+		}
+	}
+
+	// Resuming a failed item gives it a fresh set of attempts.
+	downloader.channel("q-flaky") <- DownloadResponse{Success: true}
+	if err := q.resume("q-flaky"); err != nil {
+		t.Fatal(err)
+	}
+	waitForQueue(t, "flaky completed", func() bool { return queueItemStatus(q, "q-flaky") == DownloadQueueStatusCompleted })
+}
+
+func TestDownloadQueueFailureWhilePausedKeepsPause(t *testing.T) {
+	cleanupQueueTestItems(t, "q-paused-fail")
+	downloader := newQueueTestDownloader()
+	q := newTestDownloadQueue(t, t.TempDir(), downloader)
+	q.enqueue([]DownloadRequest{{ItemID: "q-paused-fail"}})
+	q.start()
+	waitForQueue(t, "download to start", func() bool { return len(downloader.startedItems()) == 1 })
+
+	if err := q.pause("q-paused-fail"); err != nil {
+		t.Fatal(err)
+	}
+	downloader.channel("q-paused-fail") <- DownloadResponse{Error: "connection reset"}
+	waitForQueue(t, "download to return", func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return !q.inflight["q-paused-fail"]
+	})
+	item := q.snapshot().Items[0]
+	if item.Status != DownloadQueueStatusPaused || item.LastErrorType != "network" {
+		t.Fatalf("item = %+v, want paused with the failure recorded", item)
+	}
+
+	downloader.channel("q-paused-fail") <- DownloadResponse{Success: true}
+	if err := q.resume("q-paused-fail"); err != nil {
+		t.Fatal(err)
+	}
+	waitForQueue(t, "resumed completed", func() bool { return queueItemStatus(q, "q-paused-fail") == DownloadQueueStatusCompleted })
+}
+
+func TestDownloadQueuePauseRemoveAndPersistence(t *testing.T) {
+	cleanupQueueTestItems(t, "q-pause", "q-remove", "q-later", "q-next")
+	dir := t.TempDir()
+	downloader := newQueueTestDownloader()
+	q := newTestDownloadQueue(t, dir, downloader)
+	q.setLimits(DownloadQueueLimits{MaxConcurrent: 2, PerProvider: map[string]int{"deezer": 1}})
+	q.enqueue([]DownloadRequest{
+		{ItemID: "q-pause", Service: "tidal"},
+		{ItemID: "q-remove", Service: "deezer"},
+		{ItemID: "q-later", Service: "deezer"},
+		{ItemID: "q-next", Service: "tidal"},
+	})
+	q.start()
+	waitForQueue(t, "downloads to start", func() bool { return len(downloader.startedItems()) == 2 })
+
+	// Pausing a running item suspends it in place; it keeps its slot.
+	if err := q.pause("q-pause"); err != nil {
+		t.Fatal(err)
+	}
+	if !isDownloadPaused("q-pause") || isDownloadCancelled("q-pause") {
+		t.Fatal("running item was not paused in place")
+	}
+	var progress ItemProgress
+	json.Unmarshal([]byte(GetItemProgress("q-pause")), &progress)
+	if progress.Status != itemProgressStatusPaused {
+		t.Fatalf("q-pause progress = %+v", progress)
+	}
+
+	q.remove("q-remove")
+	if !isDownloadCancelled("q-remove") || GetItemProgress("q-remove") != "{}" {
+		t.Fatal("removed item was not cancelled")
+	}
+	downloader.channel("q-remove") <- DownloadResponse{Error: "download cancelled", ErrorType: "cancelled"}
+	waitForQueue(t, "q-later", func() bool { return len(downloader.startedItems()) == 3 })
+	if got := downloader.startedItems()[2]; got != "q-later" {
+		t.Fatalf("third download = %s", got)
+	}
+
+	if err := q.resume("q-pause"); err != nil {
+		t.Fatal(err)
+	}
+	if isDownloadPaused("q-pause") || queueItemStatus(q, "q-pause") != DownloadQueueStatusRunning {
+		t.Fatal("resumed item is still paused")
+	}
+	downloader.channel("q-pause") <- DownloadResponse{Success: true}
+	waitForQueue(t, "q-next", func() bool { return len(downloader.startedItems()) == 4 })
+
+	// A restart keeps paused items paused and puts running ones back in
+	// the queue.
+	q.pause("q-later")
+	q.halt()
+	restored := newTestDownloadQueue(t, dir, newQueueTestDownloader())
+	snapshot := restored.snapshot()
+	if len(snapshot.Items) != 3 || snapshot.Limits.MaxConcurrent != 2 || snapshot.Limits.PerProvider["deezer"] != 1 {
+		t.Fatalf("restored queue = %+v", snapshot)
+	}
+	statuses := map[string]string{}
+	for _, item := range snapshot.Items {
+		statuses[item.ItemID] = item.Status
+	}
+	if statuses["q-pause"] != DownloadQueueStatusCompleted || statuses["q-later"] != DownloadQueueStatusPaused || statuses["q-next"] != DownloadQueueStatusQueued {
+		t.Fatalf("restored statuses = %v", statuses)
+	}
+	downloader.channel("q-later") <- DownloadResponse{Success: true}
+	downloader.channel("q-next") <- DownloadResponse{Success: true}
+	q.workers.Wait()
+
+	restoredDownloader := newQueueTestDownloader()
+	restored.download = restoredDownloader.download
+	restoredDownloader.channel("q-later") <- DownloadResponse{Success: true}
+	restoredDownloader.channel("q-next") <- DownloadResponse{Success: true}
+	restored.resume("q-later")
+	restored.start()
+	for _, id := range []string{"q-later", "q-next"} {
+		waitForQueue(t, id+" completed", func() bool { return queueItemStatus(restored, id) == DownloadQueueStatusCompleted })
+	}
+}
@@ -0,0 +1,279 @@
+package gobackend
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A partial download keeps a sidecar next to the output file recording what
+// has been written and which remote representation it came from, so the
+// next attempt (even after the app was killed) can continue with Range and
+// If-Range instead of starting from byte 0.
+
+const (
+	downloadResumeSuffix  = ".resume"
+	downloadResumeVersion = 1
+	// downloadResumeSaveInterval bounds how much finished data can be lost
+	// when the process dies between sidecar updates.
+	downloadResumeSaveInterval = 1024 * 1024
+)
+
+type downloadResumeRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type downloadResumeState struct {
+	Version int `json:"version"`
+	// URLFingerprint ignores the query string, which usually carries
+	// short-lived CDN tokens; URLHash covers the whole URL.
+	URLFingerprint string                `json:"url_fingerprint"`
+	URLHash        string                `json:"url_hash"`
+	ETag           string                `json:"etag,omitempty"`
+	LastModified   string                `json:"last_modified,omitempty"`
+	TotalSize      int64                 `json:"total_size,omitempty"`
+	BytesWritten   int64                 `json:"bytes_written"`
+	Chunks         []downloadResumeRange `json:"chunks,omitempty"`
+	UpdatedAt      string                `json:"updated_at"`
+
+	path string
+}
+
+func downloadResumeSidecarPath(path string) string {
+	return path + downloadResumeSuffix
+}
+
+func downloadURLFingerprints(rawURL string) (string, string) {
+	full := sha256.Sum256([]byte(rawURL))
+	stable := rawURL
+	if parsed, err := url.Parse(rawURL); err == nil {
+		stable = parsed.Scheme + "://" + strings.ToLower(parsed.Host) + parsed.EscapedPath()
+	}
+	withoutQuery := sha256.Sum256([]byte(stable))
+	return hex.EncodeToString(withoutQuery[:]), hex.EncodeToString(full[:])
+}
+
+func newDownloadResumeState(path, rawURL string) *downloadResumeState {
+	fingerprint, urlHash := downloadURLFingerprints(rawURL)
+	return &downloadResumeState{
+		Version:        downloadResumeVersion,
+		URLFingerprint: fingerprint,
+		URLHash:        urlHash,
+		path:           path,
+	}
+}
+
+// loadDownloadResumeState returns the sidecar of path when it belongs to
+// rawURL and the partial file still holds the recorded bytes. Without an
+// ETag or Last-Modified to validate against, only the exact same URL may
+// resume.
+func loadDownloadResumeState(path, rawURL string) *downloadResumeState {
+	data, err := os.ReadFile(downloadResumeSidecarPath(path))
+	if err != nil {
+		return nil
+	}
+	var state downloadResumeState
+	if err := json.Unmarshal(data, &state); err != nil || state.Version != downloadResumeVersion {
+		GoLog("[Resume] Ignoring unreadable resume state for %s\n", path)
+		return nil
+	}
+	fingerprint, urlHash := downloadURLFingerprints(rawURL)
+	if state.URLFingerprint != fingerprint {
+		return nil
+	}
+	if state.URLHash != urlHash && state.ETag == "" && state.LastModified == "" {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() < state.resumeOffset() {
+		return nil
+	}
+	state.path = path
+	return &state
+}
+
+func (s *downloadResumeState) save() error {
+	s.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	sidecar := downloadResumeSidecarPath(s.path)
+	tmpPath := sidecar + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, sidecar)
+}
+
+func (s *downloadResumeState) clear() {
+	if err := os.Remove(downloadResumeSidecarPath(s.path)); err != nil && !os.IsNotExist(err) {
+		GoLog("[Resume] Failed to remove resume state for %s: %v\n", s.path, err)
+	}
+}
+
+// markWritten records [start, end) as on disk, merging adjacent ranges.
+func (s *downloadResumeState) markWritten(start, end int64) {
+	if end <= start {
+		return
+	}
+	chunks := append(s.Chunks, downloadResumeRange{Start: start, End: end})
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Start < chunks[j].Start })
+	merged := chunks[:1]
+	for _, c := range chunks[1:] {
+		last := &merged[len(merged)-1]
+		if c.Start <= last.End {
+			last.End = max(last.End, c.End)
+			continue
+		}
+		merged = append(merged, c)
+	}
+	s.Chunks = merged
+	s.BytesWritten = 0
+	for _, c := range merged {
+		s.BytesWritten += c.End - c.Start
+	}
+}
+
+// resumeOffset is the length of the contiguous prefix already on disk.
+func (s *downloadResumeState) resumeOffset() int64 {
+	if len(s.Chunks) == 0 || s.Chunks[0].Start != 0 {
+		return 0
+	}
+	return s.Chunks[0].End
+}
+
+func (s *downloadResumeState) setValidators(header http.Header) {
+	s.ETag = header.Get("ETag")
+	s.LastModified = header.Get("Last-Modified")
+}
+
+// matchesValidators reports whether a fresh response describes the same
+// remote file as the recorded one.
+func (s *downloadResumeState) matchesValidators(header http.Header) bool {
+	if s.ETag != "" {
+		return header.Get("ETag") == s.ETag
+	}
+	if s.LastModified != "" {
+		return header.Get("Last-Modified") == s.LastModified
+	}
+	return true
+}
+
+// ifRange returns the If-Range value for a resumed request. Weak ETags are
+// not allowed there, so they fall back to Last-Modified.
+func (s *downloadResumeState) ifRange() string {
+	if s.ETag != "" && !strings.HasPrefix(s.ETag, "W/") {
+		return s.ETag
+	}
+	return s.LastModified
+}
+
+// parseContentRange parses "bytes start-end/total"; total is -1 when the
+// server sends "*".
+func parseContentRange(value string) (start, end, total int64, ok bool) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "bytes ") {
+		return 0, 0, 0, false
+	}
+	var totalStr string
+	if _, err := fmt.Sscanf(strings.Replace(value[6:], "/", " ", 1), "%d-%d %s", &start, &end, &totalStr); err != nil {
+		return 0, 0, 0, false
+	}
+	total = -1
+	if totalStr != "*" {
+		if _, err := fmt.Sscanf(totalStr, "%d", &total); err != nil {
+			return 0, 0, 0, false
+		}
+	}
+	return start, end, total, end >= start
+}
+
+// openResumableOutput opens path for writing at offset, truncating anything
+// past it. An offset of 0 is a fresh download.
+func openResumableOutput(path string, offset int64) (*os.File, error) {
+	if offset <= 0 {
+		return os.Create(path)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// resumeTrackingWriter records sequential writes in the resume state and
+// saves the sidecar every downloadResumeSaveInterval bytes.
+type resumeTrackingWriter struct {
+	writer  interface{ Write([]byte) (int, error) }
+	state   *downloadResumeState
+	pos     int64
+	unsaved int64
+}
+
+func (w *resumeTrackingWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	if n > 0 {
+		w.state.markWritten(w.pos, w.pos+int64(n))
+		w.pos += int64(n)
+		w.unsaved += int64(n)
+		if w.unsaved >= downloadResumeSaveInterval {
+			if saveErr := w.state.save(); saveErr != nil {
+				GoLog("[Resume] Failed to save resume state for %s: %v\n", w.state.path, saveErr)
+			}
+			w.unsaved = 0
+		}
+	}
+	return n, err
+}
+
+// DiscardPartialDownload removes a partial download and its resume state,
+// for when the user gives up on an interrupted item.
+func DiscardPartialDownload(filePath string) error {
+	if _, err := os.Stat(downloadResumeSidecarPath(filePath)); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove partial download: %w", err)
+	}
+	if err := os.Remove(downloadResumeSidecarPath(filePath)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove resume state: %w", err)
+	}
+	return nil
+}
+
+// missingRanges lists the parts of [0, total) not yet on disk.
+func (s *downloadResumeState) missingRanges(total int64) []downloadResumeRange {
+	var missing []downloadResumeRange
+	var pos int64
+	for _, c := range s.Chunks {
+		if c.Start > pos {
+			missing = append(missing, downloadResumeRange{Start: pos, End: min(c.Start, total)})
+		}
+		pos = max(pos, c.End)
+	}
+	if pos < total {
+		missing = append(missing, downloadResumeRange{Start: pos, End: total})
+	}
+	return missing
+}
@@ -0,0 +1,310 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// failingReader returns n bytes of data and then fails, like a connection
+// dropped mid-transfer. onFail runs just before the failure.
+type failingReader struct {
+	data   []byte
+	n      int
+	onFail func()
+}
+
+func (fr *failingReader) Read(p []byte) (int, error) {
+	if fr.n <= 0 {
+		if fr.onFail != nil {
+			fr.onFail()
+		}
+		return 0, errors.New("connection reset by peer")
+	}
+	n := copy(p, fr.data[:min(len(fr.data), fr.n)])
+	fr.data, fr.n = fr.data[n:], fr.n-n
+	return n, nil
+}
+
+type resumeTestServer struct {
+	mu       sync.Mutex
+	body     []byte
+	etag     string
+	failAt   int
+	onFail   func()
+	requests []http.Header
+}
+
+func (s *resumeTestServer) roundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req.Header.Clone())
+	header := http.Header{"Etag": []string{s.etag}}
+	var start, end int64 = 0, int64(len(s.body)) - 1
+	status := http.StatusOK
+	if rangeHeader := req.Header.Get("Range"); rangeHeader != "" {
+		if ifRange := req.Header.Get("If-Range"); ifRange == "" || ifRange == s.etag {
+			status = http.StatusPartialContent
+			if _, err := fmt.Sscanf(rangeHeader, "bytes=%d-%d", &start, &end); err != nil {
+				end = int64(len(s.body)) - 1
+			}
+			end = min(end, int64(len(s.body))-1)
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(s.body)))
+		}
+	}
+	data := s.body[start : end+1]
+	var body io.Reader = bytes.NewReader(data)
+	if s.failAt > 0 && start <= int64(s.failAt) && start+int64(len(data)) > int64(s.failAt) {
+		body = &failingReader{data: data, n: s.failAt - int(start), onFail: s.onFail}
+		s.failAt = 0
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Header:        header,
+		Body:          io.NopCloser(body),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+func newResumeTestRuntime(t *testing.T, server *resumeTestServer) *extensionRuntime {
+	t.Helper()
+	runtime := &extensionRuntime{
+		extensionID: "resume-ext",
+		manifest: &ExtensionManifest{
+			Name:    "resume-ext",
+			Version: "1.0.0",
+			Permissions: ExtensionPermissions{
+				File:    true,
+				Network: []string{"cdn.example.com"},
+			},
+		},
+		dataDir:    t.TempDir(),
+		vm:         goja.New(),
+		httpClient: &http.Client{Transport: roundTripFunc(server.roundTrip)},
+	}
+	return runtime
+}
+
+func testResumeBody(size int) []byte {
+	body := make([]byte, size)
+	for i := range body {
+		body[i] = byte(i * 7)
+	}
+	return body
+}
+
+func readTestResumeState(t *testing.T, path string) downloadResumeState {
+	t.Helper()
+	data, err := os.ReadFile(downloadResumeSidecarPath(path))
+	if err != nil {
+		t.Fatalf("resume state: %v", err)
+	}
+	var state downloadResumeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestFileDownloadResumesAfterInterruption(t *testing.T) {
+	server := &resumeTestServer{body: testResumeBody(200000), etag: `"v1"`, failAt: 70000}
+	runtime := newResumeTestRuntime(t, server)
+	path := filepath.Join(runtime.dataDir, "track.flac")
+	download := func(url string) map[string]interface{} {
+		return runtime.fileDownload(goja.FunctionCall{Arguments: []goja.Value{
+			runtime.vm.ToValue(url),
+			runtime.vm.ToValue("track.flac"),
+		}}).Export().(map[string]interface{})
+	}
+
+	if result := download("https://cdn.example.com/track?token=a"); result["success"] != false {
+		t.Fatalf("expected interrupted download, got %#v", result)
+	}
+	state := readTestResumeState(t, path)
+	if state.BytesWritten != 70000 || state.TotalSize != 200000 || state.ETag != `"v1"` {
+		t.Fatalf("resume state = %+v", state)
+	}
+
+	// A fresh token in the query string still resumes thanks to the ETag.
+	result := download("https://cdn.example.com/track?token=b")
+	if result["success"] != true || result["size"] != int64(200000) {
+		t.Fatalf("resumed download = %#v", result)
+	}
+	last := server.requests[len(server.requests)-1]
+	if last.Get("Range") != "bytes=70000-" || last.Get("If-Range") != `"v1"` {
+		t.Fatalf("resume request headers = %v", last)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, server.body) {
+		t.Fatal("resumed file does not match")
+	}
+	if _, err := os.Stat(downloadResumeSidecarPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("resume state left after success: %v", err)
+	}
+
+	// When the remote file changes, If-Range makes the server send all of
+	// it and the partial data is thrown away.
+	server.failAt = 50000
+	download("https://cdn.example.com/track")
+	server.body, server.etag = testResumeBody(120000)[1:], `"v2"`
+	if result := download("https://cdn.example.com/track"); result["success"] != true || result["size"] != int64(len(server.body)) {
+		t.Fatalf("restarted download = %#v", result)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, server.body) {
+		t.Fatal("restarted file does not match")
+	}
+}
+
+// pausingReader pauses itemID once at bytes in, then keeps reading.
+type pausingReader struct {
+	data   []byte
+	at     int
+	itemID string
+}
+
+func (pr *pausingReader) Read(p []byte) (int, error) {
+	if len(pr.data) == 0 {
+		return 0, io.EOF
+	}
+	limit := len(pr.data)
+	if pr.at > 0 {
+		limit = min(limit, pr.at)
+	}
+	n := copy(p, pr.data[:limit])
+	pr.data = pr.data[n:]
+	if pr.at > 0 {
+		if pr.at -= n; pr.at == 0 {
+			pauseDownload(pr.itemID)
+		}
+	}
+	return n, nil
+}
+
+func TestFileDownloadPauseAndResume(t *testing.T) {
+	const itemID = "pause-resume-item"
+	defer func() {
+		RemoveItemProgress(itemID)
+		releaseDownloadPause(itemID)
+	}()
+
+	body := testResumeBody(300000)
+	var mu sync.Mutex
+	requests := 0
+	runtime := newResumeTestRuntime(t, &resumeTestServer{})
+	runtime.httpClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{},
+			Body:          io.NopCloser(&pausingReader{data: body, at: 100000, itemID: itemID}),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	})}
+
+	StartItemProgress(itemID)
+	runtime.setActiveDownloadItemID(itemID)
+	done := make(chan map[string]interface{})
+	go func() {
+		done <- runtime.fileDownload(goja.FunctionCall{Arguments: []goja.Value{
+			runtime.vm.ToValue("https://cdn.example.com/track"),
+			runtime.vm.ToValue("track.flac"),
+		}}).Export().(map[string]interface{})
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !isDownloadPaused(itemID) {
+		if time.Now().After(deadline) {
+			t.Fatal("download was not paused")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case result := <-done:
+		t.Fatalf("paused download returned %#v", result)
+	case <-time.After(50 * time.Millisecond):
+	}
+	var progress ItemProgress
+	json.Unmarshal([]byte(GetItemProgress(itemID)), &progress)
+	if progress.Status != itemProgressStatusPaused || progress.BytesReceived > 100000 {
+		t.Fatalf("progress while paused = %+v", progress)
+	}
+
+	// Resuming continues on the same response; nothing is requested again.
+	resumeDownload(itemID)
+	result := <-done
+	if result["success"] != true || result["size"] != int64(len(body)) {
+		t.Fatalf("resumed download = %#v", result)
+	}
+	if requests != 1 {
+		t.Fatalf("made %d requests, want 1", requests)
+	}
+	if data, _ := os.ReadFile(filepath.Join(runtime.dataDir, "track.flac")); !bytes.Equal(data, body) {
+		t.Fatal("resumed file does not match")
+	}
+}
+
+func TestFileDownloadChunkedResumeAndCancel(t *testing.T) {
+	server := &resumeTestServer{body: testResumeBody(300000), etag: `"v1"`}
+	runtime := newResumeTestRuntime(t, server)
+	path := filepath.Join(runtime.dataDir, "track.m4a")
+	download := func() map[string]interface{} {
+		return runtime.fileDownload(goja.FunctionCall{Arguments: []goja.Value{
+			runtime.vm.ToValue("https://cdn.example.com/track"),
+			runtime.vm.ToValue("track.m4a"),
+			runtime.vm.ToValue(map[string]interface{}{"chunked": float64(65536)}),
+		}}).Export().(map[string]interface{})
+	}
+
+	// Cancelling keeps the partial file and its resume state.
+	itemID := "resume-cancel-item"
+	defer func() {
+		cancelMu.Lock()
+		delete(cancelMap, itemID)
+		cancelMu.Unlock()
+	}()
+	runtime.setActiveDownloadItemID(itemID)
+	server.failAt = 150000
+	server.onFail = func() { cancelDownload(itemID) }
+	if result := download(); result["error"] != "download cancelled" {
+		t.Fatalf("expected cancelled download, got %#v", result)
+	}
+	runtime.clearActiveDownloadItemID()
+	state := readTestResumeState(t, path)
+	if state.BytesWritten != 150000 {
+		t.Fatalf("resume state after cancel = %+v", state)
+	}
+
+	server.requests = nil
+	if result := download(); result["success"] != true || result["size"] != int64(300000) {
+		t.Fatalf("resumed chunked download = %#v", result)
+	}
+	if got := server.requests[1].Get("Range"); got != "bytes=150000-215535" {
+		t.Fatalf("first resumed chunk range = %q", got)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, server.body) {
+		t.Fatal("resumed chunked file does not match")
+	}
+
+	if err := os.WriteFile(downloadResumeSidecarPath(path), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := DiscardPartialDownload(path); err != nil {
+		t.Fatalf("DiscardPartialDownload: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("partial file left behind: %v", err)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"net/http"
+	"maps"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,7 +39,7 @@ func SetSongLinkNetworkOptions(allowHTTP, insecureTLS bool) {
 	SetNetworkCompatibilityOptions(allowHTTP, insecureTLS)
 }
 
-const musicBrainzAPIBase = "https://musicbrainz.org/ws/2"
+var musicBrainzAPIBase = "https://musicbrainz.org/ws/2"
 
 type musicBrainzTag struct {
 	Count int    `json:"count"`
@@ -53,10 +55,17 @@ type musicBrainzRecordingResponse struct {
 type musicBrainzArtistCredit struct {
 	Name       string `json:"name"`
 	JoinPhrase string `json:"joinphrase"`
+	Artist     struct {
+		ID string `json:"id"`
+	} `json:"artist"`
 }
 
 type musicBrainzRelease struct {
+	ID           string                    `json:"id"`
 	Title        string                    `json:"title"`
+	Status       string                    `json:"status"`
+	Date         string                    `json:"date"`
+	TrackCount   int                       `json:"track-count"`
 	ArtistCredit []musicBrainzArtistCredit `json:"artist-credit"`
 }
 
@@ -143,49 +152,9 @@ func FetchMusicBrainzAlbumArtistByISRC(isrc string, albumName string) (string, e
 		return "", fmt.Errorf("no ISRC provided")
 	}
 
-	client := NewMetadataHTTPClient(10 * time.Second)
-	query := fmt.Sprintf("isrc:%s", normalizedISRC)
-	reqURL := fmt.Sprintf(
-		"%s/recording?query=%s&fmt=json&inc=releases+artist-credits",
-		musicBrainzAPIBase,
-		url.QueryEscape(query),
-	)
-
-	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("User-Agent", getRandomUserAgent())
-
-	var resp *http.Response
-	var lastErr error
-	for attempt := 0; attempt < 3; attempt++ {
-		resp, lastErr = client.Do(req)
-		if lastErr == nil && resp.StatusCode == http.StatusOK {
-			break
-		}
-		if resp != nil {
-			resp.Body.Close()
-		}
-		if attempt < 2 {
-			time.Sleep(2 * time.Second)
-		}
-	}
-
-	if lastErr != nil {
-		return "", lastErr
-	}
-	if resp == nil {
-		return "", fmt.Errorf("MusicBrainz request failed without response")
-	}
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return "", fmt.Errorf("MusicBrainz API returned status: %d", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-
 	var payload musicBrainzAlbumArtistResponse
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+	query := url.Values{"query": {"isrc:" + normalizedISRC}, "inc": {"releases artist-credits"}}
+	if err := musicBrainzGetJSON("recording", query, &payload); err != nil {
 		return "", err
 	}
 	for _, recording := range payload.Recordings {
@@ -203,49 +172,8 @@ func FetchMusicBrainzGenreByISRC(isrc string) (string, error) {
 		return "", fmt.Errorf("no ISRC provided")
 	}
 
-	client := NewMetadataHTTPClient(10 * time.Second)
-	query := fmt.Sprintf("isrc:%s", normalizedISRC)
-	reqURL := fmt.Sprintf(
-		"%s/recording?query=%s&fmt=json&inc=tags",
-		musicBrainzAPIBase,
-		url.QueryEscape(query),
-	)
-
-	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("User-Agent", getRandomUserAgent())
-
-	var resp *http.Response
-	var lastErr error
-	for attempt := 0; attempt < 3; attempt++ {
-		resp, lastErr = client.Do(req)
-		if lastErr == nil && resp.StatusCode == http.StatusOK {
-			break
-		}
-		if resp != nil {
-			resp.Body.Close()
-		}
-		if attempt < 2 {
-			time.Sleep(2 * time.Second)
-		}
-	}
-
-	if lastErr != nil {
-		return "", lastErr
-	}
-	if resp == nil {
-		return "", fmt.Errorf("MusicBrainz request failed without response")
-	}
-	if resp.StatusCode != http.StatusOK {
-		resp.Body.Close()
-		return "", fmt.Errorf("MusicBrainz API returned status: %d", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-
 	var payload musicBrainzRecordingResponse
-	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+	if err := musicBrainzGetJSON("recording", url.Values{"query": {"isrc:" + normalizedISRC}, "inc": {"tags"}}, &payload); err != nil {
 		return "", err
 	}
 	if len(payload.Recordings) == 0 {
@@ -259,6 +187,19 @@ func FetchMusicBrainzGenreByISRC(isrc string) (string, error) {
 	return genre, nil
 }
 
+// FetchMusicBrainzMatchJSON exposes the ISRC match for library tools.
+func FetchMusicBrainzMatchJSON(isrc, albumName string, totalTracks int) (string, error) {
+	match, err := fetchMusicBrainzMatchByISRC(isrc, albumName, totalTracks)
+	if err != nil {
+		return "", err
+	}
+	jsonBytes, err := json.Marshal(match)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
 type DownloadRequest struct {
 	ContractVersion             int    `json:"contract_version,omitempty"`
 	ISRC                        string `json:"isrc"`
@@ -280,6 +221,7 @@ type DownloadRequest struct {
 	EmbedLyrics                 bool   `json:"embed_lyrics"`
 	EmbedMaxQualityCover        bool   `json:"embed_max_quality_cover"`
 	EmbedReplayGain             bool   `json:"embed_replaygain,omitempty"`
+	EmbedMusicBrainz            bool   `json:"embed_musicbrainz,omitempty"`
 	PostProcessingEnabled       bool   `json:"post_processing_enabled,omitempty"`
 	TidalHighFormat             string `json:"tidal_high_format,omitempty"`
 	TrackNumber                 int    `json:"track_number"`
@@ -301,8 +243,18 @@ type DownloadRequest struct {
 	LyricsMode                  string `json:"lyrics_mode,omitempty"`
 	UseExtensions               bool   `json:"use_extensions,omitempty"`
 	UseFallback                 bool   `json:"use_fallback,omitempty"`
+	BestQuality                 bool   `json:"best_quality,omitempty"`
+	CoverArbitration            bool   `json:"cover_arbitration,omitempty"`
 	RequiresContainerConversion bool   `json:"requires_container_conversion,omitempty"`
 	SongLinkRegion              string `json:"songlink_region,omitempty"`
+	// Set by DownloadAlbumJSON, which looks up album artist, genre, label
+	// and copyright once for the whole album.
+	SharedMetadataResolved bool `json:"shared_metadata_resolved,omitempty"`
+
+	// Gathered while resolving metadata; not part of the bridge contract.
+	coverCandidates       []coverCandidate
+	musicBrainzMatch      *MusicBrainzMatch
+	musicBrainzLookupDone bool
 }
 
 type DownloadResponse struct {
@@ -339,6 +291,12 @@ type DownloadResponse struct {
 	LyricsLRC                   string                  `json:"lyrics_lrc,omitempty"`
 	DecryptionKey               string                  `json:"decryption_key,omitempty"`
 	Decryption                  *DownloadDecryptionInfo `json:"decryption,omitempty"`
+	Authenticity                *AudioAuthenticity      `json:"authenticity,omitempty"`
+	// Set when the quality policy turned files down on the way here.
+	QualityShortfall  string                     `json:"quality_shortfall,omitempty"`
+	QualityRejections []DownloadQualityRejection `json:"quality_rejections,omitempty"`
+	// Provider that supplied each field chosen by the metadata merge policy.
+	MetadataSources map[string]string `json:"metadata_sources,omitempty"`
 }
 
 type DownloadResult struct {
@@ -366,6 +324,7 @@ type DownloadResult struct {
 	ActualExtension             string
 	ActualContainer             string
 	RequiresContainerConversion bool
+	Authenticity                *AudioAuthenticity
 }
 
 var fetchDeezerExtendedMetadataByISRC = func(ctx context.Context, isrc string) (*AlbumExtendedMetadata, error) {
@@ -377,30 +336,33 @@ var fetchMusicBrainzGenreByISRC = FetchMusicBrainzGenreByISRC
 var fetchMusicBrainzAlbumArtistByISRC = FetchMusicBrainzAlbumArtistByISRC
 
 type reEnrichRequest struct {
-	FilePath      string   `json:"file_path"`
-	CoverURL      string   `json:"cover_url"`
-	MaxQuality    bool     `json:"max_quality"`
-	EmbedLyrics   bool     `json:"embed_lyrics"`
-	LyricsMode    string   `json:"lyrics_mode,omitempty"`
-	ArtistTagMode string   `json:"artist_tag_mode,omitempty"`
-	SpotifyID     string   `json:"spotify_id"`
-	TrackName     string   `json:"track_name"`
-	ArtistName    string   `json:"artist_name"`
-	AlbumName     string   `json:"album_name"`
-	AlbumArtist   string   `json:"album_artist"`
-	TrackNumber   int      `json:"track_number"`
-	DiscNumber    int      `json:"disc_number"`
-	TotalTracks   int      `json:"total_tracks,omitempty"`
-	TotalDiscs    int      `json:"total_discs,omitempty"`
-	ReleaseDate   string   `json:"release_date"`
-	ISRC          string   `json:"isrc"`
-	Genre         string   `json:"genre"`
-	Label         string   `json:"label"`
-	Copyright     string   `json:"copyright"`
-	Composer      string   `json:"composer"`
-	DurationMs    int64    `json:"duration_ms"`
-	SearchOnline  bool     `json:"search_online"`
-	UpdateFields  []string `json:"update_fields,omitempty"`
+	FilePath      string `json:"file_path"`
+	CoverURL      string `json:"cover_url"`
+	MaxQuality    bool   `json:"max_quality"`
+	EmbedLyrics   bool   `json:"embed_lyrics"`
+	LyricsMode    string `json:"lyrics_mode,omitempty"`
+	ArtistTagMode string `json:"artist_tag_mode,omitempty"`
+	SpotifyID     string `json:"spotify_id"`
+	TrackName     string `json:"track_name"`
+	ArtistName    string `json:"artist_name"`
+	AlbumName     string `json:"album_name"`
+	AlbumArtist   string `json:"album_artist"`
+	TrackNumber   int    `json:"track_number"`
+	DiscNumber    int    `json:"disc_number"`
+	TotalTracks   int    `json:"total_tracks,omitempty"`
+	TotalDiscs    int    `json:"total_discs,omitempty"`
+	ReleaseDate   string `json:"release_date"`
+	ISRC          string `json:"isrc"`
+	Genre         string `json:"genre"`
+	Label         string `json:"label"`
+	Copyright     string `json:"copyright"`
+	Composer      string `json:"composer"`
+	DurationMs    int64  `json:"duration_ms"`
+	SearchOnline  bool   `json:"search_online"`
+	// EmbedMusicBrainz resolves the ISRC on MusicBrainz and writes the
+	// MBIDs and release details alongside the online metadata.
+	EmbedMusicBrainz bool     `json:"embed_musicbrainz,omitempty"`
+	UpdateFields     []string `json:"update_fields,omitempty"`
 }
 
 // shouldUpdateField returns true if the given field group should be updated.
@@ -604,6 +566,53 @@ func buildReEnrichFFmpegMetadata(req *reEnrichRequest, lyricsLRC string) map[str
 	return metadata
 }
 
+// buildReEnrichEditFields maps the selected update groups onto editor field
+// keys for the native tag writers. Empty values are omitted so that existing
+// tags are kept rather than cleared.
+func buildReEnrichEditFields(req *reEnrichRequest, lyricsLRC string) map[string]string {
+	fields := map[string]string{}
+	set := func(key, value string) {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+	if req.shouldUpdateField("basic_tags") {
+		set("title", req.TrackName)
+		set("artist", req.ArtistName)
+		set("album", req.AlbumName)
+		set("album_artist", req.AlbumArtist)
+		set("artist_tag_mode", req.ArtistTagMode)
+	}
+	if req.shouldUpdateField("release_info") {
+		set("date", req.ReleaseDate)
+		set("isrc", req.ISRC)
+	}
+	if req.shouldUpdateField("extra") {
+		set("genre", req.Genre)
+		set("label", req.Label)
+		set("copyright", req.Copyright)
+		set("composer", req.Composer)
+	}
+	if req.shouldUpdateField("track_info") {
+		if req.TrackNumber > 0 {
+			set("track_number", strconv.Itoa(req.TrackNumber))
+			if req.TotalTracks > 0 {
+				set("track_total", strconv.Itoa(req.TotalTracks))
+			}
+		}
+		if req.DiscNumber > 0 {
+			set("disc_number", strconv.Itoa(req.DiscNumber))
+			if req.TotalDiscs > 0 {
+				set("disc_total", strconv.Itoa(req.TotalDiscs))
+			}
+		}
+	}
+	if req.shouldUpdateField("lyrics") && req.lyricsEmbedEnabled() {
+		set("lyrics", lyricsLRC)
+	}
+	return fields
+}
+
 func selectBestReEnrichTrack(req reEnrichRequest, tracks []ExtTrackMetadata) *ExtTrackMetadata {
 	if len(tracks) == 0 {
 		return nil
@@ -935,6 +944,7 @@ func buildDownloadSuccessResponse(
 		LyricsLRC:                   result.LyricsLRC,
 		DecryptionKey:               result.DecryptionKey,
 		Decryption:                  normalizeDownloadDecryptionInfo(result.Decryption, result.DecryptionKey),
+		Authenticity:                result.Authenticity,
 	}
 }
 
@@ -976,6 +986,18 @@ func enrichResultQualityFromFile(result *DownloadResult) {
 		} else {
 			GoLog("[Download] Actual quality from file: %d-bit/%dHz\n", quality.BitDepth, quality.SampleRate)
 		}
+		if canAnalyzeAuthenticity(filepath.Ext(path)) {
+			authenticity, err := AnalyzeAudioAuthenticity(path)
+			if err != nil {
+				LogDebug("Download", "Authenticity analysis unavailable for %s: %v", path, err)
+			} else {
+				result.Authenticity = authenticity
+				if authenticity.Suspicious {
+					GoLog("[Download] Suspicious lossless file: effective %d-bit, cutoff %d Hz, upsampled=%v, lossy source=%v\n",
+						authenticity.EffectiveBitDepth, authenticity.CutoffHz, authenticity.Upsampled, authenticity.LossySource)
+				}
+			}
+		}
 		return
 	}
 
@@ -1056,7 +1078,7 @@ func enrichRequestExtendedMetadata(req *DownloadRequest) {
 		return
 	}
 
-	if req.ISRC == "" {
+	if req.ISRC == "" || req.SharedMetadataResolved {
 		return
 	}
 
@@ -1149,6 +1171,104 @@ func CancelDownload(itemID string) {
 	cancelDownload(itemID)
 }
 
+// PauseDownload suspends an in-flight download at its next write, keeping
+// the connection and partial file. ResumeDownload continues it.
+func PauseDownload(itemID string) {
+	pauseDownload(itemID)
+}
+
+func ResumeDownload(itemID string) {
+	resumeDownload(itemID)
+}
+
+func IsDownloadPaused(itemID string) bool {
+	return isDownloadPaused(itemID)
+}
+
+// InitDownloadQueue restores the persisted download queue from dataDir.
+// Call StartDownloadQueue afterwards to begin processing it.
+func InitDownloadQueue(dataDir string) error {
+	return getDownloadQueue().load(dataDir)
+}
+
+// EnqueueDownloadsJSON appends DownloadRequest objects to the queue. Every
+// request needs an item_id; items already waiting or running are skipped.
+func EnqueueDownloadsJSON(requestsJSON string) (string, error) {
+	var reqs []DownloadRequest
+	if err := json.Unmarshal([]byte(requestsJSON), &reqs); err != nil {
+		return "", fmt.Errorf("failed to parse requests: %w", err)
+	}
+	added, err := getDownloadQueue().enqueue(reqs)
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.Marshal(map[string]any{
+		"success": true,
+		"added":   added,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// ReorderDownloadQueueJSON moves the given item IDs to the front of the
+// queue in that order.
+func ReorderDownloadQueueJSON(itemIDsJSON string) error {
+	var itemIDs []string
+	if err := json.Unmarshal([]byte(itemIDsJSON), &itemIDs); err != nil {
+		return fmt.Errorf("failed to parse item IDs: %w", err)
+	}
+	getDownloadQueue().reorder(itemIDs)
+	return nil
+}
+
+func PauseDownloadQueueItem(itemID string) error {
+	return getDownloadQueue().pause(itemID)
+}
+
+func ResumeDownloadQueueItem(itemID string) error {
+	return getDownloadQueue().resume(itemID)
+}
+
+func RemoveDownloadQueueItem(itemID string) {
+	getDownloadQueue().remove(itemID)
+}
+
+// ClearFinishedDownloadQueueItems drops completed items, and failed ones
+// when includeFailed is set, returning how many were removed.
+func ClearFinishedDownloadQueueItems(includeFailed bool) int {
+	return getDownloadQueue().clearFinished(includeFailed)
+}
+
+// SetDownloadQueueLimitsJSON sets max_concurrent, per_provider limits keyed
+// by service and max_attempts. Missing or zero values use the defaults.
+func SetDownloadQueueLimitsJSON(limitsJSON string) error {
+	var limits DownloadQueueLimits
+	if err := json.Unmarshal([]byte(limitsJSON), &limits); err != nil {
+		return fmt.Errorf("failed to parse queue limits: %w", err)
+	}
+	getDownloadQueue().setLimits(limits)
+	return nil
+}
+
+func GetDownloadQueueJSON() (string, error) {
+	jsonBytes, err := json.Marshal(getDownloadQueue().snapshot())
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+func StartDownloadQueue() {
+	getDownloadQueue().start()
+}
+
+func StopDownloadQueue() {
+	getDownloadQueue().halt()
+}
+
 func CleanupConnections() {
 	CloseIdleConnections()
 }
@@ -1553,7 +1673,10 @@ func WriteAC4Metadata(filePath, metadataJSON, coverPath string) (string, error)
 	return string(jsonBytes), nil
 }
 
-// EditFileMetadata writes audio file tags: FLAC via native Go library, MP3/Opus returns map for Dart/FFmpeg.
+// EditFileMetadata writes audio file tags natively: FLAC via the go-flac
+// library, M4A/MP4 via the iTunes ilst writer, MP3 via the ID3v2.4 writer and
+// Ogg Vorbis/Opus via the comment header writer. Unsupported formats return
+// the fields map for Dart/FFmpeg.
 func EditFileMetadata(filePath, metadataJSON string) (string, error) {
 	var fields map[string]string
 	if err := json.Unmarshal([]byte(metadataJSON), &fields); err != nil {
@@ -1562,6 +1685,7 @@ func EditFileMetadata(filePath, metadataJSON string) (string, error) {
 
 	lower := strings.ToLower(filePath)
 	isFlac := strings.HasSuffix(lower, ".flac")
+	isMp3File := strings.HasSuffix(lower, ".mp3")
 	isApeFile := strings.HasSuffix(lower, ".ape") || strings.HasSuffix(lower, ".wv") || strings.HasSuffix(lower, ".mpc")
 	isM4AFile := strings.HasSuffix(lower, ".m4a") || strings.HasSuffix(lower, ".mp4") || strings.HasSuffix(lower, ".m4b")
 	isWavFile := strings.HasSuffix(lower, ".wav")
@@ -1581,6 +1705,15 @@ func EditFileMetadata(filePath, metadataJSON string) (string, error) {
 		return string(jsonBytes), nil
 	}
 
+	if isM4AFile || isMP4ContainerFile(filePath) {
+		if err := WriteM4ATags(filePath, fields); err != nil {
+			return "", fmt.Errorf("failed to write M4A metadata: %w", err)
+		}
+		resp := map[string]any{"success": true, "method": "native_m4a"}
+		jsonBytes, _ := json.Marshal(resp)
+		return string(jsonBytes), nil
+	}
+
 	if isFlac {
 		if err := EditFlacFields(filePath, fields); err != nil {
 			return "", fmt.Errorf("failed to write FLAC metadata: %w", err)
@@ -1594,6 +1727,24 @@ func EditFileMetadata(filePath, metadataJSON string) (string, error) {
 		return string(jsonBytes), nil
 	}
 
+	if isMp3File {
+		if err := WriteMP3Tags(filePath, fields); err != nil {
+			return "", fmt.Errorf("failed to write MP3 metadata: %w", err)
+		}
+		resp := map[string]any{"success": true, "method": "native_mp3"}
+		jsonBytes, _ := json.Marshal(resp)
+		return string(jsonBytes), nil
+	}
+
+	if isOggFile(filePath) {
+		if err := WriteOggTags(filePath, fields); err != nil {
+			return "", fmt.Errorf("failed to write Ogg metadata: %w", err)
+		}
+		resp := map[string]any{"success": true, "method": "native_ogg"}
+		jsonBytes, _ := json.Marshal(resp)
+		return string(jsonBytes), nil
+	}
+
 	// WAV / AIFF: write tags into an embedded ID3v2.4 chunk natively.
 	if isWavFile {
 		if err := WriteWAVTags(filePath, fields); err != nil {
@@ -1660,10 +1811,15 @@ func EditFileMetadata(filePath, metadataJSON string) (string, error) {
 		if coverPath != "" {
 			coverData, coverErr := os.ReadFile(coverPath)
 			if coverErr == nil && len(coverData) > 0 {
+				var coverMIME string
+				coverData, coverMIME, _ = processCover(coverTargetAPE, detectCoverMIME(coverPath, coverData), coverData)
 				// The value is "filename\0" + raw bytes.  We store the
 				// description as the Value field, but since the item is
 				// flagged binary, the writer serializes it verbatim.
 				desc := "cover.jpg\x00"
+				if coverMIME == "image/png" {
+					desc = "cover.png\x00"
+				}
 				binaryValue := desc + string(coverData)
 				newItems = append(newItems, APETagItem{
 					Key:   "Cover Art (Front)",
@@ -1732,6 +1888,180 @@ func isMP4ContainerFile(filePath string) bool {
 	return string(header[4:8]) == "ftyp"
 }
 
+// ReadTagsJSON returns every text tag of a file in the unified tag model:
+// {"format":"mp3","tags":{"TITLE":["..."],"ARTIST":["A","B"],...}}. Custom
+// TXXX frames, iTunes freeform atoms and unknown Vorbis/APE keys are included
+// under their upper-cased names (e.g. MUSICBRAINZ_*, DISCOGS_*).
+func ReadTagsJSON(filePath string) (string, error) {
+	tags, format, err := readTagMap(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read tags: %w", err)
+	}
+	resp := map[string]any{
+		"success": true,
+		"format":  format,
+		"tags":    tags,
+	}
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// WriteTagsJSON writes unified-model tags to a file. tagsJSON maps canonical
+// (or native) field names to a list of values, or a single string; it may
+// also be wrapped as {"tags":{...}} as returned by ReadTagsJSON. Every listed
+// field has all of its values replaced, an empty list deletes it, and fields
+// that are not listed are kept as they are.
+func WriteTagsJSON(filePath, tagsJSON string) (string, error) {
+	edits, err := decodeTagEditsJSON(tagsJSON)
+	if err != nil {
+		return "", err
+	}
+	format, err := writeTagMap(filePath, edits)
+	if err != nil {
+		return "", fmt.Errorf("failed to write tags: %w", err)
+	}
+	resp := map[string]any{
+		"success": true,
+		"format":  format,
+	}
+	jsonBytes, _ := json.Marshal(resp)
+	return string(jsonBytes), nil
+}
+
+func decodeTagEditsJSON(tagsJSON string) (tagMap, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(tagsJSON), &raw); err != nil {
+		return nil, fmt.Errorf("invalid tags JSON: %w", err)
+	}
+	if wrapped, ok := raw["tags"]; ok && len(wrapped) > 0 && wrapped[0] == '{' {
+		raw = nil
+		if err := json.Unmarshal(wrapped, &raw); err != nil {
+			return nil, fmt.Errorf("invalid tags JSON: %w", err)
+		}
+	}
+
+	edits := make(tagMap, len(raw))
+	for key, value := range raw {
+		var values []string
+		if err := json.Unmarshal(value, &values); err != nil {
+			var single *string
+			if err := json.Unmarshal(value, &single); err != nil {
+				return nil, fmt.Errorf("invalid value for tag %q: %w", key, err)
+			}
+			if single != nil {
+				values = []string{*single}
+			}
+		}
+		edits[key] = values
+	}
+	return edits, nil
+}
+
+type replayGainRequest struct {
+	Files     []string `json:"files"`
+	Album     *bool    `json:"album,omitempty"`
+	WriteTags bool     `json:"write_tags,omitempty"`
+}
+
+type replayGainTrackResult struct {
+	FilePath     string   `json:"file_path"`
+	TrackGain    string   `json:"track_gain,omitempty"`
+	TrackPeak    string   `json:"track_peak,omitempty"`
+	LoudnessLUFS *float64 `json:"loudness_lufs,omitempty"`
+	Written      bool     `json:"written"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// AnalyzeReplayGainJSON measures EBU R128 loudness and derives ReplayGain 2.0
+// track gain/peak for every file in the request, plus album gain/peak over
+// all of them when "album" is set (the default for more than one file).
+// FLAC, WAV and AIFF are decoded natively. With "write_tags" the values are
+// embedded using the tag writer for each file's container. A file that fails
+// to decode is reported in its own entry and left out of the album values.
+func AnalyzeReplayGainJSON(requestJSON string) (string, error) {
+	var req replayGainRequest
+	if err := json.Unmarshal([]byte(requestJSON), &req); err != nil {
+		return "", fmt.Errorf("invalid ReplayGain request: %w", err)
+	}
+	if len(req.Files) == 0 {
+		return "", fmt.Errorf("no files to analyze")
+	}
+	album := len(req.Files) > 1
+	if req.Album != nil {
+		album = *req.Album
+	}
+
+	tracks := make([]replayGainTrackResult, len(req.Files))
+	analyses := make([]*loudnessAnalysis, len(req.Files))
+	var measured []*loudnessAnalysis
+	failed := 0
+	for i, path := range req.Files {
+		tracks[i].FilePath = path
+		analysis, err := analyzeLoudness(path)
+		if err != nil {
+			GoLog("[ReplayGain] Failed to analyze %s: %v\n", path, err)
+			tracks[i].Error = err.Error()
+			failed++
+			continue
+		}
+		analyses[i] = analysis
+		measured = append(measured, analysis)
+		tracks[i].TrackGain = formatReplayGainGain(replayGainForLoudness(analysis.LoudnessLUFS))
+		tracks[i].TrackPeak = formatReplayGainPeak(analysis.Peak)
+		if !math.IsInf(analysis.LoudnessLUFS, -1) {
+			lufs := math.Round(analysis.LoudnessLUFS*100) / 100
+			tracks[i].LoudnessLUFS = &lufs
+		}
+	}
+
+	resp := map[string]any{}
+	var albumGain, albumPeak string
+	if album && len(measured) > 0 {
+		albumLUFS, peak := albumLoudness(measured)
+		albumGain = formatReplayGainGain(replayGainForLoudness(albumLUFS))
+		albumPeak = formatReplayGainPeak(peak)
+		resp["album_gain"] = albumGain
+		resp["album_peak"] = albumPeak
+		if !math.IsInf(albumLUFS, -1) {
+			resp["album_loudness_lufs"] = math.Round(albumLUFS*100) / 100
+		}
+	}
+
+	if req.WriteTags {
+		for i := range tracks {
+			if analyses[i] == nil {
+				continue
+			}
+			fields := map[string]string{
+				"replaygain_track_gain": tracks[i].TrackGain,
+				"replaygain_track_peak": tracks[i].TrackPeak,
+			}
+			if albumGain != "" {
+				fields["replaygain_album_gain"] = albumGain
+				fields["replaygain_album_peak"] = albumPeak
+			}
+			if err := writeReplayGainFields(tracks[i].FilePath, fields); err != nil {
+				GoLog("[ReplayGain] Failed to write tags to %s: %v\n", tracks[i].FilePath, err)
+				tracks[i].Error = fmt.Sprintf("failed to write tags: %v", err)
+				failed++
+				continue
+			}
+			tracks[i].Written = true
+		}
+	}
+
+	resp["success"] = failed == 0
+	resp["tracks"] = tracks
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
 func hasOnlyM4AReplayGainFields(fields map[string]string) bool {
 	allowed := map[string]struct{}{
 		"replaygain_track_gain": {},
@@ -1918,6 +2248,8 @@ func GetLyricsLRCWithSource(spotifyID, trackName, artistName string, filePath st
 	return string(jsonBytes), nil
 }
 
+// EmbedLyricsToFile embeds lyrics into a FLAC, MP3, M4A, Ogg, WAV or AIFF
+// file using that container's native lyrics tag (see EmbedLyrics).
 func EmbedLyricsToFile(filePath, lyrics string) (string, error) {
 	err := EmbedLyrics(filePath, lyrics)
 	if err != nil {
@@ -2593,10 +2925,20 @@ func DownloadCoverToFile(coverURL string, outputPath string, maxQuality bool) er
 		return fmt.Errorf("no cover URL provided")
 	}
 
+	// The resolver keeps the original URL behind any CDN upgrade, so a size
+	// the CDN does not serve falls back instead of failing. A lone candidate
+	// is used without probing.
+	resolver := newCoverResolver(maxQuality)
+	resolver.add(coverSourceRequest, coverURL)
+	if resolved, ok := resolveCoverURL("Cover", resolver); ok {
+		coverURL, maxQuality = resolved, false
+	}
+
 	data, err := downloadCoverToMemory(coverURL, maxQuality)
 	if err != nil {
 		return fmt.Errorf("failed to download cover: %w", err)
 	}
+	data, _, _ = processCover(coverTargetSidecar, "", data)
 
 	if err := os.WriteFile(outputPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write cover file: %w", err)
@@ -2636,6 +2978,100 @@ func ExtractCoverToFile(audioPath string, outputPath string) error {
 	return nil
 }
 
+// ListPicturesJSON lists every picture embedded in a file, in stored order:
+// {"success":true,"format":"flac","pictures":[{"type":5,"type_name":"leaflet",
+// "mime":"image/jpeg","width":1200,"height":1600,"size":123456},...]}.
+func ListPicturesJSON(filePath string) (string, error) {
+	pictures, err := readEmbeddedPictures(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pictures: %w", err)
+	}
+	if pictures == nil {
+		pictures = []EmbeddedPicture{}
+	}
+	resp := map[string]any{
+		"success":  true,
+		"format":   tagFormatForFile(filePath),
+		"pictures": pictures,
+	}
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// ExtractPictureToFile writes an embedded picture to outputPath. pictureType
+// is a type number or name ("back_cover", "booklet", "media", ...) and index
+// picks among pictures of that type, such as the pages of a booklet.
+func ExtractPictureToFile(audioPath, outputPath, pictureType string, index int) error {
+	t, err := parsePictureType(pictureType)
+	if err != nil {
+		return err
+	}
+	pictures, err := readEmbeddedPictures(audioPath)
+	if err != nil {
+		return fmt.Errorf("failed to read pictures: %w", err)
+	}
+	matches := picturesOfType(pictures, t)
+	if index < 0 || index >= len(matches) {
+		return fmt.Errorf("no %s picture at index %d (found %d)", pictureTypeName(t), index, len(matches))
+	}
+
+	if err := os.WriteFile(outputPath, matches[index].Data, 0644); err != nil {
+		return fmt.Errorf("failed to write picture file: %w", err)
+	}
+	GoLog("[Cover] Extracted %s picture to: %s (%d KB)\n", pictureTypeName(t), outputPath, len(matches[index].Data)/1024)
+	return nil
+}
+
+type writePicturesRequest struct {
+	Pictures []struct {
+		Type        pictureTypeValue `json:"type"`
+		Path        string           `json:"path"`
+		Description string           `json:"description,omitempty"`
+	} `json:"pictures"`
+	RemoveTypes []pictureTypeValue `json:"remove_types,omitempty"`
+}
+
+// WritePicturesJSON embeds pictures by type:
+// {"pictures":[{"type":"booklet","path":"/p1.jpg","description":"Page 1"},...],
+// "remove_types":["back_cover"]}. Every type that is listed replaces all
+// existing pictures of that type, and remove_types only deletes; pictures of
+// any other type are kept.
+func WritePicturesJSON(filePath, picturesJSON string) (string, error) {
+	var req writePicturesRequest
+	if err := json.Unmarshal([]byte(picturesJSON), &req); err != nil {
+		return "", fmt.Errorf("invalid pictures JSON: %w", err)
+	}
+
+	pictures := make([]EmbeddedPicture, 0, len(req.Pictures))
+	for _, item := range req.Pictures {
+		data, err := os.ReadFile(item.Path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read picture %s: %w", item.Path, err)
+		}
+		if len(data) == 0 {
+			return "", fmt.Errorf("picture file is empty: %s", item.Path)
+		}
+		pictures = append(pictures, newEmbeddedPicture(int(item.Type), detectCoverMIME(item.Path, data), item.Description, data))
+	}
+	removeTypes := make([]int, 0, len(req.RemoveTypes))
+	for _, t := range req.RemoveTypes {
+		removeTypes = append(removeTypes, int(t))
+	}
+
+	if err := writeEmbeddedPictures(filePath, pictures, removeTypes...); err != nil {
+		return "", fmt.Errorf("failed to write pictures: %w", err)
+	}
+	resp := map[string]any{
+		"success": true,
+		"format":  tagFormatForFile(filePath),
+	}
+	jsonBytes, _ := json.Marshal(resp)
+	return string(jsonBytes), nil
+}
+
 func FetchAndSaveLyrics(trackName, artistName, spotifyID string, durationMs int64, outputPath string, audioFilePath string) error {
 	// If the audio file already has embedded lyrics or a sidecar .lrc,
 	// use those directly instead of making redundant network requests.
@@ -2724,6 +3160,72 @@ func GetLyricsFetchOptionsJSON() (string, error) {
 	return string(jsonBytes), nil
 }
 
+// SetCoverProcessingPolicyJSON sets how covers are prepared before they are
+// embedded or saved, e.g. {"mode":"process","jpeg_quality":85,
+// "convert_png_to_jpeg":true,"strip_metadata":true,
+// "formats":{"id3":{"max_dimension":1000,"max_bytes":500000}}}. Omitted
+// fields keep their defaults.
+func SetCoverProcessingPolicyJSON(policyJSON string) error {
+	policy := defaultCoverProcessingPolicy
+	if strings.TrimSpace(policyJSON) != "" {
+		if err := json.Unmarshal([]byte(policyJSON), &policy); err != nil {
+			return fmt.Errorf("failed to parse cover processing policy: %w", err)
+		}
+	}
+	SetCoverProcessingPolicy(policy)
+	return nil
+}
+
+func GetCoverProcessingPolicyJSON() (string, error) {
+	jsonBytes, err := json.Marshal(GetCoverProcessingPolicy())
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// SetDownloadQualityPolicyJSON sets the minimum codec, bit depth and sample
+// rate accepted per requested quality when falling back across providers.
+func SetDownloadQualityPolicyJSON(policyJSON string) error {
+	var policy DownloadQualityPolicy
+	if strings.TrimSpace(policyJSON) != "" {
+		if err := json.Unmarshal([]byte(policyJSON), &policy); err != nil {
+			return fmt.Errorf("failed to parse quality policy: %w", err)
+		}
+	}
+	SetDownloadQualityPolicy(policy)
+	return nil
+}
+
+func GetDownloadQualityPolicyJSON() (string, error) {
+	jsonBytes, err := json.Marshal(GetDownloadQualityPolicy())
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// SetMetadataMergePolicyJSON sets per-field source lists for merging metadata
+// from several providers, e.g. {"fields":{"genre":["musicbrainz"]}}.
+func SetMetadataMergePolicyJSON(policyJSON string) error {
+	var policy MetadataMergePolicy
+	if strings.TrimSpace(policyJSON) != "" {
+		if err := json.Unmarshal([]byte(policyJSON), &policy); err != nil {
+			return fmt.Errorf("failed to parse metadata merge policy: %w", err)
+		}
+	}
+	SetMetadataMergePolicy(policy)
+	return nil
+}
+
+func GetMetadataMergePolicyJSON() (string, error) {
+	jsonBytes, err := json.Marshal(GetMetadataMergePolicy())
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
 // ReEnrichFile re-embeds metadata, cover art, and lyrics into an existing audio file.
 // When search_online is true, searches Spotify/Deezer by track name + artist to fetch
 // complete metadata from the internet before embedding.
@@ -2740,14 +3242,18 @@ func ReEnrichFile(requestJSON string) (string, error) {
 
 	GoLog("[ReEnrich] Starting re-enrichment for: %s\n", req.FilePath)
 
+	var metadataSources map[string]string
 	if req.SearchOnline {
 		found := false
+		merger := newMetadataMerger("")
+		merger.add(metadataSourceRequest, snapshotMergeFields(reEnrichRequestMergeFields(&req)))
 
 		GoLog("[ReEnrich] Trying metadata providers in configured priority...\n")
 		manager := getExtensionManager()
 		if identifierTrack, err := resolveReEnrichTrackFromIdentifiers(req); err == nil && identifierTrack != nil {
 			GoLog("[ReEnrich] Identifier-first metadata match (%s): %s - %s (album: %s, date: %s)\n",
 				identifierTrack.ProviderID, identifierTrack.Name, identifierTrack.Artists, identifierTrack.AlbumName, identifierTrack.ReleaseDate)
+			merger.add(identifierTrack.ProviderID, *identifierTrack)
 			applyReEnrichTrackMetadata(&req, *identifierTrack)
 			found = true
 		}
@@ -2761,6 +3267,7 @@ func ReEnrichFile(requestJSON string) (string, error) {
 				if track != nil {
 					GoLog("[ReEnrich] Metadata match (%s): %s - %s (album: %s, date: %s)\n",
 						track.ProviderID, track.Name, track.Artists, track.AlbumName, track.ReleaseDate)
+					merger.add(track.ProviderID, *track)
 					applyReEnrichTrackMetadata(&req, *track)
 					found = true
 				}
@@ -2787,11 +3294,24 @@ func ReEnrichFile(requestJSON string) (string, error) {
 			enrichExtraMetadataByISRC("ReEnrich", req.ISRC, &req.Genre, &req.Label, &req.Copyright)
 		}
 
-		if !found {
+		if found {
+			merger.fetchISRCSources("ReEnrich", req.ISRC, req.AlbumName)
+			metadataSources = merger.apply("ReEnrich", reEnrichRequestMergeFields(&req))
+		} else {
 			GoLog("[ReEnrich] No online match found, using existing metadata\n")
 		}
 	}
 
+	var musicBrainzMatch *MusicBrainzMatch
+	if req.SearchOnline && req.EmbedMusicBrainz && req.ISRC != "" {
+		match, err := fetchMusicBrainzMatchByISRC(req.ISRC, req.AlbumName, req.TotalTracks)
+		if err != nil {
+			GoLog("[ReEnrich] MusicBrainz lookup failed: %v\n", err)
+		} else {
+			musicBrainzMatch = match
+		}
+	}
+
 	GoLog("[ReEnrich] Metadata to embed: title=%s, artist=%s, album=%s, albumArtist=%s\n",
 		req.TrackName, req.ArtistName, req.AlbumName, req.AlbumArtist)
 	GoLog("[ReEnrich] track=%d, disc=%d, date=%s, isrc=%s, genre=%s, label=%s\n",
@@ -2799,6 +3319,7 @@ func ReEnrichFile(requestJSON string) (string, error) {
 
 	lower := strings.ToLower(req.FilePath)
 	isFlac := strings.HasSuffix(lower, ".flac")
+	isM4AFile := strings.HasSuffix(lower, ".m4a") || strings.HasSuffix(lower, ".mp4") || strings.HasSuffix(lower, ".m4b")
 
 	var coverTempPath string
 	var coverDataBytes []byte
@@ -2809,7 +3330,8 @@ func ReEnrichFile(requestJSON string) (string, error) {
 		} else {
 			coverDataBytes = coverData
 			GoLog("[ReEnrich] Cover downloaded: %d KB\n", len(coverData)/1024)
-			// MP3/Opus requires a real image file path for Dart FFmpeg.
+			// MP3 requires a real image file path for Dart FFmpeg, and the
+			// native Ogg/M4A writers read the cover from a path as well.
 			// FLAC uses in-memory embed and does not require temp files.
 			if !isFlac {
 				tmpFile, err := os.CreateTemp("", "reenrich_cover_*.jpg")
@@ -2840,8 +3362,8 @@ func ReEnrichFile(requestJSON string) (string, error) {
 			}
 		}
 	}
-	// Only cleanup cover temp for FLAC (native embed).
-	// For MP3/Opus, Dart needs the file for FFmpeg — Dart handles cleanup.
+	// Only cleanup cover temp for native embeds (FLAC, Ogg, M4A).
+	// For MP3, Dart needs the file for FFmpeg — Dart handles cleanup.
 	cleanupCover := true
 
 	defer func() {
@@ -2906,6 +3428,12 @@ func ReEnrichFile(requestJSON string) (string, error) {
 		enrichedMeta["copyright"] = req.Copyright
 		enrichedMeta["composer"] = req.Composer
 	}
+	if len(metadataSources) > 0 {
+		enrichedMeta["metadata_sources"] = metadataSources
+	}
+	if musicBrainzMatch != nil {
+		enrichedMeta["musicbrainz"] = musicBrainzMatch
+	}
 
 	if isFlac {
 		// Only populate Metadata fields for selected update groups; empty/zero
@@ -2963,6 +3491,7 @@ func ReEnrichFile(requestJSON string) (string, error) {
 		}
 
 		GoLog("[ReEnrich] FLAC metadata embedded successfully\n")
+		writeReEnrichMusicBrainzTags(req.FilePath, musicBrainzMatch)
 
 		result := map[string]interface{}{
 			"method":            "native",
@@ -2978,9 +3507,44 @@ func ReEnrichFile(requestJSON string) (string, error) {
 		return string(jsonBytes), nil
 	}
 
+	if isOggFile(req.FilePath) || isM4AFile {
+		fields := buildReEnrichEditFields(&req, lyricsLRC)
+		if coverTempPath != "" {
+			fields["cover_path"] = coverTempPath
+		}
+		method := "native_ogg"
+		if isM4AFile {
+			method = "native_m4a"
+			if err := WriteM4ATags(req.FilePath, fields); err != nil {
+				return "", fmt.Errorf("failed to embed M4A metadata: %w", err)
+			}
+		} else if err := WriteOggTags(req.FilePath, fields); err != nil {
+			return "", fmt.Errorf("failed to embed Ogg metadata: %w", err)
+		}
+
+		GoLog("[ReEnrich] Metadata embedded natively (%s)\n", method)
+		writeReEnrichMusicBrainzTags(req.FilePath, musicBrainzMatch)
+
+		result := map[string]interface{}{
+			"method":            method,
+			"success":           true,
+			"enriched_metadata": enrichedMeta,
+			"lyrics":            lyricsLRC,
+			"write_external_lrc": req.EmbedLyrics &&
+				req.shouldUpdateField("lyrics") &&
+				req.lyricsSidecarEnabled() &&
+				strings.TrimSpace(lyricsLRC) != "",
+		}
+		jsonBytes, _ := json.Marshal(result)
+		return string(jsonBytes), nil
+	}
+
 	// Don't cleanup cover temp — Dart needs it for FFmpeg embed
 	cleanupCover = false
 	ffmpegMetadata := buildReEnrichFFmpegMetadata(&req, lyricsLRC)
+	if musicBrainzMatch != nil {
+		maps.Copy(ffmpegMetadata, musicBrainzFFmpegMetadata(musicBrainzMatch))
+	}
 
 	result := map[string]interface{}{
 		"method":            "ffmpeg",
@@ -2998,6 +3562,15 @@ func ReEnrichFile(requestJSON string) (string, error) {
 	return string(jsonBytes), nil
 }
 
+func writeReEnrichMusicBrainzTags(filePath string, match *MusicBrainzMatch) {
+	if match == nil {
+		return
+	}
+	if err := writeMusicBrainzTags("ReEnrich", filePath, match); err != nil {
+		GoLog("[ReEnrich] %v\n", err)
+	}
+}
+
 func InitExtensionSystem(extensionsDir, dataDir string) error {
 	manager := getExtensionManager()
 	if err := manager.SetDirectories(extensionsDir, dataDir); err != nil {
@@ -4127,6 +4700,124 @@ func CancelLibraryScanJSON() {
 	CancelLibraryScan()
 }
 
+func SetLibraryScanAuthenticityEnabledJSON(enabled bool) {
+	SetLibraryScanAuthenticityEnabled(enabled)
+}
+
+// AnalyzeAudioAuthenticityJSON runs the fake-lossless analysis on a FLAC,
+// WAV or AIFF file.
+func AnalyzeAudioAuthenticityJSON(filePath string) (string, error) {
+	authenticity, err := AnalyzeAudioAuthenticity(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to analyze audio: %w", err)
+	}
+	resp := map[string]any{
+		"success":      true,
+		"authenticity": authenticity,
+	}
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// VerifyFLACFileJSON decodes a FLAC file and checks every frame CRC and the
+// STREAMINFO MD5. "valid" is false for corrupt or truncated files, with
+// first_corrupt_offset pointing at the first bad frame.
+func VerifyFLACFileJSON(filePath string) (string, error) {
+	result := VerifyFLACFile(filePath)
+	jsonBytes, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// VerifyFLACLibraryJSON verifies every FLAC file under folderPath. Poll
+// GetFLACVerifyProgressJSON for progress; only failed files are listed.
+func VerifyFLACLibraryJSON(folderPath string) (string, error) {
+	failed, totalFiles, err := VerifyFLACLibrary(folderPath)
+	if err != nil {
+		return "", err
+	}
+	if failed == nil {
+		failed = []*FLACVerifyResult{}
+	}
+	resp := map[string]any{
+		"success":      true,
+		"total_files":  totalFiles,
+		"failed_count": len(failed),
+		"failed":       failed,
+	}
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+func GetFLACVerifyProgressJSON() string {
+	jsonBytes, _ := json.Marshal(GetFLACVerifyProgress())
+	return string(jsonBytes)
+}
+
+func CancelFLACVerifyJSON() {
+	CancelFLACVerify()
+}
+
+// DecryptMP4JSON decrypts a CENC (AES-CTR) or CBCS protected MP4/M4A file in
+// place. key is a 128-bit hex or base64 key; iv optionally overrides the
+// constant IV.
+func DecryptMP4JSON(filePath, key, iv string) (string, error) {
+	if err := DecryptMP4File(filePath, key, iv); err != nil {
+		return "", fmt.Errorf("failed to decrypt: %w", err)
+	}
+	resp := map[string]any{
+		"success":   true,
+		"file_path": filePath,
+	}
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// DefragmentMP4JSON rewrites a fragmented MP4 (moof/mdat) as a progressive
+// MP4 with a single moov. outputPath may equal inputPath.
+func DefragmentMP4JSON(inputPath, outputPath string) (string, error) {
+	if err := DefragmentMP4(inputPath, outputPath); err != nil {
+		return "", fmt.Errorf("failed to defragment: %w", err)
+	}
+	resp := map[string]any{
+		"success":   true,
+		"file_path": outputPath,
+	}
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
+// RemuxMP4FLACJSON writes the FLAC track of an MP4/M4A file to outputPath as
+// a native .flac, carrying over tags and cover art. The input is kept.
+func RemuxMP4FLACJSON(inputPath, outputPath string) (string, error) {
+	if err := RemuxMP4FLACToFLAC(inputPath, outputPath); err != nil {
+		return "", fmt.Errorf("failed to remux FLAC: %w", err)
+	}
+	resp := map[string]any{
+		"success":   true,
+		"file_path": outputPath,
+	}
+	jsonBytes, err := json.Marshal(resp)
+	if err != nil {
+		return "", err
+	}
+	return string(jsonBytes), nil
+}
+
 func ReadAudioMetadataJSON(filePath string) (string, error) {
 	return ReadAudioMetadata(filePath)
 }
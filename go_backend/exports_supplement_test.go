@@ -140,7 +140,7 @@ func TestExportsJSONWrappersAndExtensionManagerSurface(t *testing.T) {
 	if response, err := EditFileMetadata(apePath, editJSON); err != nil || !strings.Contains(response, "native_ape") {
 		t.Fatalf("EditFileMetadata ape = %q/%v", response, err)
 	}
-	if response, err := EditFileMetadata(filepath.Join(dir, "edit.mp3"), editJSON); err != nil || !strings.Contains(response, "ffmpeg") {
+	if response, err := EditFileMetadata(filepath.Join(dir, "edit.wma"), editJSON); err != nil || !strings.Contains(response, "ffmpeg") {
 		t.Fatalf("EditFileMetadata ffmpeg = %q/%v", response, err)
 	}
 	misnamedM4APath := filepath.Join(dir, "misnamed.flac")
@@ -269,6 +269,27 @@ func TestEnrichRequestExtendedMetadataDoesNotFallbackAlbumArtistToTrackArtist(t
 	}
 }
 
+func TestEnrichRequestExtendedMetadataSkipsSharedAlbumMetadata(t *testing.T) {
+	origDeezerFetcher := fetchDeezerExtendedMetadataByISRC
+	origMusicBrainzAlbumArtistFetcher := fetchMusicBrainzAlbumArtistByISRC
+	defer func() {
+		fetchDeezerExtendedMetadataByISRC = origDeezerFetcher
+		fetchMusicBrainzAlbumArtistByISRC = origMusicBrainzAlbumArtistFetcher
+	}()
+
+	fetchDeezerExtendedMetadataByISRC = func(ctx context.Context, isrc string) (*AlbumExtendedMetadata, error) {
+		t.Fatal("album metadata was resolved once and should not be looked up per track")
+		return nil, nil
+	}
+	fetchMusicBrainzAlbumArtistByISRC = func(isrc string, albumName string) (string, error) {
+		t.Fatal("album artist was resolved once and should not be looked up per track")
+		return "", nil
+	}
+
+	req := DownloadRequest{ISRC: "TESTISRC", AlbumName: "Target Album", SharedMetadataResolved: true}
+	enrichRequestExtendedMetadata(&req)
+}
+
 func TestEnrichExtraMetadataByISRCFallsBackToMusicBrainzGenre(t *testing.T) {
 	origDeezerFetcher := fetchDeezerExtendedMetadataByISRC
 	origMusicBrainzFetcher := fetchMusicBrainzGenreByISRC
@@ -102,6 +102,12 @@ type ExtAvailabilityResult struct {
 	Reason       string `json:"reason,omitempty"`
 	TrackID      string `json:"track_id,omitempty"`
 	SkipFallback bool   `json:"skip_fallback,omitempty"`
+	// Declared quality of the match, used to rank providers when racing
+	// for the best quality. All optional.
+	BitDepth   int    `json:"bit_depth,omitempty"`
+	SampleRate int    `json:"sample_rate,omitempty"`
+	Codec      string `json:"codec,omitempty"`
+	Quality    string `json:"quality,omitempty"`
 }
 
 type ExtDownloadURLResult struct {
@@ -237,9 +243,12 @@ func normalizeDownloadResultExtension(candidates ...string) string {
 	return ""
 }
 
-func normalizeExtensionDownloadResult(result *ExtDownloadResult) (DownloadResult, bool) {
+// normalizeExtensionDownloadResult converts an extension result and runs the
+// native post-processing on fresh downloads. The error is a failed native
+// decryption, which fails the provider.
+func normalizeExtensionDownloadResult(result *ExtDownloadResult) (DownloadResult, bool, error) {
 	if result == nil {
-		return DownloadResult{}, false
+		return DownloadResult{}, false, nil
 	}
 
 	downloadResult := DownloadResult{
@@ -275,8 +284,26 @@ func normalizeExtensionDownloadResult(result *ExtDownloadResult) (DownloadResult
 		downloadResult.FilePath = strings.TrimPrefix(downloadResult.FilePath, "EXISTS:")
 	}
 
+	if !alreadyExists {
+		if err := applyNativeDecryption(&downloadResult); err != nil {
+			return downloadResult, false, err
+		}
+		applyNativeDefragment(&downloadResult)
+		applyNativeFLACRemux(&downloadResult)
+		// overlayExtensionDownloadMetadata reads the raw result again, so it
+		// has to follow the native post-processing.
+		result.FilePath = downloadResult.FilePath
+		result.ActualExtension = downloadResult.ActualExtension
+		result.OutputExtension = ""
+		result.ActualContainer = downloadResult.ActualContainer
+		result.RequiresContainerConversion = downloadResult.RequiresContainerConversion
+		if downloadResult.Decryption == nil {
+			result.Decryption = nil
+			result.DecryptionKey = ""
+		}
+	}
 	enrichResultQualityFromFile(&downloadResult)
-	return downloadResult, alreadyExists
+	return downloadResult, alreadyExists, nil
 }
 
 func overlayExtensionDownloadMetadata(resp *DownloadResponse, result *ExtDownloadResult) {
@@ -1087,6 +1114,10 @@ func parseExtensionAvailabilityValue(vm *goja.Runtime, value goja.Value) ExtAvai
 		Reason:       gojaObjectString(obj, "reason"),
 		TrackID:      gojaObjectString(obj, "track_id", "trackId"),
 		SkipFallback: gojaObjectBool(obj, "skip_fallback", "skipFallback"),
+		BitDepth:     gojaObjectInt(obj, "bit_depth", "bitDepth"),
+		SampleRate:   gojaObjectInt(obj, "sample_rate", "sampleRate"),
+		Codec:        gojaObjectString(obj, "codec", "format"),
+		Quality:      gojaObjectString(obj, "quality"),
 	}
 }
 
@@ -2325,6 +2356,10 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 	var sourceExtensionLocked bool
 	var sourceExtensionAvailability *ExtAvailabilityResult
 	var sourceExtensionTrackID string
+	qualityGate := newDownloadQualityGate(req)
+	defer qualityGate.discardKept()
+	merger := newMetadataMerger(req.Source)
+	merger.add(metadataSourceRequest, snapshotMergeFields(downloadRequestMergeFields(&req)))
 
 	if req.Source != "" && selectedProvider != req.Source {
 		ext, err := extManager.GetExtension(req.Source)
@@ -2373,6 +2408,8 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 				return nil, ErrDownloadCancelled
 			}
 			if err == nil && enrichedTrack != nil {
+				merger.add(req.Source, *enrichedTrack)
+				req.coverCandidates = append(req.coverCandidates, coverCandidate{Source: req.Source, URL: enrichedTrack.CoverURL})
 				if enrichedTrack.ISRC != "" && enrichedTrack.ISRC != req.ISRC {
 					GoLog("[DownloadWithExtensionFallback] ISRC enriched: %s -> %s\n", req.ISRC, enrichedTrack.ISRC)
 					req.ISRC = enrichedTrack.ISRC
@@ -2468,6 +2505,8 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 			track := tracks[0]
 			GoLog("[DownloadWithExtensionFallback] Metadata match (%s): %s - %s (album: %s, date: %s, isrc: %s)\n",
 				track.ProviderID, track.Name, track.Artists, track.AlbumName, track.ReleaseDate, track.ISRC)
+			merger.add(track.ProviderID, track)
+			req.coverCandidates = append(req.coverCandidates, coverCandidate{Source: track.ProviderID, URL: track.CoverURL})
 
 			if track.AlbumName != "" && req.AlbumName == "" {
 				req.AlbumName = track.AlbumName
@@ -2512,7 +2551,7 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 			GoLog("[DownloadWithExtensionFallback] Metadata provider search failed (non-fatal): %v\n", searchErr)
 		}
 
-		if req.ISRC != "" &&
+		if req.ISRC != "" && !req.SharedMetadataResolved &&
 			(req.Genre == "" || req.Label == "" || req.Copyright == "") {
 			enrichExtraMetadataByISRC("DownloadWithExtensionFallback", req.ISRC, &req.Genre, &req.Label, &req.Copyright)
 			if isDownloadCancelled(req.ItemID) {
@@ -2521,6 +2560,9 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 		}
 	}
 
+	merger.fetchISRCSources("DownloadWithExtensionFallback", req.ISRC, req.AlbumName)
+	metadataSources := merger.apply("DownloadWithExtensionFallback", downloadRequestMergeFields(&req))
+
 	if req.Source != "" && selectedProvider == req.Source {
 		if isDownloadCancelled(req.ItemID) {
 			return nil, ErrDownloadCancelled
@@ -2560,6 +2602,11 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 					SetItemProgress(req.ItemID, normalized, 0, 0)
 				}
 			})
+			var normalizedResult DownloadResult
+			var alreadyExists bool
+			if err == nil && result != nil && result.Success {
+				normalizedResult, alreadyExists, err = normalizeExtensionDownloadResult(result)
+			}
 			if req.ItemID != "" {
 				if err == nil && result != nil && result.Success {
 					CompleteItemProgress(req.ItemID)
@@ -2572,7 +2619,6 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 			}
 
 			if err == nil && result.Success {
-				normalizedResult, alreadyExists := normalizeExtensionDownloadResult(result)
 				message := "Downloaded from " + req.Source
 				if alreadyExists {
 					message = "File already exists"
@@ -2602,22 +2648,21 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 					resp.Composer = req.Composer
 				}
 
-				embedExtensionDownloadMetadata(resp, req, alreadyExists)
-
-				if !alreadyExists && !isFDOutput(req.OutputFD) && strings.TrimSpace(req.OutputDir) != "" {
-					indexISRC := strings.TrimSpace(resp.ISRC)
-					if indexISRC == "" {
-						indexISRC = strings.TrimSpace(req.ISRC)
-					}
-					if indexISRC != "" && strings.TrimSpace(resp.FilePath) != "" {
-						AddToISRCIndex(req.OutputDir, indexISRC, resp.FilePath)
+				// The quality check comes first so a rejected file is not
+				// tagged only to be discarded.
+				if stopProviderFallback || sourceExtensionLocked || qualityGate.accept(req, req.Source, req.Quality, &resp) {
+					embedExtensionDownloadMetadata(resp, applyCoverArbitration(&resp, &req), alreadyExists)
+					if !alreadyExists {
+						addExtensionDownloadToISRCIndex(req, resp)
 					}
+					qualityGate.finish(&resp)
+					resp.MetadataSources = metadataSources
+					return &resp, nil
 				}
-
-				return &resp, nil
-			}
-
-			if err != nil {
+				lastErr = fmt.Errorf("%s delivered less than the quality policy allows", req.Source)
+				lastErrType = "quality_below_policy"
+				lastRetryAfterSeconds = 0
+			} else if err != nil {
 				if errors.Is(err, ErrDownloadCancelled) {
 					return &DownloadResponse{
 						Success:   false,
@@ -2666,6 +2711,27 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 
 	priority = prioritizeFallbackProvidersByHealth(priority, extManager, req.Source)
 
+	var racedAvailability map[string]providerAvailability
+	if req.BestQuality && len(priority) > 1 {
+		candidates := make([]string, 0, len(priority))
+		for _, providerID := range priority {
+			providerID = strings.TrimSpace(providerID)
+			if providerID == "" || (providerID == req.Source && req.Source != selectedProvider) {
+				continue
+			}
+			if providerID != selectedProvider && !isExtensionFallbackAllowed(providerID) {
+				continue
+			}
+			candidates = append(candidates, providerID)
+		}
+		racedAvailability = raceProviderAvailability(req, candidates, extManager)
+		if isDownloadCancelled(req.ItemID) {
+			return nil, ErrDownloadCancelled
+		}
+		priority = rankProvidersByQuality(priority, req.Quality, racedAvailability)
+		GoLog("[DownloadWithExtensionFallback] Best quality order: %v\n", priority)
+	}
+
 	for _, providerID := range priority {
 		if isDownloadCancelled(req.ItemID) {
 			return nil, ErrDownloadCancelled
@@ -2685,6 +2751,9 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 			GoLog("[DownloadWithExtensionFallback] Skipping extension provider %s (not enabled for fallback)\n", providerID)
 			continue
 		}
+		if qualityGate.skip(providerID) {
+			continue
+		}
 
 		GoLog("[DownloadWithExtensionFallback] Trying provider: %s\n", providerID)
 
@@ -2701,7 +2770,12 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 
 			provider := newExtensionProviderWrapper(ext)
 
-			availability, err := provider.CheckAvailabilityForItemID(req.ISRC, req.TrackName, req.ArtistName, req.SpotifyID, req.DeezerID, req.TidalID, req.QobuzID, req.DurationMS, req.ItemID)
+			var availability *ExtAvailabilityResult
+			if raced, ok := racedAvailability[providerID]; ok {
+				availability, err = raced.availability, raced.err
+			} else {
+				availability, err = provider.CheckAvailabilityForItemID(req.ISRC, req.TrackName, req.ArtistName, req.SpotifyID, req.DeezerID, req.TidalID, req.QobuzID, req.DurationMS, req.ItemID)
+			}
 			if shouldAbortCancelledFallback(req.ItemID, err) {
 				return nil, ErrDownloadCancelled
 			}
@@ -2768,6 +2842,11 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 					SetItemProgress(req.ItemID, normalized, 0, 0)
 				}
 			})
+			var normalizedResult DownloadResult
+			var alreadyExists bool
+			if err == nil && result != nil && result.Success {
+				normalizedResult, alreadyExists, err = normalizeExtensionDownloadResult(result)
+			}
 			if req.ItemID != "" {
 				if err == nil && result != nil && result.Success {
 					CompleteItemProgress(req.ItemID)
@@ -2780,7 +2859,6 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 			}
 
 			if err == nil && result.Success {
-				normalizedResult, alreadyExists := normalizeExtensionDownloadResult(result)
 				message := "Downloaded from " + providerID
 				if alreadyExists {
 					message = "File already exists"
@@ -2800,19 +2878,19 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 				}
 				applyExtensionRequestFallbacks(&resp, req)
 
-				embedExtensionDownloadMetadata(resp, req, alreadyExists)
-
-				if !alreadyExists && !isFDOutput(req.OutputFD) && strings.TrimSpace(req.OutputDir) != "" {
-					indexISRC := strings.TrimSpace(resp.ISRC)
-					if indexISRC == "" {
-						indexISRC = strings.TrimSpace(req.ISRC)
-					}
-					if indexISRC != "" && strings.TrimSpace(resp.FilePath) != "" {
-						AddToISRCIndex(req.OutputDir, indexISRC, resp.FilePath)
+				if terminalAvailability || qualityGate.accept(req, providerID, fallbackQuality, &resp) {
+					embedExtensionDownloadMetadata(resp, applyCoverArbitration(&resp, &req), alreadyExists)
+					if !alreadyExists {
+						addExtensionDownloadToISRCIndex(req, resp)
 					}
+					qualityGate.finish(&resp)
+					resp.MetadataSources = metadataSources
+					return &resp, nil
 				}
-
-				return &resp, nil
+				lastErr = fmt.Errorf("%s delivered less than the quality policy allows", providerID)
+				lastErrType = "quality_below_policy"
+				lastRetryAfterSeconds = 0
+				continue
 			}
 
 			if err != nil {
@@ -2857,6 +2935,17 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 		}
 	}
 
+	if resp := qualityGate.restoreKept(); resp != nil {
+		embedReq := applyCoverArbitration(resp, &req)
+		embedExtensionDownloadMetadata(*resp, embedReq, false)
+		addExtensionDownloadToISRCIndex(req, *resp)
+		resp.MetadataSources = metadataSources
+		return resp, nil
+	}
+	if lastErrType == "quality_below_policy" {
+		return qualityGate.failure(), nil
+	}
+
 	if lastErr != nil {
 		errorType := firstNonEmptyString(lastErrType, classifyDownloadErrorType(lastErr.Error()))
 		if errorType == "unknown" {
@@ -2867,6 +2956,7 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 			Error:             "All providers failed. Last error: " + lastErr.Error(),
 			ErrorType:         errorType,
 			RetryAfterSeconds: lastRetryAfterSeconds,
+			QualityRejections: qualityGate.rejections,
 		}, nil
 	}
 
@@ -2877,6 +2967,19 @@ func DownloadWithExtensionFallback(req DownloadRequest) (*DownloadResponse, erro
 	}, nil
 }
 
+func addExtensionDownloadToISRCIndex(req DownloadRequest, resp DownloadResponse) {
+	if isFDOutput(req.OutputFD) || strings.TrimSpace(req.OutputDir) == "" {
+		return
+	}
+	indexISRC := strings.TrimSpace(resp.ISRC)
+	if indexISRC == "" {
+		indexISRC = strings.TrimSpace(req.ISRC)
+	}
+	if indexISRC != "" && strings.TrimSpace(resp.FilePath) != "" {
+		AddToISRCIndex(req.OutputDir, indexISRC, resp.FilePath)
+	}
+}
+
 func buildOutputPath(req DownloadRequest) string {
 	if strings.TrimSpace(req.OutputPath) != "" {
 		return strings.TrimSpace(req.OutputPath)
@@ -3053,6 +3156,22 @@ func embedExtensionDownloadMetadata(resp DownloadResponse, req DownloadRequest,
 	} else {
 		GoLog("[DownloadWithExtensionFallback] Embedded metadata without cover\n")
 	}
+
+	if req.EmbedReplayGain {
+		if err := embedTrackReplayGain(filePath); err != nil {
+			GoLog("[DownloadWithExtensionFallback] Warning: failed to embed ReplayGain: %v\n", err)
+		}
+	}
+
+	if req.EmbedMusicBrainz && req.musicBrainzMatch != nil {
+		if err := writeMusicBrainzTags("DownloadWithExtensionFallback", filePath, req.musicBrainzMatch); err != nil {
+			GoLog("[DownloadWithExtensionFallback] Warning: %v\n", err)
+		}
+	} else if req.EmbedMusicBrainz && metadata.ISRC != "" && !req.musicBrainzLookupDone {
+		if _, err := embedMusicBrainzTags("DownloadWithExtensionFallback", filePath, metadata.ISRC, metadata.Album, metadata.TotalTracks); err != nil {
+			GoLog("[DownloadWithExtensionFallback] Warning: failed to embed MusicBrainz tags: %v\n", err)
+		}
+	}
 }
 
 func firstPositiveInt(values ...int) int {
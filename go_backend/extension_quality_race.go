@@ -0,0 +1,193 @@
+package gobackend
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// audioQualityTier orders declared qualities coarsely. A provider that does
+// not declare anything ranks above a lossy one: most download providers are
+// lossless and simply predate the quality fields.
+const (
+	audioQualityTierLossy = iota
+	audioQualityTierUnknown
+	audioQualityTierLossless
+)
+
+// audioQualityRank is a comparable quality. Zero bit depth or sample rate
+// means unknown, or no limit when used as a requested target.
+type audioQualityRank struct {
+	Tier       int
+	BitDepth   int
+	SampleRate int
+}
+
+var (
+	losslessAudioCodecs = []string{"flac", "alac", "wav", "aiff", "ape", "wavpack", "lossless"}
+	lossyAudioCodecs    = []string{"mp3", "aac", "opus", "vorbis", "ogg", "ac3", "eac3", "ac4"}
+)
+
+// requestedQualityRank maps the requested quality token onto a target.
+// Provider tokens differ, so it understands the usual families:
+// "24bit/96kHz" labels, HI_RES/MAX variants, LOSSLESS/CD and lossy names.
+// Anything else asks for the best available.
+func requestedQualityRank(quality string) audioQualityRank {
+	upper := strings.ToUpper(strings.TrimSpace(quality))
+	if bitDepth, sampleRate := parseBitDepthSampleRate(upper); bitDepth > 0 {
+		return audioQualityRank{Tier: audioQualityTierLossless, BitDepth: bitDepth, SampleRate: int(sampleRate * 1000)}
+	}
+	switch {
+	case upper == "",
+		strings.Contains(upper, "HI_RES"),
+		strings.Contains(upper, "HIRES"),
+		strings.Contains(upper, "MAX"),
+		strings.Contains(upper, "MASTER"):
+		return audioQualityRank{Tier: audioQualityTierLossless}
+	case strings.Contains(upper, "LOSSLESS"),
+		strings.Contains(upper, "FLAC"),
+		strings.Contains(upper, "CD"):
+		return audioQualityRank{Tier: audioQualityTierLossless, BitDepth: 16, SampleRate: 44100}
+	case strings.Contains(upper, "MP3"),
+		strings.Contains(upper, "AAC"),
+		strings.Contains(upper, "OPUS"),
+		strings.Contains(upper, "320"),
+		upper == "HIGH",
+		upper == "LOW":
+		return audioQualityRank{Tier: audioQualityTierLossy}
+	}
+	return audioQualityRank{Tier: audioQualityTierLossless}
+}
+
+// availabilityQualityRank reads the quality a provider declared for its
+// match, from the explicit fields first and the quality label second.
+func availabilityQualityRank(availability *ExtAvailabilityResult) audioQualityRank {
+	if availability == nil {
+		return audioQualityRank{Tier: audioQualityTierUnknown}
+	}
+	rank := audioQualityRank{
+		Tier:       audioQualityTierUnknown,
+		BitDepth:   availability.BitDepth,
+		SampleRate: availability.SampleRate,
+	}
+	// Some providers report kHz.
+	if rank.SampleRate > 0 && rank.SampleRate < 1000 {
+		rank.SampleRate *= 1000
+	}
+	if rank.BitDepth == 0 {
+		bitDepth, sampleRate := parseBitDepthSampleRate(availability.Quality)
+		rank.BitDepth = bitDepth
+		if rank.SampleRate == 0 {
+			rank.SampleRate = int(sampleRate * 1000)
+		}
+	}
+
+	codec := strings.ToLower(strings.TrimSpace(availability.Codec))
+	label := strings.ToUpper(availability.Quality)
+	switch {
+	case codec != "" && containsAudioCodec(losslessAudioCodecs, codec):
+		rank.Tier = audioQualityTierLossless
+	case codec != "" && containsAudioCodec(lossyAudioCodecs, codec):
+		rank.Tier = audioQualityTierLossy
+	case rank.BitDepth > 0,
+		strings.Contains(label, "LOSSLESS"),
+		strings.Contains(label, "HI_RES"):
+		rank.Tier = audioQualityTierLossless
+	}
+	if rank.Tier == audioQualityTierLossless && rank.BitDepth == 0 && strings.Contains(label, "HI_RES") {
+		rank.BitDepth = 24
+	}
+	return rank
+}
+
+func containsAudioCodec(codecs []string, codec string) bool {
+	for _, c := range codecs {
+		if strings.Contains(codec, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// cappedTo limits a rank to the requested target, so that anything at least
+// as good as what was asked for compares equal and priority decides.
+func (r audioQualityRank) cappedTo(target audioQualityRank) audioQualityRank {
+	if r.Tier > target.Tier && target.Tier != audioQualityTierUnknown {
+		r.Tier = target.Tier
+	}
+	if target.Tier == audioQualityTierLossy {
+		r.BitDepth, r.SampleRate = 0, 0
+	}
+	if target.BitDepth > 0 {
+		r.BitDepth = min(r.BitDepth, target.BitDepth)
+	}
+	if target.SampleRate > 0 {
+		r.SampleRate = min(r.SampleRate, target.SampleRate)
+	}
+	return r
+}
+
+func (r audioQualityRank) better(other audioQualityRank) bool {
+	if r.Tier != other.Tier {
+		return r.Tier > other.Tier
+	}
+	if r.BitDepth != other.BitDepth {
+		return r.BitDepth > other.BitDepth
+	}
+	return r.SampleRate > other.SampleRate
+}
+
+type providerAvailability struct {
+	availability *ExtAvailabilityResult
+	err          error
+}
+
+// raceProviderAvailability checks every provider at once instead of one
+// after another.
+func raceProviderAvailability(req DownloadRequest, providerIDs []string, extManager *extensionManager) map[string]providerAvailability {
+	results := make(map[string]providerAvailability, len(providerIDs))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, providerID := range providerIDs {
+		ext, err := extManager.GetExtension(providerID)
+		if err != nil || !ext.Enabled || ext.Error != "" || !ext.Manifest.IsDownloadProvider() {
+			continue
+		}
+		wg.Add(1)
+		go func(providerID string, ext *loadedExtension) {
+			defer wg.Done()
+			provider := newExtensionProviderWrapper(ext)
+			availability, err := provider.CheckAvailabilityForItemID(req.ISRC, req.TrackName, req.ArtistName, req.SpotifyID, req.DeezerID, req.TidalID, req.QobuzID, req.DurationMS, req.ItemID)
+			mu.Lock()
+			results[providerID] = providerAvailability{availability: availability, err: err}
+			mu.Unlock()
+		}(providerID, ext)
+	}
+	wg.Wait()
+	return results
+}
+
+// rankProvidersByQuality reorders providers so the available ones come
+// first, best declared quality relative to the requested one leading. Ties
+// and everything unavailable keep their priority order, which is also the
+// order the fallback continues in when the best provider fails.
+func rankProvidersByQuality(priority []string, quality string, results map[string]providerAvailability) []string {
+	target := requestedQualityRank(quality)
+	ranks := make(map[string]audioQualityRank, len(results))
+	for providerID, result := range results {
+		if result.err == nil && result.availability != nil && result.availability.Available {
+			ranks[providerID] = availabilityQualityRank(result.availability).cappedTo(target)
+		}
+	}
+
+	ranked := append([]string(nil), priority...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		ri, okI := ranks[ranked[i]]
+		rj, okJ := ranks[ranked[j]]
+		if okI != okJ {
+			return okI
+		}
+		return okI && ri.better(rj)
+	})
+	return ranked
+}
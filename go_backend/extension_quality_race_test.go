@@ -0,0 +1,76 @@
+package gobackend
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRequestedQualityRank(t *testing.T) {
+	tests := []struct {
+		quality string
+		want    audioQualityRank
+	}{
+		{"", audioQualityRank{Tier: audioQualityTierLossless}},
+		{"HI_RES_LOSSLESS", audioQualityRank{Tier: audioQualityTierLossless}},
+		{"LOSSLESS", audioQualityRank{Tier: audioQualityTierLossless, BitDepth: 16, SampleRate: 44100}},
+		{"24bit/96kHz", audioQualityRank{Tier: audioQualityTierLossless, BitDepth: 24, SampleRate: 96000}},
+		{"mp3_320", audioQualityRank{Tier: audioQualityTierLossy}},
+	}
+	for _, tt := range tests {
+		if got := requestedQualityRank(tt.quality); got != tt.want {
+			t.Errorf("requestedQualityRank(%q) = %+v, want %+v", tt.quality, got, tt.want)
+		}
+	}
+}
+
+func TestAvailabilityQualityRank(t *testing.T) {
+	tests := []struct {
+		name         string
+		availability *ExtAvailabilityResult
+		want         audioQualityRank
+	}{
+		{"nil", nil, audioQualityRank{Tier: audioQualityTierUnknown}},
+		{"undeclared", &ExtAvailabilityResult{Available: true}, audioQualityRank{Tier: audioQualityTierUnknown}},
+		{"explicit fields in kHz", &ExtAvailabilityResult{BitDepth: 24, SampleRate: 192, Codec: "FLAC"}, audioQualityRank{Tier: audioQualityTierLossless, BitDepth: 24, SampleRate: 192000}},
+		{"label only", &ExtAvailabilityResult{Quality: "16bit/44.1kHz"}, audioQualityRank{Tier: audioQualityTierLossless, BitDepth: 16, SampleRate: 44100}},
+		{"hi-res label", &ExtAvailabilityResult{Quality: "HI_RES_LOSSLESS"}, audioQualityRank{Tier: audioQualityTierLossless, BitDepth: 24}},
+		{"lossy codec", &ExtAvailabilityResult{Codec: "aac"}, audioQualityRank{Tier: audioQualityTierLossy}},
+	}
+	for _, tt := range tests {
+		if got := availabilityQualityRank(tt.availability); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRankProvidersByQualityPrefersHigherQuality(t *testing.T) {
+	results := map[string]providerAvailability{
+		"cd":      {availability: &ExtAvailabilityResult{Available: true, BitDepth: 16, SampleRate: 44100}},
+		"missing": {availability: &ExtAvailabilityResult{Available: false, BitDepth: 24, SampleRate: 192000}},
+		"hires":   {availability: &ExtAvailabilityResult{Available: true, BitDepth: 24, SampleRate: 96000}},
+		"lossy":   {availability: &ExtAvailabilityResult{Available: true, Codec: "mp3"}},
+	}
+	priority := []string{"cd", "missing", "lossy", "hires", "unchecked"}
+
+	got := rankProvidersByQuality(priority, "", results)
+	want := []string{"hires", "cd", "lossy", "missing", "unchecked"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("best quality order = %v, want %v", got, want)
+	}
+	if priority[0] != "cd" {
+		t.Fatalf("priority slice was modified: %v", priority)
+	}
+}
+
+func TestRankProvidersByQualityKeepsPriorityAboveTarget(t *testing.T) {
+	results := map[string]providerAvailability{
+		"first":  {availability: &ExtAvailabilityResult{Available: true, BitDepth: 16, SampleRate: 44100}},
+		"second": {availability: &ExtAvailabilityResult{Available: true, BitDepth: 24, SampleRate: 192000}},
+	}
+
+	got := rankProvidersByQuality([]string{"first", "second"}, "LOSSLESS", results)
+	want := []string{"first", "second"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("order for CD request = %v, want %v", got, want)
+	}
+}
@@ -506,6 +506,8 @@ func (r *extensionRuntime) RegisterAPIs(vm *goja.Runtime) {
 
 	fileObj := vm.NewObject()
 	fileObj.Set("download", r.fileDownload)
+	fileObj.Set("downloadHLS", r.fileDownloadHLS)
+	fileObj.Set("downloadDASH", r.fileDownloadDASH)
 	fileObj.Set("exists", r.fileExists)
 	fileObj.Set("delete", r.fileDelete)
 	fileObj.Set("read", r.fileRead)
@@ -550,6 +552,8 @@ func (r *extensionRuntime) RegisterAPIs(vm *goja.Runtime) {
 	utilsObj.Set("appUserAgent", r.appUserAgent)
 	utilsObj.Set("sleep", r.sleep)
 	utilsObj.Set("isDownloadCancelled", r.isDownloadCancelled)
+	utilsObj.Set("isDownloadPaused", r.isDownloadPaused)
+	utilsObj.Set("waitWhileDownloadPaused", r.waitWhileDownloadPaused)
 	utilsObj.Set("isRequestCancelled", r.isRequestCancelled)
 	utilsObj.Set("setDownloadStatus", r.setDownloadStatus)
 	vm.Set("utils", utilsObj)
@@ -137,6 +137,8 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 	var headers map[string]string
 	var chunkedDownload bool
 	trackItemBytes := true
+	resume := true
+	connections := int(parallelDownloadConnections.Load())
 	var chunkSize int64
 	if len(call.Arguments) > 2 && !goja.IsUndefined(call.Arguments[2]) && !goja.IsNull(call.Arguments[2]) {
 		optionsObj := call.Arguments[2].Export()
@@ -161,6 +163,12 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 					trackItemBytes = v
 				}
 			}
+			if v, ok := opts["resume"].(bool); ok {
+				resume = v
+			}
+			if runtimeOptionHasKey(opts, "connections") {
+				connections = int(min(max(runtimeOptionInt64(opts, "connections", 1), 1), maxParallelDownloadConnections))
+			}
 			if chunked, ok := opts["chunked"]; ok {
 				switch v := chunked.(type) {
 				case bool:
@@ -204,7 +212,13 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 	}
 
 	if chunkedDownload {
-		return r.fileDownloadChunked(client, urlStr, fullPath, headers, ua, chunkSize, onProgress, trackItemBytes)
+		return r.fileDownloadChunked(client, urlStr, fullPath, headers, ua, chunkSize, onProgress, trackItemBytes, resume)
+	}
+
+	if connections > 1 {
+		if result, handled := r.fileDownloadParallel(client, urlStr, fullPath, headers, ua, connections, onProgress, trackItemBytes, resume); handled {
+			return result
+		}
 	}
 
 	req, err := http.NewRequest("GET", urlStr, nil)
@@ -223,6 +237,24 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 		req.Header.Set("User-Agent", appUserAgent())
 	}
 
+	// Continue a partial file left by an interrupted attempt. If-Range makes
+	// the server send the whole file instead when it has changed since.
+	var resumeState *downloadResumeState
+	var offset int64
+	if resume {
+		if resumeState = loadDownloadResumeState(fullPath, urlStr); resumeState != nil {
+			offset = resumeState.resumeOffset()
+		}
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if v := resumeState.ifRange(); v != "" {
+			req.Header.Set("If-Range", v)
+		}
+	} else {
+		resumeState = nil
+	}
+
 	resp, err := client.Do(req)
 	if err != nil {
 		return r.vm.ToValue(map[string]interface{}{
@@ -232,6 +264,17 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0 && offset == resumeState.TotalSize {
+		// The previous attempt got every byte but died before finishing up.
+		resumeState.clear()
+		GoLog("[Extension:%s] Partial download %s was already complete\n", r.extensionID, fullPath)
+		return r.vm.ToValue(map[string]interface{}{
+			"success": true,
+			"path":    fullPath,
+			"size":    offset,
+		})
+	}
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -239,7 +282,25 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 		})
 	}
 
-	out, err := os.Create(fullPath)
+	if offset > 0 {
+		if resp.StatusCode == http.StatusPartialContent {
+			start, _, total, ok := parseContentRange(resp.Header.Get("Content-Range"))
+			if !ok || start != offset || (resumeState.TotalSize > 0 && total != resumeState.TotalSize) {
+				resumeState.clear()
+				return r.vm.ToValue(map[string]interface{}{
+					"success": false,
+					"error":   fmt.Sprintf("unexpected Content-Range on resume: %q", resp.Header.Get("Content-Range")),
+				})
+			}
+			GoLog("[Extension:%s] Resuming download of %s at %d bytes\n", r.extensionID, fullPath, offset)
+		} else {
+			GoLog("[Extension:%s] Server did not honor resume range, restarting %s\n", r.extensionID, fullPath)
+			offset = 0
+			resumeState = nil
+		}
+	}
+
+	out, err := openResumableOutput(fullPath, offset)
 	if err != nil {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -254,6 +315,9 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 	}
 
 	contentLength := resp.ContentLength
+	if contentLength > 0 {
+		contentLength += offset
+	}
 	shouldTrackItemBytes := activeItemID != "" && trackItemBytes
 	if shouldTrackItemBytes && contentLength > 0 {
 		SetItemBytesTotal(activeItemID, contentLength)
@@ -261,10 +325,33 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 
 	var progressWriter interface{ Write([]byte) (int, error) } = out
 	if shouldTrackItemBytes {
-		progressWriter = NewItemProgressWriter(out, activeItemID)
+		progressWriter = newResumedItemProgressWriter(out, activeItemID, offset)
+	} else if activeItemID != "" {
+		progressWriter = &pausableWriter{writer: out, itemID: activeItemID}
+	}
+
+	// Keep the sidecar up to date; it outlives failures and cancellation so
+	// the next attempt can pick up from here.
+	completed := false
+	if resume {
+		if resumeState == nil {
+			resumeState = newDownloadResumeState(fullPath, urlStr)
+			resumeState.setValidators(resp.Header)
+		}
+		if contentLength > 0 {
+			resumeState.TotalSize = contentLength
+		}
+		progressWriter = &resumeTrackingWriter{writer: progressWriter, state: resumeState, pos: offset}
+		defer func() {
+			if !completed {
+				if err := resumeState.save(); err != nil {
+					GoLog("[Extension:%s] Failed to save resume state: %v\n", r.extensionID, err)
+				}
+			}
+		}()
 	}
 
-	var written int64
+	written := offset
 	buf := make([]byte, 32*1024)
 	for {
 		nr, er := resp.Body.Read(buf)
@@ -302,6 +389,12 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 		}
 		if er != nil {
 			if er != io.EOF {
+				if activeItemID != "" && isDownloadCancelled(activeItemID) {
+					return r.vm.ToValue(map[string]interface{}{
+						"success": false,
+						"error":   "download cancelled",
+					})
+				}
 				return r.vm.ToValue(map[string]interface{}{
 					"success": false,
 					"error":   fmt.Sprintf("failed to read response: %v", er),
@@ -319,6 +412,11 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 		}
 	}
 
+	if resumeState != nil {
+		completed = true
+		resumeState.clear()
+	}
+
 	GoLog("[Extension:%s] Downloaded %d bytes to %s\n", r.extensionID, written, fullPath)
 
 	return r.vm.ToValue(map[string]interface{}{
@@ -331,7 +429,9 @@ func (r *extensionRuntime) fileDownload(call goja.FunctionCall) goja.Value {
 // fileDownloadChunked downloads a URL using sequential Range requests.
 // This is needed for servers (like YouTube's googlevideo CDN) that reject
 // non-ranged or large-range requests with 403 and require small chunk downloads.
-func (r *extensionRuntime) fileDownloadChunked(client *http.Client, urlStr, fullPath string, headers map[string]string, ua string, chunkSize int64, onProgress goja.Callable, trackItemBytes bool) goja.Value {
+// When the total size is known, an interrupted download resumes from its
+// resume state if the probe still reports the same ETag/Last-Modified.
+func (r *extensionRuntime) fileDownloadChunked(client *http.Client, urlStr, fullPath string, headers map[string]string, ua string, chunkSize int64, onProgress goja.Callable, trackItemBytes, resume bool) goja.Value {
 	// First, get the total content length with a small probe request
 	probeReq, err := http.NewRequest("GET", urlStr, nil)
 	if err != nil {
@@ -386,7 +486,23 @@ func (r *extensionRuntime) fileDownloadChunked(client *http.Client, urlStr, full
 		GoLog("[Extension:%s] Chunked download: total size %d bytes, chunk size %d\n", r.extensionID, totalSize, chunkSize)
 	}
 
-	out, err := os.Create(fullPath)
+	var resumeState *downloadResumeState
+	var resumeFrom int64
+	if resume && totalSize > 0 {
+		resumeState = loadDownloadResumeState(fullPath, urlStr)
+		if resumeState != nil && resumeState.TotalSize == totalSize && resumeState.matchesValidators(probeResp.Header) {
+			resumeFrom = resumeState.resumeOffset()
+		}
+		if resumeFrom > 0 {
+			GoLog("[Extension:%s] Chunked download: resuming %s at %d bytes\n", r.extensionID, fullPath, resumeFrom)
+		} else {
+			resumeState = newDownloadResumeState(fullPath, urlStr)
+			resumeState.setValidators(probeResp.Header)
+			resumeState.TotalSize = totalSize
+		}
+	}
+
+	out, err := openResumableOutput(fullPath, resumeFrom)
 	if err != nil {
 		return r.vm.ToValue(map[string]interface{}{
 			"success": false,
@@ -407,14 +523,28 @@ func (r *extensionRuntime) fileDownloadChunked(client *http.Client, urlStr, full
 
 	var progressWriter interface{ Write([]byte) (int, error) } = out
 	if shouldTrackItemBytes {
-		progressWriter = NewItemProgressWriter(out, activeItemID)
+		progressWriter = newResumedItemProgressWriter(out, activeItemID, resumeFrom)
+	} else if activeItemID != "" {
+		progressWriter = &pausableWriter{writer: out, itemID: activeItemID}
+	}
+
+	completed := false
+	if resumeState != nil {
+		progressWriter = &resumeTrackingWriter{writer: progressWriter, state: resumeState, pos: resumeFrom}
+		defer func() {
+			if !completed && resumeState != nil {
+				if err := resumeState.save(); err != nil {
+					GoLog("[Extension:%s] Failed to save resume state: %v\n", r.extensionID, err)
+				}
+			}
+		}()
 	}
 
-	var totalWritten int64
+	totalWritten := resumeFrom
 	buf := make([]byte, 32*1024)
 	maxRetries := 3
 
-	for offset := int64(0); totalSize <= 0 || offset < totalSize; {
+	for offset := resumeFrom; totalSize <= 0 || offset < totalSize; {
 		end := offset + chunkSize - 1
 		if totalSize > 0 && end >= totalSize {
 			end = totalSize - 1
@@ -439,6 +569,11 @@ func (r *extensionRuntime) fileDownloadChunked(client *http.Client, urlStr, full
 				}
 			}
 			chunkReq.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, end))
+			if resumeState != nil {
+				if v := resumeState.ifRange(); v != "" {
+					chunkReq.Header.Set("If-Range", v)
+				}
+			}
 
 			chunkResp, chunkErr = client.Do(chunkReq)
 			if chunkErr != nil {
@@ -452,6 +587,22 @@ func (r *extensionRuntime) fileDownloadChunked(client *http.Client, urlStr, full
 				})
 			}
 
+			if chunkResp.StatusCode == 200 && offset > 0 {
+				// A full response part way through means the file changed
+				// (If-Range) or ranges stopped working; appending it would
+				// corrupt the output.
+				io.Copy(io.Discard, chunkResp.Body)
+				chunkResp.Body.Close()
+				if resumeState != nil {
+					resumeState.clear()
+					resumeState = nil
+				}
+				return r.vm.ToValue(map[string]interface{}{
+					"success": false,
+					"error":   fmt.Sprintf("chunked: remote file changed at offset %d", offset),
+				})
+			}
+
 			if chunkResp.StatusCode == 206 || chunkResp.StatusCode == 200 {
 				break // Success
 			}
@@ -513,6 +664,12 @@ func (r *extensionRuntime) fileDownloadChunked(client *http.Client, urlStr, full
 			if er != nil {
 				if er != io.EOF {
 					chunkResp.Body.Close()
+					if activeItemID != "" && isDownloadCancelled(activeItemID) {
+						return r.vm.ToValue(map[string]interface{}{
+							"success": false,
+							"error":   "download cancelled",
+						})
+					}
 					return r.vm.ToValue(map[string]interface{}{
 						"success": false,
 						"error":   fmt.Sprintf("failed to read chunk at offset %d: %v", offset, er),
@@ -549,6 +706,11 @@ func (r *extensionRuntime) fileDownloadChunked(client *http.Client, urlStr, full
 		}
 	}
 
+	if resumeState != nil {
+		completed = true
+		resumeState.clear()
+	}
+
 	GoLog("[Extension:%s] Chunked download complete: %d bytes to %s\n", r.extensionID, totalWritten, fullPath)
 
 	return r.vm.ToValue(map[string]interface{}{
@@ -0,0 +1,401 @@
+package gobackend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	maxParallelDownloadConnections = 16
+	// minParallelDownloadPart keeps small files on a single connection: the
+	// extra round trips cost more than they win below this size per part.
+	minParallelDownloadPart  = 512 * 1024
+	parallelProgressInterval = 250 * time.Millisecond
+)
+
+// parallelDownloadConnections is the app-wide connection count for
+// file.download when the extension does not pass one. 1 disables it.
+var parallelDownloadConnections atomic.Int32
+
+func init() {
+	parallelDownloadConnections.Store(1)
+}
+
+// SetParallelDownloadConnections sets how many connections extension
+// downloads use by default. Values below 2 keep single-connection downloads.
+func SetParallelDownloadConnections(connections int) {
+	parallelDownloadConnections.Store(int32(min(max(connections, 1), maxParallelDownloadConnections)))
+}
+
+var errParallelRemoteChanged = errors.New("remote file changed during download")
+
+type parallelDownload struct {
+	client   *http.Client
+	ctx      context.Context
+	url      string
+	headers  map[string]string
+	ua       string
+	ifRange  string
+	out      *os.File
+	itemID   string
+	persist  bool
+	received atomic.Int64
+
+	mu      sync.Mutex
+	state   *downloadResumeState
+	unsaved int64
+}
+
+// fileDownloadParallel fetches urlStr over several connections, each filling
+// its own byte range of a preallocated file. It reports handled=false when
+// the server does not support ranges or the file is too small to split, and
+// the caller then downloads over a single connection.
+func (r *extensionRuntime) fileDownloadParallel(client *http.Client, urlStr, fullPath string, headers map[string]string, ua string, connections int, onProgress goja.Callable, trackItemBytes, resume bool) (result goja.Value, handled bool) {
+	probeReq, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return nil, false
+	}
+	probeReq = r.bindDownloadCancelContext(probeReq)
+	setDownloadRequestHeaders(probeReq, headers, ua)
+	probeReq.Header.Set("Range", "bytes=0-0")
+	probeResp, err := client.Do(probeReq)
+	if err != nil {
+		GoLog("[Extension:%s] Parallel download probe failed, using one connection: %v\n", r.extensionID, err)
+		return nil, false
+	}
+	io.Copy(io.Discard, probeResp.Body)
+	probeResp.Body.Close()
+
+	_, _, totalSize, ok := parseContentRange(probeResp.Header.Get("Content-Range"))
+	if probeResp.StatusCode != http.StatusPartialContent || !ok || totalSize < 2*minParallelDownloadPart {
+		GoLog("[Extension:%s] Parallel download not possible (HTTP %d, size %d), using one connection\n", r.extensionID, probeResp.StatusCode, totalSize)
+		return nil, false
+	}
+	connections = int(min(int64(connections), totalSize/minParallelDownloadPart))
+
+	var state *downloadResumeState
+	if resume {
+		state = loadDownloadResumeState(fullPath, urlStr)
+		if state != nil && (state.TotalSize != totalSize || !state.matchesValidators(probeResp.Header)) {
+			state = nil
+		}
+	}
+	if state == nil {
+		state = newDownloadResumeState(fullPath, urlStr)
+		state.setValidators(probeResp.Header)
+		state.TotalSize = totalSize
+	} else {
+		GoLog("[Extension:%s] Parallel download: resuming %s with %d of %d bytes\n", r.extensionID, fullPath, state.BytesWritten, totalSize)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE
+	if len(state.Chunks) == 0 {
+		flags |= os.O_TRUNC
+	}
+	out, err := os.OpenFile(fullPath, flags, 0644)
+	if err == nil {
+		err = out.Truncate(totalSize)
+	}
+	if err != nil {
+		if out != nil {
+			out.Close()
+		}
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("failed to create file: %v", err),
+		}), true
+	}
+	defer out.Close()
+
+	ctx, cancel := context.WithCancel(probeReq.Context())
+	defer cancel()
+	pd := &parallelDownload{
+		client:  client,
+		ctx:     ctx,
+		url:     urlStr,
+		headers: headers,
+		ua:      ua,
+		ifRange: state.ifRange(),
+		out:     out,
+		itemID:  r.getActiveDownloadItemID(),
+		persist: resume,
+		state:   state,
+	}
+	pd.received.Store(state.BytesWritten)
+
+	if pd.itemID != "" {
+		SetItemDownloading(pd.itemID)
+	}
+	shouldTrackItemBytes := pd.itemID != "" && trackItemBytes
+	if shouldTrackItemBytes {
+		SetItemBytesTotal(pd.itemID, totalSize)
+	}
+
+	parts := splitDownloadRanges(state.missingRanges(totalSize), connections)
+	GoLog("[Extension:%s] Parallel download: %d bytes in %d parts over %d connections\n", r.extensionID, totalSize, len(parts), connections)
+
+	jobs := make(chan downloadResumeRange)
+	errs := make(chan error, len(parts))
+	var wg sync.WaitGroup
+	for i := 0; i < connections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range jobs {
+				if err := pd.fetchRange(part); err != nil {
+					errs <- err
+					cancel()
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, part := range parts {
+			select {
+			case jobs <- part:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	// Progress is merged here, on the VM goroutine, so onProgress is safe to
+	// call and the speed covers all connections together.
+	ticker := time.NewTicker(parallelProgressInterval)
+	defer ticker.Stop()
+	lastBytes, lastTime := pd.received.Load(), time.Now()
+	report := func() {
+		received := pd.received.Load()
+		now := time.Now()
+		if shouldTrackItemBytes {
+			var speedMBps float64
+			if elapsed := now.Sub(lastTime).Seconds(); elapsed > 0 {
+				speedMBps = float64(received-lastBytes) / (1024 * 1024) / elapsed
+			}
+			SetItemBytesReceivedWithSpeed(pd.itemID, received, speedMBps)
+		}
+		lastBytes, lastTime = received, now
+		if onProgress != nil {
+			_, _ = onProgress(goja.Undefined(), r.vm.ToValue(received), r.vm.ToValue(totalSize))
+		}
+	}
+wait:
+	for {
+		select {
+		case <-finished:
+			break wait
+		case <-ticker.C:
+			report()
+		}
+	}
+	close(errs)
+
+	if err := <-errs; err != nil {
+		if pd.persist {
+			pd.mu.Lock()
+			if errors.Is(err, errParallelRemoteChanged) {
+				state.clear()
+			} else if saveErr := state.save(); saveErr != nil {
+				GoLog("[Extension:%s] Failed to save resume state: %v\n", r.extensionID, saveErr)
+			}
+			pd.mu.Unlock()
+		}
+		if errors.Is(err, ErrDownloadCancelled) || (pd.itemID != "" && isDownloadCancelled(pd.itemID)) {
+			return r.vm.ToValue(map[string]interface{}{
+				"success": false,
+				"error":   "download cancelled",
+			}), true
+		}
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("parallel: %v", err),
+		}), true
+	}
+
+	report()
+	if shouldTrackItemBytes {
+		SetItemProgress(pd.itemID, 1, totalSize, totalSize)
+	}
+	if pd.persist {
+		state.clear()
+	}
+	GoLog("[Extension:%s] Parallel download complete: %d bytes to %s\n", r.extensionID, totalSize, fullPath)
+
+	return r.vm.ToValue(map[string]interface{}{
+		"success": true,
+		"path":    fullPath,
+		"size":    totalSize,
+	}), true
+}
+
+// splitDownloadRanges cuts the missing ranges into parts of roughly equal
+// size so every connection gets work, never below minParallelDownloadPart.
+func splitDownloadRanges(ranges []downloadResumeRange, connections int) []downloadResumeRange {
+	var remaining int64
+	for _, rg := range ranges {
+		remaining += rg.End - rg.Start
+	}
+	partSize := max((remaining+int64(connections)-1)/int64(connections), minParallelDownloadPart)
+
+	var parts []downloadResumeRange
+	for _, rg := range ranges {
+		for start := rg.Start; start < rg.End; start += partSize {
+			end := min(start+partSize, rg.End)
+			// Fold a small tail into the previous part of the same range.
+			if rg.End-end < minParallelDownloadPart/2 {
+				end = rg.End
+			}
+			parts = append(parts, downloadResumeRange{Start: start, End: end})
+			if end == rg.End {
+				break
+			}
+		}
+	}
+	return parts
+}
+
+// fetchRange downloads one part, retrying from where the previous attempt
+// stopped. Transport errors, 403, 429 and 5xx responses are retried.
+func (pd *parallelDownload) fetchRange(part downloadResumeRange) error {
+	const maxRetries = 3
+	pos := part.Start
+	var lastErr error
+	buf := make([]byte, 32*1024)
+
+	for attempt := 0; attempt < maxRetries && pos < part.End; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * segmentRetryDelay):
+			case <-pd.ctx.Done():
+				return pd.contextErr()
+			}
+		}
+
+		req, err := http.NewRequestWithContext(pd.ctx, "GET", pd.url, nil)
+		if err != nil {
+			return err
+		}
+		setDownloadRequestHeaders(req, pd.headers, pd.ua)
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", pos, part.End-1))
+		if pd.ifRange != "" {
+			req.Header.Set("If-Range", pd.ifRange)
+		}
+
+		resp, err := pd.client.Do(req)
+		if err != nil {
+			if pd.ctx.Err() != nil {
+				return pd.contextErr()
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode == http.StatusOK {
+			resp.Body.Close()
+			return errParallelRemoteChanged
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("HTTP %d at offset %d", resp.StatusCode, pos)
+			if resp.StatusCode == 403 || resp.StatusCode == 429 || resp.StatusCode >= 500 {
+				continue
+			}
+			return lastErr
+		}
+		if start, _, _, ok := parseContentRange(resp.Header.Get("Content-Range")); !ok || start != pos {
+			resp.Body.Close()
+			return fmt.Errorf("unexpected Content-Range %q for offset %d", resp.Header.Get("Content-Range"), pos)
+		}
+
+		body := io.LimitReader(resp.Body, part.End-pos)
+		for {
+			nr, er := body.Read(buf)
+			if nr > 0 {
+				if _, ew := pd.out.WriteAt(buf[:nr], pos); ew != nil {
+					resp.Body.Close()
+					return fmt.Errorf("failed to write file: %w", ew)
+				}
+				if ew := pd.track(pos, pos+int64(nr)); ew != nil {
+					resp.Body.Close()
+					return ew
+				}
+				pos += int64(nr)
+			}
+			if er != nil {
+				if er != io.EOF {
+					lastErr = er
+				}
+				break
+			}
+		}
+		resp.Body.Close()
+		if pd.ctx.Err() != nil {
+			return pd.contextErr()
+		}
+	}
+	if pos < part.End {
+		if lastErr == nil {
+			lastErr = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("range %d-%d after %d attempts: %w", part.Start, part.End-1, maxRetries, lastErr)
+	}
+	return nil
+}
+
+func (pd *parallelDownload) track(start, end int64) error {
+	if pd.itemID != "" && isDownloadCancelled(pd.itemID) {
+		return ErrDownloadCancelled
+	}
+	pd.received.Add(end - start)
+
+	pd.mu.Lock()
+	pd.state.markWritten(start, end)
+	pd.unsaved += end - start
+	paused := isDownloadPaused(pd.itemID)
+	if pd.persist && (pd.unsaved >= downloadResumeSaveInterval || (paused && pd.unsaved > 0)) {
+		if err := pd.state.save(); err != nil {
+			GoLog("[Resume] Failed to save resume state for %s: %v\n", pd.state.path, err)
+		}
+		pd.unsaved = 0
+	}
+	pd.mu.Unlock()
+
+	// Holding the worker here stops its reads; the other connections stall
+	// on their own next writes.
+	if paused {
+		if _, err := waitWhileDownloadPaused(pd.itemID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (pd *parallelDownload) contextErr() error {
+	if pd.itemID != "" && isDownloadCancelled(pd.itemID) {
+		return ErrDownloadCancelled
+	}
+	return pd.ctx.Err()
+}
+
+func setDownloadRequestHeaders(req *http.Request, headers map[string]string, ua string) {
+	req.Header.Set("User-Agent", ua)
+	for k, v := range headers {
+		if k != "Range" {
+			req.Header.Set(k, v)
+		}
+	}
+}
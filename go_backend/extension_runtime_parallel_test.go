@@ -0,0 +1,121 @@
+package gobackend
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func TestSplitDownloadRanges(t *testing.T) {
+	parts := splitDownloadRanges([]downloadResumeRange{{Start: 0, End: 4 << 20}}, 4)
+	if len(parts) != 4 || parts[0].End != 1<<20 || parts[3].End != 4<<20 {
+		t.Fatalf("parts = %+v", parts)
+	}
+	// Holes left by an interrupted download are split on their own, and
+	// parts never shrink below minParallelDownloadPart.
+	holes := []downloadResumeRange{{Start: 100, End: 200}, {Start: 1 << 20, End: 2<<20 + 1000}}
+	parts = splitDownloadRanges(holes, 4)
+	if len(parts) != 3 || parts[0] != holes[0] || parts[1].Start != holes[1].Start || parts[1].End != parts[2].Start || parts[2].End != holes[1].End {
+		t.Fatalf("parts = %+v", parts)
+	}
+	if size := parts[1].End - parts[1].Start; size < minParallelDownloadPart {
+		t.Fatalf("part of %d bytes", size)
+	}
+	// A small tail is folded into the previous part.
+	parts = splitDownloadRanges([]downloadResumeRange{{Start: 0, End: 2*minParallelDownloadPart + 1000}}, 2)
+	if len(parts) != 2 || parts[1].End != 2*minParallelDownloadPart+1000 {
+		t.Fatalf("parts = %+v", parts)
+	}
+}
+
+func TestFileDownloadParallel(t *testing.T) {
+	oldDelay := segmentRetryDelay
+	segmentRetryDelay = time.Millisecond
+	defer func() { segmentRetryDelay = oldDelay }()
+
+	server := &resumeTestServer{body: testResumeBody(3 << 20), etag: `"v1"`, failAt: 2<<20 + 5000}
+	runtime := newResumeTestRuntime(t, server)
+	path := filepath.Join(runtime.dataDir, "album/track.flac")
+	download := func(options map[string]interface{}) map[string]interface{} {
+		return runtime.fileDownload(goja.FunctionCall{Arguments: []goja.Value{
+			runtime.vm.ToValue("https://cdn.example.com/track"),
+			runtime.vm.ToValue("album/track.flac"),
+			runtime.vm.ToValue(options),
+		}}).Export().(map[string]interface{})
+	}
+
+	// A dropped connection is retried from where that range stopped.
+	result := download(map[string]interface{}{"connections": float64(4)})
+	if result["success"] != true || result["size"] != int64(len(server.body)) {
+		t.Fatalf("parallel download = %#v", result)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, server.body) {
+		t.Fatal("parallel download does not match")
+	}
+	var ranges []string
+	for _, h := range server.requests[1:] {
+		ranges = append(ranges, h.Get("Range"))
+	}
+	joined := strings.Join(ranges, " ")
+	for _, want := range []string{"bytes=0-786431", "bytes=1572864-2359295", "bytes=2102152-2359295"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("range requests %v missing %s", ranges, want)
+		}
+	}
+
+	// Cancelling leaves holes in the chunk map; the next run only fetches
+	// what is missing.
+	itemID := "parallel-cancel-item"
+	defer func() {
+		cancelMu.Lock()
+		delete(cancelMap, itemID)
+		cancelMu.Unlock()
+	}()
+	SetParallelDownloadConnections(3)
+	defer SetParallelDownloadConnections(1)
+	runtime.setActiveDownloadItemID(itemID)
+	server.mu.Lock()
+	server.failAt, server.onFail, server.requests = 1<<20+300000, func() { cancelDownload(itemID) }, nil
+	server.mu.Unlock()
+	if result := download(nil); result["error"] != "download cancelled" {
+		t.Fatalf("expected cancelled download, got %#v", result)
+	}
+	runtime.clearActiveDownloadItemID()
+	state := readTestResumeState(t, path)
+	if state.BytesWritten >= int64(len(server.body)) || len(state.missingRanges(state.TotalSize)) == 0 {
+		t.Fatalf("resume state after cancel = %+v", state)
+	}
+
+	server.mu.Lock()
+	server.onFail, server.requests = nil, nil
+	server.mu.Unlock()
+	if result := download(nil); result["success"] != true {
+		t.Fatalf("resumed parallel download = %#v", result)
+	}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, server.body) {
+		t.Fatal("resumed parallel download does not match")
+	}
+	var requested int64
+	for _, h := range server.requests[1:] {
+		var start, end int64
+		if _, err := fmt.Sscanf(h.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			t.Fatalf("range %q: %v", h.Get("Range"), err)
+		}
+		requested += end - start + 1
+	}
+	if requested != state.TotalSize-state.BytesWritten {
+		t.Fatalf("resumed download requested %d bytes, %d were missing", requested, state.TotalSize-state.BytesWritten)
+	}
+
+	// Small files stay on one connection.
+	server.body = testResumeBody(4096)
+	if result := download(map[string]interface{}{"connections": float64(8)}); result["success"] != true || result["size"] != int64(4096) {
+		t.Fatalf("small download = %#v", result)
+	}
+}
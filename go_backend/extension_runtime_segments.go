@@ -0,0 +1,444 @@
+package gobackend
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	defaultSegmentConcurrency = 4
+	maxSegmentConcurrency     = 16
+	defaultSegmentRetries     = 3
+)
+
+// segmentRetryDelay is the base back-off between attempts at one segment.
+var segmentRetryDelay = time.Second
+
+type segmentDownloadOptions struct {
+	headers        map[string]string
+	pref           segmentVariantPreference
+	concurrency    int
+	retries        int
+	onProgress     goja.Callable
+	trackItemBytes bool
+}
+
+type segmentResult struct {
+	data []byte
+	err  error
+}
+
+func (r *extensionRuntime) parseSegmentDownloadOptions(call goja.FunctionCall) segmentDownloadOptions {
+	opts := segmentDownloadOptions{
+		concurrency:    defaultSegmentConcurrency,
+		retries:        defaultSegmentRetries,
+		trackItemBytes: true,
+	}
+	if len(call.Arguments) < 3 || goja.IsUndefined(call.Arguments[2]) || goja.IsNull(call.Arguments[2]) {
+		return opts
+	}
+	raw, ok := call.Arguments[2].Export().(map[string]interface{})
+	if !ok {
+		return opts
+	}
+
+	if h, ok := raw["headers"].(map[string]interface{}); ok {
+		opts.headers = make(map[string]string)
+		for k, v := range h {
+			opts.headers[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	if progressVal, ok := raw["onProgress"]; ok {
+		if callable, ok := goja.AssertFunction(r.vm.ToValue(progressVal)); ok {
+			opts.onProgress = callable
+		}
+	}
+	if runtimeOptionHasKey(raw, "trackItemBytes") {
+		opts.trackItemBytes = runtimeOptionBool(raw, "trackItemBytes", true)
+	} else {
+		opts.trackItemBytes = runtimeOptionBool(raw, "track_item_bytes", true)
+	}
+
+	opts.pref.maxBandwidth = runtimeOptionInt64(raw, "maxBandwidth", 0)
+	switch codecs := raw["codecs"].(type) {
+	case string:
+		for _, codec := range strings.Split(codecs, ",") {
+			if codec = strings.TrimSpace(codec); codec != "" {
+				opts.pref.codecs = append(opts.pref.codecs, codec)
+			}
+		}
+	case []interface{}:
+		for _, codec := range codecs {
+			if s := strings.TrimSpace(fmt.Sprintf("%v", codec)); s != "" {
+				opts.pref.codecs = append(opts.pref.codecs, s)
+			}
+		}
+	}
+
+	concurrency := runtimeOptionInt64(raw, "concurrency", defaultSegmentConcurrency)
+	opts.concurrency = int(min(max(concurrency, 1), maxSegmentConcurrency))
+	opts.retries = int(max(runtimeOptionInt64(raw, "retries", defaultSegmentRetries), 1))
+	return opts
+}
+
+// fileDownloadHLS downloads an HLS stream: file.downloadHLS(url, path, options).
+func (r *extensionRuntime) fileDownloadHLS(call goja.FunctionCall) goja.Value {
+	return r.fileDownloadSegmented(call, "HLS", r.planHLSDownload)
+}
+
+// fileDownloadDASH downloads a static DASH stream: file.downloadDASH(url, path, options).
+func (r *extensionRuntime) fileDownloadDASH(call goja.FunctionCall) goja.Value {
+	return r.fileDownloadSegmented(call, "DASH", r.planDASHDownload)
+}
+
+func (r *extensionRuntime) fileDownloadSegmented(
+	call goja.FunctionCall,
+	kind string,
+	planDownload func(client *http.Client, manifestURL string, opts segmentDownloadOptions) (*segmentPlan, error),
+) goja.Value {
+	if len(call.Arguments) < 2 {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   "manifest URL and output path are required",
+		})
+	}
+
+	manifestURL := call.Arguments[0].String()
+	outputPath := call.Arguments[1].String()
+
+	if err := r.validateDomain(manifestURL); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+
+	fullPath, err := r.validatePath(outputPath)
+	if err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+	}
+	opts := r.parseSegmentDownloadOptions(call)
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   fmt.Sprintf("failed to create directory: %v", err),
+		})
+	}
+
+	client := r.downloadClient
+	if client == nil {
+		client = r.httpClient
+	}
+
+	plan, err := planDownload(client, manifestURL, opts)
+	if err == nil {
+		var written int64
+		written, err = r.downloadSegments(client, plan, fullPath, opts)
+		if err == nil {
+			GoLog("[Extension:%s] Downloaded %s stream (%d segments, %d bytes) to %s\n", r.extensionID, kind, len(plan.segments), written, fullPath)
+			return r.vm.ToValue(map[string]interface{}{
+				"success":   true,
+				"path":      fullPath,
+				"size":      written,
+				"segments":  len(plan.segments),
+				"bandwidth": plan.bandwidth,
+				"codecs":    plan.codecs,
+			})
+		}
+	}
+
+	if errors.Is(err, ErrDownloadCancelled) {
+		return r.vm.ToValue(map[string]interface{}{
+			"success": false,
+			"error":   "download cancelled",
+		})
+	}
+	GoLog("[Extension:%s] %s download failed: %v\n", r.extensionID, kind, err)
+	return r.vm.ToValue(map[string]interface{}{
+		"success": false,
+		"error":   err.Error(),
+	})
+}
+
+func (r *extensionRuntime) planHLSDownload(client *http.Client, manifestURL string, opts segmentDownloadOptions) (*segmentPlan, error) {
+	body, err := r.fetchSegment(client, mediaSegment{url: manifestURL}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch playlist: %w", err)
+	}
+	playlistURL := manifestURL
+	plan := &segmentPlan{}
+
+	if isHLSMasterPlaylist(string(body)) {
+		base, _ := url.Parse(manifestURL)
+		master, err := parseHLSMasterPlaylist(base, string(body))
+		if err != nil {
+			return nil, err
+		}
+		index, err := selectSegmentVariant(master.variants, opts.pref)
+		if err != nil {
+			return nil, err
+		}
+		variant := master.variants[index]
+		plan.bandwidth, plan.codecs = variant.bandwidth, variant.codecs
+		playlistURL = master.audioPlaylistURL(variant)
+		if body, err = r.fetchSegment(client, mediaSegment{url: playlistURL}, opts); err != nil {
+			return nil, fmt.Errorf("failed to fetch media playlist: %w", err)
+		}
+	}
+
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid playlist URL: %w", err)
+	}
+	if plan.segments, err = parseHLSMediaPlaylist(base, string(body)); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (r *extensionRuntime) planDASHDownload(client *http.Client, manifestURL string, opts segmentDownloadOptions) (*segmentPlan, error) {
+	body, err := r.fetchSegment(client, mediaSegment{url: manifestURL}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch MPD: %w", err)
+	}
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MPD URL: %w", err)
+	}
+	return parseDASHManifest(base, body, opts.pref)
+}
+
+// fetchSegment GETs one manifest, key or segment. Every URL is checked against
+// the extension's network permissions since manifests may point anywhere.
+// Transport errors, 403, 429 and 5xx responses are retried.
+func (r *extensionRuntime) fetchSegment(client *http.Client, seg mediaSegment, opts segmentDownloadOptions) ([]byte, error) {
+	if err := r.validateDomain(seg.url); err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < opts.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * segmentRetryDelay)
+		}
+
+		req, err := http.NewRequest("GET", seg.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = r.bindDownloadCancelContext(req)
+		for k, v := range opts.headers {
+			if k != "Range" {
+				req.Header.Set(k, v)
+			}
+		}
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", appUserAgent())
+		}
+		if seg.byteRange != nil {
+			req.Header.Set("Range", seg.byteRange.header())
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			if req.Context().Err() != nil {
+				return nil, ErrDownloadCancelled
+			}
+			lastErr = err
+			continue
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == 403 || resp.StatusCode == 429 || resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("HTTP error: %d", resp.StatusCode)
+			continue
+		case resp.StatusCode < 200 || resp.StatusCode >= 300:
+			return nil, fmt.Errorf("HTTP error: %d", resp.StatusCode)
+		case err != nil:
+			if req.Context().Err() != nil {
+				return nil, ErrDownloadCancelled
+			}
+			lastErr = err
+			continue
+		}
+
+		if seg.byteRange == nil {
+			return data, nil
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			// The server ignored Range and sent the whole resource.
+			end := seg.byteRange.offset + seg.byteRange.length
+			if int64(len(data)) < end {
+				return nil, fmt.Errorf("response too short for range %s", seg.byteRange.header())
+			}
+			data = data[seg.byteRange.offset:end]
+		}
+		if int64(len(data)) != seg.byteRange.length {
+			lastErr = fmt.Errorf("got %d bytes for range %s", len(data), seg.byteRange.header())
+			continue
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%s after %d attempts: %w", seg.url, opts.retries, lastErr)
+}
+
+// fetchHLSKeys downloads every distinct AES-128 key of the plan up front.
+func (r *extensionRuntime) fetchHLSKeys(client *http.Client, plan *segmentPlan, opts segmentDownloadOptions) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, seg := range plan.segments {
+		if seg.key == nil {
+			continue
+		}
+		if _, ok := keys[seg.key.uri]; ok {
+			continue
+		}
+		key, err := r.fetchSegment(client, mediaSegment{url: seg.key.uri}, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch AES-128 key: %w", err)
+		}
+		if len(key) != aes.BlockSize {
+			return nil, fmt.Errorf("AES-128 key is %d bytes", len(key))
+		}
+		keys[seg.key.uri] = key
+	}
+	return keys, nil
+}
+
+// decryptHLSSegment reverses METHOD=AES-128: whole-segment AES-CBC with
+// PKCS#7 padding.
+func decryptHLSSegment(data, key, iv []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("encrypted segment is %d bytes", len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, fmt.Errorf("invalid AES-128 padding (wrong key?)")
+	}
+	return out[:len(out)-pad], nil
+}
+
+// downloadSegments fetches the plan with a pool of workers and writes the
+// segments in order. Workers never touch the goja VM: progress callbacks run
+// here, on the calling goroutine. At most two segments per worker are held
+// in memory waiting for their turn.
+func (r *extensionRuntime) downloadSegments(client *http.Client, plan *segmentPlan, fullPath string, opts segmentDownloadOptions) (written int64, err error) {
+	keys, err := r.fetchHLSKeys(client, plan, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := os.Create(fullPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file: %w", err)
+	}
+	defer func() {
+		out.Close()
+		if err != nil {
+			os.Remove(fullPath)
+		}
+	}()
+
+	activeItemID := r.getActiveDownloadItemID()
+	if activeItemID != "" {
+		SetItemDownloading(activeItemID)
+	}
+	shouldTrackItemBytes := activeItemID != "" && opts.trackItemBytes
+
+	var writer io.Writer = out
+	if shouldTrackItemBytes {
+		writer = NewItemProgressWriter(out, activeItemID)
+	} else if activeItemID != "" {
+		writer = &pausableWriter{writer: out, itemID: activeItemID}
+	}
+
+	count := len(plan.segments)
+	results := make([]chan segmentResult, count)
+	for i := range results {
+		results[i] = make(chan segmentResult, 1)
+	}
+	jobs := make(chan int)
+	done := make(chan struct{})
+	defer close(done)
+	window := make(chan struct{}, opts.concurrency*2)
+
+	go func() {
+		defer close(jobs)
+		for i := range plan.segments {
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for w := 0; w < min(opts.concurrency, count); w++ {
+		go func() {
+			for i := range jobs {
+				seg := plan.segments[i]
+				data, err := r.fetchSegment(client, seg, opts)
+				if err == nil && seg.key != nil {
+					data, err = decryptHLSSegment(data, keys[seg.key.uri], seg.key.iv)
+				}
+				results[i] <- segmentResult{data: data, err: err}
+			}
+		}()
+	}
+
+	for i := range plan.segments {
+		res := <-results[i]
+		<-window
+		if res.err != nil {
+			if errors.Is(res.err, ErrDownloadCancelled) {
+				return written, ErrDownloadCancelled
+			}
+			return written, fmt.Errorf("segment %d/%d: %w", i+1, count, res.err)
+		}
+		n, err := writer.Write(res.data)
+		written += int64(n)
+		if err != nil {
+			if errors.Is(err, ErrDownloadCancelled) {
+				return written, err
+			}
+			return written, fmt.Errorf("failed to write file: %w", err)
+		}
+
+		// Segment sizes are unknown up front; extrapolate from the average.
+		estimatedTotal := written * int64(count) / int64(i+1)
+		if shouldTrackItemBytes {
+			SetItemProgress(activeItemID, float64(i+1)/float64(count), written, estimatedTotal)
+		}
+		if opts.onProgress != nil {
+			_, _ = opts.onProgress(goja.Undefined(), r.vm.ToValue(written), r.vm.ToValue(estimatedTotal))
+		}
+	}
+	return written, nil
+}
@@ -0,0 +1,163 @@
+package gobackend
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+func newSegmentTestRuntime(t *testing.T, files map[string][]byte, requests *sync.Map) *extensionRuntime {
+	t.Helper()
+	runtime := &extensionRuntime{
+		extensionID: "segments-ext",
+		manifest: &ExtensionManifest{
+			Name:    "segments-ext",
+			Version: "1.0.0",
+			Permissions: ExtensionPermissions{
+				File:    true,
+				Network: []string{"cdn.example.com"},
+			},
+		},
+		dataDir: t.TempDir(),
+		vm:      goja.New(),
+		httpClient: &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			count, _ := requests.LoadOrStore(req.URL.Path, new(int))
+			*count.(*int)++
+			body, ok := files[req.URL.Path]
+			if !ok {
+				return &http.Response{StatusCode: 404, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+			}
+			if req.URL.Path == "/flaky.m4s" && *count.(*int) == 1 {
+				return &http.Response{StatusCode: 503, Body: io.NopCloser(strings.NewReader("")), Request: req}, nil
+			}
+			return &http.Response{StatusCode: 200, Header: make(http.Header), Body: io.NopCloser(bytes.NewReader(body)), Request: req}, nil
+		})},
+	}
+	return runtime
+}
+
+func encryptTestHLSSegment(key, iv, data []byte) []byte {
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	block, _ := aes.NewCipher(key)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(padded, padded)
+	return padded
+}
+
+func TestExtensionRuntimeDownloadHLS(t *testing.T) {
+	oldDelay := segmentRetryDelay
+	segmentRetryDelay = time.Millisecond
+	defer func() { segmentRetryDelay = oldDelay }()
+
+	key := []byte("hls-segment-key!")
+	seqIV := make([]byte, 16)
+	seqIV[15] = 3
+	files := map[string][]byte{
+		"/master.m3u8": []byte("#EXTM3U\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=128000,CODECS=\"mp4a.40.2\"\nlow.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=900000,CODECS=\"fLaC\"\nhigh.m3u8\n"),
+		"/high.m3u8": []byte("#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:3\n" +
+			"#EXT-X-MAP:URI=\"init.mp4\"\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"/key\"\n" +
+			"#EXTINF:4,\nseg0.m4s\n" +
+			"#EXT-X-KEY:METHOD=NONE\n" +
+			"#EXTINF:4,\nflaky.m4s\n" +
+			"#EXTINF:4,\nseg2.m4s\n#EXT-X-ENDLIST\n"),
+		"/key":       key,
+		"/init.mp4":  []byte("INIT"),
+		"/seg0.m4s":  encryptTestHLSSegment(key, seqIV, []byte("first segment")),
+		"/flaky.m4s": []byte("-second-"),
+		"/seg2.m4s":  []byte("-third"),
+	}
+	var requests sync.Map
+	runtime := newSegmentTestRuntime(t, files, &requests)
+	vm := runtime.vm
+
+	var progressCalls int
+	onProgress := func(call goja.FunctionCall) goja.Value {
+		progressCalls++
+		return goja.Undefined()
+	}
+	result := runtime.fileDownloadHLS(goja.FunctionCall{Arguments: []goja.Value{
+		vm.ToValue("https://cdn.example.com/master.m3u8"),
+		vm.ToValue("out/track.m4a"),
+		vm.ToValue(map[string]interface{}{"concurrency": float64(2), "onProgress": onProgress}),
+	}}).Export().(map[string]interface{})
+	if result["success"] != true || result["segments"] != 4 || result["codecs"] != "fLaC" {
+		t.Fatalf("fileDownloadHLS = %#v", result)
+	}
+	data, err := os.ReadFile(filepath.Join(runtime.dataDir, "out/track.m4a"))
+	if err != nil || string(data) != "INITfirst segment-second--third" {
+		t.Fatalf("downloaded data = %q/%v", data, err)
+	}
+	if progressCalls != 4 {
+		t.Fatalf("onProgress called %d times", progressCalls)
+	}
+	if _, ok := requests.Load("/low.m3u8"); ok {
+		t.Fatal("low bandwidth variant was fetched")
+	}
+	if count, _ := requests.Load("/flaky.m4s"); *count.(*int) != 2 {
+		t.Fatalf("flaky segment fetched %d times", *count.(*int))
+	}
+
+	// Segment URLs are held to the extension's network permissions.
+	files["/evil.m3u8"] = []byte("#EXTM3U\n#EXTINF:4,\nhttps://evil.example.net/seg.m4s\n#EXT-X-ENDLIST\n")
+	blocked := runtime.fileDownloadHLS(goja.FunctionCall{Arguments: []goja.Value{
+		vm.ToValue("https://cdn.example.com/evil.m3u8"),
+		vm.ToValue("out/evil.m4a"),
+	}}).Export().(map[string]interface{})
+	if blocked["success"] != false || !strings.Contains(blocked["error"].(string), "not in allowed list") {
+		t.Fatalf("expected blocked segment, got %#v", blocked)
+	}
+	if _, err := os.Stat(filepath.Join(runtime.dataDir, "out/evil.m4a")); !os.IsNotExist(err) {
+		t.Fatalf("partial output left behind: %v", err)
+	}
+}
+
+func TestExtensionRuntimeDownloadDASH(t *testing.T) {
+	files := map[string][]byte{
+		"/track.mpd": []byte(`<MPD type="static" mediaPresentationDuration="PT8S"><Period>
+  <AdaptationSet mimeType="audio/mp4" codecs="fLaC">
+    <SegmentTemplate timescale="1" duration="4" startNumber="1" initialization="$RepresentationID$-init.mp4" media="$RepresentationID$-$Number$.m4s"/>
+    <Representation id="hi" bandwidth="1000000"/>
+    <Representation id="lo" bandwidth="300000"/>
+  </AdaptationSet>
+</Period></MPD>`),
+		"/lo-init.mp4": []byte("init|"),
+		"/lo-1.m4s":    []byte("one|"),
+		"/lo-2.m4s":    []byte("two"),
+	}
+	var requests sync.Map
+	runtime := newSegmentTestRuntime(t, files, &requests)
+	vm := runtime.vm
+
+	result := runtime.fileDownloadDASH(goja.FunctionCall{Arguments: []goja.Value{
+		vm.ToValue("https://cdn.example.com/track.mpd"),
+		vm.ToValue("track.m4a"),
+		vm.ToValue(map[string]interface{}{"maxBandwidth": float64(500000)}),
+	}}).Export().(map[string]interface{})
+	if result["success"] != true || result["bandwidth"] != int64(300000) || result["size"] != int64(12) {
+		t.Fatalf("fileDownloadDASH = %#v", result)
+	}
+	if data, err := os.ReadFile(filepath.Join(runtime.dataDir, "track.m4a")); err != nil || string(data) != "init|one|two" {
+		t.Fatalf("downloaded data = %q/%v", data, err)
+	}
+
+	missing := runtime.fileDownloadDASH(goja.FunctionCall{Arguments: []goja.Value{
+		vm.ToValue("https://cdn.example.com/track.mpd"),
+		vm.ToValue("hi.m4a"),
+	}}).Export().(map[string]interface{})
+	if missing["success"] != false || !strings.Contains(missing["error"].(string), "HTTP error: 404") {
+		t.Fatalf("expected missing segment error, got %#v", missing)
+	}
+}
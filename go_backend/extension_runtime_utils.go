@@ -312,6 +312,17 @@ func (r *extensionRuntime) isDownloadCancelled(call goja.FunctionCall) goja.Valu
 	return r.vm.ToValue(isDownloadCancelled(itemID))
 }
 
+func (r *extensionRuntime) isDownloadPaused(call goja.FunctionCall) goja.Value {
+	return r.vm.ToValue(isDownloadPaused(r.getActiveDownloadItemID()))
+}
+
+// waitWhileDownloadPaused lets extensions that move data themselves honour
+// a pause. It returns false when the download was cancelled while paused.
+func (r *extensionRuntime) waitWhileDownloadPaused(call goja.FunctionCall) goja.Value {
+	_, err := waitWhileDownloadPaused(r.getActiveDownloadItemID())
+	return r.vm.ToValue(err == nil)
+}
+
 func (r *extensionRuntime) isRequestCancelled(call goja.FunctionCall) goja.Value {
 	requestID := r.getActiveRequestID()
 	if requestID == "" {
@@ -0,0 +1,625 @@
+package gobackend
+
+// Pure-Go FLAC decoder.
+//
+// Frames are decoded sequentially straight from the byte stream: the bit
+// reader only pulls bytes as the current frame needs them, so it also keeps
+// the running CRC-8 (frame header) and CRC-16 (whole frame) and the byte
+// offset of each frame without buffering the file. Streams with more than
+// 24 bits per sample are rejected since samples are kept as int32.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+var (
+	flacCRC8Table  = buildFLACCRC8Table()
+	flacCRC16Table = buildFLACCRC16Table()
+)
+
+// buildFLACCRC8Table builds the CRC-8 table (polynomial x^8+x^2+x+1) used for
+// FLAC frame headers.
+func buildFLACCRC8Table() [256]uint8 {
+	var t [256]uint8
+	for i := 0; i < 256; i++ {
+		crc := uint8(i)
+		for j := 0; j < 8; j++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// buildFLACCRC16Table builds the CRC-16 table (polynomial
+// x^16+x^15+x^2+1) used for whole FLAC frames.
+func buildFLACCRC16Table() [256]uint16 {
+	var t [256]uint16
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+		t[i] = crc
+	}
+	return t
+}
+
+// flacBitReader reads MSB-first bits from a byte stream, updating the frame
+// CRCs and the byte position for every byte it consumes.
+type flacBitReader struct {
+	r     io.ByteReader
+	cache uint64 // the next n bits, left-aligned; the rest is zero
+	n     uint
+	crc8  uint8
+	crc16 uint16
+	pos   int64
+}
+
+func (b *flacBitReader) fill() error {
+	c, err := b.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	b.crc8 = flacCRC8Table[b.crc8^c]
+	b.crc16 = b.crc16<<8 ^ flacCRC16Table[byte(b.crc16>>8)^c]
+	b.pos++
+	b.cache |= uint64(c) << (56 - b.n)
+	b.n += 8
+	return nil
+}
+
+// readBits returns the next k (at most 56) bits as an unsigned value.
+func (b *flacBitReader) readBits(k uint) (uint64, error) {
+	if k == 0 {
+		return 0, nil
+	}
+	for b.n < k {
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	v := b.cache >> (64 - k)
+	b.cache <<= k
+	b.n -= k
+	return v, nil
+}
+
+// readSigned returns the next k bits as a two's complement value.
+func (b *flacBitReader) readSigned(k uint) (int64, error) {
+	if k == 0 {
+		return 0, nil
+	}
+	v, err := b.readBits(k)
+	if err != nil {
+		return 0, err
+	}
+	return int64(v<<(64-k)) >> (64 - k), nil
+}
+
+// readUnary counts zero bits up to and including the next one bit.
+func (b *flacBitReader) readUnary() (uint64, error) {
+	var count uint64
+	for {
+		if b.n == 0 {
+			if err := b.fill(); err != nil {
+				return 0, err
+			}
+		}
+		if b.cache == 0 {
+			count += uint64(b.n)
+			b.n = 0
+			continue
+		}
+		lz := uint(bits.LeadingZeros64(b.cache))
+		count += uint64(lz)
+		b.cache <<= lz + 1
+		b.n -= lz + 1
+		return count, nil
+	}
+}
+
+// alignToByte drops the bits left in the current byte.
+func (b *flacBitReader) alignToByte() {
+	drop := b.n % 8
+	b.cache <<= drop
+	b.n -= drop
+}
+
+// flacStreamInfo is the decoded STREAMINFO metadata block.
+type flacStreamInfo struct {
+	MinBlockSize  int
+	MaxBlockSize  int
+	SampleRate    int
+	Channels      int
+	BitsPerSample int
+	TotalSamples  int64
+	MD5           [16]byte
+}
+
+// flacFrame is one decoded audio frame.
+type flacFrame struct {
+	Offset        int64 // byte offset of the frame header in the file
+	SampleRate    int
+	BitsPerSample int
+	Samples       [][]int32 // one slice per channel
+}
+
+// flacFrameError reports a frame that failed to decode or verify.
+type flacFrameError struct {
+	Offset int64
+	Reason string
+}
+
+func (e *flacFrameError) Error() string {
+	return fmt.Sprintf("corrupt FLAC frame at byte %d: %s", e.Offset, e.Reason)
+}
+
+type flacDecoder struct {
+	Info        flacStreamInfo
+	AudioOffset int64 // byte offset of the first frame
+	br          *flacBitReader
+	decoded     int64
+}
+
+// newFLACDecoder reads the stream marker and metadata blocks (skipping a
+// leading ID3v2 tag) and leaves r positioned at the first frame.
+func newFLACDecoder(r io.Reader) (*flacDecoder, error) {
+	br := &flacBitReader{r: bufio.NewReaderSize(r, 64*1024)}
+	readFull := func(n int) ([]byte, error) {
+		out := make([]byte, n)
+		for i := range out {
+			v, err := br.readBits(8)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = byte(v)
+		}
+		return out, nil
+	}
+
+	marker, err := readFull(4)
+	if err != nil {
+		return nil, err
+	}
+	if string(marker[:3]) == "ID3" {
+		rest, err := readFull(6)
+		if err != nil {
+			return nil, err
+		}
+		size := synchsafeDecode(rest[2:6])
+		if rest[1]&0x10 != 0 {
+			size += 10
+		}
+		if _, err := readFull(size); err != nil {
+			return nil, err
+		}
+		if marker, err = readFull(4); err != nil {
+			return nil, err
+		}
+	}
+	if string(marker) != "fLaC" {
+		return nil, fmt.Errorf("not a FLAC stream")
+	}
+
+	d := &flacDecoder{br: br}
+	haveInfo := false
+	for last := false; !last; {
+		header, err := readFull(4)
+		if err != nil {
+			return nil, fmt.Errorf("truncated FLAC metadata: %w", err)
+		}
+		last = header[0]&0x80 != 0
+		blockType := header[0] & 0x7F
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		body, err := readFull(length)
+		if err != nil {
+			return nil, fmt.Errorf("truncated FLAC metadata: %w", err)
+		}
+		if blockType == 0 {
+			if length < 34 {
+				return nil, fmt.Errorf("invalid STREAMINFO block")
+			}
+			d.Info = parseFLACStreamInfo(body)
+			haveInfo = true
+		}
+	}
+	if !haveInfo {
+		return nil, fmt.Errorf("missing STREAMINFO block")
+	}
+	if d.Info.BitsPerSample > 24 {
+		return nil, fmt.Errorf("unsupported FLAC bit depth: %d", d.Info.BitsPerSample)
+	}
+	d.AudioOffset = br.pos
+	return d, nil
+}
+
+func parseFLACStreamInfo(b []byte) flacStreamInfo {
+	info := flacStreamInfo{
+		MinBlockSize:  int(b[0])<<8 | int(b[1]),
+		MaxBlockSize:  int(b[2])<<8 | int(b[3]),
+		SampleRate:    int(b[10])<<12 | int(b[11])<<4 | int(b[12])>>4,
+		Channels:      int(b[12]>>1&0x07) + 1,
+		BitsPerSample: int(b[12]&0x01)<<4 | int(b[13]>>4) + 1,
+		TotalSamples:  int64(b[13]&0x0F)<<32 | int64(b[14])<<24 | int64(b[15])<<16 | int64(b[16])<<8 | int64(b[17]),
+	}
+	copy(info.MD5[:], b[18:34])
+	return info
+}
+
+var flacSampleRates = [...]int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+
+var flacSampleSizes = [...]int{0, 8, 12, 0, 16, 20, 24, 32}
+
+// nextFrame decodes the next audio frame and verifies its CRCs. It returns
+// io.EOF once the stream (or the STREAMINFO sample count) is exhausted.
+func (d *flacDecoder) nextFrame() (*flacFrame, error) {
+	if d.Info.TotalSamples > 0 && d.decoded >= d.Info.TotalSamples {
+		return nil, io.EOF
+	}
+	br := d.br
+	br.crc8, br.crc16 = 0, 0
+	offset := br.pos
+
+	sync, err := br.readBits(15)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) && br.pos == offset {
+			return nil, io.EOF
+		}
+		return nil, &flacFrameError{offset, "truncated frame header"}
+	}
+	if sync != 0x7FFC {
+		return nil, &flacFrameError{offset, "missing frame sync code"}
+	}
+	fail := func(reason string) (*flacFrame, error) {
+		return nil, &flacFrameError{offset, reason}
+	}
+
+	hdr, err := br.readBits(17) // blocking strategy + the next two bytes
+	if err != nil {
+		return fail("truncated frame header")
+	}
+	blockSizeCode := hdr >> 12 & 0x0F
+	sampleRateCode := hdr >> 8 & 0x0F
+	channelCode := hdr >> 4 & 0x0F
+	sampleSizeCode := hdr >> 1 & 0x07
+	if hdr&1 != 0 || sampleRateCode == 15 || channelCode > 10 || sampleSizeCode == 3 {
+		return fail("reserved value in frame header")
+	}
+
+	// UTF-8 style coded frame/sample number.
+	first, err := br.readBits(8)
+	if err != nil {
+		return fail("truncated frame header")
+	}
+	extra := bits.LeadingZeros8(^uint8(first))
+	if extra == 1 || extra > 7 {
+		return fail("invalid coded frame number")
+	}
+	for i := 1; i < extra; i++ {
+		if cont, err := br.readBits(8); err != nil || cont&0xC0 != 0x80 {
+			return fail("invalid coded frame number")
+		}
+	}
+
+	var blockSize int
+	switch {
+	case blockSizeCode == 0:
+		return fail("reserved block size")
+	case blockSizeCode == 1:
+		blockSize = 192
+	case blockSizeCode <= 5:
+		blockSize = 576 << (blockSizeCode - 2)
+	case blockSizeCode == 6:
+		v, err := br.readBits(8)
+		if err != nil {
+			return fail("truncated frame header")
+		}
+		blockSize = int(v) + 1
+	case blockSizeCode == 7:
+		v, err := br.readBits(16)
+		if err != nil {
+			return fail("truncated frame header")
+		}
+		blockSize = int(v) + 1
+	default:
+		blockSize = 256 << (blockSizeCode - 8)
+	}
+
+	sampleRate := d.Info.SampleRate
+	switch {
+	case sampleRateCode >= 1 && sampleRateCode <= 11:
+		sampleRate = flacSampleRates[sampleRateCode]
+	case sampleRateCode == 12:
+		v, err := br.readBits(8)
+		if err != nil {
+			return fail("truncated frame header")
+		}
+		sampleRate = int(v) * 1000
+	case sampleRateCode == 13 || sampleRateCode == 14:
+		v, err := br.readBits(16)
+		if err != nil {
+			return fail("truncated frame header")
+		}
+		sampleRate = int(v)
+		if sampleRateCode == 14 {
+			sampleRate *= 10
+		}
+	}
+
+	bps := d.Info.BitsPerSample
+	if sampleSizeCode != 0 {
+		bps = flacSampleSizes[sampleSizeCode]
+	}
+	if bps > 24 {
+		return fail(fmt.Sprintf("unsupported bit depth %d", bps))
+	}
+
+	if _, err := br.readBits(8); err != nil {
+		return fail("truncated frame header")
+	}
+	if br.crc8 != 0 {
+		return fail("frame header CRC-8 mismatch")
+	}
+
+	channels := int(channelCode) + 1
+	if channelCode >= 8 {
+		channels = 2
+	}
+	samples := make([][]int32, channels)
+	for ch := range samples {
+		subBps := uint(bps)
+		switch {
+		case channelCode == 8 && ch == 1, channelCode == 9 && ch == 0, channelCode == 10 && ch == 1:
+			subBps++ // side channel
+		}
+		samples[ch] = make([]int32, blockSize)
+		if err := d.decodeSubframe(samples[ch], subBps); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return fail("truncated subframe")
+			}
+			return fail(err.Error())
+		}
+	}
+
+	br.alignToByte()
+	if _, err := br.readBits(16); err != nil {
+		return fail("truncated frame footer")
+	}
+	if br.crc16 != 0 {
+		return fail("frame CRC-16 mismatch")
+	}
+
+	switch channelCode {
+	case 8: // left/side
+		for i, side := range samples[1] {
+			samples[1][i] = samples[0][i] - side
+		}
+	case 9: // side/right
+		for i, side := range samples[0] {
+			samples[0][i] = side + samples[1][i]
+		}
+	case 10: // mid/side
+		for i := range samples[0] {
+			side := samples[1][i]
+			mid := samples[0][i]<<1 | side&1
+			samples[0][i] = (mid + side) >> 1
+			samples[1][i] = (mid - side) >> 1
+		}
+	}
+
+	d.decoded += int64(blockSize)
+	return &flacFrame{Offset: offset, SampleRate: sampleRate, BitsPerSample: bps, Samples: samples}, nil
+}
+
+func (d *flacDecoder) decodeSubframe(out []int32, bps uint) error {
+	br := d.br
+	header, err := br.readBits(8)
+	if err != nil {
+		return err
+	}
+	if header&0x80 != 0 {
+		return errors.New("invalid subframe padding bit")
+	}
+	subType := header >> 1 & 0x3F
+	var wasted uint
+	if header&1 != 0 {
+		k, err := br.readUnary()
+		if err != nil {
+			return err
+		}
+		wasted = uint(k) + 1
+		if wasted >= bps {
+			return errors.New("invalid wasted bits")
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case subType == 0: // CONSTANT
+		v, err := br.readSigned(bps)
+		if err != nil {
+			return err
+		}
+		for i := range out {
+			out[i] = int32(v)
+		}
+	case subType == 1: // VERBATIM
+		for i := range out {
+			v, err := br.readSigned(bps)
+			if err != nil {
+				return err
+			}
+			out[i] = int32(v)
+		}
+	case subType >= 8 && subType <= 12: // FIXED
+		order := int(subType & 0x07)
+		if order > len(out) {
+			return errors.New("predictor order exceeds block size")
+		}
+		for i := 0; i < order; i++ {
+			v, err := br.readSigned(bps)
+			if err != nil {
+				return err
+			}
+			out[i] = int32(v)
+		}
+		if err := d.decodeResidual(out, order); err != nil {
+			return err
+		}
+		restoreFixedPrediction(out, order)
+	case subType >= 32: // LPC
+		order := int(subType&0x1F) + 1
+		if order > len(out) {
+			return errors.New("predictor order exceeds block size")
+		}
+		for i := 0; i < order; i++ {
+			v, err := br.readSigned(bps)
+			if err != nil {
+				return err
+			}
+			out[i] = int32(v)
+		}
+		precision, err := br.readBits(4)
+		if err != nil {
+			return err
+		}
+		if precision == 15 {
+			return errors.New("invalid LPC precision")
+		}
+		shift, err := br.readSigned(5)
+		if err != nil {
+			return err
+		}
+		if shift < 0 {
+			return errors.New("negative LPC shift")
+		}
+		coeffs := make([]int64, order)
+		for i := range coeffs {
+			if coeffs[i], err = br.readSigned(uint(precision) + 1); err != nil {
+				return err
+			}
+		}
+		if err := d.decodeResidual(out, order); err != nil {
+			return err
+		}
+		for i := order; i < len(out); i++ {
+			var sum int64
+			for j, c := range coeffs {
+				sum += c * int64(out[i-1-j])
+			}
+			out[i] += int32(sum >> uint(shift))
+		}
+	default:
+		return errors.New("reserved subframe type")
+	}
+
+	if wasted > 0 {
+		for i := range out {
+			out[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// decodeResidual reads the Rice-coded residual into out[order:].
+func (d *flacDecoder) decodeResidual(out []int32, order int) error {
+	br := d.br
+	method, err := br.readBits(2)
+	if err != nil {
+		return err
+	}
+	if method > 1 {
+		return errors.New("reserved residual coding method")
+	}
+	paramBits, escape := uint(4), uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	partitionOrder, err := br.readBits(4)
+	if err != nil {
+		return err
+	}
+	partitions := 1 << partitionOrder
+	if len(out)%partitions != 0 || len(out)>>partitionOrder < order {
+		return errors.New("invalid residual partition order")
+	}
+
+	pos := order
+	for p := 0; p < partitions; p++ {
+		count := len(out) >> partitionOrder
+		if p == 0 {
+			count -= order
+		}
+		param, err := br.readBits(paramBits)
+		if err != nil {
+			return err
+		}
+		if param == escape {
+			n, err := br.readBits(5)
+			if err != nil {
+				return err
+			}
+			for i := 0; i < count; i++ {
+				v, err := br.readSigned(uint(n))
+				if err != nil {
+					return err
+				}
+				out[pos] = int32(v)
+				pos++
+			}
+			continue
+		}
+		for i := 0; i < count; i++ {
+			q, err := br.readUnary()
+			if err != nil {
+				return err
+			}
+			r, err := br.readBits(uint(param))
+			if err != nil {
+				return err
+			}
+			v := q<<param | r
+			out[pos] = int32(v>>1) ^ -int32(v&1)
+			pos++
+		}
+	}
+	return nil
+}
+
+// restoreFixedPrediction undoes the fixed polynomial predictors in place.
+func restoreFixedPrediction(out []int32, order int) {
+	switch order {
+	case 1:
+		for i := 1; i < len(out); i++ {
+			out[i] += out[i-1]
+		}
+	case 2:
+		for i := 2; i < len(out); i++ {
+			out[i] += 2*out[i-1] - out[i-2]
+		}
+	case 3:
+		for i := 3; i < len(out); i++ {
+			out[i] += 3*out[i-1] - 3*out[i-2] + out[i-3]
+		}
+	case 4:
+		for i := 4; i < len(out); i++ {
+			out[i] += 4*out[i-1] - 6*out[i-2] + 4*out[i-3] - out[i-4]
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package gobackend
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// FLAC verification statuses.
+const (
+	flacVerifyOK          = "ok"
+	flacVerifyCorrupt     = "corrupt"      // a frame failed its CRC or could not be decoded
+	flacVerifyTruncated   = "truncated"    // the stream ended before STREAMINFO's sample count
+	flacVerifyMD5Mismatch = "md5_mismatch" // every frame decoded but the audio differs
+	flacVerifyError       = "error"        // not a readable FLAC file
+)
+
+type FLACVerifyResult struct {
+	FilePath           string `json:"file_path"`
+	Status             string `json:"status"`
+	Valid              bool   `json:"valid"`
+	Error              string `json:"error,omitempty"`
+	FirstCorruptOffset int64  `json:"first_corrupt_offset"` // -1 when no frame is corrupt
+	FramesChecked      int    `json:"frames_checked"`
+	SamplesDecoded     int64  `json:"samples_decoded"`
+	TotalSamples       int64  `json:"total_samples"`
+	MD5Checked         bool   `json:"md5_checked"` // false when the encoder left the MD5 unset
+	ExpectedMD5        string `json:"expected_md5,omitempty"`
+	ActualMD5          string `json:"actual_md5,omitempty"`
+}
+
+var (
+	flacVerifyProgress   LibraryScanProgress
+	flacVerifyProgressMu sync.RWMutex
+	flacVerifyCancel     chan struct{}
+	flacVerifyCancelMu   sync.Mutex
+)
+
+// VerifyFLACFile decodes every frame of a FLAC file, checking the header
+// CRC-8 and frame CRC-16 of each, then compares the MD5 of the decoded audio
+// with the one stored in STREAMINFO.
+func VerifyFLACFile(filePath string) *FLACVerifyResult {
+	result := &FLACVerifyResult{FilePath: filePath, FirstCorruptOffset: -1}
+	fail := func(status string, offset int64, err error) *FLACVerifyResult {
+		result.Status = status
+		result.FirstCorruptOffset = offset
+		result.Error = err.Error()
+		return result
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fail(flacVerifyError, -1, err)
+	}
+	defer f.Close()
+
+	dec, err := newFLACDecoder(f)
+	if err != nil {
+		return fail(flacVerifyError, -1, err)
+	}
+	info := dec.Info
+	result.TotalSamples = info.TotalSamples
+
+	hash := md5.New()
+	bytesPerSample := (info.BitsPerSample + 7) / 8
+	var buf []byte
+	for {
+		frame, err := dec.nextFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var frameErr *flacFrameError
+			if errors.As(err, &frameErr) {
+				status := flacVerifyCorrupt
+				if strings.HasPrefix(frameErr.Reason, "truncated") {
+					status = flacVerifyTruncated
+				}
+				return fail(status, frameErr.Offset, err)
+			}
+			return fail(flacVerifyError, dec.br.pos, err)
+		}
+		if len(frame.Samples) != info.Channels || frame.BitsPerSample != info.BitsPerSample {
+			return fail(flacVerifyCorrupt, frame.Offset, &flacFrameError{frame.Offset, "frame format differs from STREAMINFO"})
+		}
+
+		frames := len(frame.Samples[0])
+		if info.TotalSamples > 0 && result.SamplesDecoded+int64(frames) > info.TotalSamples {
+			frames = int(info.TotalSamples - result.SamplesDecoded)
+		}
+		buf = appendFLACMD5Samples(buf[:0], frame.Samples, frames, bytesPerSample)
+		hash.Write(buf)
+		result.FramesChecked++
+		result.SamplesDecoded += int64(frames)
+	}
+
+	if info.TotalSamples > 0 && result.SamplesDecoded < info.TotalSamples {
+		return fail(flacVerifyTruncated, dec.br.pos, fmt.Errorf("stream ends after %d of %d samples", result.SamplesDecoded, info.TotalSamples))
+	}
+
+	if info.MD5 != ([16]byte{}) {
+		result.MD5Checked = true
+		result.ExpectedMD5 = hex.EncodeToString(info.MD5[:])
+		result.ActualMD5 = hex.EncodeToString(hash.Sum(nil))
+		if result.ActualMD5 != result.ExpectedMD5 {
+			result.Status = flacVerifyMD5Mismatch
+			result.Error = "decoded audio does not match the STREAMINFO MD5"
+			return result
+		}
+	}
+
+	result.Status = flacVerifyOK
+	result.Valid = true
+	return result
+}
+
+// appendFLACMD5Samples serialises samples the way the reference encoder
+// hashes them: interleaved, signed little-endian, in whole bytes.
+func appendFLACMD5Samples(dst []byte, samples [][]int32, frames, bytesPerSample int) []byte {
+	for i := 0; i < frames; i++ {
+		for _, ch := range samples {
+			v := ch[i]
+			for b := 0; b < bytesPerSample; b++ {
+				dst = append(dst, byte(v>>(8*b)))
+			}
+		}
+	}
+	return dst
+}
+
+func updateFLACVerifyProgress(checkedFiles, totalFiles int, currentPath string) {
+	flacVerifyProgressMu.Lock()
+	flacVerifyProgress.ScannedFiles = checkedFiles
+	flacVerifyProgress.CurrentFile = filepath.Base(currentPath)
+	if totalFiles > 0 {
+		flacVerifyProgress.ProgressPct = float64(checkedFiles) / float64(totalFiles) * 100
+	}
+	flacVerifyProgressMu.Unlock()
+}
+
+// VerifyFLACLibrary verifies every FLAC file under folderPath. Progress is
+// reported through GetFLACVerifyProgress, with error_count counting files
+// that failed. Only the failed files are listed in the returned results.
+func VerifyFLACLibrary(folderPath string) ([]*FLACVerifyResult, int, error) {
+	if folderPath == "" {
+		return nil, 0, fmt.Errorf("folder path is empty")
+	}
+	info, err := os.Stat(folderPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("folder not found: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, 0, fmt.Errorf("path is not a folder: %s", folderPath)
+	}
+
+	flacVerifyProgressMu.Lock()
+	flacVerifyProgress = LibraryScanProgress{}
+	flacVerifyProgressMu.Unlock()
+
+	flacVerifyCancelMu.Lock()
+	if flacVerifyCancel != nil {
+		close(flacVerifyCancel)
+	}
+	flacVerifyCancel = make(chan struct{})
+	cancelCh := flacVerifyCancel
+	flacVerifyCancelMu.Unlock()
+
+	audioFiles, err := collectLibraryAudioFiles(folderPath, cancelCh)
+	if err != nil {
+		return nil, 0, err
+	}
+	var paths []string
+	for _, file := range audioFiles {
+		if strings.ToLower(filepath.Ext(file.path)) == ".flac" {
+			paths = append(paths, file.path)
+		}
+	}
+
+	totalFiles := len(paths)
+	flacVerifyProgressMu.Lock()
+	flacVerifyProgress.TotalFiles = totalFiles
+	flacVerifyProgressMu.Unlock()
+	GoLog("[FLACVerify] Found %d FLAC files to verify\n", totalFiles)
+
+	pathCh := make(chan string)
+	resultCh := make(chan *FLACVerifyResult)
+	var wg sync.WaitGroup
+	for i := 0; i < max(libraryScanWorkerCount(totalFiles), 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range pathCh {
+				result := VerifyFLACFile(path)
+				select {
+				case <-cancelCh:
+					return
+				case resultCh <- result:
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(pathCh)
+		for _, path := range paths {
+			select {
+			case <-cancelCh:
+				return
+			case pathCh <- path:
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	var failed []*FLACVerifyResult
+	checked := 0
+	for result := range resultCh {
+		checked++
+		updateFLACVerifyProgress(checked, totalFiles, result.FilePath)
+		if !result.Valid {
+			GoLog("[FLACVerify] %s: %s (%s)\n", result.FilePath, result.Status, result.Error)
+			failed = append(failed, result)
+		}
+	}
+
+	select {
+	case <-cancelCh:
+		return nil, totalFiles, fmt.Errorf("verification cancelled")
+	default:
+	}
+
+	flacVerifyProgressMu.Lock()
+	flacVerifyProgress.ErrorCount = len(failed)
+	flacVerifyProgress.IsComplete = true
+	flacVerifyProgressMu.Unlock()
+
+	GoLog("[FLACVerify] Verification complete: %d files, %d failed\n", totalFiles, len(failed))
+	return failed, totalFiles, nil
+}
+
+func GetFLACVerifyProgress() LibraryScanProgress {
+	flacVerifyProgressMu.RLock()
+	defer flacVerifyProgressMu.RUnlock()
+	return flacVerifyProgress
+}
+
+func CancelFLACVerify() {
+	flacVerifyCancelMu.Lock()
+	defer flacVerifyCancelMu.Unlock()
+
+	if flacVerifyCancel != nil {
+		close(flacVerifyCancel)
+		flacVerifyCancel = nil
+	}
+}
@@ -0,0 +1,118 @@
+package gobackend
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTestVerifyFLAC returns a three-frame stereo FLAC whose STREAMINFO
+// carries the MD5 of its audio, and the byte offset of every frame.
+func buildTestVerifyFLAC() ([]byte, []int) {
+	const blockSize = 500
+	left := testSine(3*blockSize, 440, 0.5, 44100, 0)
+	right := testSine(3*blockSize, 880, 0.3, 44100, 0)
+
+	stream := append([]byte("fLaC"), buildTestFLACStreamInfo(44100, 2, 16, 3*blockSize)...)
+	sum := md5.Sum(appendFLACMD5Samples(nil, [][]int32{left, right}, len(left), 2))
+	copy(stream[8+18:8+34], sum[:])
+
+	var offsets []int
+	for i := 0; i < 3; i++ {
+		offsets = append(offsets, len(stream))
+		l, r := left[i*blockSize:(i+1)*blockSize], right[i*blockSize:(i+1)*blockSize]
+		stream = append(stream, buildTestFLACFrame(i, 10, l, r, testFixed2, testVerbatim)...)
+	}
+	return stream, offsets
+}
+
+func writeTestVerifyFLAC(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyFLACFileDetectsCorruption(t *testing.T) {
+	dir := t.TempDir()
+	stream, offsets := buildTestVerifyFLAC()
+
+	good := filepath.Join(dir, "good.flac")
+	writeTestVerifyFLAC(t, good, stream)
+	if r := VerifyFLACFile(good); !r.Valid || !r.MD5Checked || r.FramesChecked != 3 || r.FirstCorruptOffset != -1 {
+		t.Fatalf("good file = %+v", r)
+	}
+
+	badMD5 := append([]byte{}, stream...)
+	badMD5[8+18] ^= 0xFF
+	writeTestVerifyFLAC(t, good, badMD5)
+	if r := VerifyFLACFile(good); r.Valid || r.Status != flacVerifyMD5Mismatch {
+		t.Fatalf("bad MD5 = %+v", r)
+	}
+
+	noMD5 := append([]byte{}, stream...)
+	copy(noMD5[8+18:8+34], make([]byte, 16))
+	writeTestVerifyFLAC(t, good, noMD5)
+	if r := VerifyFLACFile(good); !r.Valid || r.MD5Checked {
+		t.Fatalf("unset MD5 = %+v", r)
+	}
+
+	flipped := append([]byte{}, stream...)
+	flipped[offsets[1]+40] ^= 0x08
+	writeTestVerifyFLAC(t, good, flipped)
+	if r := VerifyFLACFile(good); r.Status != flacVerifyCorrupt || r.FirstCorruptOffset != int64(offsets[1]) || r.FramesChecked != 1 {
+		t.Fatalf("flipped bit = %+v", r)
+	}
+
+	writeTestVerifyFLAC(t, good, stream[:offsets[2]+100])
+	if r := VerifyFLACFile(good); r.Status != flacVerifyTruncated || r.FirstCorruptOffset != int64(offsets[2]) {
+		t.Fatalf("cut mid-frame = %+v", r)
+	}
+
+	writeTestVerifyFLAC(t, good, stream[:offsets[2]])
+	if r := VerifyFLACFile(good); r.Status != flacVerifyTruncated || r.FirstCorruptOffset != int64(offsets[2]) || r.SamplesDecoded != 1000 {
+		t.Fatalf("cut at frame boundary = %+v", r)
+	}
+
+	writeTestVerifyFLAC(t, good, []byte("not flac"))
+	if r := VerifyFLACFile(good); r.Status != flacVerifyError {
+		t.Fatalf("non-FLAC = %+v", r)
+	}
+}
+
+func TestVerifyFLACLibraryReportsProgressAndFailures(t *testing.T) {
+	dir := t.TempDir()
+	stream, offsets := buildTestVerifyFLAC()
+	if err := os.MkdirAll(filepath.Join(dir, "album"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeTestVerifyFLAC(t, filepath.Join(dir, "album", "01.flac"), stream)
+	writeTestVerifyFLAC(t, filepath.Join(dir, "album", "02.flac"), stream[:offsets[1]+10])
+	writeTestVerifyFLAC(t, filepath.Join(dir, "album", "03.mp3"), testMP3Audio)
+
+	response, err := VerifyFLACLibraryJSON(dir)
+	if err != nil {
+		t.Fatalf("VerifyFLACLibraryJSON: %v", err)
+	}
+	var decoded struct {
+		TotalFiles  int                `json:"total_files"`
+		FailedCount int                `json:"failed_count"`
+		Failed      []FLACVerifyResult `json:"failed"`
+	}
+	if err := json.Unmarshal([]byte(response), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.TotalFiles != 2 || decoded.FailedCount != 1 || filepath.Base(decoded.Failed[0].FilePath) != "02.flac" {
+		t.Fatalf("response = %s", response)
+	}
+
+	var progress LibraryScanProgress
+	if err := json.Unmarshal([]byte(GetFLACVerifyProgressJSON()), &progress); err != nil {
+		t.Fatal(err)
+	}
+	if !progress.IsComplete || progress.ScannedFiles != 2 || progress.ErrorCount != 1 || progress.ProgressPct != 100 {
+		t.Fatalf("progress = %+v", progress)
+	}
+}
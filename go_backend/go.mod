@@ -11,6 +11,7 @@ require (
 	github.com/go-flac/go-flac/v2 v2.0.4
 	github.com/refraction-networking/utls v1.8.2
 	golang.org/x/crypto v0.53.0
+	golang.org/x/image v0.42.0
 	golang.org/x/mobile v0.0.0-20260611195102-4dd8f1dbf5d2
 	golang.org/x/net v0.56.0
 	golang.org/x/text v0.38.0
@@ -39,6 +39,8 @@ type LibraryScanResult struct {
 	Copyright            string `json:"copyright,omitempty"`
 	Format               string `json:"format,omitempty"`
 	MetadataFromFilename bool   `json:"metadataFromFilename,omitempty"`
+	// Authenticity holds the fake-lossless analysis for FLAC/WAV/AIFF files.
+	Authenticity *AudioAuthenticity `json:"authenticity,omitempty"`
 }
 
 type LibraryScanProgress struct {
@@ -188,6 +190,7 @@ func updateLibraryScanProgress(scannedFiles, totalFiles int, currentPath string)
 		libraryScanProgress.ProgressPct = float64(scannedFiles) / float64(totalFiles) * 100
 	}
 	libraryScanProgressMu.Unlock()
+	markLibraryScanProgressChanged()
 }
 
 func scanLibraryAudioTasksParallel(tasks []libraryScanTask, scanTime string, cancelCh <-chan struct{}, totalFiles int, completed *int) (map[int][]LibraryScanResult, int, error) {
@@ -308,6 +311,7 @@ func ScanLibraryFolder(folderPath string) (string, error) {
 	libraryScanProgressMu.Lock()
 	libraryScanProgress = LibraryScanProgress{}
 	libraryScanProgressMu.Unlock()
+	markLibraryScanProgressChanged()
 
 	libraryScanCancelMu.Lock()
 	if libraryScanCancel != nil {
@@ -326,11 +330,13 @@ func ScanLibraryFolder(folderPath string) (string, error) {
 	libraryScanProgressMu.Lock()
 	libraryScanProgress.TotalFiles = totalFiles
 	libraryScanProgressMu.Unlock()
+	markLibraryScanProgressChanged()
 
 	if totalFiles == 0 {
 		libraryScanProgressMu.Lock()
 		libraryScanProgress.IsComplete = true
 		libraryScanProgressMu.Unlock()
+		markLibraryScanProgressChanged()
 		return "[]", nil
 	}
 
@@ -438,6 +444,7 @@ func ScanLibraryFolder(folderPath string) (string, error) {
 	libraryScanProgress.ErrorCount = errorCount
 	libraryScanProgress.IsComplete = true
 	libraryScanProgressMu.Unlock()
+	markLibraryScanProgressChanged()
 
 	GoLog("[LibraryScan] Scan complete: %d tracks found, %d errors\n", len(results), errorCount)
 
@@ -561,6 +568,7 @@ func scanFLACFile(filePath string, result *LibraryScanResult, displayNameHint st
 			result.Duration = int(quality.TotalSamples / int64(quality.SampleRate))
 		}
 	}
+	applyLibraryScanAuthenticity(filePath, ".flac", result)
 
 	applyDefaultLibraryMetadata(filePath, displayNameHint, result)
 
@@ -914,6 +922,7 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 	libraryScanProgressMu.Lock()
 	libraryScanProgress = LibraryScanProgress{}
 	libraryScanProgressMu.Unlock()
+	markLibraryScanProgressChanged()
 
 	libraryScanCancelMu.Lock()
 	if libraryScanCancel != nil {
@@ -936,6 +945,7 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 	libraryScanProgressMu.Lock()
 	libraryScanProgress.TotalFiles = totalFiles
 	libraryScanProgressMu.Unlock()
+	markLibraryScanProgressChanged()
 
 	var filesToScan []libraryAudioFileInfo
 	skippedCount := 0
@@ -992,6 +1002,7 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 		libraryScanProgress.IsComplete = true
 		libraryScanProgress.ProgressPct = 100
 		libraryScanProgressMu.Unlock()
+		markLibraryScanProgressChanged()
 
 		result := IncrementalScanResult{
 			Scanned:      []LibraryScanResult{},
@@ -1102,6 +1113,7 @@ func scanLibraryFolderIncrementalWithExistingFiles(folderPath string, existingFi
 	libraryScanProgress.ScannedFiles = totalFiles
 	libraryScanProgress.ProgressPct = 100
 	libraryScanProgressMu.Unlock()
+	markLibraryScanProgressChanged()
 
 	GoLog("[LibraryScan] Incremental scan complete: %d scanned, %d skipped, %d deleted, %d errors\n",
 		len(results), skippedCount, len(deletedPaths), errorCount)
@@ -0,0 +1,286 @@
+package gobackend
+
+// EBU R128 / ITU-R BS.1770-4 integrated loudness and ReplayGain 2.0 values.
+//
+// Audio is K-weighted, split into 400 ms gating blocks with 75% overlap and
+// gated at -70 LUFS (absolute) and -10 LU below the ungated mean (relative).
+// Album loudness gates the pooled blocks of every track, so it is not simply
+// the average of the track values. ReplayGain 2.0 gain is the distance to the
+// -18 LUFS reference; the peak is the sample peak.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	replayGainReferenceLUFS = -18.0
+	loudnessAbsoluteGate    = -70.0
+	loudnessRelativeGate    = -10.0
+)
+
+// errTooShortForLoudness is returned for audio shorter than one 400 ms block.
+var errTooShortForLoudness = errors.New("audio too short to measure loudness")
+
+// biquad is a direct form I second-order IIR filter.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+func (f *biquad) process(x float64) float64 {
+	y := f.b0*x + f.b1*f.x1 + f.b2*f.x2 - f.a1*f.y1 - f.a2*f.y2
+	f.x2, f.x1 = f.x1, x
+	f.y2, f.y1 = f.y1, y
+	return y
+}
+
+// newKWeightingFilters returns the BS.1770 pre-filter (high shelf) and RLB
+// high-pass stages, with coefficients derived for the given sample rate.
+func newKWeightingFilters(sampleRate int) (biquad, biquad) {
+	rate := float64(sampleRate)
+
+	f0, gain, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, gain/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf := biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass := biquad{
+		b0: 1, b1: -2, b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return shelf, highPass
+}
+
+// loudnessChannelWeight returns the BS.1770 weight for a channel, assuming
+// the WAVE/FLAC channel order (L R C LFE Ls Rs for 5.1).
+func loudnessChannelWeight(channel, channels int) float64 {
+	switch {
+	case channels == 6 && channel == 3:
+		return 0 // LFE
+	case channels == 6 && channel >= 4, channels == 5 && channel >= 3:
+		return 1.41
+	}
+	return 1
+}
+
+// loudnessMeter accumulates K-weighted energy over 100 ms steps and records
+// the mean-square energy of every complete 400 ms gating block.
+type loudnessMeter struct {
+	channels  int
+	weights   []float64
+	shelves   []biquad
+	highPass  []biquad
+	stepSize  int
+	stepFill  int
+	stepSum   float64
+	steps     [4]float64 // last four 100 ms step energies
+	stepCount int
+	blocks    []float64
+	peak      float64
+}
+
+func newLoudnessMeter(sampleRate, channels int) *loudnessMeter {
+	m := &loudnessMeter{
+		channels: channels,
+		weights:  make([]float64, channels),
+		shelves:  make([]biquad, channels),
+		highPass: make([]biquad, channels),
+		stepSize: int(math.Round(float64(sampleRate) / 10)),
+	}
+	for ch := 0; ch < channels; ch++ {
+		m.weights[ch] = loudnessChannelWeight(ch, channels)
+		m.shelves[ch], m.highPass[ch] = newKWeightingFilters(sampleRate)
+	}
+	return m
+}
+
+// add feeds one block of per-channel samples to the meter.
+func (m *loudnessMeter) add(samples [][]float64) {
+	frames := len(samples[0])
+	for i := 0; i < frames; i++ {
+		var energy float64
+		for ch := 0; ch < m.channels; ch++ {
+			x := samples[ch][i]
+			if a := math.Abs(x); a > m.peak {
+				m.peak = a
+			}
+			y := m.highPass[ch].process(m.shelves[ch].process(x))
+			energy += m.weights[ch] * y * y
+		}
+		m.stepSum += energy
+		m.stepFill++
+		if m.stepFill == m.stepSize {
+			m.steps[m.stepCount%4] = m.stepSum
+			m.stepCount++
+			m.stepSum, m.stepFill = 0, 0
+			if m.stepCount >= 4 {
+				block := m.steps[0] + m.steps[1] + m.steps[2] + m.steps[3]
+				m.blocks = append(m.blocks, block/float64(4*m.stepSize))
+			}
+		}
+	}
+}
+
+func blockLoudness(energy float64) float64 {
+	return -0.691 + 10*math.Log10(energy)
+}
+
+// gatedLoudness applies the BS.1770 absolute and relative gates to a set of
+// block energies and returns the integrated loudness in LUFS. Silence yields
+// -Inf.
+func gatedLoudness(blocks []float64) float64 {
+	gateMean := func(threshold float64) float64 {
+		var sum float64
+		var n int
+		for _, e := range blocks {
+			if e > 0 && blockLoudness(e) > threshold {
+				sum += e
+				n++
+			}
+		}
+		if n == 0 {
+			return 0
+		}
+		return sum / float64(n)
+	}
+	mean := gateMean(loudnessAbsoluteGate)
+	if mean == 0 {
+		return math.Inf(-1)
+	}
+	mean = gateMean(blockLoudness(mean) + loudnessRelativeGate)
+	if mean == 0 {
+		return math.Inf(-1)
+	}
+	return blockLoudness(mean)
+}
+
+// loudnessAnalysis is the measurement of a single file.
+type loudnessAnalysis struct {
+	LoudnessLUFS float64
+	Peak         float64
+	blocks       []float64
+}
+
+// analyzeLoudness decodes a FLAC, WAV or AIFF file and measures it.
+func analyzeLoudness(filePath string) (*loudnessAnalysis, error) {
+	src, err := openPCMSource(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	meter := newLoudnessMeter(src.SampleRate(), src.Channels())
+	for {
+		samples, err := src.ReadBlock()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		meter.add(samples)
+	}
+	if len(meter.blocks) == 0 {
+		return nil, errTooShortForLoudness
+	}
+	return &loudnessAnalysis{
+		LoudnessLUFS: gatedLoudness(meter.blocks),
+		Peak:         meter.peak,
+		blocks:       meter.blocks,
+	}, nil
+}
+
+// albumLoudness pools the gating blocks of every track and returns the album
+// loudness and the highest track peak.
+func albumLoudness(tracks []*loudnessAnalysis) (float64, float64) {
+	var blocks []float64
+	var peak float64
+	for _, t := range tracks {
+		blocks = append(blocks, t.blocks...)
+		peak = math.Max(peak, t.Peak)
+	}
+	return gatedLoudness(blocks), peak
+}
+
+// replayGainForLoudness converts integrated loudness to a ReplayGain 2.0 gain
+// in dB. Digital silence gets no adjustment.
+func replayGainForLoudness(lufs float64) float64 {
+	if math.IsInf(lufs, -1) {
+		return 0
+	}
+	return replayGainReferenceLUFS - lufs
+}
+
+func formatReplayGainGain(db float64) string {
+	return fmt.Sprintf("%.2f dB", db)
+}
+
+func formatReplayGainPeak(peak float64) string {
+	return fmt.Sprintf("%.6f", peak)
+}
+
+// writeReplayGainFields writes replaygain_* edit fields with the writer that
+// matches the file's container.
+func writeReplayGainFields(filePath string, fields map[string]string) error {
+	switch tagFormatForFile(filePath) {
+	case "flac":
+		return EditFlacFields(filePath, fields)
+	case "mp4":
+		return EditM4AReplayGain(filePath, fields)
+	case "mp3":
+		return WriteMP3Tags(filePath, fields)
+	case "ogg":
+		return WriteOggTags(filePath, fields)
+	case "wav":
+		return WriteWAVTags(filePath, fields)
+	case "aiff":
+		return WriteAIFFTags(filePath, fields)
+	case "ape":
+		edits := tagMap{}
+		for key, value := range fields {
+			edits[strings.ToUpper(key)] = nil
+			if value != "" {
+				edits[strings.ToUpper(key)] = []string{value}
+			}
+		}
+		_, err := writeTagMap(filePath, edits)
+		return err
+	}
+	return fmt.Errorf("unsupported file type for ReplayGain tags: %s", filePath)
+}
+
+// embedTrackReplayGain measures a single downloaded file and writes its
+// track gain/peak.
+func embedTrackReplayGain(filePath string) error {
+	analysis, err := analyzeLoudness(filePath)
+	if err != nil {
+		return err
+	}
+	gain := formatReplayGainGain(replayGainForLoudness(analysis.LoudnessLUFS))
+	peak := formatReplayGainPeak(analysis.Peak)
+	if err := writeReplayGainFields(filePath, map[string]string{
+		"replaygain_track_gain": gain,
+		"replaygain_track_peak": peak,
+	}); err != nil {
+		return err
+	}
+	GoLog("[ReplayGain] %s: track gain %s, peak %s\n", filepath.Base(filePath), gain, peak)
+	return nil
+}
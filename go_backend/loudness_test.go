@@ -0,0 +1,335 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testBitWriter packs MSB-first bits, mirroring flacBitReader.
+type testBitWriter struct {
+	out  []byte
+	acc  uint64
+	nacc uint
+}
+
+func (w *testBitWriter) write(v uint64, n uint) {
+	for i := int(n) - 1; i >= 0; i-- {
+		w.acc = w.acc<<1 | v>>uint(i)&1
+		w.nacc++
+		if w.nacc == 8 {
+			w.out = append(w.out, byte(w.acc))
+			w.acc, w.nacc = 0, 0
+		}
+	}
+}
+
+func (w *testBitWriter) writeSigned(v int64, n uint) { w.write(uint64(v)&(1<<n-1), n) }
+
+func (w *testBitWriter) align() {
+	for w.nacc != 0 {
+		w.write(0, 1)
+	}
+}
+
+// writeRice writes residual as a single-partition, method 0 Rice block.
+func (w *testBitWriter) writeRice(residual []int32, param uint) {
+	w.write(0, 2) // method 0
+	w.write(0, 4) // partition order 0
+	w.write(uint64(param), 4)
+	for _, r := range residual {
+		u := uint64(uint32(r<<1) ^ uint32(r>>31))
+		for q := u >> param; q > 0; q-- {
+			w.write(0, 1)
+		}
+		w.write(1, 1)
+		w.write(u&(1<<param-1), param)
+	}
+}
+
+type testFLACSubframe func(w *testBitWriter, samples []int32, bps uint)
+
+func testVerbatim(w *testBitWriter, samples []int32, bps uint) {
+	w.write(0x02, 8)
+	for _, s := range samples {
+		w.writeSigned(int64(s), bps)
+	}
+}
+
+func testConstant(w *testBitWriter, samples []int32, bps uint) {
+	w.write(0x00, 8)
+	w.writeSigned(int64(samples[0]), bps)
+}
+
+func testFixed2(w *testBitWriter, samples []int32, bps uint) {
+	w.write(uint64(8+2)<<1, 8)
+	w.writeSigned(int64(samples[0]), bps)
+	w.writeSigned(int64(samples[1]), bps)
+	residual := make([]int32, 0, len(samples)-2)
+	for i := 2; i < len(samples); i++ {
+		residual = append(residual, samples[i]-2*samples[i-1]+samples[i-2])
+	}
+	w.writeRice(residual, 4)
+}
+
+// testLPC1WastedBit encodes an order-1 LPC predictor (coefficient 1) over
+// samples that all have their lowest bit clear.
+func testLPC1WastedBit(w *testBitWriter, samples []int32, bps uint) {
+	w.write(uint64(32)<<1|1, 8)
+	w.write(1, 1) // one wasted bit
+	bps--
+	w.writeSigned(int64(samples[0]>>1), bps)
+	w.write(1, 4)       // precision 2
+	w.writeSigned(0, 5) // shift
+	w.writeSigned(1, 2)
+	residual := make([]int32, 0, len(samples)-1)
+	for i := 1; i < len(samples); i++ {
+		residual = append(residual, samples[i]>>1-samples[i-1]>>1)
+	}
+	w.writeRice(residual, 3)
+}
+
+// buildTestFLACFrame encodes one 16-bit, 44.1 kHz stereo frame.
+func buildTestFLACFrame(number int, channelCode uint64, left, right []int32, sub0, sub1 testFLACSubframe) []byte {
+	w := &testBitWriter{}
+	w.write(0x3FFE, 14)
+	w.write(0, 2)
+	w.write(7, 4) // 16-bit block size follows
+	w.write(9, 4) // 44.1 kHz
+	w.write(channelCode, 4)
+	w.write(4, 3) // 16 bits per sample
+	w.write(0, 1)
+	w.write(uint64(number), 8)
+	w.write(uint64(len(left)-1), 16)
+	crc8 := uint8(0)
+	for _, c := range w.out {
+		crc8 = flacCRC8Table[crc8^c]
+	}
+	w.write(uint64(crc8), 8)
+
+	ch0, ch1, bps0, bps1 := left, right, uint(16), uint(16)
+	switch channelCode {
+	case 8: // left/side
+		ch1 = make([]int32, len(left))
+		for i := range left {
+			ch1[i] = left[i] - right[i]
+		}
+		bps1 = 17
+	case 10: // mid/side
+		ch0, ch1 = make([]int32, len(left)), make([]int32, len(left))
+		for i := range left {
+			ch0[i] = (left[i] + right[i]) >> 1
+			ch1[i] = left[i] - right[i]
+		}
+		bps1 = 17
+	}
+	sub0(w, ch0, bps0)
+	sub1(w, ch1, bps1)
+	w.align()
+
+	crc16 := uint16(0)
+	for _, c := range w.out {
+		crc16 = crc16<<8 ^ flacCRC16Table[byte(crc16>>8)^c]
+	}
+	w.write(uint64(crc16), 16)
+	return w.out
+}
+
+func buildTestFLACStreamInfo(sampleRate, channels, bps int, totalSamples int64) []byte {
+	info := make([]byte, 34)
+	binary.BigEndian.PutUint16(info[0:2], 16)
+	binary.BigEndian.PutUint16(info[2:4], 4096)
+	packed := uint64(sampleRate)<<44 | uint64(channels-1)<<41 | uint64(bps-1)<<36 | uint64(totalSamples)
+	binary.BigEndian.PutUint64(info[10:18], packed)
+	return append([]byte{0x80, 0, 0, 34}, info...)
+}
+
+func testSine(n int, freq, amplitude, sampleRate float64, phase float64) []int32 {
+	out := make([]int32, n)
+	for i := range out {
+		out[i] = int32(math.Round(amplitude * 32767 * math.Sin(2*math.Pi*freq*float64(i)/sampleRate+phase)))
+	}
+	return out
+}
+
+func buildTestPCMWAV(sampleRate int, channels [][]int32) []byte {
+	frames := len(channels[0])
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], wavFormatPCM)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], uint16(len(channels)))
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], uint32(sampleRate*2*len(channels)))
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], uint16(2*len(channels)))
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], 16)
+	data := make([]byte, 0, frames*2*len(channels))
+	for i := 0; i < frames; i++ {
+		for _, ch := range channels {
+			data = binary.LittleEndian.AppendUint16(data, uint16(int16(ch[i])))
+		}
+	}
+	body := append([]byte("WAVE"), buildTestRIFFChunk("fmt ", fmtChunk, false)...)
+	body = append(body, buildTestRIFFChunk("data", data, false)...)
+	return buildTestRIFFChunk("RIFF", body, false)
+}
+
+func TestFLACDecoderDecodesEverySubframeType(t *testing.T) {
+	const blockSize = 1000
+	left := testSine(3*blockSize, 440, 0.5, 44100, 0)
+	right := testSine(3*blockSize, 660, 0.25, 44100, 1)
+	for i := 2 * blockSize; i < 3*blockSize; i++ {
+		left[i] &^= 1 // let the LPC subframe use a wasted bit
+	}
+	constant := make([]int32, blockSize)
+	for i := range constant {
+		constant[i] = -1234
+	}
+	copy(left[:blockSize], constant)
+
+	stream := append([]byte("fLaC"), buildTestFLACStreamInfo(44100, 2, 16, 3*blockSize)...)
+	stream = append(stream, buildTestFLACFrame(0, 1, left[:blockSize], right[:blockSize], testConstant, testVerbatim)...)
+	stream = append(stream, buildTestFLACFrame(1, 10, left[blockSize:2*blockSize], right[blockSize:2*blockSize], testFixed2, testFixed2)...)
+	frame3 := len(stream)
+	stream = append(stream, buildTestFLACFrame(2, 8, left[2*blockSize:], right[2*blockSize:], testLPC1WastedBit, testVerbatim)...)
+
+	path := filepath.Join(t.TempDir(), "song.flac")
+	if err := os.WriteFile(path, stream, 0600); err != nil {
+		t.Fatal(err)
+	}
+	src, err := openPCMSource(path)
+	if err != nil {
+		t.Fatalf("openPCMSource: %v", err)
+	}
+	defer src.Close()
+	if src.SampleRate() != 44100 || src.Channels() != 2 {
+		t.Fatalf("format = %d Hz, %d ch", src.SampleRate(), src.Channels())
+	}
+
+	var gotL, gotR []int32
+	for {
+		block, err := src.ReadBlock()
+		if err != nil {
+			break
+		}
+		for i := range block[0] {
+			gotL = append(gotL, int32(math.Round(block[0][i]*32768)))
+			gotR = append(gotR, int32(math.Round(block[1][i]*32768)))
+		}
+	}
+	if len(gotL) != len(left) {
+		t.Fatalf("decoded %d samples, want %d", len(gotL), len(left))
+	}
+	for i := range left {
+		if gotL[i] != left[i] || gotR[i] != right[i] {
+			t.Fatalf("sample %d = %d/%d, want %d/%d", i, gotL[i], gotR[i], left[i], right[i])
+		}
+	}
+
+	// A flipped bit inside the third frame is caught by its CRC-16.
+	stream[len(stream)-10] ^= 0x40
+	dec, err := newFLACDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = dec.nextFrame()
+		if err != nil {
+			break
+		}
+	}
+	frameErr, ok := err.(*flacFrameError)
+	if !ok || frameErr.Offset != int64(frame3) {
+		t.Fatalf("nextFrame error = %v, want corruption at byte %d", err, frame3)
+	}
+}
+
+func TestAnalyzeLoudnessMatchesBS1770Sine(t *testing.T) {
+	// A 997 Hz stereo sine at -20 dBFS measures -20 LUFS (each channel
+	// contributes -23.01 LUFS).
+	sine := testSine(48000*5, 997, 0.1, 48000, 0)
+	path := filepath.Join(t.TempDir(), "sine.wav")
+	if err := os.WriteFile(path, buildTestPCMWAV(48000, [][]int32{sine, sine}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	analysis, err := analyzeLoudness(path)
+	if err != nil {
+		t.Fatalf("analyzeLoudness: %v", err)
+	}
+	if math.Abs(analysis.LoudnessLUFS+20) > 0.05 {
+		t.Fatalf("loudness = %.3f LUFS, want -20", analysis.LoudnessLUFS)
+	}
+	if got := formatReplayGainGain(replayGainForLoudness(analysis.LoudnessLUFS)); got != "2.00 dB" {
+		t.Fatalf("gain = %s", got)
+	}
+	if math.Abs(analysis.Peak-0.1) > 0.001 {
+		t.Fatalf("peak = %f", analysis.Peak)
+	}
+
+	short := filepath.Join(t.TempDir(), "short.wav")
+	if err := os.WriteFile(short, buildTestPCMWAV(48000, [][]int32{sine[:1000]}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := analyzeLoudness(short); err != errTooShortForLoudness {
+		t.Fatalf("short file error = %v", err)
+	}
+}
+
+func TestAnalyzeReplayGainJSONWritesTrackAndAlbumTags(t *testing.T) {
+	dir := t.TempDir()
+	quiet := testSine(44100*3, 997, 0.05, 44100, 0)
+	loud := testSine(44100*3, 997, 0.4, 44100, 0)
+	quietPath := filepath.Join(dir, "quiet.wav")
+	if err := os.WriteFile(quietPath, buildTestPCMWAV(44100, [][]int32{quiet, quiet}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	flacPath := filepath.Join(dir, "loud.flac")
+	stream := append([]byte("fLaC"), buildTestFLACStreamInfo(44100, 2, 16, int64(len(loud)))...)
+	for i := 0; i*4096 < len(loud); i++ {
+		end := min((i+1)*4096, len(loud))
+		stream = append(stream, buildTestFLACFrame(i, 1, loud[i*4096:end], loud[i*4096:end], testFixed2, testVerbatim)...)
+	}
+	if err := os.WriteFile(flacPath, stream, 0600); err != nil {
+		t.Fatal(err)
+	}
+	missingPath := filepath.Join(dir, "missing.flac")
+
+	request, _ := json.Marshal(map[string]any{"files": []string{quietPath, flacPath, missingPath}, "write_tags": true})
+	response, err := AnalyzeReplayGainJSON(string(request))
+	if err != nil {
+		t.Fatalf("AnalyzeReplayGainJSON: %v", err)
+	}
+	var decoded struct {
+		Success   bool                    `json:"success"`
+		AlbumGain string                  `json:"album_gain"`
+		AlbumPeak string                  `json:"album_peak"`
+		Tracks    []replayGainTrackResult `json:"tracks"`
+	}
+	if err := json.Unmarshal([]byte(response), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Success || len(decoded.Tracks) != 3 || decoded.Tracks[2].Error == "" {
+		t.Fatalf("response = %s", response)
+	}
+	// -26.02 and -7.96 LUFS; the quiet track's blocks fall below the album's
+	// relative gate, so the album value follows the loud track.
+	if decoded.Tracks[0].TrackGain != "8.02 dB" || decoded.Tracks[1].TrackGain != "-10.04 dB" {
+		t.Fatalf("track gains = %s, %s", decoded.Tracks[0].TrackGain, decoded.Tracks[1].TrackGain)
+	}
+	if decoded.AlbumGain != "-10.04 dB" || decoded.AlbumPeak != decoded.Tracks[1].TrackPeak {
+		t.Fatalf("album = %s / %s", decoded.AlbumGain, decoded.AlbumPeak)
+	}
+
+	for i, path := range []string{quietPath, flacPath} {
+		if !decoded.Tracks[i].Written {
+			t.Fatalf("%s not written", path)
+		}
+		_, tags := readTestTagsJSON(t, path)
+		assertTagValues(t, tags, "REPLAYGAIN_TRACK_GAIN", decoded.Tracks[i].TrackGain)
+		assertTagValues(t, tags, "REPLAYGAIN_ALBUM_GAIN", decoded.AlbumGain)
+		assertTagValues(t, tags, "REPLAYGAIN_ALBUM_PEAK", decoded.AlbumPeak)
+	}
+}
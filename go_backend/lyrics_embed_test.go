@@ -0,0 +1,109 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildTestRIFFChunk(id string, payload []byte, bigEndian bool) []byte {
+	out := append([]byte(id), 0, 0, 0, 0)
+	if bigEndian {
+		binary.BigEndian.PutUint32(out[4:8], uint32(len(payload)))
+	} else {
+		binary.LittleEndian.PutUint32(out[4:8], uint32(len(payload)))
+	}
+	out = append(out, payload...)
+	if len(payload)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+func buildTestWAV() []byte {
+	fmtChunk := make([]byte, 16)
+	binary.LittleEndian.PutUint16(fmtChunk[0:2], 1)
+	binary.LittleEndian.PutUint16(fmtChunk[2:4], 2)
+	binary.LittleEndian.PutUint32(fmtChunk[4:8], 44100)
+	binary.LittleEndian.PutUint32(fmtChunk[8:12], 44100*4)
+	binary.LittleEndian.PutUint16(fmtChunk[12:14], 4)
+	binary.LittleEndian.PutUint16(fmtChunk[14:16], 16)
+	body := append([]byte("WAVE"), buildTestRIFFChunk("fmt ", fmtChunk, false)...)
+	body = append(body, buildTestRIFFChunk("data", make([]byte, 64), false)...)
+	return buildTestRIFFChunk("RIFF", body, false)
+}
+
+func buildTestAIFF() []byte {
+	comm := make([]byte, 18)
+	binary.BigEndian.PutUint16(comm[0:2], 2)
+	binary.BigEndian.PutUint32(comm[2:6], 16)
+	binary.BigEndian.PutUint16(comm[6:8], 16)
+	copy(comm[8:18], []byte{0x40, 0x0E, 0xAC, 0x44, 0, 0, 0, 0, 0, 0})
+	body := append([]byte("AIFF"), buildTestRIFFChunk("COMM", comm, true)...)
+	body = append(body, buildTestRIFFChunk("SSND", make([]byte, 72), true)...)
+	return buildTestRIFFChunk("FORM", body, true)
+}
+
+func TestEmbedLyricsRoundTripsEveryContainer(t *testing.T) {
+	const lyrics = "[00:01.00]Hello\n[00:02.50]<00:02.50>Big <00:03.00>world"
+	cases := []struct {
+		name     string
+		data     []byte
+		wantSYLT bool
+	}{
+		{"song.mp3", append(buildID3v23Tag(id3TextFrame("TIT2", "Song")), testMP3Audio...), true},
+		{"song.wav", buildTestWAV(), true},
+		{"song.aiff", buildTestAIFF(), true},
+		{"song.m4a", buildTestM4AWithChunks(buildM4ATextTag("\xa9nam", "Song"), false), false},
+		{"song.opus", buildTestOggStream(testOpusHeaders("TITLE=Song"), [][]byte{{1, 2, 3}}), false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tc.name)
+			if err := os.WriteFile(path, tc.data, 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := EmbedLyrics(path, lyrics); err != nil {
+				t.Fatalf("EmbedLyrics: %v", err)
+			}
+			got, err := ExtractLyrics(path)
+			if err != nil || got != lyrics {
+				t.Fatalf("ExtractLyrics = %q/%v", got, err)
+			}
+			if hasSYLT := bytes.Contains(mustReadFile(t, path), []byte("SYLT")); hasSYLT != tc.wantSYLT {
+				t.Fatalf("SYLT present = %v, want %v", hasSYLT, tc.wantSYLT)
+			}
+		})
+	}
+}
+
+func TestExtractLyricsFallsBackToSYLT(t *testing.T) {
+	sylt, ok := newID3SyncedLyricsFrame("[ar:Someone]\n[00:01.00]Hello\n[01:02.345]<01:02.345>Big <01:03.000>world")
+	if !ok {
+		t.Fatal("expected a SYLT frame for timed lyrics")
+	}
+	if _, ok := newID3SyncedLyricsFrame("plain text lyrics"); ok {
+		t.Fatal("plain lyrics should not produce SYLT")
+	}
+
+	path := filepath.Join(t.TempDir(), "synced.mp3")
+	tag := buildID3v23Tag(id3TextFrame("TIT2", "Song"), id3v23Frame("SYLT", sylt.data))
+	if err := os.WriteFile(path, append(tag, testMP3Audio...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ExtractLyrics(path)
+	if err != nil || got != "[00:01.00]Hello\n[01:02.34]Big world" {
+		t.Fatalf("ExtractLyrics = %q/%v", got, err)
+	}
+
+	// SYLT timed in MPEG frames cannot be converted to LRC.
+	sylt.data[4] = 0x01
+	if v := extractSyncedLyricsFrame(sylt.data); v != "" {
+		t.Fatalf("extractSyncedLyricsFrame(MPEG frames) = %q", v)
+	}
+}
@@ -0,0 +1,552 @@
+package gobackend
+
+// Native iTunes-style (moov>udta>meta>ilst) tag writing for M4A/MP4 files.
+//
+// Only the moov box is loaded into memory. The rebuilt ilst is followed by a
+// "free" atom inside meta so later edits can usually be absorbed in place;
+// when moov itself has to change size the writer first tries the free space
+// directly after moov, then a plain moov rewrite for moov-after-mdat files,
+// and only as a last resort rewrites the whole file with stco/co64 entries
+// shifted past the grown moov. Fragmented files are defragmented first in
+// that last case.
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// m4aTagPadding is the free space reserved after ilst whenever moov has to
+// grow, so that follow-up edits fit without moving mdat.
+const m4aTagPadding = 4096
+
+var errM4ANoIlst = errors.New("ilst not found")
+
+func buildM4AFreeAtom(size int) []byte {
+	return buildM4AAtom("free", make([]byte, size-8))
+}
+
+// m4aIlstLocation is the ilst box inside an in-memory moov together with its
+// ancestors (moov first) whose sizes must follow any change to ilst.
+type m4aIlstLocation struct {
+	ancestors []mp4Box
+	ilst      mp4Box
+}
+
+// metaChildrenStart returns where child boxes begin inside meta: ISO meta is a
+// full box (4-byte version/flags), QuickTime meta is not.
+func metaChildrenStart(buf []byte, meta mp4Box) int64 {
+	if meta.body()+12 <= meta.end() {
+		if typ := string(buf[meta.body()+8 : meta.body()+12]); typ == "hdlr" || typ == "ilst" || typ == "free" {
+			return meta.body() + 4
+		}
+	}
+	return meta.body()
+}
+
+func locateM4AIlst(buf []byte, moov mp4Box) (m4aIlstLocation, bool) {
+	if udta, ok := findChildMP4(buf, moov.body(), moov.end(), "udta"); ok {
+		if meta, ok := findChildMP4(buf, udta.body(), udta.end(), "meta"); ok {
+			if ilst, ok := findChildMP4(buf, metaChildrenStart(buf, meta), meta.end(), "ilst"); ok {
+				return m4aIlstLocation{ancestors: []mp4Box{moov, udta, meta}, ilst: ilst}, true
+			}
+		}
+	}
+	if meta, ok := findChildMP4(buf, moov.body(), moov.end(), "meta"); ok {
+		if ilst, ok := findChildMP4(buf, metaChildrenStart(buf, meta), meta.end(), "ilst"); ok {
+			return m4aIlstLocation{ancestors: []mp4Box{moov, meta}, ilst: ilst}, true
+		}
+	}
+	return m4aIlstLocation{}, false
+}
+
+// spliceMP4 replaces buf[start:end] with replacement and adjusts the sizes of
+// the enclosing boxes, whose headers all sit before start.
+func spliceMP4(buf []byte, start, end int64, replacement []byte, ancestors []mp4Box) []byte {
+	delta := int64(len(replacement)) - (end - start)
+	out := make([]byte, 0, int64(len(buf))+delta)
+	out = append(out, buf[:start]...)
+	out = append(out, replacement...)
+	out = append(out, buf[end:]...)
+	for _, b := range ancestors {
+		growBoxSize(out, b, delta)
+	}
+	return out
+}
+
+// rebuildM4AMoovIlst returns a copy of moovBuf whose ilst items have been
+// replaced by edit(items). When create is false and the file has no ilst,
+// errM4ANoIlst is returned.
+func rebuildM4AMoovIlst(moovBuf []byte, create bool, edit func(buf []byte, items []mp4Box) []byte) ([]byte, error) {
+	moov, ok := readMP4Box(moovBuf, 0)
+	if !ok || moov.typ != "moov" {
+		return nil, fmt.Errorf("invalid moov box")
+	}
+
+	loc, found := locateM4AIlst(moovBuf, moov)
+	if !found {
+		if !create {
+			return nil, errM4ANoIlst
+		}
+		items := edit(moovBuf, nil)
+		meta := buildITunesMeta(items, m4aTagPadding)
+		if udta, ok := findChildMP4(moovBuf, moov.body(), moov.end(), "udta"); ok {
+			return spliceMP4(moovBuf, udta.end(), udta.end(), meta, []mp4Box{moov, udta}), nil
+		}
+		return spliceMP4(moovBuf, moov.end(), moov.end(), buildM4AAtom("udta", meta), []mp4Box{moov}), nil
+	}
+
+	var items []mp4Box
+	for pos := loc.ilst.body(); pos+8 <= loc.ilst.end(); {
+		item, ok := readMP4Box(moovBuf, pos)
+		if !ok {
+			return nil, fmt.Errorf("invalid ilst item at %d", pos)
+		}
+		items = append(items, item)
+		pos = item.end()
+	}
+	newIlst := buildM4AAtom("ilst", edit(moovBuf, items))
+
+	// Absorb the size change in a free atom that directly follows ilst.
+	regionEnd := loc.ilst.end()
+	if next, ok := readMP4Box(moovBuf, regionEnd); ok && regionEnd < loc.ancestors[len(loc.ancestors)-1].end() &&
+		(next.typ == "free" || next.typ == "skip") {
+		regionEnd = next.end()
+	}
+	slack := regionEnd - loc.ilst.offset - int64(len(newIlst))
+	replacement := newIlst
+	switch {
+	case slack == 0:
+	case slack >= 8:
+		replacement = append(replacement, buildM4AFreeAtom(int(slack))...)
+	default:
+		replacement = append(replacement, buildM4AFreeAtom(m4aTagPadding)...)
+	}
+	return spliceMP4(moovBuf, loc.ilst.offset, regionEnd, replacement, loc.ancestors), nil
+}
+
+// rewriteM4AIlst rebuilds the ilst of an MP4 file on disk, reusing free space
+// where possible and shifting chunk offsets when mdat has to move.
+func rewriteM4AIlst(filePath string, create bool, edit func(buf []byte, items []mp4Box) []byte) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	var topLevel []atomHeader
+	moovIdx := -1
+	hasMoof := false
+	for pos := int64(0); pos+8 <= fileSize; {
+		header, err := readAtomHeaderAt(f, pos, fileSize)
+		if err != nil {
+			return err
+		}
+		if header.size == 0 {
+			header.size = fileSize - pos
+		}
+		if header.size < header.headerSize || pos+header.size > fileSize {
+			return fmt.Errorf("invalid atom size for %s", header.typ)
+		}
+		switch header.typ {
+		case "moov":
+			if moovIdx < 0 {
+				moovIdx = len(topLevel)
+			}
+		case "moof":
+			hasMoof = true
+		}
+		topLevel = append(topLevel, header)
+		pos += header.size
+	}
+	if moovIdx < 0 {
+		return fmt.Errorf("moov atom not found")
+	}
+	moov := topLevel[moovIdx]
+
+	moovBuf := make([]byte, moov.size)
+	if _, err := f.ReadAt(moovBuf, moov.offset); err != nil {
+		return err
+	}
+	newMoov, err := rebuildM4AMoovIlst(moovBuf, create, edit)
+	if err != nil {
+		return err
+	}
+	moovDelta := int64(len(newMoov)) - moov.size
+
+	// Free space right after moov can absorb growth (or take up shrinkage).
+	var following int64
+	if moovIdx+1 < len(topLevel) {
+		if next := topLevel[moovIdx+1]; next.typ == "free" || next.typ == "skip" {
+			following = next.size
+		}
+	}
+	if slack := following - moovDelta; slack == 0 || slack >= 8 {
+		out := newMoov
+		if slack > 0 {
+			out = append(out, buildM4AFreeAtom(int(slack))...)
+		}
+		f.Close()
+		return writeFileAt(filePath, out, moov.offset)
+	}
+
+	// moov after mdat: nothing references the bytes behind moov, so it can be
+	// rewritten at the end of the file without touching chunk offsets.
+	moovIsLast := true
+	for _, atom := range topLevel[moovIdx+1:] {
+		if atom.typ != "free" && atom.typ != "skip" {
+			moovIsLast = false
+			break
+		}
+	}
+	if moovIsLast {
+		f.Close()
+		out, err := os.OpenFile(filePath, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		if _, err := out.WriteAt(newMoov, moov.offset); err != nil {
+			out.Close()
+			return err
+		}
+		if err := out.Truncate(moov.offset + int64(len(newMoov))); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+
+	if hasMoof {
+		// Fragment offsets are relative to moof, so convert the file to a
+		// progressive one, which has a sample table that can be shifted.
+		f.Close()
+		if err := DefragmentMP4(filePath, filePath); err != nil {
+			return fmt.Errorf("fragmented MP4 has no room to grow moov: %w", err)
+		}
+		return rewriteM4AIlst(filePath, create, edit)
+	}
+
+	newMoovBox, _ := readMP4Box(newMoov, 0)
+	shiftChunkOffsets(newMoov, newMoovBox, moov.offset+moov.size, moovDelta)
+
+	tmpPath := filePath + ".tagtmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(f, 0, moov.offset)); err != nil {
+		return fail(err)
+	}
+	if _, err := out.Write(newMoov); err != nil {
+		return fail(err)
+	}
+	rest := moov.offset + moov.size
+	if _, err := io.Copy(out, io.NewSectionReader(f, rest, fileSize-rest)); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	f.Close()
+
+	return os.Rename(tmpPath, filePath)
+}
+
+func writeFileAt(filePath string, data []byte, offset int64) error {
+	out, err := os.OpenFile(filePath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := out.WriteAt(data, offset); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// m4aItemData returns the payload (after type and locale) of the first data
+// atom of an ilst item.
+func m4aItemData(buf []byte, item mp4Box) []byte {
+	start := item.body()
+	if item.typ == "----" {
+		data, ok := findChildMP4(buf, start, item.end(), "data")
+		if !ok || data.body()+8 > data.end() {
+			return nil
+		}
+		return buf[data.body()+8 : data.end()]
+	}
+	data, ok := readMP4Box(buf, start)
+	if !ok || data.typ != "data" || data.body()+8 > data.end() {
+		return nil
+	}
+	return buf[data.body()+8 : data.end()]
+}
+
+// m4aFreeformName returns the upper-cased name of a "----" item.
+func m4aFreeformName(buf []byte, item mp4Box) string {
+	if item.typ != "----" {
+		return ""
+	}
+	name, ok := findChildMP4(buf, item.body(), item.end(), "name")
+	if !ok || name.body()+4 > name.end() {
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(string(buf[name.body()+4 : name.end()])))
+}
+
+// m4aTextAtomFields maps edit-field keys to the standard ilst atoms they own.
+var m4aTextAtomFields = []struct {
+	field string
+	atom  string
+}{
+	{"title", "\xa9nam"},
+	{"artist", "\xa9ART"},
+	{"album", "\xa9alb"},
+	{"album_artist", "aART"},
+	{"date", "\xa9day"},
+	{"genre", "\xa9gen"},
+	{"composer", "\xa9wrt"},
+	{"copyright", "cprt"},
+	{"comment", "\xa9cmt"},
+	{"lyrics", "\xa9lyr"},
+}
+
+// m4aFreeformFields maps edit-field keys to the freeform names they own. The
+// first name is the one written; the rest are aliases that get removed.
+var m4aFreeformFields = []struct {
+	field string
+	names []string
+}{
+	{"isrc", []string{"ISRC"}},
+	{"label", []string{"LABEL", "ORGANIZATION", "PUBLISHER"}},
+	{"replaygain_track_gain", []string{"replaygain_track_gain"}},
+	{"replaygain_track_peak", []string{"replaygain_track_peak"}},
+	{"replaygain_album_gain", []string{"replaygain_album_gain"}},
+	{"replaygain_album_peak", []string{"replaygain_album_peak"}},
+}
+
+// m4aItemOwner returns the edit-field key that owns an existing ilst item.
+func m4aItemOwner(buf []byte, item mp4Box) string {
+	switch item.typ {
+	case "trkn":
+		return "track"
+	case "disk":
+		return "disc"
+	case "covr":
+		return "cover_path"
+	case "gnre":
+		return "genre"
+	case "cpil":
+		return "compilation"
+	case "rtng":
+		return "content_rating"
+	case "----":
+		name := m4aFreeformName(buf, item)
+		switch name {
+		case "ITUNNORM":
+			return "itunnorm"
+		case "LYRICS", "UNSYNCEDLYRICS":
+			return "lyrics"
+		case "COMMENT":
+			return "comment"
+		case "COMPOSER":
+			return "composer"
+		case "COPYRIGHT":
+			return "copyright"
+		}
+		for _, m := range m4aFreeformFields {
+			for _, alias := range m.names {
+				if strings.EqualFold(alias, name) {
+					return m.field
+				}
+			}
+		}
+		return ""
+	}
+	for _, m := range m4aTextAtomFields {
+		if m.atom == item.typ {
+			return m.field
+		}
+	}
+	return ""
+}
+
+func itunesUint8Tag(atomType string, value byte) []byte {
+	data := make([]byte, 9)
+	binary.BigEndian.PutUint32(data[0:4], 21) // well-known type 21 = signed int
+	data[8] = value
+	return buildM4AAtom(atomType, buildM4AAtom("data", data))
+}
+
+// parseContentRating maps "explicit"/"clean"/"none" (or the raw iTunes
+// numbers 1/2/0) to an rtng value.
+func parseContentRating(value string) (byte, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "0", "none":
+		return 0, false
+	case "1", "4", "explicit":
+		return 1, true
+	case "2", "clean":
+		return 2, true
+	}
+	return 0, false
+}
+
+func parseBoolField(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes":
+		return true
+	}
+	return false
+}
+
+// applyM4AEditFields returns the new ilst body: items owned by an edited field
+// are dropped and rewritten, everything else is copied verbatim.
+func applyM4AEditFields(buf []byte, items []mp4Box, fields map[string]string) []byte {
+	edited := func(key string) bool {
+		_, ok := fields[key]
+		return ok
+	}
+	trackEdited := edited("track_number") || edited("track_total")
+	discEdited := edited("disc_number") || edited("disc_total")
+	normEdited := edited("replaygain_track_gain") || edited("replaygain_track_peak")
+
+	var trackNum, trackTotal, discNum, discTotal int
+	existingFreeform := map[string]string{}
+	var existingCovers [][]byte
+	out := make([]byte, 0, 1024)
+	for _, item := range items {
+		owner := m4aItemOwner(buf, item)
+		switch owner {
+		case "track":
+			if payload := m4aItemData(buf, item); len(payload) >= 6 {
+				trackNum = int(binary.BigEndian.Uint16(payload[2:4]))
+				trackTotal = int(binary.BigEndian.Uint16(payload[4:6]))
+			}
+			if trackEdited {
+				continue
+			}
+		case "disc":
+			if payload := m4aItemData(buf, item); len(payload) >= 6 {
+				discNum = int(binary.BigEndian.Uint16(payload[2:4]))
+				discTotal = int(binary.BigEndian.Uint16(payload[4:6]))
+			}
+			if discEdited {
+				continue
+			}
+		case "itunnorm":
+			if normEdited {
+				continue
+			}
+		case "":
+		default:
+			if item.typ == "covr" {
+				existingCovers = m4aItemValues(buf, item)
+			}
+			if item.typ == "----" {
+				existingFreeform[strings.ToLower(m4aFreeformName(buf, item))] = string(m4aItemData(buf, item))
+			}
+			if edited(owner) {
+				continue
+			}
+		}
+		out = append(out, buf[item.offset:item.end()]...)
+	}
+
+	for _, m := range m4aTextAtomFields {
+		if v := strings.TrimSpace(fields[m.field]); v != "" {
+			out = append(out, itunesTextTag(m.atom, fields[m.field])...)
+		}
+	}
+	if trackEdited {
+		if edited("track_number") {
+			trackNum = parsePositiveInt(fields["track_number"])
+		}
+		if edited("track_total") {
+			trackTotal = parsePositiveInt(fields["track_total"])
+		}
+		if trackNum > 0 {
+			out = append(out, itunesNumberPairTag("trkn", trackNum, trackTotal)...)
+		}
+	}
+	if discEdited {
+		if edited("disc_number") {
+			discNum = parsePositiveInt(fields["disc_number"])
+		}
+		if edited("disc_total") {
+			discTotal = parsePositiveInt(fields["disc_total"])
+		}
+		if discNum > 0 {
+			out = append(out, itunesNumberPairTag("disk", discNum, discTotal)...)
+		}
+	}
+	for _, m := range m4aFreeformFields {
+		if v := strings.TrimSpace(fields[m.field]); v != "" {
+			out = append(out, buildM4AFreeformAtom(m.names[0], v)...)
+		}
+	}
+	if normEdited {
+		value := func(key string) string {
+			if v, ok := fields[key]; ok {
+				return strings.TrimSpace(v)
+			}
+			return existingFreeform[key]
+		}
+		if norm := buildITunNORMTag(value("replaygain_track_gain"), value("replaygain_track_peak")); norm != "" {
+			out = append(out, buildM4AFreeformAtom("iTunNORM", norm)...)
+		}
+	}
+	if parseBoolField(fields["compilation"]) {
+		out = append(out, itunesUint8Tag("cpil", 1)...)
+	}
+	if rating, ok := parseContentRating(fields["content_rating"]); ok {
+		out = append(out, itunesUint8Tag("rtng", rating)...)
+	}
+	if coverData, coverMIME := loadCoverForTag(fields); len(coverData) > 0 {
+		coverData, _, _ = processCover(coverTargetMP4, coverMIME, coverData)
+		// The new cover replaces the first image; any further images, such as
+		// booklet scans, are kept.
+		images := [][]byte{coverData}
+		if len(existingCovers) > 1 {
+			images = append(images, existingCovers[1:]...)
+		}
+		out = append(out, itunesCoverImagesTag(images)...)
+	}
+	return out
+}
+
+// WriteM4ATags writes/merges edit fields into the iTunes ilst of an M4A/MP4
+// file (AAC, ALAC, FLAC-in-MP4 ...). Keys present with an empty value clear
+// the tag; absent keys and unrelated atoms are preserved. cover_path only
+// replaces covr when the image can be read.
+func WriteM4ATags(filePath string, fields map[string]string) error {
+	editFields := fields
+	if _, ok := fields["cover_path"]; ok {
+		if coverData, _ := loadCoverForTag(fields); len(coverData) == 0 {
+			editFields = make(map[string]string, len(fields))
+			for k, v := range fields {
+				if k != "cover_path" {
+					editFields[k] = v
+				}
+			}
+		}
+	}
+	return rewriteM4AIlst(filePath, true, func(buf []byte, items []mp4Box) []byte {
+		return applyM4AEditFields(buf, items, editFields)
+	})
+}
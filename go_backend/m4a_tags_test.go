@@ -0,0 +1,172 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testMdatPayload = []byte("SAMPLE-DATA-0123456789")
+
+// buildTestM4AWithChunks builds ftyp + moov + mdat (or ftyp + mdat + moov when
+// moovLast is set) with a single stco entry pointing at the mdat payload.
+func buildTestM4AWithChunks(ilstPayload []byte, moovLast bool) []byte {
+	ftyp := buildM4AAtom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	buildMoov := func(chunkOffset uint32) []byte {
+		stco := make([]byte, 12)
+		binary.BigEndian.PutUint32(stco[4:8], 1)
+		binary.BigEndian.PutUint32(stco[8:12], chunkOffset)
+		stbl := buildM4AAtom("stbl", buildM4AAtom("stco", stco))
+		trak := buildM4AAtom("trak", buildM4AAtom("mdia", buildM4AAtom("minf", stbl)))
+		var udta []byte
+		if ilstPayload != nil {
+			meta := buildM4AAtom("meta", append([]byte{0, 0, 0, 0}, buildM4AAtom("ilst", ilstPayload)...))
+			udta = buildM4AAtom("udta", meta)
+		}
+		return buildM4AAtom("moov", append(trak, udta...))
+	}
+	mdat := buildM4AAtom("mdat", testMdatPayload)
+
+	if moovLast {
+		out := append(append([]byte{}, ftyp...), mdat...)
+		return append(out, buildMoov(uint32(len(ftyp)+8))...)
+	}
+	moovLen := len(buildMoov(0))
+	out := append(append([]byte{}, ftyp...), buildMoov(uint32(len(ftyp)+moovLen+8))...)
+	return append(out, mdat...)
+}
+
+// assertChunkOffsetValid checks that the stco entry still points at the mdat
+// payload after a rewrite.
+func assertChunkOffsetValid(t *testing.T, data []byte) {
+	t.Helper()
+	moov, ok := findChildMP4(data, 0, int64(len(data)), "moov")
+	if !ok {
+		t.Fatal("moov not found")
+	}
+	stco, ok := findBoxBySignature(data, moov.body(), moov.end(), "stco")
+	if !ok {
+		t.Fatal("stco not found")
+	}
+	offset := int64(binary.BigEndian.Uint32(data[stco.body()+8 : stco.body()+12]))
+	if offset+int64(len(testMdatPayload)) > int64(len(data)) ||
+		!bytes.Equal(data[offset:offset+int64(len(testMdatPayload))], testMdatPayload) {
+		t.Fatalf("stco offset %d no longer points at the mdat payload", offset)
+	}
+}
+
+func TestWriteM4ATagsShiftsChunkOffsetsWhenMoovGrows(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.m4a")
+	ilst := append(buildM4ATextTag("\xa9nam", "Old"), buildM4AIndexTag("trkn", 4, 9)...)
+	ilst = append(ilst, buildM4AFreeformAtom("MusicBrainz Track Id", "keep-me")...)
+	if err := os.WriteFile(path, buildTestM4AWithChunks(ilst, false), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	coverPath := filepath.Join(dir, "cover.jpg")
+	if err := os.WriteFile(coverPath, append([]byte{0xFF, 0xD8, 0xFF}, bytes.Repeat([]byte{1}, 500)...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WriteM4ATags(path, map[string]string{
+		"title":                 "New Title",
+		"artist":                "Artist",
+		"album_artist":          "Various",
+		"disc_number":           "1",
+		"disc_total":            "2",
+		"track_total":           "12",
+		"isrc":                  "USABC1234567",
+		"lyrics":                "[00:01.00]Hello",
+		"compilation":           "true",
+		"content_rating":        "explicit",
+		"replaygain_track_gain": "-6.00 dB",
+		"replaygain_track_peak": "0.9",
+		"cover_path":            coverPath,
+	})
+	if err != nil {
+		t.Fatalf("WriteM4ATags: %v", err)
+	}
+
+	data := mustReadFile(t, path)
+	assertChunkOffsetValid(t, data)
+
+	meta, err := ReadM4ATags(path)
+	if err != nil {
+		t.Fatalf("ReadM4ATags: %v", err)
+	}
+	if meta.Title != "New Title" || meta.Artist != "Artist" || meta.AlbumArtist != "Various" {
+		t.Fatalf("basic tags = %+v", meta)
+	}
+	if meta.TrackNumber != 4 || meta.TotalTracks != 12 || meta.DiscNumber != 1 || meta.TotalDiscs != 2 {
+		t.Fatalf("track/disc = %d/%d %d/%d", meta.TrackNumber, meta.TotalTracks, meta.DiscNumber, meta.TotalDiscs)
+	}
+	if meta.ISRC != "USABC1234567" || meta.Lyrics != "[00:01.00]Hello" || meta.ReplayGainTrackGain != "-6.00 dB" {
+		t.Fatalf("extended tags = %+v", meta)
+	}
+	for _, want := range []string{"keep-me", "iTunNORM", "cpil", "rtng", "covr"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Fatalf("missing %q after rewrite", want)
+		}
+	}
+	if cover, err := extractCoverFromM4A(path); err != nil || len(cover) != 503 {
+		t.Fatalf("extractCoverFromM4A = %d bytes, %v", len(cover), err)
+	}
+
+	// The rewrite reserved padding, so a follow-up edit keeps the file size.
+	sizeBefore := len(data)
+	if err := WriteM4ATags(path, map[string]string{"album": "Album", "lyrics": ""}); err != nil {
+		t.Fatal(err)
+	}
+	data = mustReadFile(t, path)
+	if len(data) != sizeBefore {
+		t.Fatalf("second edit resized file: %d -> %d", sizeBefore, len(data))
+	}
+	assertChunkOffsetValid(t, data)
+	meta, err = ReadM4ATags(path)
+	if err != nil || meta.Album != "Album" || meta.Lyrics != "" || meta.Title != "New Title" {
+		t.Fatalf("ReadM4ATags after second edit = %+v/%v", meta, err)
+	}
+}
+
+func TestWriteM4ATagsMoovAfterMdatAndMissingIlst(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tail.m4a")
+	original := buildTestM4AWithChunks(nil, true)
+	if err := os.WriteFile(path, original, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteM4ATags(path, map[string]string{"title": "Created", "track_number": "3"}); err != nil {
+		t.Fatalf("WriteM4ATags: %v", err)
+	}
+	data := mustReadFile(t, path)
+	assertChunkOffsetValid(t, data)
+	oldMoov, _ := findChildMP4(original, 0, int64(len(original)), "moov")
+	if !bytes.HasPrefix(data, original[:oldMoov.offset]) {
+		t.Fatal("bytes before moov were rewritten")
+	}
+
+	meta, err := ReadM4ATags(path)
+	if err != nil || meta.Title != "Created" || meta.TrackNumber != 3 {
+		t.Fatalf("ReadM4ATags = %+v/%v", meta, err)
+	}
+}
+
+func TestEditFileMetadataUsesNativeM4AWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edit.m4a")
+	if err := os.WriteFile(path, buildTestM4AWithChunks(buildM4ATextTag("\xa9nam", "Old"), false), 0600); err != nil {
+		t.Fatal(err)
+	}
+	response, err := EditFileMetadata(path, `{"title":"Edited","genre":"Jazz"}`)
+	if err != nil || !strings.Contains(response, `"native_m4a"`) {
+		t.Fatalf("EditFileMetadata = %q/%v", response, err)
+	}
+	assertChunkOffsetValid(t, mustReadFile(t, path))
+	meta, err := ReadM4ATags(path)
+	if err != nil || meta.Title != "Edited" || meta.Genre != "Jazz" {
+		t.Fatalf("ReadM4ATags = %+v/%v", meta, err)
+	}
+}
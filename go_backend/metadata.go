@@ -3,6 +3,7 @@ package gobackend
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	stdimage "image"
 	_ "image/gif"
@@ -75,35 +76,6 @@ func detectCoverMIME(coverPath string, coverData []byte) string {
 // metadata block; go-flac silently truncates oversized blocks into a corrupt file.
 const maxFlacPictureBytes = 16 * 1000 * 1000
 
-// fitCoverForFlac returns cover bytes that fit inside a FLAC PICTURE block,
-// re-encoding and downscaling when needed. Returns false if the data cannot be
-// decoded as an image.
-func fitCoverForFlac(coverData []byte) ([]byte, bool) {
-	if len(coverData) <= maxFlacPictureBytes {
-		return coverData, true
-	}
-
-	img, _, err := stdimage.Decode(bytes.NewReader(coverData))
-	if err != nil {
-		return nil, false
-	}
-
-	for _, quality := range []int{90, 80, 70, 60} {
-		if encoded, ok := encodeJPEGUnder(img, quality, maxFlacPictureBytes); ok {
-			return encoded, true
-		}
-	}
-
-	for _, maxDim := range []int{1500, 1200, 1000, 800} {
-		scaled := downscaleImage(img, maxDim)
-		if encoded, ok := encodeJPEGUnder(scaled, 85, maxFlacPictureBytes); ok {
-			return encoded, true
-		}
-	}
-
-	return nil, false
-}
-
 func encodeJPEGUnder(img stdimage.Image, quality, limit int) ([]byte, bool) {
 	var buf bytes.Buffer
 	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
@@ -138,11 +110,18 @@ func downscaleImage(img stdimage.Image, maxDim int) stdimage.Image {
 }
 
 func buildPictureBlock(coverPath string, coverData []byte) (flac.MetaDataBlock, error) {
+	return buildTypedPictureBlock(coverTargetFLAC, coverPath, coverData, pictureTypeFrontCover, "Front Cover")
+}
+
+// buildTypedPictureBlock builds a FLAC picture block of any ID3 picture type
+// for a FLAC or Ogg target, running the image through the cover processing
+// policy, which also shrinks it when it does not fit the block.
+func buildTypedPictureBlock(target, coverPath string, coverData []byte, pictureType int, description string) (flac.MetaDataBlock, error) {
 	if len(coverData) == 0 {
 		return flac.MetaDataBlock{}, fmt.Errorf("empty cover data")
 	}
 
-	fitted, ok := fitCoverForFlac(coverData)
+	fitted, _, ok := processCover(target, "", coverData)
 	if !ok {
 		return flac.MetaDataBlock{}, fmt.Errorf("cover too large for FLAC picture block and could not be resized")
 	}
@@ -150,9 +129,9 @@ func buildPictureBlock(coverPath string, coverData []byte) (flac.MetaDataBlock,
 
 	mime := detectCoverMIME(coverPath, coverData)
 	picture := &flacpicture.MetadataBlockPicture{
-		PictureType: flacpicture.PictureTypeFrontCover,
+		PictureType: flacpicture.PictureType(pictureType),
 		MIME:        mime,
-		Description: "Front Cover",
+		Description: description,
 		ImageData:   coverData,
 	}
 
@@ -239,11 +218,7 @@ func EmbedMetadata(filePath string, metadata Metadata, coverPath string) error {
 			if err != nil {
 				fmt.Printf("[Metadata] Warning: Failed to read cover file %s: %v\n", coverPath, err)
 			} else {
-				for i := len(f.Meta) - 1; i >= 0; i-- {
-					if f.Meta[i].Type == flac.Picture {
-						f.Meta = append(f.Meta[:i], f.Meta[i+1:]...)
-					}
-				}
+				f.Meta = dropFLACPictures(f.Meta, frontCoverPictureTypes)
 
 				picBlock, err := buildPictureBlock(coverPath, coverData)
 				if err != nil {
@@ -295,11 +270,7 @@ func EmbedMetadataWithCoverData(filePath string, metadata Metadata, coverData []
 	}
 
 	if len(coverData) > 0 {
-		for i := len(f.Meta) - 1; i >= 0; i-- {
-			if f.Meta[i].Type == flac.Picture {
-				f.Meta = append(f.Meta[:i], f.Meta[i+1:]...)
-			}
-		}
+		f.Meta = dropFLACPictures(f.Meta, frontCoverPictureTypes)
 
 		picBlock, err := buildPictureBlock("", coverData)
 		if err != nil {
@@ -425,6 +396,33 @@ func EditFlacFields(filePath string, fields map[string]string) error {
 		cmt = flacvorbis.New()
 	}
 
+	applyVorbisEditFields(cmt, fields)
+
+	cmtBlock := cmt.Marshal()
+	if cmtIdx >= 0 {
+		f.Meta[cmtIdx] = &cmtBlock
+	} else {
+		f.Meta = append(f.Meta, &cmtBlock)
+	}
+
+	coverPath := strings.TrimSpace(fields["cover_path"])
+	if coverPath != "" && fileExists(coverPath) {
+		coverData, err := os.ReadFile(coverPath)
+		if err == nil && len(coverData) > 0 {
+			f.Meta = dropFLACPictures(f.Meta, frontCoverPictureTypes)
+			picBlock, err := buildPictureBlock("", coverData)
+			if err == nil {
+				f.Meta = append(f.Meta, &picBlock)
+			}
+		}
+	}
+
+	return f.Save(filePath)
+}
+
+// applyVorbisEditFields applies editor fields to a Vorbis comment list with
+// set-or-clear semantics. Shared by the FLAC and Ogg writers.
+func applyVorbisEditFields(cmt *flacvorbis.MetaDataBlockVorbisComment, fields map[string]string) {
 	artistMode := fields["artist_tag_mode"]
 
 	// Mapping from fields-map key → one or more Vorbis Comment keys.
@@ -528,31 +526,6 @@ func EditFlacFields(filePath string, fields map[string]string) error {
 			removeCommentKey(cmt, "UNSYNCEDLYRICS")
 		}
 	}
-
-	cmtBlock := cmt.Marshal()
-	if cmtIdx >= 0 {
-		f.Meta[cmtIdx] = &cmtBlock
-	} else {
-		f.Meta = append(f.Meta, &cmtBlock)
-	}
-
-	coverPath := strings.TrimSpace(fields["cover_path"])
-	if coverPath != "" && fileExists(coverPath) {
-		coverData, err := os.ReadFile(coverPath)
-		if err == nil && len(coverData) > 0 {
-			for i := len(f.Meta) - 1; i >= 0; i-- {
-				if f.Meta[i].Type == flac.Picture {
-					f.Meta = append(f.Meta[:i], f.Meta[i+1:]...)
-				}
-			}
-			picBlock, err := buildPictureBlock("", coverData)
-			if err == nil {
-				f.Meta = append(f.Meta, &picBlock)
-			}
-		}
-	}
-
-	return f.Save(filePath)
 }
 
 // writeVorbisMetadata writes all metadata fields to a Vorbis Comment block.
@@ -853,7 +826,30 @@ func ExtractCoverArt(filePath string) ([]byte, error) {
 	return nil, fmt.Errorf("no cover art found in file")
 }
 
+// EmbedLyrics writes lyrics into the container's own lyrics tag: LYRICS and
+// UNSYNCEDLYRICS comments for FLAC and Ogg, USLT plus a time-coded SYLT frame
+// for MP3, WAV and AIFF, and ©lyr for MP4/M4A. Empty lyrics are a no-op.
 func EmbedLyrics(filePath string, lyrics string) error {
+	if lyrics == "" {
+		return nil
+	}
+
+	lower := strings.ToLower(filePath)
+	fields := map[string]string{"lyrics": lyrics}
+	switch {
+	case strings.HasSuffix(lower, ".flac"):
+	case strings.HasSuffix(lower, ".mp3"):
+		return WriteMP3Tags(filePath, fields)
+	case strings.HasSuffix(lower, ".wav"):
+		return WriteWAVTags(filePath, fields)
+	case strings.HasSuffix(lower, ".aiff") || strings.HasSuffix(lower, ".aif") || strings.HasSuffix(lower, ".aifc"):
+		return WriteAIFFTags(filePath, fields)
+	case isOggFile(filePath):
+		return WriteOggTags(filePath, fields)
+	case isM4AExtension(lower) || isMP4ContainerFile(filePath):
+		return WriteM4ATags(filePath, fields)
+	}
+
 	f, err := flac.ParseFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to parse FLAC file: %w", err)
@@ -946,7 +942,7 @@ func ExtractLyrics(filePath string) (string, error) {
 		return extractLyricsFromSidecarLRC(filePath)
 	}
 
-	if strings.HasSuffix(lower, ".m4a") || strings.HasSuffix(lower, ".mp4") || strings.HasSuffix(lower, ".aac") {
+	if isM4AExtension(lower) || strings.HasSuffix(lower, ".aac") {
 		lyrics, err := extractLyricsFromM4A(filePath)
 		if err == nil && strings.TrimSpace(lyrics) != "" {
 			return lyrics, nil
@@ -967,7 +963,7 @@ func ExtractLyrics(filePath string) (string, error) {
 		return extractLyricsFromSidecarLRC(filePath)
 	}
 
-	if strings.HasSuffix(lower, ".opus") || strings.HasSuffix(lower, ".ogg") {
+	if isOggFile(filePath) {
 		meta, err := ReadOggVorbisComments(filePath)
 		if err == nil && meta != nil {
 			if strings.TrimSpace(meta.Lyrics) != "" {
@@ -1006,9 +1002,20 @@ func ExtractLyrics(filePath string) (string, error) {
 		return extractLyricsFromSidecarLRC(filePath)
 	}
 
+	if isMP4ContainerFile(filePath) {
+		if lyrics, err := extractLyricsFromM4A(filePath); err == nil {
+			return lyrics, nil
+		}
+	}
+
 	return extractLyricsFromSidecarLRC(filePath)
 }
 
+// isM4AExtension reports whether a lower-cased path has an MP4 audio extension.
+func isM4AExtension(lower string) bool {
+	return strings.HasSuffix(lower, ".m4a") || strings.HasSuffix(lower, ".mp4") || strings.HasSuffix(lower, ".m4b")
+}
+
 func ReadM4ATags(filePath string) (*AudioMetadata, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
@@ -1554,104 +1561,41 @@ type m4aFreeformTag struct {
 	value string
 }
 
-// writeM4AFreeformTags rewrites the ilst atom in place: it drops every existing
+// writeM4AFreeformTags rewrites the ilst atom: it drops every existing
 // freeform ("----") atom whose uppercased name is in `remove`, then appends the
 // supplied tags (empty values are skipped, which effectively clears the field).
-// Atom sizes are fixed up along the ilst -> meta -> udta -> moov chain.
+// Free space and chunk offsets are handled by rewriteM4AIlst.
 //
 // FFmpeg's MP4 muxer only writes a fixed set of recognized keys to the ilst, so
 // fields like ISRC and LABEL are silently dropped when written via -metadata.
 // Writing them as iTunes freeform atoms natively is the only way they persist.
 func writeM4AFreeformTags(filePath string, remove map[string]struct{}, tags []m4aFreeformTag) error {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	info, err := f.Stat()
-	if err != nil {
-		return err
-	}
-
-	path, err := findM4AMetadataPath(f, info.Size())
-	if err != nil {
-		// MOV-style containers (e.g. AC-4 passthrough) store tags as QuickTime
-		// atoms under udta with no iTunes meta>ilst structure. There is nowhere
-		// to write freeform tags, so skip gracefully instead of failing.
-		if strings.Contains(err.Error(), "ilst not found") {
-			GoLog("[Metadata] No iTunes ilst container; skipping freeform tags")
-			return nil
-		}
-		return err
-	}
-
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return err
-	}
-
-	bodyStart := path.ilst.offset + path.ilst.headerSize
-	bodyEnd := path.ilst.offset + path.ilst.size
-	newBody := make([]byte, 0, int(path.ilst.size))
-
-	for pos := bodyStart; pos+8 <= bodyEnd; {
-		header, readErr := readAtomHeaderAt(f, pos, info.Size())
-		if readErr != nil {
-			return readErr
-		}
-		if header.size == 0 {
-			header.size = bodyEnd - pos
-		}
-		if header.size < header.headerSize {
-			return fmt.Errorf("invalid atom size for %s", header.typ)
-		}
-
-		keep := true
-		if header.typ == "----" {
-			name, _, freeformErr := readM4AFreeformValue(f, header, info.Size())
-			if freeformErr == nil {
-				if _, ok := remove[strings.ToUpper(strings.TrimSpace(name))]; ok {
-					keep = false
+	err := rewriteM4AIlst(filePath, false, func(buf []byte, items []mp4Box) []byte {
+		newBody := make([]byte, 0, 1024)
+		for _, item := range items {
+			if item.typ == "----" {
+				if _, ok := remove[m4aFreeformName(buf, item)]; ok {
+					continue
 				}
 			}
+			newBody = append(newBody, buf[item.offset:item.end()]...)
 		}
-		if keep {
-			newBody = append(newBody, data[pos:pos+header.size]...)
-		}
-
-		pos += header.size
-	}
-
-	for _, tag := range tags {
-		if strings.TrimSpace(tag.value) == "" {
-			continue
-		}
-		newBody = append(newBody, buildM4AFreeformAtom(tag.name, tag.value)...)
-	}
-
-	newIlst := buildM4AAtom("ilst", newBody)
-	updated := append([]byte{}, data[:path.ilst.offset]...)
-	updated = append(updated, newIlst...)
-	updated = append(updated, data[path.ilst.offset+path.ilst.size:]...)
-
-	delta := int64(len(newIlst)) - path.ilst.size
-	if err := writeAtomSize(updated, path.ilst, path.ilst.size+delta); err != nil {
-		return err
-	}
-	if err := writeAtomSize(updated, path.meta, path.meta.size+delta); err != nil {
-		return err
-	}
-	if path.udta != nil {
-		if err := writeAtomSize(updated, *path.udta, path.udta.size+delta); err != nil {
-			return err
+		for _, tag := range tags {
+			if strings.TrimSpace(tag.value) == "" {
+				continue
+			}
+			newBody = append(newBody, buildM4AFreeformAtom(tag.name, tag.value)...)
 		}
+		return newBody
+	})
+	if errors.Is(err, errM4ANoIlst) {
+		// MOV-style containers (e.g. AC-4 passthrough) store tags as QuickTime
+		// atoms under udta with no iTunes meta>ilst structure. There is nowhere
+		// to write freeform tags, so skip gracefully instead of failing.
+		GoLog("[Metadata] No iTunes ilst container; skipping freeform tags")
+		return nil
 	}
-	if err := writeAtomSize(updated, path.moov, path.moov.size+delta); err != nil {
-		return err
-	}
-
-	return os.WriteFile(filePath, updated, 0o644)
+	return err
 }
 
 // EditM4AFreeformText writes ISRC and label tags into an M4A/MP4 file as iTunes
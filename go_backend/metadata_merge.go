@@ -0,0 +1,325 @@
+package gobackend
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Merge sources that are not provider IDs.
+const (
+	metadataSourceRequest     = "request"
+	metadataSourceOrigin      = "source"
+	metadataSourceDeezer      = "deezer"
+	metadataSourceMusicBrainz = "musicbrainz"
+	metadataSourceAny         = "*"
+	// metadataSourceBestCover picks the best scoring cover among every
+	// candidate: resolution counts, but non-square images are discounted by
+	// their aspect ratio. Only meaningful for cover_url.
+	metadataSourceBestCover = "highest_resolution"
+)
+
+// MetadataMergePolicy gives fields their own ordered source lists, e.g.
+// {"genre": ["musicbrainz", "deezer"], "cover_url": ["highest_resolution"]}.
+// A source is an extension ID, "source" for the track's origin extension,
+// "request" for the metadata the caller sent, "deezer", "musicbrainz" or "*"
+// for any candidate. Fields without a list keep the provider-priority result.
+type MetadataMergePolicy struct {
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+var (
+	metadataMergePolicy   MetadataMergePolicy
+	metadataMergePolicyMu sync.RWMutex
+)
+
+func SetMetadataMergePolicy(policy MetadataMergePolicy) {
+	fields := make(map[string][]string, len(policy.Fields))
+	for field, sources := range policy.Fields {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if _, ok := trackMergeFields(&ExtTrackMetadata{})[field]; !ok {
+			GoLog("[MetadataMerge] Ignoring unknown field: %s\n", field)
+			continue
+		}
+		cleaned := make([]string, 0, len(sources))
+		for _, source := range sources {
+			if source = strings.TrimSpace(source); source != "" {
+				cleaned = append(cleaned, source)
+			}
+		}
+		if len(cleaned) > 0 {
+			fields[field] = cleaned
+		}
+	}
+
+	metadataMergePolicyMu.Lock()
+	metadataMergePolicy = MetadataMergePolicy{Fields: fields}
+	metadataMergePolicyMu.Unlock()
+	GoLog("[MetadataMerge] Policy set for %d fields\n", len(fields))
+}
+
+func GetMetadataMergePolicy() MetadataMergePolicy {
+	metadataMergePolicyMu.RLock()
+	defer metadataMergePolicyMu.RUnlock()
+	return metadataMergePolicy
+}
+
+// trackMergeFields exposes the mergeable fields of a track by policy name.
+// The target structs below use the same names so values copy across.
+func trackMergeFields(t *ExtTrackMetadata) map[string]any {
+	return map[string]any{
+		"title":        &t.Name,
+		"artist":       &t.Artists,
+		"album":        &t.AlbumName,
+		"album_artist": &t.AlbumArtist,
+		"release_date": &t.ReleaseDate,
+		"track_number": &t.TrackNumber,
+		"total_tracks": &t.TotalTracks,
+		"disc_number":  &t.DiscNumber,
+		"total_discs":  &t.TotalDiscs,
+		"isrc":         &t.ISRC,
+		"cover_url":    &t.CoverURL,
+		"genre":        &t.Genre,
+		"label":        &t.Label,
+		"copyright":    &t.Copyright,
+		"composer":     &t.Composer,
+	}
+}
+
+func downloadRequestMergeFields(req *DownloadRequest) map[string]any {
+	return map[string]any{
+		"title":        &req.TrackName,
+		"artist":       &req.ArtistName,
+		"album":        &req.AlbumName,
+		"album_artist": &req.AlbumArtist,
+		"release_date": &req.ReleaseDate,
+		"track_number": &req.TrackNumber,
+		"total_tracks": &req.TotalTracks,
+		"disc_number":  &req.DiscNumber,
+		"total_discs":  &req.TotalDiscs,
+		"isrc":         &req.ISRC,
+		"cover_url":    &req.CoverURL,
+		"genre":        &req.Genre,
+		"label":        &req.Label,
+		"copyright":    &req.Copyright,
+		"composer":     &req.Composer,
+	}
+}
+
+func reEnrichRequestMergeFields(req *reEnrichRequest) map[string]any {
+	return map[string]any{
+		"title":        &req.TrackName,
+		"artist":       &req.ArtistName,
+		"album":        &req.AlbumName,
+		"album_artist": &req.AlbumArtist,
+		"release_date": &req.ReleaseDate,
+		"track_number": &req.TrackNumber,
+		"total_tracks": &req.TotalTracks,
+		"disc_number":  &req.DiscNumber,
+		"total_discs":  &req.TotalDiscs,
+		"isrc":         &req.ISRC,
+		"cover_url":    &req.CoverURL,
+		"genre":        &req.Genre,
+		"label":        &req.Label,
+		"copyright":    &req.Copyright,
+		"composer":     &req.Composer,
+	}
+}
+
+// snapshotMergeFields copies target values into a track so the caller's
+// original metadata can compete as the "request" candidate.
+func snapshotMergeFields(target map[string]any) ExtTrackMetadata {
+	var track ExtTrackMetadata
+	fields := trackMergeFields(&track)
+	for name, ptr := range target {
+		copyMergeValue(fields[name], ptr)
+	}
+	return track
+}
+
+func mergeValueSet(ptr any) bool {
+	switch v := ptr.(type) {
+	case *string:
+		return strings.TrimSpace(*v) != ""
+	case *int:
+		return *v > 0
+	}
+	return false
+}
+
+func copyMergeValue(dst, src any) {
+	switch d := dst.(type) {
+	case *string:
+		if s, ok := src.(*string); ok {
+			*d = strings.TrimSpace(*s)
+		}
+	case *int:
+		if s, ok := src.(*int); ok {
+			*d = *s
+		}
+	}
+}
+
+type metadataCandidate struct {
+	source string
+	track  ExtTrackMetadata
+}
+
+// metadataMerger collects what each provider returned during one download
+// or re-enrichment and resolves the policy over it at the end. A nil merger
+// means no policy is configured and every method is a no-op.
+type metadataMerger struct {
+	policy     MetadataMergePolicy
+	origin     string
+	candidates []metadataCandidate
+}
+
+func newMetadataMerger(origin string) *metadataMerger {
+	policy := GetMetadataMergePolicy()
+	if len(policy.Fields) == 0 {
+		return nil
+	}
+	return &metadataMerger{policy: policy, origin: strings.TrimSpace(origin)}
+}
+
+func (m *metadataMerger) add(source string, track ExtTrackMetadata) {
+	if m == nil {
+		return
+	}
+	if source = strings.TrimSpace(source); source == "" {
+		source = m.origin
+	}
+	m.candidates = append(m.candidates, metadataCandidate{source: source, track: track})
+}
+
+func (m *metadataMerger) wants(source string) bool {
+	if m == nil {
+		return false
+	}
+	for _, sources := range m.policy.Fields {
+		for _, s := range sources {
+			if strings.EqualFold(s, source) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fetchISRCSources queries Deezer and MusicBrainz when the policy names them.
+// Unlike enrichExtraMetadataByISRC it fetches even when the fields are
+// already filled, since the policy may prefer these sources outright.
+func (m *metadataMerger) fetchISRCSources(logPrefix, isrc, albumName string) {
+	isrc = strings.TrimSpace(isrc)
+	if m == nil || isrc == "" {
+		return
+	}
+
+	if m.wants(metadataSourceDeezer) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		extMeta, err := fetchDeezerExtendedMetadataByISRC(ctx, isrc)
+		cancel()
+		if err != nil {
+			GoLog("[%s] Merge: failed to get Deezer metadata: %v\n", logPrefix, err)
+		} else if extMeta != nil {
+			m.add(metadataSourceDeezer, ExtTrackMetadata{Genre: extMeta.Genre, Label: extMeta.Label, Copyright: extMeta.Copyright})
+		}
+	}
+
+	if m.wants(metadataSourceMusicBrainz) {
+		var track ExtTrackMetadata
+		if genre, err := fetchMusicBrainzGenreByISRC(isrc); err != nil {
+			GoLog("[%s] Merge: failed to get MusicBrainz genre: %v\n", logPrefix, err)
+		} else {
+			track.Genre = genre
+		}
+		if albumArtist, err := fetchMusicBrainzAlbumArtistByISRC(isrc, albumName); err != nil {
+			GoLog("[%s] Merge: failed to get MusicBrainz album artist: %v\n", logPrefix, err)
+		} else {
+			track.AlbumArtist = strings.TrimSpace(albumArtist)
+		}
+		m.add(metadataSourceMusicBrainz, track)
+	}
+}
+
+// candidatesFor resolves a source name to the candidates it covers, in the
+// order they were added.
+func (m *metadataMerger) candidatesFor(source string) []metadataCandidate {
+	switch {
+	case source == metadataSourceAny:
+		return m.candidates
+	case strings.EqualFold(source, metadataSourceOrigin):
+		var matched []metadataCandidate
+		if m.origin != "" {
+			matched = m.candidatesNamed(m.origin)
+		}
+		return append(matched, m.candidatesNamed(metadataSourceRequest)...)
+	}
+	return m.candidatesNamed(source)
+}
+
+func (m *metadataMerger) candidatesNamed(source string) []metadataCandidate {
+	var matched []metadataCandidate
+	for _, candidate := range m.candidates {
+		if strings.EqualFold(candidate.source, source) {
+			matched = append(matched, candidate)
+		}
+	}
+	return matched
+}
+
+// apply writes the policy's choice for each configured field into target
+// and returns the source that supplied it. Fields where no listed source
+// has a value keep what target already holds.
+func (m *metadataMerger) apply(logPrefix string, target map[string]any) map[string]string {
+	if m == nil {
+		return nil
+	}
+	supplied := make(map[string]string)
+	for field, sources := range m.policy.Fields {
+		dst, ok := target[field]
+		if !ok {
+			continue
+		}
+		for _, source := range sources {
+			if field == "cover_url" && strings.EqualFold(source, metadataSourceBestCover) {
+				if url, from := m.bestCover(logPrefix); url != "" {
+					*dst.(*string) = url
+					supplied[field] = from
+					break
+				}
+				continue
+			}
+			found := false
+			for _, candidate := range m.candidatesFor(source) {
+				src := trackMergeFields(&candidate.track)[field]
+				if mergeValueSet(src) {
+					copyMergeValue(dst, src)
+					supplied[field] = candidate.source
+					found = true
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+	}
+	if len(supplied) > 0 {
+		GoLog("[%s] Merged metadata sources: %v\n", logPrefix, supplied)
+	}
+	return supplied
+}
+
+func (m *metadataMerger) bestCover(logPrefix string) (string, string) {
+	resolver := newCoverResolver(false)
+	for _, candidate := range m.candidates {
+		resolver.add(candidate.source, candidate.track.CoverURL)
+	}
+	best, ok := resolver.best(logPrefix)
+	if !ok {
+		return "", ""
+	}
+	return best.URL, best.Source
+}
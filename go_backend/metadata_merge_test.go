@@ -0,0 +1,143 @@
+package gobackend
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func withMetadataMergePolicy(t *testing.T, policyJSON string) {
+	t.Helper()
+	original := GetMetadataMergePolicy()
+	t.Cleanup(func() { SetMetadataMergePolicy(original) })
+	if err := SetMetadataMergePolicyJSON(policyJSON); err != nil {
+		t.Fatalf("SetMetadataMergePolicyJSON: %v", err)
+	}
+}
+
+func TestSetMetadataMergePolicyDropsUnknownFields(t *testing.T) {
+	withMetadataMergePolicy(t, `{"fields":{" Genre ":["musicbrainz"," "],"mood":["deezer"],"label":[]}}`)
+
+	got := GetMetadataMergePolicy().Fields
+	want := map[string][]string{"genre": {"musicbrainz"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("policy fields = %v, want %v", got, want)
+	}
+}
+
+func TestNewMetadataMergerNilWithoutPolicy(t *testing.T) {
+	withMetadataMergePolicy(t, ``)
+
+	merger := newMetadataMerger("ext-src")
+	if merger != nil {
+		t.Fatal("expected nil merger without a policy")
+	}
+	merger.add("ext-src", ExtTrackMetadata{Name: "x"})
+	if got := merger.apply("test", downloadRequestMergeFields(&DownloadRequest{})); got != nil {
+		t.Fatalf("nil merger should not report sources, got %v", got)
+	}
+}
+
+func TestMetadataMergerAppliesPerFieldSources(t *testing.T) {
+	withMetadataMergePolicy(t, `{"fields":{
+		"title":["source"],
+		"genre":["musicbrainz","deezer"],
+		"label":["deezer"],
+		"album":["missing-ext","*"],
+		"track_number":["meta-ext"]
+	}}`)
+
+	req := DownloadRequest{TrackName: "Original Title", AlbumName: "", Genre: "Rock", Source: "ext-src"}
+	merger := newMetadataMerger(req.Source)
+	merger.add(metadataSourceRequest, snapshotMergeFields(downloadRequestMergeFields(&req)))
+
+	// Simulate the existing enrichment overwriting the request.
+	req.TrackName = "Enriched Title"
+	merger.add("meta-ext", ExtTrackMetadata{Name: "Search Title", AlbumName: "Search Album", TrackNumber: 4})
+	merger.add(metadataSourceDeezer, ExtTrackMetadata{Genre: "Pop", Label: "Deezer Label"})
+	merger.add(metadataSourceMusicBrainz, ExtTrackMetadata{Genre: ""})
+
+	sources := merger.apply("test", downloadRequestMergeFields(&req))
+
+	if req.TrackName != "Original Title" || req.Genre != "Pop" || req.Label != "Deezer Label" || req.AlbumName != "Search Album" || req.TrackNumber != 4 {
+		t.Fatalf("unexpected merged request: %+v", req)
+	}
+	want := map[string]string{
+		"title":        "request",
+		"genre":        "deezer",
+		"label":        "deezer",
+		"album":        "meta-ext",
+		"track_number": "meta-ext",
+	}
+	if !reflect.DeepEqual(sources, want) {
+		t.Fatalf("sources = %v, want %v", sources, want)
+	}
+}
+
+func TestMetadataMergerSourcePrefersOriginExtension(t *testing.T) {
+	withMetadataMergePolicy(t, `{"fields":{"title":["source"]}}`)
+
+	merger := newMetadataMerger("ext-src")
+	merger.add(metadataSourceRequest, ExtTrackMetadata{Name: "Request"})
+	merger.add("ext-src", ExtTrackMetadata{Name: "Origin"})
+
+	var req reEnrichRequest
+	sources := merger.apply("test", reEnrichRequestMergeFields(&req))
+	if req.TrackName != "Origin" || sources["title"] != "ext-src" {
+		t.Fatalf("expected origin extension title, got %q from %v", req.TrackName, sources)
+	}
+}
+
+func TestMetadataMergerPicksHighestResolutionCover(t *testing.T) {
+	withMetadataMergePolicy(t, `{"fields":{"cover_url":["highest_resolution"]}}`)
+
+	origProbe := probeCoverResolution
+	defer func() { probeCoverResolution = origProbe }()
+	sizes := map[string]int{"https://a/cover.jpg": 640, "https://b/cover.jpg": 3000}
+	probeCoverResolution = func(url string) (int, int, error) {
+		size, ok := sizes[url]
+		if !ok {
+			return 0, 0, fmt.Errorf("unreachable")
+		}
+		return size, size, nil
+	}
+
+	merger := newMetadataMerger("")
+	merger.add("ext-a", ExtTrackMetadata{CoverURL: "https://a/cover.jpg"})
+	merger.add("ext-c", ExtTrackMetadata{CoverURL: "https://c/cover.jpg"})
+	merger.add("ext-b", ExtTrackMetadata{CoverURL: "https://b/cover.jpg"})
+
+	req := DownloadRequest{CoverURL: "https://a/cover.jpg"}
+	sources := merger.apply("test", downloadRequestMergeFields(&req))
+	if req.CoverURL != "https://b/cover.jpg" || sources["cover_url"] != "ext-b" {
+		t.Fatalf("expected largest cover, got %q from %v", req.CoverURL, sources)
+	}
+}
+
+func TestMetadataMergerFetchesNamedISRCSources(t *testing.T) {
+	withMetadataMergePolicy(t, `{"fields":{"label":["deezer"]}}`)
+
+	origDeezer := fetchDeezerExtendedMetadataByISRC
+	origGenre := fetchMusicBrainzGenreByISRC
+	defer func() {
+		fetchDeezerExtendedMetadataByISRC = origDeezer
+		fetchMusicBrainzGenreByISRC = origGenre
+	}()
+	fetchDeezerExtendedMetadataByISRC = func(ctx context.Context, isrc string) (*AlbumExtendedMetadata, error) {
+		return &AlbumExtendedMetadata{Label: "Deezer Label"}, nil
+	}
+	fetchMusicBrainzGenreByISRC = func(isrc string) (string, error) {
+		t.Fatal("MusicBrainz is not named by the policy and should not be queried")
+		return "", nil
+	}
+
+	merger := newMetadataMerger("")
+	merger.fetchISRCSources("test", "USRC17607839", "")
+
+	req := DownloadRequest{Label: "Existing"}
+	merger.apply("test", downloadRequestMergeFields(&req))
+	if req.Label != "Deezer Label" {
+		t.Fatalf("expected Deezer label to override, got %q", req.Label)
+	}
+}
@@ -0,0 +1,748 @@
+package gobackend
+
+// Native ID3v2.4 tag writing for MP3 files.
+//
+// Existing tags (v2.2, v2.3 or v2.4) are parsed into a flat frame list, the
+// edited fields replace only the frames they own, and every other frame
+// (TXXX, PRIV, GEOB, UFID, ...) is carried over verbatim. The result is
+// always written as ID3v2.4 with UTF-8 text, which the existing reader parses
+// losslessly. When the new tag fits inside the old tag's footprint (including
+// its padding) it is overwritten in place; otherwise the file is rewritten with
+// fresh padding so the next edit can be done in place.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// mp3TagPadding is the padding reserved after the frames whenever the file has
+// to be rewritten, so that follow-up edits usually fit in place.
+const mp3TagPadding = 4096
+
+// id3Frame is a single ID3v2.4 frame with its payload fully decoded: no
+// unsynchronisation, grouping byte or data-length indicator. status holds the
+// v2.4 frame status flags; format flags are always written as zero.
+type id3Frame struct {
+	id     string
+	status byte
+	data   []byte
+}
+
+// id3v22FrameIDs maps ID3v2.2 three-character frame IDs to their v2.4
+// equivalents. Frames missing from this table are dropped on conversion.
+var id3v22FrameIDs = map[string]string{
+	"TT1": "TIT1", "TT2": "TIT2", "TT3": "TIT3",
+	"TP1": "TPE1", "TP2": "TPE2", "TP3": "TPE3", "TP4": "TPE4",
+	"TAL": "TALB", "TYE": "TDRC", "TCO": "TCON", "TRK": "TRCK",
+	"TPA": "TPOS", "TCM": "TCOM", "TPB": "TPUB", "TCR": "TCOP",
+	"TRC": "TSRC", "TEN": "TENC", "TSS": "TSSE", "TBP": "TBPM",
+	"TOA": "TOPE", "TOT": "TOAL", "TOL": "TOLY", "TOR": "TDOR",
+	"TLA": "TLAN", "TMT": "TMED", "TKE": "TKEY", "TLE": "TLEN",
+	"TCP": "TCMP", "TXT": "TEXT", "TXX": "TXXX", "COM": "COMM",
+	"ULT": "USLT", "SLT": "SYLT", "PIC": "APIC", "GEO": "GEOB",
+	"UFI": "UFID", "WXX": "WXXX", "CNT": "PCNT", "POP": "POPM",
+}
+
+// readID3v2TagRegion returns the raw leading ID3v2 tag (header included) and
+// the number of bytes it occupies at the start of the file, footer included.
+// A file without a tag yields (nil, 0, nil).
+func readID3v2TagRegion(f *os.File) ([]byte, int64, error) {
+	header := make([]byte, 10)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		if err == io.EOF {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	if string(header[0:3]) != "ID3" {
+		return nil, 0, nil
+	}
+
+	size := int64(synchsafeDecode(header[6:10]))
+	region := 10 + size
+	if header[3] == 4 && header[5]&0x10 != 0 {
+		region += 10
+	}
+
+	tag := make([]byte, 10+size)
+	if _, err := f.ReadAt(tag, 0); err != nil {
+		return nil, 0, fmt.Errorf("truncated ID3v2 tag: %w", err)
+	}
+	return tag, region, nil
+}
+
+func isValidID3FrameID(id string) bool {
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return len(id) > 0
+}
+
+// parseID3v2FramesForRewrite decodes a complete ID3v2 tag (header included)
+// into v2.4 frames. Compressed and encrypted frames are dropped because they
+// cannot be re-encoded faithfully; everything else is preserved.
+func parseID3v2FramesForRewrite(tag []byte) ([]id3Frame, error) {
+	if len(tag) < 10 || string(tag[0:3]) != "ID3" {
+		return nil, fmt.Errorf("no ID3v2 header")
+	}
+	version := tag[3]
+	flags := tag[5]
+	tagUnsync := flags&0x80 != 0
+
+	size := synchsafeDecode(tag[6:10])
+	if size <= 0 || 10+size > len(tag) {
+		size = len(tag) - 10
+	}
+	data := tag[10 : 10+size]
+	if version < 4 && tagUnsync {
+		data = removeUnsync(data)
+	}
+	if flags&0x40 != 0 {
+		if skip := extendedHeaderSize(data, version); skip > 0 && skip < len(data) {
+			data = data[skip:]
+		}
+	}
+
+	var frames []id3Frame
+	switch version {
+	case 2:
+		frames = parseID3v22FramesForRewrite(data)
+	case 3, 4:
+		frames = parseID3v23FramesForRewrite(data, version, tagUnsync)
+	default:
+		return nil, fmt.Errorf("unsupported ID3v2 version: 2.%d", version)
+	}
+	if version < 4 {
+		frames = upgradeID3v23DateFrames(frames)
+	}
+	return frames, nil
+}
+
+func parseID3v22FramesForRewrite(data []byte) []id3Frame {
+	var frames []id3Frame
+	pos := 0
+	for pos+6 <= len(data) {
+		id := string(data[pos : pos+3])
+		if data[pos] == 0 || !isValidID3FrameID(id) {
+			break
+		}
+		size := int(data[pos+3])<<16 | int(data[pos+4])<<8 | int(data[pos+5])
+		if size <= 0 {
+			// An empty frame carries nothing; skip its header so the frames
+			// after it survive the rewrite.
+			pos += 6
+			continue
+		}
+		if pos+6+size > len(data) {
+			break
+		}
+		payload := data[pos+6 : pos+6+size]
+		pos += 6 + size
+
+		newID, ok := id3v22FrameIDs[id]
+		if !ok {
+			continue
+		}
+		if newID == "APIC" {
+			payload = convertID3v22Picture(payload)
+			if payload == nil {
+				continue
+			}
+		}
+		frames = append(frames, id3Frame{id: newID, data: append([]byte{}, payload...)})
+	}
+	return frames
+}
+
+// convertID3v22Picture rewrites a v2.2 PIC payload (3-byte image format) as a
+// v2.4 APIC payload (null-terminated MIME type).
+func convertID3v22Picture(payload []byte) []byte {
+	if len(payload) < 5 {
+		return nil
+	}
+	mime := "image/jpeg"
+	if strings.EqualFold(string(payload[1:4]), "PNG") {
+		mime = "image/png"
+	}
+	out := []byte{payload[0]}
+	out = append(out, mime...)
+	out = append(out, 0)
+	return append(out, payload[4:]...)
+}
+
+func parseID3v23FramesForRewrite(data []byte, version byte, tagUnsync bool) []id3Frame {
+	var frames []id3Frame
+	pos := 0
+	for pos+10 <= len(data) {
+		id := string(data[pos : pos+4])
+		if data[pos] == 0 || !isValidID3FrameID(id) {
+			break
+		}
+		var size int
+		if version == 4 {
+			size = synchsafeDecode(data[pos+4 : pos+8])
+		} else {
+			size = int(data[pos+4])<<24 | int(data[pos+5])<<16 | int(data[pos+6])<<8 | int(data[pos+7])
+		}
+		if size <= 0 {
+			// An empty frame carries nothing; skip its header so the frames
+			// after it survive the rewrite.
+			pos += 10
+			continue
+		}
+		if pos+10+size > len(data) {
+			break
+		}
+		statusFlags := data[pos+8]
+		formatFlags := data[pos+9]
+		payload := data[pos+10 : pos+10+size]
+		pos += 10 + size
+
+		var status byte
+		if version == 3 {
+			if formatFlags&0xC0 != 0 { // compression / encryption
+				continue
+			}
+			if formatFlags&0x20 != 0 { // grouping identity
+				if len(payload) < 1 {
+					continue
+				}
+				payload = payload[1:]
+			}
+			// v2.3 status flags sit one bit higher than their v2.4 counterparts.
+			status = (statusFlags >> 1) & 0x70
+		} else {
+			if formatFlags&0x0C != 0 { // compression / encryption
+				continue
+			}
+			if formatFlags&0x40 != 0 {
+				if len(payload) < 1 {
+					continue
+				}
+				payload = payload[1:]
+			}
+			if formatFlags&0x01 != 0 {
+				if len(payload) < 4 {
+					continue
+				}
+				payload = payload[4:]
+			}
+			if formatFlags&0x02 != 0 || tagUnsync {
+				payload = removeUnsync(payload)
+			}
+			status = statusFlags & 0x70
+		}
+
+		frames = append(frames, id3Frame{id: id, status: status, data: append([]byte{}, payload...)})
+	}
+	return frames
+}
+
+// upgradeID3v23DateFrames folds the v2.3-only TYER/TDAT/TIME/TORY frames into
+// their v2.4 timestamp equivalents (TDRC/TDOR).
+func upgradeID3v23DateFrames(frames []id3Frame) []id3Frame {
+	var year, dayMonth, origYear string
+	hasTDRC := false
+	for _, fr := range frames {
+		switch fr.id {
+		case "TYER":
+			year = firstTextValue(extractTextFrame(fr.data))
+		case "TDAT":
+			dayMonth = firstTextValue(extractTextFrame(fr.data))
+		case "TORY":
+			origYear = firstTextValue(extractTextFrame(fr.data))
+		case "TDRC":
+			hasTDRC = true
+		}
+	}
+
+	out := make([]id3Frame, 0, len(frames))
+	for _, fr := range frames {
+		switch fr.id {
+		case "TDAT", "TIME", "TRDA", "TSIZ":
+			continue
+		case "TYER":
+			if hasTDRC || strings.TrimSpace(year) == "" {
+				continue
+			}
+			date := strings.TrimSpace(year)
+			if len(dayMonth) == 4 && len(date) == 4 {
+				date = fmt.Sprintf("%s-%s-%s", date, dayMonth[2:4], dayMonth[0:2])
+			}
+			out = append(out, newID3TextFrame("TDRC", date))
+		case "TORY":
+			if strings.TrimSpace(origYear) != "" {
+				out = append(out, newID3TextFrame("TDOR", strings.TrimSpace(origYear)))
+			}
+		default:
+			out = append(out, fr)
+		}
+	}
+	return out
+}
+
+func newID3TextFrame(id, value string) id3Frame {
+	return id3Frame{id: id, data: append([]byte{0x03}, value...)}
+}
+
+// newID3LangTextFrame builds a COMM/USLT-style frame: encoding, 3-byte
+// language, null-terminated description, then the text.
+func newID3LangTextFrame(id, lang, desc, text string) id3Frame {
+	payload := []byte{0x03}
+	payload = append(payload, lang...)
+	payload = append(payload, desc...)
+	payload = append(payload, 0x00)
+	payload = append(payload, text...)
+	return id3Frame{id: id, data: payload}
+}
+
+func newID3UserTextFrame(desc, value string) id3Frame {
+	payload := []byte{0x03}
+	payload = append(payload, desc...)
+	payload = append(payload, 0x00)
+	payload = append(payload, value...)
+	return id3Frame{id: "TXXX", data: payload}
+}
+
+var lrcInlineTimestampPattern = regexp.MustCompile(`<\d{2}:\d{2}\.\d{2,3}>`)
+
+// newID3SyncedLyricsFrame builds a SYLT frame (UTF-8, millisecond timestamps,
+// content type "lyrics") from LRC text. ok is false when the lyrics carry no
+// line timings. Inline word timings are dropped since SYLT is line-based.
+func newID3SyncedLyricsFrame(lyrics string) (fr id3Frame, ok bool) {
+	lines := parseSyncedLyrics(lyrics)
+	if len(lines) == 0 {
+		return id3Frame{}, false
+	}
+	payload := []byte{0x03}
+	payload = append(payload, "eng"...)
+	payload = append(payload, 0x02, 0x01, 0x00)
+	for _, line := range lines {
+		payload = append(payload, strings.TrimSpace(lrcInlineTimestampPattern.ReplaceAllString(line.Words, ""))...)
+		payload = append(payload, 0x00)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(line.StartTimeMs))
+	}
+	return id3Frame{id: "SYLT", data: payload}, true
+}
+
+// id3LyricsFrames returns the USLT frame for lyrics, followed by a SYLT frame
+// when the lyrics are time-coded LRC.
+func id3LyricsFrames(lyrics string) []id3Frame {
+	if strings.TrimSpace(lyrics) == "" {
+		return nil
+	}
+	frames := []id3Frame{newID3LangTextFrame("USLT", "eng", "", lyrics)}
+	if sylt, ok := newID3SyncedLyricsFrame(lyrics); ok {
+		frames = append(frames, sylt)
+	}
+	return frames
+}
+
+// newID3PictureFrame builds an APIC frame for a new image, which goes through
+// the cover processing policy first. Every ID3 writer (MP3, WAV, AIFF) adds
+// pictures through here.
+func newID3PictureFrame(mime string, pictureType byte, desc string, image []byte) id3Frame {
+	image, mime, _ = processCover(coverTargetID3, mime, image)
+	if strings.TrimSpace(mime) == "" {
+		mime = "image/jpeg"
+	}
+	payload := []byte{0x03}
+	payload = append(payload, mime...)
+	payload = append(payload, 0x00, pictureType)
+	payload = append(payload, desc...)
+	payload = append(payload, 0x00)
+	payload = append(payload, image...)
+	return id3Frame{id: "APIC", data: payload}
+}
+
+// splitID3Terminated splits b at the first string terminator for the given
+// text encoding (a single NUL for Latin-1/UTF-8, an aligned double NUL for
+// UTF-16). ok is false when no terminator is present.
+func splitID3Terminated(encoding byte, b []byte) (head, rest []byte, ok bool) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return b[:i], b[i+2:], true
+			}
+		}
+		return b, nil, false
+	}
+	if idx := bytes.IndexByte(b, 0); idx >= 0 {
+		return b[:idx], b[idx+1:], true
+	}
+	return b, nil, false
+}
+
+func decodeID3String(encoding byte, raw []byte) string {
+	return strings.TrimSpace(extractTextFrame(append([]byte{encoding}, raw...)))
+}
+
+// id3FrameDescription returns the description of a TXXX, COMM or USLT frame.
+func id3FrameDescription(fr id3Frame) string {
+	if len(fr.data) < 1 {
+		return ""
+	}
+	encoding := fr.data[0]
+	rest := fr.data[1:]
+	if fr.id == "COMM" || fr.id == "USLT" {
+		if len(rest) < 3 {
+			return ""
+		}
+		rest = rest[3:]
+	}
+	desc, _, _ := splitID3Terminated(encoding, rest)
+	return decodeID3String(encoding, desc)
+}
+
+// id3PictureType returns the picture type byte of an APIC frame, or -1.
+func id3PictureType(fr id3Frame) int {
+	if fr.id != "APIC" || len(fr.data) < 2 {
+		return -1
+	}
+	idx := bytes.IndexByte(fr.data[1:], 0)
+	if idx < 0 || 1+idx+1 >= len(fr.data) {
+		return -1
+	}
+	return int(fr.data[1+idx+1])
+}
+
+func findID3TextValue(frames []id3Frame, id string) string {
+	for _, fr := range frames {
+		if fr.id == id {
+			return firstTextValue(extractTextFrame(fr.data))
+		}
+	}
+	return ""
+}
+
+// replaceID3Frames removes every frame matched by match and inserts
+// replacement at the position of the first removed frame (or at the end when
+// nothing matched), keeping the overall frame order stable across edits.
+func replaceID3Frames(frames []id3Frame, match func(id3Frame) bool, replacement ...id3Frame) []id3Frame {
+	out := make([]id3Frame, 0, len(frames)+len(replacement))
+	inserted := false
+	for _, fr := range frames {
+		if match(fr) {
+			if !inserted {
+				out = append(out, replacement...)
+				inserted = true
+			}
+			continue
+		}
+		out = append(out, fr)
+	}
+	if !inserted {
+		out = append(out, replacement...)
+	}
+	return out
+}
+
+func id3FrameIDIn(ids ...string) func(id3Frame) bool {
+	return func(fr id3Frame) bool {
+		for _, id := range ids {
+			if fr.id == id {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// id3EditTextFrames maps edit-field keys to the single text frame they own.
+var id3EditTextFrames = []struct {
+	field string
+	frame string
+}{
+	{"title", "TIT2"},
+	{"artist", "TPE1"},
+	{"album", "TALB"},
+	{"album_artist", "TPE2"},
+	{"genre", "TCON"},
+	{"composer", "TCOM"},
+	{"label", "TPUB"},
+	{"copyright", "TCOP"},
+	{"isrc", "TSRC"},
+}
+
+var id3ReplayGainFields = []struct {
+	field string
+	desc  string
+}{
+	{"replaygain_track_gain", "REPLAYGAIN_TRACK_GAIN"},
+	{"replaygain_track_peak", "REPLAYGAIN_TRACK_PEAK"},
+	{"replaygain_album_gain", "REPLAYGAIN_ALBUM_GAIN"},
+	{"replaygain_album_peak", "REPLAYGAIN_ALBUM_PEAK"},
+}
+
+// applyID3EditFields applies editor fields to an existing frame list with the
+// same set-or-clear semantics as EditFlacFields: keys present with a value are
+// written, keys present but empty are removed, absent keys are left alone.
+func applyID3EditFields(frames []id3Frame, fields map[string]string) []id3Frame {
+	textFrame := func(id, value string) []id3Frame {
+		if strings.TrimSpace(value) == "" {
+			return nil
+		}
+		return []id3Frame{newID3TextFrame(id, value)}
+	}
+
+	for _, m := range id3EditTextFrames {
+		if v, ok := fields[m.field]; ok {
+			frames = replaceID3Frames(frames, id3FrameIDIn(m.frame), textFrame(m.frame, v)...)
+		}
+	}
+
+	if v, ok := fields["date"]; ok {
+		frames = replaceID3Frames(frames, id3FrameIDIn("TDRC", "TYER", "TDAT", "TIME", "TRDA"), textFrame("TDRC", v)...)
+	}
+
+	indexPair := func(frameID, numberKey, totalKey string) {
+		_, hasNumber := fields[numberKey]
+		_, hasTotal := fields[totalKey]
+		if !hasNumber && !hasTotal {
+			return
+		}
+		number, total := parseIndexPair(findID3TextValue(frames, frameID))
+		if hasNumber {
+			number = parsePositiveInt(fields[numberKey])
+		}
+		if hasTotal {
+			total = parsePositiveInt(fields[totalKey])
+		}
+		frames = replaceID3Frames(frames, id3FrameIDIn(frameID), textFrame(frameID, formatIndexValue(number, total))...)
+	}
+	indexPair("TRCK", "track_number", "track_total")
+	indexPair("TPOS", "disc_number", "disc_total")
+
+	if v, ok := fields["comment"]; ok {
+		var replacement []id3Frame
+		if strings.TrimSpace(v) != "" {
+			replacement = []id3Frame{newID3LangTextFrame("COMM", "eng", "", v)}
+		}
+		frames = replaceID3Frames(frames, func(fr id3Frame) bool {
+			return fr.id == "COMM" && id3FrameDescription(fr) == ""
+		}, replacement...)
+	}
+
+	if v, ok := fields["lyrics"]; ok {
+		frames = replaceID3Frames(frames, func(fr id3Frame) bool {
+			return fr.id == "USLT" || fr.id == "SYLT" ||
+				(fr.id == "TXXX" && isLyricsDescription(id3FrameDescription(fr)))
+		}, id3LyricsFrames(v)...)
+	}
+
+	for _, rg := range id3ReplayGainFields {
+		v, ok := fields[rg.field]
+		if !ok {
+			continue
+		}
+		var replacement []id3Frame
+		if strings.TrimSpace(v) != "" {
+			replacement = []id3Frame{newID3UserTextFrame(rg.desc, v)}
+		}
+		desc := rg.desc
+		frames = replaceID3Frames(frames, func(fr id3Frame) bool {
+			return fr.id == "TXXX" && strings.EqualFold(id3FrameDescription(fr), desc)
+		}, replacement...)
+	}
+
+	if coverData, coverMIME := loadCoverForTag(fields); len(coverData) > 0 {
+		frames = replaceID3Frames(frames, func(fr id3Frame) bool {
+			return id3PictureType(fr) == 0x03
+		}, newID3PictureFrame(coverMIME, 0x03, "", coverData))
+	}
+
+	return frames
+}
+
+func serializeID3v24Frames(frames []id3Frame) []byte {
+	var body bytes.Buffer
+	for _, fr := range frames {
+		body.WriteString(fr.id)
+		body.Write(synchsafeEncode(len(fr.data)))
+		body.Write([]byte{fr.status, 0x00})
+		body.Write(fr.data)
+	}
+	return body.Bytes()
+}
+
+// serializeID3v24Tag wraps frame bytes in an ID3v2.4 header, appending
+// padding zero bytes (counted in the header size as the spec requires).
+func serializeID3v24Tag(body []byte, padding int) []byte {
+	var out bytes.Buffer
+	out.WriteString("ID3")
+	out.Write([]byte{0x04, 0x00}) // v2.4.0
+	out.WriteByte(0x00)           // flags
+	out.Write(synchsafeEncode(len(body) + padding))
+	out.Write(body)
+	out.Write(make([]byte, padding))
+	return out.Bytes()
+}
+
+// WriteMP3Tags writes/merges edit fields into an MP3 file's ID3v2 tag. Frames
+// not owned by an edited field are preserved, and the existing tag footprint
+// (padding included) is reused in place whenever the new tag fits.
+func WriteMP3Tags(filePath string, fields map[string]string) error {
+	return rewriteMP3Frames(filePath, func(frames []id3Frame) []id3Frame {
+		return applyID3EditFields(frames, fields)
+	})
+}
+
+// rewriteMP3Frames replaces the MP3's ID3v2 frames with edit(frames).
+func rewriteMP3Frames(filePath string, edit func([]id3Frame) []id3Frame) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	existing, region, err := readID3v2TagRegion(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	var frames []id3Frame
+	if len(existing) > 0 {
+		frames, err = parseID3v2FramesForRewrite(existing)
+		if err != nil {
+			GoLog("[MP3Tags] Discarding unreadable ID3v2 tag: %v\n", err)
+			frames = nil
+		}
+	}
+	frames = dropTagAlterDiscardFrames(edit(frames))
+
+	return writeID3v2TagToFile(filePath, serializeID3v24Frames(frames), region)
+}
+
+// dropTagAlterDiscardFrames removes frames whose "tag alter preservation"
+// status flag asks for them to be discarded once the tag is modified.
+func dropTagAlterDiscardFrames(frames []id3Frame) []id3Frame {
+	out := frames[:0]
+	for _, fr := range frames {
+		if fr.status&0x40 != 0 {
+			continue
+		}
+		out = append(out, fr)
+	}
+	return out
+}
+
+// writeID3v2TagToFile stores body as the file's leading ID3v2.4 tag. region is
+// the size of the tag currently at the start of the file (0 when none).
+func writeID3v2TagToFile(filePath string, body []byte, region int64) error {
+	if region >= int64(10+len(body)) {
+		tag := serializeID3v24Tag(body, int(region)-10-len(body))
+		f, err := os.OpenFile(filePath, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		if _, err := f.WriteAt(tag, 0); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	}
+
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if _, err := in.Seek(region, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".tagtmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(serializeID3v24Tag(body, mp3TagPadding)); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	in.Close()
+
+	return os.Rename(tmpPath, filePath)
+}
+
+// id3FramesFromMetadata builds the frames written for a complete tag (used by
+// the WAV/AIFF writers through buildID3v24TagKeepingPictures).
+func id3FramesFromMetadata(meta *AudioMetadata, coverData []byte, coverMIME string) []id3Frame {
+	var frames []id3Frame
+	addText := func(id, val string) {
+		if strings.TrimSpace(val) == "" {
+			return
+		}
+		frames = append(frames, newID3TextFrame(id, val))
+	}
+
+	addText("TIT2", meta.Title)
+	addText("TPE1", meta.Artist)
+	addText("TALB", meta.Album)
+	addText("TPE2", meta.AlbumArtist)
+	addText("TCON", meta.Genre)
+	addText("TCOM", meta.Composer)
+	addText("TPUB", meta.Label)
+	addText("TCOP", meta.Copyright)
+	addText("TSRC", meta.ISRC)
+
+	date := meta.Date
+	if date == "" {
+		date = meta.Year
+	}
+	addText("TDRC", date)
+
+	if meta.TrackNumber > 0 {
+		if meta.TotalTracks > 0 {
+			addText("TRCK", fmt.Sprintf("%d/%d", meta.TrackNumber, meta.TotalTracks))
+		} else {
+			addText("TRCK", strconv.Itoa(meta.TrackNumber))
+		}
+	}
+	if meta.DiscNumber > 0 {
+		if meta.TotalDiscs > 0 {
+			addText("TPOS", fmt.Sprintf("%d/%d", meta.DiscNumber, meta.TotalDiscs))
+		} else {
+			addText("TPOS", strconv.Itoa(meta.DiscNumber))
+		}
+	}
+
+	if strings.TrimSpace(meta.Comment) != "" {
+		frames = append(frames, newID3LangTextFrame("COMM", "eng", "", meta.Comment))
+	}
+	frames = append(frames, id3LyricsFrames(meta.Lyrics)...)
+
+	for _, rg := range []struct{ desc, val string }{
+		{"REPLAYGAIN_TRACK_GAIN", meta.ReplayGainTrackGain},
+		{"REPLAYGAIN_TRACK_PEAK", meta.ReplayGainTrackPeak},
+		{"REPLAYGAIN_ALBUM_GAIN", meta.ReplayGainAlbumGain},
+		{"REPLAYGAIN_ALBUM_PEAK", meta.ReplayGainAlbumPeak},
+	} {
+		if strings.TrimSpace(rg.val) != "" {
+			frames = append(frames, newID3UserTextFrame(rg.desc, rg.val))
+		}
+	}
+
+	if len(coverData) > 0 {
+		frames = append(frames, newID3PictureFrame(coverMIME, 0x03, "", coverData))
+	}
+	return frames
+}
@@ -0,0 +1,230 @@
+package gobackend
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testMP3Audio = []byte{0xFF, 0xFB, 0x90, 0x64, 0x00, 0x00, 0x00, 0x00, 'a', 'u', 'd', 'i', 'o'}
+
+func padID3Tag(tag []byte, padding int) []byte {
+	size := synchsafeDecode(tag[6:10]) + padding
+	out := append([]byte{}, tag...)
+	copy(out[6:10], syncsafeBytes(size))
+	return append(out, make([]byte, padding)...)
+}
+
+func TestWriteMP3TagsReusesPaddingAndKeepsUnknownFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	tag := padID3Tag(buildID3v23Tag(
+		id3TextFrame("TIT2", "Old Title"),
+		id3TextFrame("TPE1", "Artist"),
+		id3TextFrame("TYER", "2001"),
+		id3TextFrame("TRCK", "3/12"),
+		id3UserTextFrame("TXXX", "CUSTOM", "keep me"),
+		id3v23Frame("PRIV", append([]byte("owner\x00"), 1, 2, 3)),
+	), 512)
+	if err := os.WriteFile(path, append(tag, testMP3Audio...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WriteMP3Tags(path, map[string]string{
+		"title":                 "New Title",
+		"track_total":           "10",
+		"replaygain_track_gain": "-6.50 dB",
+		"artist":                "",
+	})
+	if err != nil {
+		t.Fatalf("WriteMP3Tags: %v", err)
+	}
+
+	data := mustReadFile(t, path)
+	if len(data) != len(tag)+len(testMP3Audio) {
+		t.Fatalf("file size = %d, want in-place rewrite of %d", len(data), len(tag)+len(testMP3Audio))
+	}
+	if !bytes.HasSuffix(data, testMP3Audio) {
+		t.Fatal("audio data was not preserved")
+	}
+	if data[3] != 4 {
+		t.Fatalf("tag version = 2.%d, want 2.4", data[3])
+	}
+
+	meta, err := ReadID3Tags(path)
+	if err != nil {
+		t.Fatalf("ReadID3Tags: %v", err)
+	}
+	if meta.Title != "New Title" || meta.Artist != "" {
+		t.Fatalf("title/artist = %q/%q", meta.Title, meta.Artist)
+	}
+	if meta.TrackNumber != 3 || meta.TotalTracks != 10 {
+		t.Fatalf("track = %d/%d, want 3/10", meta.TrackNumber, meta.TotalTracks)
+	}
+	if meta.Year != "2001" && meta.Date != "2001" {
+		t.Fatalf("TYER was not carried over: year=%q date=%q", meta.Year, meta.Date)
+	}
+	if meta.ReplayGainTrackGain != "-6.50 dB" {
+		t.Fatalf("replaygain = %q", meta.ReplayGainTrackGain)
+	}
+
+	frames, err := parseID3v2FramesForRewrite(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sawCustom, sawPriv bool
+	for _, fr := range frames {
+		if fr.id == "TXXX" && id3FrameDescription(fr) == "CUSTOM" {
+			sawCustom = true
+		}
+		if fr.id == "PRIV" && bytes.Equal(fr.data, append([]byte("owner\x00"), 1, 2, 3)) {
+			sawPriv = true
+		}
+		if fr.id == "TYER" {
+			t.Fatal("v2.3 TYER frame should be upgraded to TDRC")
+		}
+	}
+	if !sawCustom || !sawPriv {
+		t.Fatalf("unknown frames lost: TXXX=%v PRIV=%v", sawCustom, sawPriv)
+	}
+}
+
+func TestWriteMP3TagsGrowsTagAndReplacesFrontCover(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.mp3")
+	backCover := append([]byte{3}, []byte("image/jpeg\x00")...)
+	backCover = append(backCover, 0x04, 0x00, 0xFF, 0xD8, 0xFF, 0xD9)
+	tag := buildID3v23Tag(
+		id3TextFrame("TIT2", "Title"),
+		id3v23Frame("APIC", backCover),
+	)
+	if err := os.WriteFile(path, append(tag, testMP3Audio...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	coverPath := filepath.Join(dir, "cover.png")
+	cover := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0x42}, 64)...)
+	if err := os.WriteFile(coverPath, cover, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lyrics := strings.Repeat("[00:01.00]line\n", 20)
+	if err := WriteMP3Tags(path, map[string]string{"lyrics": lyrics, "cover_path": coverPath}); err != nil {
+		t.Fatalf("WriteMP3Tags: %v", err)
+	}
+
+	data := mustReadFile(t, path)
+	if !bytes.HasSuffix(data, testMP3Audio) {
+		t.Fatal("audio data was not preserved")
+	}
+	_, region, err := func() ([]byte, int64, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		defer f.Close()
+		return readID3v2TagRegion(f)
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(region)+len(testMP3Audio) != len(data) {
+		t.Fatalf("tag region %d does not end at audio start", region)
+	}
+
+	frames, err := parseID3v2FramesForRewrite(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pictureTypes := map[int]bool{}
+	for _, fr := range frames {
+		pictureTypes[id3PictureType(fr)] = true
+	}
+	if !pictureTypes[3] || !pictureTypes[4] {
+		t.Fatalf("picture types = %v, want front cover added and back cover kept", pictureTypes)
+	}
+
+	meta, err := ReadID3Tags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Title" || meta.Lyrics != lyrics {
+		t.Fatalf("title/lyrics = %q/%q", meta.Title, meta.Lyrics)
+	}
+
+	// A second, smaller edit must fit in the padding left by the rewrite.
+	sizeBefore := len(data)
+	if err := WriteMP3Tags(path, map[string]string{"album": "Album"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(mustReadFile(t, path)); got != sizeBefore {
+		t.Fatalf("second edit resized file: %d -> %d", sizeBefore, got)
+	}
+}
+
+func TestWriteMP3TagsUpgradesID3v22(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.mp3")
+	pic := append([]byte{0}, []byte("PNG")...)
+	pic = append(pic, 0x03, 0x00, 0x89, 'P', 'N', 'G')
+	tag := buildID3v22Tag(
+		id3v22TextFrame("TT2", "Legacy"),
+		id3v22Frame("PIC", pic),
+		id3v22Frame("ZZZ", []byte("dropped")),
+	)
+	if err := os.WriteFile(path, append(tag, testMP3Audio...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteMP3Tags(path, map[string]string{"comment": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ReadID3Tags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Legacy" || meta.Comment != "hello" {
+		t.Fatalf("title/comment = %q/%q", meta.Title, meta.Comment)
+	}
+	if coverData, mime := extractAPICFromID3(mustReadFile(t, path)); len(coverData) == 0 || mime != "image/png" {
+		t.Fatalf("converted PIC frame = %d bytes, %q", len(coverData), mime)
+	}
+}
+
+func TestWriteMP3TagsSkipsEmptyFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.mp3")
+	tag := buildID3v23Tag(
+		id3TextFrame("TIT2", "Song"),
+		id3v23Frame("TXXX", nil),
+		id3TextFrame("TALB", "Album"),
+	)
+	if err := os.WriteFile(path, append(tag, testMP3Audio...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := WriteMP3Tags(path, map[string]string{"comment": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := ReadID3Tags(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Song" || meta.Album != "Album" || meta.Comment != "hello" {
+		t.Fatalf("title/album/comment = %q/%q/%q", meta.Title, meta.Album, meta.Comment)
+	}
+}
+
+func TestEditFileMetadataUsesNativeMP3Writer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edit.mp3")
+	if err := os.WriteFile(path, testMP3Audio, 0600); err != nil {
+		t.Fatal(err)
+	}
+	response, err := EditFileMetadata(path, `{"title":"Native","track_number":"2"}`)
+	if err != nil || !strings.Contains(response, "native_mp3") {
+		t.Fatalf("EditFileMetadata = %q/%v", response, err)
+	}
+	meta, err := ReadID3Tags(path)
+	if err != nil || meta.Title != "Native" || meta.TrackNumber != 2 {
+		t.Fatalf("ReadID3Tags = %+v/%v", meta, err)
+	}
+}
@@ -0,0 +1,760 @@
+package gobackend
+
+// Native Common Encryption (ISO/IEC 23001-7) decryption of MP4 files.
+//
+// Both plain and fragmented files are handled. Per-sample IVs and subsample
+// maps come from senc, or from saiz/saio when senc is absent; the scheme and
+// defaults come from the tenc box of the protected sample entry. "cenc"
+// (AES-128 CTR) and "cbcs" (AES-128 CBC with a crypt/skip pattern) are
+// supported. Every sample is decrypted at its own offset in a copy of the
+// file, so no offsets move; the sample entry is then restored to its original format with sinf removed and
+// the shrunk moov is followed by a free atom of the same total size.
+//
+// Sample-group key overrides (seig) are not supported: every sample is
+// decrypted with the single key passed in.
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	cencSchemeCTR = "cenc"
+	cencSchemeCBC = "cbcs"
+)
+
+var errMP4NotEncrypted = errors.New("no encrypted track found")
+
+// cencTrack describes one protected track of the movie.
+type cencTrack struct {
+	trackID     uint32
+	scheme      string
+	protected   bool // tenc default_isProtected; unprotected tracks are only unwrapped
+	ivSize      int
+	constantIV  []byte
+	cryptBlocks int
+	skipBlocks  int
+	format      string   // original sample entry type from frma
+	entry       mp4Box   // enca/encv sample entry inside moov
+	sinf        mp4Box   // protection scheme box inside entry
+	ancestors   []mp4Box // moov, trak, mdia, minf, stbl, stsd
+	stbl        mp4Box
+}
+
+type cencSubsample struct {
+	clear     uint32
+	protected uint32
+}
+
+// cencAuxInfo is the sample auxiliary information of one sample.
+type cencAuxInfo struct {
+	iv         []byte
+	subsamples []cencSubsample
+}
+
+// cencSample is one sample to decrypt, located in the file.
+type cencSample struct {
+	track  *cencTrack
+	offset int64
+	size   uint32
+	aux    cencAuxInfo
+}
+
+// parseCENCKey accepts a 128-bit key as hex (optionally 0x-prefixed or with
+// separators), base64, or a "KID:KEY" pair.
+func parseCENCKey(raw string) ([]byte, error) {
+	key := strings.TrimSpace(raw)
+	if _, after, ok := strings.Cut(key, ":"); ok && !strings.Contains(after, ":") {
+		key = strings.TrimSpace(after)
+	}
+	if len(key) > 2 && strings.EqualFold(key[:2], "0x") {
+		key = key[2:]
+	}
+	compact := strings.Map(func(r rune) rune {
+		if strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return r
+		}
+		if r == '-' || r == ' ' || r == ':' {
+			return -1
+		}
+		return 'x'
+	}, key)
+	if decoded, err := hex.DecodeString(compact); err == nil && len(decoded) == 16 {
+		return decoded, nil
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(key); err == nil && len(decoded) == 16 {
+		return decoded, nil
+	}
+	if decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(key, "=")); err == nil && len(decoded) == 16 {
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("decryption key is not a 128-bit hex or base64 value")
+}
+
+// DecryptMP4File decrypts a CENC/CBCS protected MP4 with a 128-bit key. The
+// decrypted copy is written next to the file and only renamed over it once
+// every sample has been decrypted, so on error the original is untouched.
+// ivOverride, when set, replaces the constant IV of tracks that carry no
+// per-sample IVs.
+func DecryptMP4File(filePath, key, ivOverride string) error {
+	keyBytes, err := parseCENCKey(key)
+	if err != nil {
+		return err
+	}
+	var overrideIV []byte
+	if strings.TrimSpace(ivOverride) != "" {
+		overrideIV, err = hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(ivOverride), "0x"))
+		if err != nil || (len(overrideIV) != 8 && len(overrideIV) != 16) {
+			return fmt.Errorf("invalid IV %q", ivOverride)
+		}
+	}
+	block, err := aes.NewCipher(keyBytes)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	var topLevel []atomHeader
+	moovIdx := -1
+	for pos := int64(0); pos+8 <= fileSize; {
+		header, err := readAtomHeaderAt(f, pos, fileSize)
+		if err != nil {
+			return err
+		}
+		if header.size == 0 {
+			header.size = fileSize - pos
+		}
+		if header.size < header.headerSize || pos+header.size > fileSize {
+			return fmt.Errorf("invalid atom size for %s", header.typ)
+		}
+		if header.typ == "moov" && moovIdx < 0 {
+			moovIdx = len(topLevel)
+		}
+		topLevel = append(topLevel, header)
+		pos += header.size
+	}
+	if moovIdx < 0 {
+		return fmt.Errorf("moov atom not found")
+	}
+	moovHeader := topLevel[moovIdx]
+	moov := make([]byte, moovHeader.size)
+	if _, err := f.ReadAt(moov, moovHeader.offset); err != nil {
+		return err
+	}
+
+	tracks, err := parseCENCTracks(moov)
+	if err != nil {
+		return err
+	}
+	for _, track := range tracks {
+		if track.ivSize == 0 && overrideIV != nil {
+			track.constantIV = overrideIV
+		}
+	}
+
+	defaults := parseMP4TrackDefaults(moov)
+	var samples []cencSample
+	var fragmentBoxes []int64 // encryption boxes in moof, turned into free atoms
+	for _, track := range tracks {
+		found, err := collectCENCTrackSamples(f, moov, track)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, found...)
+	}
+	for _, header := range topLevel {
+		if header.typ != "moof" {
+			continue
+		}
+		moof := make([]byte, header.size)
+		if _, err := f.ReadAt(moof, header.offset); err != nil {
+			return err
+		}
+		found, boxes, err := collectCENCFragmentSamples(f, moof, header.offset, tracks, defaults)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, found...)
+		fragmentBoxes = append(fragmentBoxes, boxes...)
+	}
+	for _, s := range samples {
+		if s.offset < 0 || s.offset+int64(s.size) > fileSize {
+			return fmt.Errorf("encrypted sample at %d lies outside the file", s.offset)
+		}
+		var total int64
+		for _, sub := range s.aux.subsamples {
+			total += int64(sub.clear) + int64(sub.protected)
+		}
+		if total > int64(s.size) {
+			return fmt.Errorf("subsample map of sample at %d exceeds its size", s.offset)
+		}
+		if len(s.aux.iv) == 0 {
+			return fmt.Errorf("sample at %d has no IV", s.offset)
+		}
+	}
+
+	// Decrypt into a copy so a failure partway through never leaves a
+	// half-decrypted original behind.
+	out, err := os.CreateTemp(filepath.Dir(filePath), ".decrypt-*"+filepath.Ext(filePath))
+	if err != nil {
+		return err
+	}
+	tmpPath := out.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			out.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+	if err := out.Chmod(info.Mode().Perm()); err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, io.NewSectionReader(f, 0, fileSize)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 0, 64*1024)
+	for _, s := range samples {
+		if cap(buf) < int(s.size) {
+			buf = make([]byte, s.size)
+		}
+		data := buf[:s.size]
+		if _, err := f.ReadAt(data, s.offset); err != nil {
+			return err
+		}
+		decryptCENCSample(block, s.track, s.aux, data)
+		if _, err := out.WriteAt(data, s.offset); err != nil {
+			return err
+		}
+	}
+
+	for _, offset := range fragmentBoxes {
+		if _, err := out.WriteAt([]byte("free"), offset+4); err != nil {
+			return err
+		}
+	}
+	newMoov := stripCENCFromMoov(moov, tracks)
+	if removed := int64(len(moov) - len(newMoov)); removed > 0 {
+		newMoov = append(newMoov, buildM4AFreeAtom(int(removed))...)
+	}
+	if _, err := out.WriteAt(newMoov, moovHeader.offset); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	f.Close()
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return err
+	}
+	committed = true
+
+	GoLog("[Decrypt] %s: decrypted %d samples in %d track(s)\n", filepath.Base(filePath), len(samples), len(tracks))
+	return nil
+}
+
+// parseCENCTracks finds every protected sample entry in moov.
+func parseCENCTracks(moov []byte) ([]*cencTrack, error) {
+	root, ok := readMP4Box(moov, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid moov box")
+	}
+
+	var tracks []*cencTrack
+	var parseErr error
+	eachChildMP4(moov, root.body(), root.end(), "trak", func(trak mp4Box) bool {
+		trackID, ok := mp4TrackID(moov, trak)
+		if !ok {
+			return true
+		}
+
+		mdia, ok := findChildMP4(moov, trak.body(), trak.end(), "mdia")
+		if !ok {
+			return true
+		}
+		minf, ok := findChildMP4(moov, mdia.body(), mdia.end(), "minf")
+		if !ok {
+			return true
+		}
+		stbl, ok := findChildMP4(moov, minf.body(), minf.end(), "stbl")
+		if !ok {
+			return true
+		}
+		stsd, ok := findChildMP4(moov, stbl.body(), stbl.end(), "stsd")
+		if !ok || stsd.body()+8 > stsd.end() {
+			return true
+		}
+		for pos := stsd.body() + 8; pos+8 <= stsd.end(); {
+			entry, ok := readMP4Box(moov, pos)
+			if !ok {
+				break
+			}
+			pos = entry.end()
+			if entry.typ != "enca" && entry.typ != "encv" {
+				continue
+			}
+			track, err := parseCENCSampleEntry(moov, entry)
+			if err != nil {
+				parseErr = err
+				return false
+			}
+			track.trackID = trackID
+			track.ancestors = []mp4Box{root, trak, mdia, minf, stbl, stsd}
+			track.stbl = stbl
+			tracks = append(tracks, track)
+			// Only the first protected entry of a track is used.
+			break
+		}
+		return true
+	})
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	if len(tracks) == 0 {
+		return nil, errMP4NotEncrypted
+	}
+	return tracks, nil
+}
+
+func parseCENCSampleEntry(moov []byte, entry mp4Box) (*cencTrack, error) {
+	hdrLen := int64(78) // visual sample entry
+	if entry.typ == "enca" {
+		var ok bool
+		if hdrLen, ok = audioSampleEntryHeaderLen(moov, entry); !ok {
+			return nil, fmt.Errorf("invalid enca sample entry")
+		}
+	}
+	if entry.body()+hdrLen > entry.end() {
+		return nil, fmt.Errorf("invalid %s sample entry", entry.typ)
+	}
+	sinf, ok := findChildMP4(moov, entry.body()+hdrLen, entry.end(), "sinf")
+	if !ok {
+		return nil, fmt.Errorf("%s sample entry has no sinf", entry.typ)
+	}
+	track := &cencTrack{entry: entry, sinf: sinf}
+
+	if frma, ok := findChildMP4(moov, sinf.body(), sinf.end(), "frma"); ok && frma.body()+4 <= frma.end() {
+		track.format = string(moov[frma.body() : frma.body()+4])
+	} else {
+		return nil, fmt.Errorf("sinf has no frma")
+	}
+	schm, ok := findChildMP4(moov, sinf.body(), sinf.end(), "schm")
+	if !ok || schm.body()+8 > schm.end() {
+		return nil, fmt.Errorf("sinf has no schm")
+	}
+	track.scheme = string(moov[schm.body()+4 : schm.body()+8])
+	if track.scheme != cencSchemeCTR && track.scheme != cencSchemeCBC {
+		return nil, fmt.Errorf("unsupported protection scheme %q", track.scheme)
+	}
+
+	schi, ok := findChildMP4(moov, sinf.body(), sinf.end(), "schi")
+	if !ok {
+		return nil, fmt.Errorf("sinf has no schi")
+	}
+	tenc, ok := findChildMP4(moov, schi.body(), schi.end(), "tenc")
+	if !ok || tenc.body()+24 > tenc.end() {
+		return nil, fmt.Errorf("schi has no tenc")
+	}
+	body := moov[tenc.body():tenc.end()]
+	if body[0] > 0 {
+		track.cryptBlocks = int(body[5] >> 4)
+		track.skipBlocks = int(body[5] & 0x0F)
+	}
+	track.protected = body[6] != 0
+	track.ivSize = int(body[7])
+	if track.ivSize != 0 && track.ivSize != 8 && track.ivSize != 16 {
+		return nil, fmt.Errorf("invalid per-sample IV size %d", track.ivSize)
+	}
+	if track.protected && track.ivSize == 0 {
+		if len(body) < 25 || len(body) < 25+int(body[24]) {
+			return nil, fmt.Errorf("truncated tenc box")
+		}
+		track.constantIV = append([]byte(nil), body[25:25+int(body[24])]...)
+	}
+	return track, nil
+}
+
+// collectCENCTrackSamples returns the samples described by the track's own
+// sample table. Fragmented tracks have none there.
+func collectCENCTrackSamples(f *os.File, moov []byte, track *cencTrack) ([]cencSample, error) {
+	stsz, ok := findChildMP4(moov, track.stbl.body(), track.stbl.end(), "stsz")
+	if !ok || stsz.body()+12 > stsz.end() || binary.BigEndian.Uint32(moov[stsz.body()+8:]) == 0 {
+		return nil, nil
+	}
+	offsets, sizes, err := parseMP4SampleTable(moov, track.stbl)
+	if err != nil {
+		return nil, err
+	}
+
+	var aux []cencAuxInfo
+	if senc, ok := findChildMP4(moov, track.stbl.body(), track.stbl.end(), "senc"); ok {
+		aux, err = parseCENCSenc(moov[senc.body():senc.end()], track)
+	} else {
+		aux, err = readCENCSaizSaio(f, moov, track.stbl, 0, track)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pairCENCSamples(track, offsets, sizes, aux)
+}
+
+// collectCENCFragmentSamples returns the encrypted samples of one moof and the
+// file offsets of its encryption boxes.
+func collectCENCFragmentSamples(f *os.File, moof []byte, moofOffset int64, tracks []*cencTrack, defaults map[uint32]mp4TrackDefaults) ([]cencSample, []int64, error) {
+	var samples []cencSample
+	var boxes []int64
+	err := eachMP4TrackFragment(moof, moofOffset, defaults, func(traf mp4Box, frag mp4TrackFragment) error {
+		var track *cencTrack
+		for _, t := range tracks {
+			if t.trackID == frag.trackID {
+				track = t
+			}
+		}
+		if track == nil {
+			return nil
+		}
+
+		var aux []cencAuxInfo
+		var err error
+		if senc, ok := findChildMP4(moof, traf.body(), traf.end(), "senc"); ok {
+			aux, err = parseCENCSenc(moof[senc.body():senc.end()], track)
+		} else {
+			aux, err = readCENCSaizSaio(f, moof, traf, frag.base, track)
+		}
+		if err != nil {
+			return err
+		}
+		offsets := make([]int64, len(frag.samples))
+		sizes := make([]uint32, len(frag.samples))
+		for i, s := range frag.samples {
+			offsets[i], sizes[i] = s.offset, s.size
+		}
+		found, err := pairCENCSamples(track, offsets, sizes, aux)
+		if err != nil {
+			return err
+		}
+		samples = append(samples, found...)
+
+		for p := traf.body(); p+8 <= traf.end(); {
+			child, ok := readMP4Box(moof, p)
+			if !ok {
+				break
+			}
+			if child.typ == "senc" || child.typ == "saiz" || child.typ == "saio" || isCENCSampleGroup(moof, child) {
+				boxes = append(boxes, moofOffset+child.offset)
+			}
+			p = child.end()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	root, _ := readMP4Box(moof, 0)
+	eachChildMP4(moof, root.body(), root.end(), "pssh", func(pssh mp4Box) bool {
+		boxes = append(boxes, moofOffset+pssh.offset)
+		return true
+	})
+	return samples, boxes, nil
+}
+
+// parseCENCSenc parses a SampleEncryptionBox body (after the box header).
+func parseCENCSenc(body []byte, track *cencTrack) ([]cencAuxInfo, error) {
+	if len(body) < 8 {
+		return nil, fmt.Errorf("truncated senc box")
+	}
+	flags := binary.BigEndian.Uint32(body[0:4]) & 0xFFFFFF
+	count := int(binary.BigEndian.Uint32(body[4:8]))
+	pos := 8
+	aux := make([]cencAuxInfo, 0, min(count, len(body)))
+	for i := 0; i < count; i++ {
+		if pos+track.ivSize > len(body) {
+			return nil, fmt.Errorf("truncated senc box")
+		}
+		info := cencAuxInfo{iv: track.sampleIV(body[pos : pos+track.ivSize])}
+		pos += track.ivSize
+		if flags&0x2 != 0 {
+			if pos+2 > len(body) {
+				return nil, fmt.Errorf("truncated senc box")
+			}
+			n := int(binary.BigEndian.Uint16(body[pos:]))
+			pos += 2
+			if pos+n*6 > len(body) {
+				return nil, fmt.Errorf("truncated senc box")
+			}
+			info.subsamples = parseCENCSubsamples(body[pos:], n)
+			pos += n * 6
+		}
+		aux = append(aux, info)
+	}
+	return aux, nil
+}
+
+// readCENCSaizSaio reads sample auxiliary information through saiz/saio.
+// saio offsets are relative to base (0 in moov, the data base in a traf).
+func readCENCSaizSaio(f *os.File, buf []byte, parent mp4Box, base int64, track *cencTrack) ([]cencAuxInfo, error) {
+	saiz, okSaiz := findChildMP4(buf, parent.body(), parent.end(), "saiz")
+	saio, okSaio := findChildMP4(buf, parent.body(), parent.end(), "saio")
+	if !okSaiz || !okSaio {
+		return nil, fmt.Errorf("no sample encryption information")
+	}
+
+	body := buf[saiz.body():saiz.end()]
+	pos := 4
+	if len(body) >= 4 && binary.BigEndian.Uint32(body[0:4])&1 != 0 {
+		pos += 8
+	}
+	if len(body) < pos+5 {
+		return nil, fmt.Errorf("truncated saiz box")
+	}
+	defaultSize := int(body[pos])
+	count := int(binary.BigEndian.Uint32(body[pos+1:]))
+	pos += 5
+	infoSizes := make([]int, count)
+	for i := range infoSizes {
+		if defaultSize != 0 {
+			infoSizes[i] = defaultSize
+			continue
+		}
+		if pos >= len(body) {
+			return nil, fmt.Errorf("truncated saiz box")
+		}
+		infoSizes[i] = int(body[pos])
+		pos++
+	}
+
+	body = buf[saio.body():saio.end()]
+	if len(body) < 8 {
+		return nil, fmt.Errorf("truncated saio box")
+	}
+	version := body[0]
+	pos = 4
+	if binary.BigEndian.Uint32(body[0:4])&1 != 0 {
+		pos += 8
+	}
+	if len(body) < pos+4 {
+		return nil, fmt.Errorf("truncated saio box")
+	}
+	entries := int(binary.BigEndian.Uint32(body[pos:]))
+	pos += 4
+	width := 4
+	if version == 1 {
+		width = 8
+	}
+	if entries != 1 && entries != count {
+		return nil, fmt.Errorf("unsupported saio layout: %d offsets for %d samples", entries, count)
+	}
+	if len(body) < pos+entries*width {
+		return nil, fmt.Errorf("truncated saio box")
+	}
+	offsetAt := func(i int) int64 {
+		if width == 8 {
+			return base + int64(binary.BigEndian.Uint64(body[pos+i*8:]))
+		}
+		return base + int64(binary.BigEndian.Uint32(body[pos+i*4:]))
+	}
+
+	aux := make([]cencAuxInfo, count)
+	next := int64(0)
+	if entries > 0 {
+		next = offsetAt(0)
+	}
+	for i, size := range infoSizes {
+		if entries == count {
+			next = offsetAt(i)
+		}
+		record := make([]byte, size)
+		if _, err := f.ReadAt(record, next); err != nil {
+			return nil, fmt.Errorf("failed to read sample auxiliary information: %w", err)
+		}
+		next += int64(size)
+		if size < track.ivSize {
+			return nil, fmt.Errorf("sample auxiliary information too short")
+		}
+		aux[i].iv = track.sampleIV(record[:track.ivSize])
+		if rest := record[track.ivSize:]; len(rest) >= 2 {
+			n := int(binary.BigEndian.Uint16(rest))
+			if 2+n*6 > len(rest) {
+				return nil, fmt.Errorf("truncated subsample map")
+			}
+			aux[i].subsamples = parseCENCSubsamples(rest[2:], n)
+		}
+	}
+	return aux, nil
+}
+
+func parseCENCSubsamples(data []byte, n int) []cencSubsample {
+	subsamples := make([]cencSubsample, n)
+	for i := range subsamples {
+		subsamples[i] = cencSubsample{
+			clear:     uint32(binary.BigEndian.Uint16(data[i*6:])),
+			protected: binary.BigEndian.Uint32(data[i*6+2:]),
+		}
+	}
+	return subsamples
+}
+
+// sampleIV returns the IV of a sample: its own, or the track's constant IV.
+func (t *cencTrack) sampleIV(perSample []byte) []byte {
+	iv := perSample
+	if len(iv) == 0 {
+		iv = t.constantIV
+	}
+	if len(iv) == 0 {
+		return nil
+	}
+	out := make([]byte, aes.BlockSize)
+	copy(out, iv)
+	return out
+}
+
+func pairCENCSamples(track *cencTrack, offsets []int64, sizes []uint32, aux []cencAuxInfo) ([]cencSample, error) {
+	if !track.protected {
+		return nil, nil
+	}
+	if len(aux) == 0 && len(track.constantIV) > 0 {
+		aux = make([]cencAuxInfo, len(offsets))
+		for i := range aux {
+			aux[i].iv = track.sampleIV(nil)
+		}
+	}
+	if len(aux) != len(offsets) {
+		return nil, fmt.Errorf("track %d has encryption info for %d of %d samples", track.trackID, len(aux), len(offsets))
+	}
+	samples := make([]cencSample, len(offsets))
+	for i := range offsets {
+		samples[i] = cencSample{track: track, offset: offsets[i], size: sizes[i], aux: aux[i]}
+	}
+	return samples, nil
+}
+
+// decryptCENCSample decrypts one sample in place.
+func decryptCENCSample(block cipher.Block, track *cencTrack, aux cencAuxInfo, data []byte) {
+	subsamples := aux.subsamples
+	if len(subsamples) == 0 {
+		subsamples = []cencSubsample{{clear: 0, protected: uint32(len(data))}}
+	}
+
+	if track.scheme == cencSchemeCTR {
+		// The keystream runs on across the protected ranges of a sample.
+		stream := cipher.NewCTR(block, aux.iv)
+		pos := 0
+		for _, sub := range subsamples {
+			pos += int(sub.clear)
+			end := pos + int(sub.protected)
+			stream.XORKeyStream(data[pos:end], data[pos:end])
+			pos = end
+		}
+		return
+	}
+
+	// cbcs restarts the CBC chain with the sample IV at every subsample and
+	// leaves trailing partial blocks in the clear.
+	pos := 0
+	for _, sub := range subsamples {
+		pos += int(sub.clear)
+		region := data[pos : pos+int(sub.protected)]
+		pos += int(sub.protected)
+
+		mode := cipher.NewCBCDecrypter(block, aux.iv)
+		whole := len(region) / aes.BlockSize * aes.BlockSize
+		if track.cryptBlocks == 0 {
+			mode.CryptBlocks(region[:whole], region[:whole])
+			continue
+		}
+		for off := 0; off < whole; {
+			n := min(track.cryptBlocks*aes.BlockSize, whole-off)
+			mode.CryptBlocks(region[off:off+n], region[off:off+n])
+			off += n + track.skipBlocks*aes.BlockSize
+		}
+	}
+}
+
+func isCENCSampleGroup(buf []byte, box mp4Box) bool {
+	return (box.typ == "sbgp" || box.typ == "sgpd") && box.body()+8 <= box.end() &&
+		string(buf[box.body()+4:box.body()+8]) == "seig"
+}
+
+// stripCENCFromMoov returns moov with the protected sample entries restored
+// to their original format, sinf and pssh removed and the encryption boxes of
+// the sample tables turned into free atoms.
+func stripCENCFromMoov(moov []byte, tracks []*cencTrack) []byte {
+	out := append([]byte(nil), moov...)
+	root, _ := readMP4Box(out, 0)
+
+	type removal struct {
+		box       mp4Box
+		ancestors []mp4Box
+	}
+	var removals []removal
+	for _, track := range tracks {
+		copy(out[track.entry.offset+4:track.entry.offset+8], track.format)
+		for p := track.stbl.body(); p+8 <= track.stbl.end(); {
+			child, ok := readMP4Box(out, p)
+			if !ok {
+				break
+			}
+			if child.typ == "senc" || child.typ == "saiz" || child.typ == "saio" || isCENCSampleGroup(out, child) {
+				copy(out[child.offset+4:child.offset+8], "free")
+			}
+			p = child.end()
+		}
+		ancestors := append(append([]mp4Box(nil), track.ancestors...), track.entry)
+		removals = append(removals, removal{track.sinf, ancestors})
+	}
+	eachChildMP4(out, root.body(), root.end(), "pssh", func(pssh mp4Box) bool {
+		removals = append(removals, removal{pssh, []mp4Box{root}})
+		return true
+	})
+
+	// Splice from the back so earlier offsets stay valid.
+	sort.Slice(removals, func(i, j int) bool { return removals[i].box.offset > removals[j].box.offset })
+	for _, r := range removals {
+		out = spliceMP4(out, r.box.offset, r.box.end(), nil, r.ancestors)
+	}
+	return out
+}
+
+// applyNativeDecryption decrypts an extension download that carries an MP4
+// decryption key, so the app does not have to run FFmpeg for it. A failure
+// is returned as is: the original file is untouched, and handing it to
+// FFmpeg with the same key would not do better.
+func applyNativeDecryption(result *DownloadResult) error {
+	info := result.Decryption
+	if info == nil || info.Strategy != genericFFmpegMOVDecryptionStrategy || shouldSkipQualityProbe(result.FilePath) {
+		return nil
+	}
+	if err := DecryptMP4File(result.FilePath, info.Key, info.IV); err != nil {
+		if !errors.Is(err, errMP4NotEncrypted) {
+			return fmt.Errorf("native decryption failed: %w", err)
+		}
+		GoLog("[Decrypt] %s carries no encrypted track; nothing to decrypt\n", filepath.Base(result.FilePath))
+	}
+	if normalizeDownloadResultExtension(info.OutputExtension) == ".flac" {
+		result.RequiresContainerConversion = true
+	}
+	result.Decryption = nil
+	result.DecryptionKey = ""
+	return nil
+}
@@ -0,0 +1,254 @@
+package gobackend
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testCENCKey = []byte("0123456789abcdef")
+
+// testCENCFrames splits the stream of buildTestVerifyFLAC into its
+// STREAMINFO block and frames.
+func testCENCFrames() ([]byte, [][]byte) {
+	stream, offsets := buildTestVerifyFLAC()
+	var frames [][]byte
+	for i, start := range offsets {
+		end := len(stream)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		frames = append(frames, append([]byte(nil), stream[start:end]...))
+	}
+	return stream[4:offsets[0]], frames
+}
+
+// encryptTestCENCSample is the inverse of decryptCENCSample: the first six
+// bytes of the sample stay clear and the rest is one protected range.
+func encryptTestCENCSample(track *cencTrack, iv, data []byte) []byte {
+	block, _ := aes.NewCipher(testCENCKey)
+	out := append([]byte(nil), data...)
+	region := out[6:]
+	if track.scheme == cencSchemeCTR {
+		cipher.NewCTR(block, iv).XORKeyStream(region, region)
+		return out
+	}
+	mode := cipher.NewCBCEncrypter(block, iv)
+	whole := len(region) / aes.BlockSize * aes.BlockSize
+	for off := 0; off < whole; {
+		n := min(track.cryptBlocks*aes.BlockSize, whole-off)
+		mode.CryptBlocks(region[off:off+n], region[off:off+n])
+		off += n + track.skipBlocks*aes.BlockSize
+	}
+	return out
+}
+
+func buildTestCENCSampleEntry(streamInfo []byte, tenc []byte, scheme string) []byte {
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[6:8], 1)
+	binary.BigEndian.PutUint16(entry[16:18], 2)
+	binary.BigEndian.PutUint16(entry[18:20], 16)
+	binary.BigEndian.PutUint32(entry[24:28], 44100<<16)
+	entry = append(entry, buildM4AAtom("dfLa", append([]byte{0, 0, 0, 0}, streamInfo...))...)
+	schm := append([]byte{0, 0, 0, 0}, scheme...)
+	schm = append(schm, 0, 1, 0, 0)
+	sinf := buildM4AAtom("frma", []byte("fLaC"))
+	sinf = append(sinf, buildM4AAtom("schm", schm)...)
+	sinf = append(sinf, buildM4AAtom("schi", buildM4AAtom("tenc", tenc))...)
+	entry = append(entry, buildM4AAtom("sinf", sinf)...)
+	return append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, buildM4AAtom("enca", entry)...)
+}
+
+func buildTestCENCTrak(stsd []byte, stbl ...[]byte) []byte {
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], 1)
+	body := buildM4AAtom("stsd", stsd)
+	for _, child := range stbl {
+		body = append(body, child...)
+	}
+	mdhd := make([]byte, 24)
+	binary.BigEndian.PutUint32(mdhd[12:16], 44100)
+	mdia := append(buildM4AAtom("mdhd", mdhd), buildM4AAtom("minf", buildM4AAtom("stbl", body))...)
+	return buildM4AAtom("trak", append(buildM4AAtom("tkhd", tkhd), buildM4AAtom("mdia", mdia)...))
+}
+
+// buildTestCBCSMP4 builds a plain MP4 protected with cbcs (1:9 pattern,
+// constant IV) whose subsample maps are reached through saiz/saio.
+func buildTestCBCSMP4(streamInfo []byte, frames [][]byte) []byte {
+	iv := []byte("fedcba9876543210")
+	tenc := []byte{1, 0, 0, 0, 0, 0x19, 1, 0}
+	tenc = append(tenc, make([]byte, 16)...)
+	tenc = append(append(tenc, 16), iv...)
+	track := &cencTrack{scheme: cencSchemeCBC, cryptBlocks: 1, skipBlocks: 9}
+
+	var samples, aux []byte
+	stsz := make([]byte, 12)
+	binary.BigEndian.PutUint32(stsz[8:12], uint32(len(frames)))
+	saiz := []byte{0, 0, 0, 0, 0}
+	saiz = binary.BigEndian.AppendUint32(saiz, uint32(len(frames)))
+	for _, frame := range frames {
+		samples = append(samples, encryptTestCENCSample(track, iv, frame)...)
+		stsz = binary.BigEndian.AppendUint32(stsz, uint32(len(frame)))
+		aux = append(aux, 0, 1, 0, 6)
+		aux = binary.BigEndian.AppendUint32(aux, uint32(len(frame)-6))
+		saiz = append(saiz, 8)
+	}
+	stsc := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1}
+	stsc = binary.BigEndian.AppendUint32(stsc, uint32(len(frames)))
+	stsc = binary.BigEndian.AppendUint32(stsc, 1)
+
+	ftyp := buildM4AAtom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	pssh := buildM4AAtom("pssh", make([]byte, 28))
+	buildMoov := func(mdatStart uint32) []byte {
+		stco := binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0, 0, 0, 0, 1}, mdatStart)
+		saio := binary.BigEndian.AppendUint32([]byte{0, 0, 0, 0, 0, 0, 0, 1}, mdatStart+uint32(len(samples)))
+		trak := buildTestCENCTrak(buildTestCENCSampleEntry(streamInfo, tenc, "cbcs"),
+			buildM4AAtom("stsz", stsz), buildM4AAtom("stsc", stsc), buildM4AAtom("stco", stco),
+			buildM4AAtom("saiz", saiz), buildM4AAtom("saio", saio))
+		return buildM4AAtom("moov", append(append([]byte(nil), pssh...), trak...))
+	}
+	moovLen := len(buildMoov(0))
+	out := append(append([]byte{}, ftyp...), buildMoov(uint32(len(ftyp)+moovLen+8))...)
+	return append(out, buildM4AAtom("mdat", append(samples, aux...))...)
+}
+
+// buildTestCENCFragmentedMP4 builds a fragmented MP4 protected with cenc and
+// 8-byte per-sample IVs carried in senc. It returns the file and the offset of
+// the first sample.
+func buildTestCENCFragmentedMP4(streamInfo []byte, frames [][]byte) ([]byte, int) {
+	tenc := append([]byte{0, 0, 0, 0, 0, 0, 1, 8}, make([]byte, 16)...)
+	track := &cencTrack{scheme: cencSchemeCTR}
+
+	empty := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	trak := buildTestCENCTrak(buildTestCENCSampleEntry(streamInfo, tenc, "cenc"),
+		buildM4AAtom("stsz", make([]byte, 12)), buildM4AAtom("stsc", empty), buildM4AAtom("stco", empty))
+	trex := make([]byte, 24)
+	binary.BigEndian.PutUint32(trex[4:8], 1)
+	moov := buildM4AAtom("moov", append(trak, buildM4AAtom("mvex", buildM4AAtom("trex", trex))...))
+
+	var samples []byte
+	senc := binary.BigEndian.AppendUint32([]byte{0, 0, 0, 2}, uint32(len(frames)))
+	trunSizes := []byte{}
+	for i, frame := range frames {
+		iv := []byte{0, 0, 0, 0, 0, 0, 0, byte(i + 1)}
+		samples = append(samples, encryptTestCENCSample(track, append(iv, make([]byte, 8)...), frame)...)
+		senc = append(senc, iv...)
+		senc = append(senc, 0, 1, 0, 6)
+		senc = binary.BigEndian.AppendUint32(senc, uint32(len(frame)-6))
+		trunSizes = binary.BigEndian.AppendUint32(trunSizes, uint32(len(frame)))
+	}
+	tfhd := []byte{0, 2, 0, 0, 0, 0, 0, 1}
+	buildMoof := func(dataOffset uint32) []byte {
+		trun := binary.BigEndian.AppendUint32([]byte{0, 0, 2, 1}, uint32(len(frames)))
+		trun = binary.BigEndian.AppendUint32(trun, dataOffset)
+		traf := buildM4AAtom("tfhd", tfhd)
+		traf = append(traf, buildM4AAtom("trun", append(trun, trunSizes...))...)
+		traf = append(traf, buildM4AAtom("senc", senc)...)
+		return buildM4AAtom("moof", append(buildM4AAtom("mfhd", make([]byte, 8)), buildM4AAtom("traf", traf)...))
+	}
+	moofLen := len(buildMoof(0))
+	ftyp := buildM4AAtom("ftyp", []byte("iso6\x00\x00\x00\x00"))
+	out := append(append(append([]byte{}, ftyp...), moov...), buildMoof(uint32(moofLen+8))...)
+	first := len(out) + 8
+	return append(out, buildM4AAtom("mdat", samples)...), first
+}
+
+func TestDecryptMP4FileCBCSAndRemux(t *testing.T) {
+	dir := t.TempDir()
+	streamInfo, frames := testCENCFrames()
+	input := filepath.Join(dir, "song.m4a")
+	encrypted := buildTestCBCSMP4(streamInfo, frames)
+	if err := os.WriteFile(input, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := &ExtDownloadResult{
+		FilePath: input,
+		Decryption: &DownloadDecryptionInfo{
+			Strategy:        genericFFmpegMOVDecryptionStrategy,
+			Key:             "0x" + hex.EncodeToString(testCENCKey),
+			OutputExtension: ".flac",
+		},
+	}
+	result, _, err := normalizeExtensionDownloadResult(raw)
+	if err != nil {
+		t.Fatalf("normalizeExtensionDownloadResult: %v", err)
+	}
+	if result.Decryption != nil || raw.Decryption != nil || result.FilePath != filepath.Join(dir, "song.flac") {
+		t.Fatalf("result = %+v", result)
+	}
+	if r := VerifyFLACFile(result.FilePath); !r.Valid || !r.MD5Checked || r.FramesChecked != len(frames) {
+		t.Fatalf("decrypted and remuxed file = %+v", r)
+	}
+}
+
+func TestDecryptMP4FileFragmentedCENC(t *testing.T) {
+	dir := t.TempDir()
+	streamInfo, frames := testCENCFrames()
+	path := filepath.Join(dir, "song.mp4")
+	encrypted, first := buildTestCENCFragmentedMP4(streamInfo, frames)
+	if err := os.WriteFile(path, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DecryptMP4File(path, base64.StdEncoding.EncodeToString(testCENCKey), ""); err != nil {
+		t.Fatalf("DecryptMP4File: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(encrypted) {
+		t.Fatalf("file size changed from %d to %d", len(encrypted), len(data))
+	}
+	if !bytes.Equal(data[first:], bytes.Join(frames, nil)) {
+		t.Fatal("samples were not decrypted")
+	}
+	for _, typ := range []string{"enca", "sinf", "tenc", "senc"} {
+		if bytes.Contains(data, []byte(typ)) {
+			t.Fatalf("%s left in the decrypted file", typ)
+		}
+	}
+	if quality, err := GetM4AQuality(path); err != nil || quality.Codec != "flac" {
+		t.Fatalf("quality = %+v/%v", quality, err)
+	}
+
+	if err := DecryptMP4File(path, hex.EncodeToString(testCENCKey), ""); !errors.Is(err, errMP4NotEncrypted) {
+		t.Fatalf("second pass error = %v", err)
+	}
+}
+
+func TestDecryptMP4FileFailureLeavesOriginal(t *testing.T) {
+	dir := t.TempDir()
+	streamInfo, frames := testCENCFrames()
+	path := filepath.Join(dir, "song.mp4")
+	encrypted, _ := buildTestCENCFragmentedMP4(streamInfo, frames)
+	truncated := encrypted[:len(encrypted)-3]
+	if err := os.WriteFile(path, truncated, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := &ExtDownloadResult{
+		FilePath: path,
+		Decryption: &DownloadDecryptionInfo{
+			Strategy: genericFFmpegMOVDecryptionStrategy,
+			Key:      hex.EncodeToString(testCENCKey),
+		},
+	}
+	if _, _, err := normalizeExtensionDownloadResult(raw); err == nil {
+		t.Fatal("expected a decryption error instead of an FFmpeg fallback")
+	}
+	if !bytes.Equal(mustReadFile(t, path), truncated) {
+		t.Fatal("original file was modified")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatalf("temporary files left behind: %v", entries)
+	}
+}
@@ -0,0 +1,715 @@
+package gobackend
+
+// Fragmented MP4 (moov with mvex, then moof/mdat pairs) to progressive MP4.
+//
+// The trun/tfhd tables of every fragment are merged into classic
+// stts/ctts/stss/stsz/stsc/stco tables, and the output is written as
+// ftyp + moov + one mdat with the samples in their original order, one chunk
+// per contiguous run of a track fragment. Durations in mvhd/tkhd/mdhd (and a
+// single open-ended edit) are filled in from the summed sample durations.
+// Encrypted fragments must be decrypted first, since their senc boxes are
+// not carried over.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Sample flag bit marking a sample that is not a sync sample.
+const mp4SampleIsNonSync = 0x10000
+
+var errMP4NotFragmented = errors.New("not a fragmented MP4")
+
+// mp4TrackDefaults holds the trex defaults of a track.
+type mp4TrackDefaults struct {
+	duration uint32
+	size     uint32
+	flags    uint32
+}
+
+type mp4FragmentSample struct {
+	offset   int64
+	size     uint32
+	duration uint32
+	flags    uint32
+	cto      int32
+}
+
+// mp4TrackFragment is one traf resolved against its moof.
+type mp4TrackFragment struct {
+	trackID uint32
+	base    int64 // base data offset, also the base of saio offsets
+	samples []mp4FragmentSample
+}
+
+func parseMP4TrackDefaults(moov []byte) map[uint32]mp4TrackDefaults {
+	defaults := map[uint32]mp4TrackDefaults{}
+	root, ok := readMP4Box(moov, 0)
+	if !ok {
+		return defaults
+	}
+	if mvex, ok := findChildMP4(moov, root.body(), root.end(), "mvex"); ok {
+		eachChildMP4(moov, mvex.body(), mvex.end(), "trex", func(trex mp4Box) bool {
+			if trex.body()+24 <= trex.end() {
+				b := moov[trex.body():]
+				defaults[binary.BigEndian.Uint32(b[4:8])] = mp4TrackDefaults{
+					duration: binary.BigEndian.Uint32(b[12:16]),
+					size:     binary.BigEndian.Uint32(b[16:20]),
+					flags:    binary.BigEndian.Uint32(b[20:24]),
+				}
+			}
+			return true
+		})
+	}
+	return defaults
+}
+
+// parseMP4TrackFragment resolves the samples of a traf. prevEnd is where the
+// data of the previous traf in the same moof ended (the moof offset for the
+// first one), which is the implicit base when tfhd sets neither flag.
+func parseMP4TrackFragment(moof []byte, moofOffset int64, traf mp4Box, prevEnd int64, defaults map[uint32]mp4TrackDefaults) (mp4TrackFragment, error) {
+	tfhd, ok := findChildMP4(moof, traf.body(), traf.end(), "tfhd")
+	if !ok || tfhd.body()+8 > tfhd.end() {
+		return mp4TrackFragment{}, fmt.Errorf("traf without tfhd at %d", moofOffset+traf.offset)
+	}
+	body := moof[tfhd.body():tfhd.end()]
+	flags := binary.BigEndian.Uint32(body[0:4]) & 0xFFFFFF
+	frag := mp4TrackFragment{trackID: binary.BigEndian.Uint32(body[4:8]), base: prevEnd}
+	def := defaults[frag.trackID]
+	if flags&0x20000 != 0 {
+		frag.base = moofOffset
+	}
+
+	pos := 8
+	field := func() (uint32, bool) {
+		if pos+4 > len(body) {
+			return 0, false
+		}
+		v := binary.BigEndian.Uint32(body[pos:])
+		pos += 4
+		return v, true
+	}
+	if flags&0x1 != 0 {
+		if pos+8 > len(body) {
+			return mp4TrackFragment{}, fmt.Errorf("truncated tfhd")
+		}
+		frag.base = int64(binary.BigEndian.Uint64(body[pos:]))
+		pos += 8
+	}
+	for _, f := range []struct {
+		bit uint32
+		dst *uint32
+	}{{0x2, nil}, {0x8, &def.duration}, {0x10, &def.size}, {0x20, &def.flags}} {
+		if flags&f.bit == 0 {
+			continue
+		}
+		v, ok := field()
+		if !ok {
+			return mp4TrackFragment{}, fmt.Errorf("truncated tfhd")
+		}
+		if f.dst != nil {
+			*f.dst = v
+		}
+	}
+
+	next := frag.base
+	var runErr error
+	eachChildMP4(moof, traf.body(), traf.end(), "trun", func(trun mp4Box) bool {
+		body := moof[trun.body():trun.end()]
+		if len(body) < 8 {
+			runErr = fmt.Errorf("truncated trun")
+			return false
+		}
+		version := body[0]
+		flags := binary.BigEndian.Uint32(body[0:4]) & 0xFFFFFF
+		count := int(binary.BigEndian.Uint32(body[4:8]))
+		pos := 8
+		if flags&0x1 != 0 {
+			if len(body) < pos+4 {
+				runErr = fmt.Errorf("truncated trun")
+				return false
+			}
+			next = frag.base + int64(int32(binary.BigEndian.Uint32(body[pos:])))
+			pos += 4
+		}
+		firstFlags, hasFirstFlags := uint32(0), flags&0x4 != 0
+		if hasFirstFlags {
+			if len(body) < pos+4 {
+				runErr = fmt.Errorf("truncated trun")
+				return false
+			}
+			firstFlags = binary.BigEndian.Uint32(body[pos:])
+			pos += 4
+		}
+		fieldCount := 0
+		for _, bit := range []uint32{0x100, 0x200, 0x400, 0x800} {
+			if flags&bit != 0 {
+				fieldCount++
+			}
+		}
+		if count < 0 || len(body) < pos+count*fieldCount*4 {
+			runErr = fmt.Errorf("truncated trun")
+			return false
+		}
+		read := func() uint32 {
+			v := binary.BigEndian.Uint32(body[pos:])
+			pos += 4
+			return v
+		}
+		for i := 0; i < count; i++ {
+			s := mp4FragmentSample{offset: next, size: def.size, duration: def.duration, flags: def.flags}
+			if i == 0 && hasFirstFlags {
+				s.flags = firstFlags
+			}
+			if flags&0x100 != 0 {
+				s.duration = read()
+			}
+			if flags&0x200 != 0 {
+				s.size = read()
+			}
+			if flags&0x400 != 0 {
+				s.flags = read()
+			}
+			if flags&0x800 != 0 {
+				v := read()
+				if version == 0 && v > 1<<31-1 {
+					v = 1<<31 - 1
+				}
+				s.cto = int32(v)
+			}
+			frag.samples = append(frag.samples, s)
+			next += int64(s.size)
+		}
+		return true
+	})
+	return frag, runErr
+}
+
+// eachMP4TrackFragment parses every traf of a moof in order.
+func eachMP4TrackFragment(moof []byte, moofOffset int64, defaults map[uint32]mp4TrackDefaults, fn func(traf mp4Box, frag mp4TrackFragment) error) error {
+	root, ok := readMP4Box(moof, 0)
+	if !ok {
+		return fmt.Errorf("invalid moof box at %d", moofOffset)
+	}
+	prevEnd := moofOffset
+	var err error
+	eachChildMP4(moof, root.body(), root.end(), "traf", func(traf mp4Box) bool {
+		var frag mp4TrackFragment
+		if frag, err = parseMP4TrackFragment(moof, moofOffset, traf, prevEnd, defaults); err != nil {
+			return false
+		}
+		if n := len(frag.samples); n > 0 {
+			prevEnd = frag.samples[n-1].offset + int64(frag.samples[n-1].size)
+		}
+		err = fn(traf, frag)
+		return err == nil
+	})
+	return err
+}
+
+// defragTrack collects the samples of one track across all fragments.
+type defragTrack struct {
+	id      uint32
+	samples []mp4FragmentSample
+	chunks  []*defragChunk
+}
+
+// defragChunk is a contiguous run of samples of one track.
+type defragChunk struct {
+	count     int
+	srcOffset int64
+	size      int64
+	outOffset int64
+}
+
+// isFragmentedMP4 reports whether a file is an MP4 made of movie fragments.
+func isFragmentedMP4(filePath string) bool {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	first, err := readAtomHeaderAt(f, 0, info.Size())
+	if err != nil || (first.typ != "ftyp" && first.typ != "styp" && first.typ != "moov") {
+		return false
+	}
+	_, found, err := findAtomInRange(f, 0, info.Size(), "moof", info.Size())
+	return err == nil && found
+}
+
+// DefragmentMP4 rewrites a fragmented MP4 at inputPath as a progressive MP4
+// at outputPath (which may be the same path).
+func DefragmentMP4(inputPath, outputPath string) error {
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	fileSize := info.Size()
+
+	var ftyp, moov []byte
+	var moofs []atomHeader
+	for pos := int64(0); pos+8 <= fileSize; {
+		header, err := readAtomHeaderAt(in, pos, fileSize)
+		if err != nil {
+			return err
+		}
+		if header.size == 0 {
+			header.size = fileSize - pos
+		}
+		if header.size < header.headerSize || pos+header.size > fileSize {
+			return fmt.Errorf("invalid atom size for %s", header.typ)
+		}
+		switch header.typ {
+		case "ftyp", "moov":
+			if header.size > 256*1024*1024 {
+				return fmt.Errorf("%s box too large: %d bytes", header.typ, header.size)
+			}
+			buf := make([]byte, header.size)
+			if _, err := in.ReadAt(buf, pos); err != nil {
+				return err
+			}
+			if header.typ == "ftyp" && ftyp == nil {
+				ftyp = buf
+			} else if header.typ == "moov" && moov == nil {
+				moov = buf
+			}
+		case "moof":
+			moofs = append(moofs, header)
+		}
+		pos += header.size
+	}
+	if moov == nil {
+		return fmt.Errorf("moov atom not found")
+	}
+	if len(moofs) == 0 {
+		return errMP4NotFragmented
+	}
+	if _, err := parseCENCTracks(moov); !errors.Is(err, errMP4NotEncrypted) {
+		return fmt.Errorf("encrypted MP4 must be decrypted before defragmenting")
+	}
+	if ftyp == nil {
+		ftyp = buildM4AAtom("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	}
+
+	root, _ := readMP4Box(moov, 0)
+	tracks := map[uint32]*defragTrack{}
+	eachChildMP4(moov, root.body(), root.end(), "trak", func(trak mp4Box) bool {
+		if id, ok := mp4TrackID(moov, trak); ok {
+			tracks[id] = &defragTrack{id: id}
+		}
+		return true
+	})
+
+	defaults := parseMP4TrackDefaults(moov)
+	var chunks []*defragChunk
+	var payload int64
+	for _, header := range moofs {
+		moof := make([]byte, header.size)
+		if _, err := in.ReadAt(moof, header.offset); err != nil {
+			return err
+		}
+		err := eachMP4TrackFragment(moof, header.offset, defaults, func(_ mp4Box, frag mp4TrackFragment) error {
+			track := tracks[frag.trackID]
+			if track == nil {
+				return nil
+			}
+			var chunk *defragChunk
+			for _, s := range frag.samples {
+				if s.offset < 0 || s.offset+int64(s.size) > fileSize {
+					return fmt.Errorf("sample at %d lies outside the file", s.offset)
+				}
+				if chunk == nil || chunk.srcOffset+chunk.size != s.offset {
+					chunk = &defragChunk{srcOffset: s.offset}
+					track.chunks = append(track.chunks, chunk)
+					chunks = append(chunks, chunk)
+				}
+				chunk.count++
+				chunk.size += int64(s.size)
+				payload += int64(s.size)
+				track.samples = append(track.samples, s)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	mdatHeader := buildMP4MdatHeader(payload)
+	useCo64 := int64(len(ftyp))+int64(len(moov))+int64(len(mdatHeader))+payload > 0xFFFFFFFF-(64<<20)
+	sized, err := rebuildDefragmentedMoov(moov, tracks, useCo64)
+	if err != nil {
+		return err
+	}
+	next := int64(len(ftyp)) + int64(len(sized)) + int64(len(mdatHeader))
+	for _, chunk := range chunks {
+		chunk.outOffset = next
+		next += chunk.size
+	}
+	newMoov, err := rebuildDefragmentedMoov(moov, tracks, useCo64)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := outputPath + ".defragtmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(out, 256*1024)
+	writeErr := func() error {
+		for _, part := range [][]byte{ftyp, newMoov, mdatHeader} {
+			if _, err := w.Write(part); err != nil {
+				return err
+			}
+		}
+		for _, chunk := range chunks {
+			if _, err := io.Copy(w, io.NewSectionReader(in, chunk.srcOffset, chunk.size)); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}()
+	if closeErr := out.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write MP4: %w", writeErr)
+	}
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	GoLog("[Defrag] %s: merged %d fragments into %d chunks\n", filepath.Base(outputPath), len(moofs), len(chunks))
+	return nil
+}
+
+func mp4TrackID(moov []byte, trak mp4Box) (uint32, bool) {
+	tkhd, ok := findChildMP4(moov, trak.body(), trak.end(), "tkhd")
+	if !ok || tkhd.body()+24 > tkhd.end() {
+		return 0, false
+	}
+	if moov[tkhd.body()] == 1 {
+		return binary.BigEndian.Uint32(moov[tkhd.body()+20:]), true
+	}
+	return binary.BigEndian.Uint32(moov[tkhd.body()+12:]), true
+}
+
+func buildMP4MdatHeader(payload int64) []byte {
+	if payload+8 <= 0xFFFFFFFF {
+		header := make([]byte, 8)
+		binary.BigEndian.PutUint32(header[0:4], uint32(payload+8))
+		copy(header[4:8], "mdat")
+		return header
+	}
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint32(header[0:4], 1)
+	copy(header[4:8], "mdat")
+	binary.BigEndian.PutUint64(header[8:16], uint64(payload+16))
+	return header
+}
+
+// rebuildMP4Box rebuilds a container box, keeping the first skip bytes of its
+// body and replacing each child with edit(child); nil drops the child.
+func rebuildMP4Box(buf []byte, box mp4Box, skip int64, edit func(child mp4Box) []byte) []byte {
+	payload := append([]byte(nil), buf[box.body():box.body()+skip]...)
+	for pos := box.body() + skip; pos+8 <= box.end(); {
+		child, ok := readMP4Box(buf, pos)
+		if !ok {
+			break
+		}
+		payload = append(payload, edit(child)...)
+		pos = child.end()
+	}
+	return buildM4AAtom(box.typ, payload)
+}
+
+func mp4BoxBytes(buf []byte, box mp4Box) []byte {
+	return append([]byte(nil), buf[box.offset:box.end()]...)
+}
+
+// mp4MediaTimescale returns the timescale from an mvhd or mdhd box.
+func mp4MediaTimescale(buf []byte, box mp4Box) uint32 {
+	pos := box.body() + 12
+	if buf[box.body()] == 1 {
+		pos = box.body() + 20
+	}
+	if pos+4 > box.end() {
+		return 0
+	}
+	return binary.BigEndian.Uint32(buf[pos:])
+}
+
+// setMP4Duration writes duration into a copied mvhd, mdhd or tkhd box.
+func setMP4Duration(box []byte, duration uint64) {
+	b, ok := readMP4Box(box, 0)
+	if !ok || b.body() >= b.end() {
+		return
+	}
+	version := box[b.body()]
+	pos := b.body() + 16
+	switch {
+	case b.typ == "tkhd" && version == 1:
+		pos = b.body() + 28
+	case b.typ == "tkhd":
+		pos = b.body() + 20
+	case version == 1:
+		pos = b.body() + 24
+	}
+	if version == 1 {
+		if pos+8 <= b.end() {
+			binary.BigEndian.PutUint64(box[pos:], duration)
+		}
+		return
+	}
+	if pos+4 <= b.end() {
+		binary.BigEndian.PutUint32(box[pos:], uint32(min(duration, 0xFFFFFFFF)))
+	}
+}
+
+func rebuildDefragmentedMoov(moov []byte, tracks map[uint32]*defragTrack, useCo64 bool) ([]byte, error) {
+	root, _ := readMP4Box(moov, 0)
+	var movieScale uint64
+	if mvhd, ok := findChildMP4(moov, root.body(), root.end(), "mvhd"); ok {
+		movieScale = uint64(mp4MediaTimescale(moov, mvhd))
+	}
+
+	var movieDuration uint64
+	var trakErr error
+	newMoov := rebuildMP4Box(moov, root, 0, func(child mp4Box) []byte {
+		switch child.typ {
+		case "mvex", "pssh":
+			return nil
+		case "trak":
+			id, _ := mp4TrackID(moov, child)
+			track := tracks[id]
+			if track == nil {
+				return mp4BoxBytes(moov, child)
+			}
+			trak, duration, err := rebuildDefragmentedTrak(moov, child, track, movieScale, useCo64)
+			if err != nil {
+				trakErr = err
+			}
+			movieDuration = max(movieDuration, duration)
+			return trak
+		}
+		return mp4BoxBytes(moov, child)
+	})
+	if trakErr != nil {
+		return nil, trakErr
+	}
+
+	newRoot, _ := readMP4Box(newMoov, 0)
+	if mvhd, ok := findChildMP4(newMoov, newRoot.body(), newRoot.end(), "mvhd"); ok {
+		setMP4Duration(newMoov[mvhd.offset:mvhd.end()], movieDuration)
+	}
+	return newMoov, nil
+}
+
+// rebuildDefragmentedTrak replaces the sample table of a trak and returns it
+// with its duration in the movie timescale.
+func rebuildDefragmentedTrak(moov []byte, trak mp4Box, track *defragTrack, movieScale uint64, useCo64 bool) ([]byte, uint64, error) {
+	mdia, ok := findChildMP4(moov, trak.body(), trak.end(), "mdia")
+	if !ok {
+		return nil, 0, fmt.Errorf("track %d has no mdia", track.id)
+	}
+	mdhd, ok := findChildMP4(moov, mdia.body(), mdia.end(), "mdhd")
+	if !ok {
+		return nil, 0, fmt.Errorf("track %d has no mdhd", track.id)
+	}
+	mediaScale := uint64(mp4MediaTimescale(moov, mdhd))
+	var mediaDuration uint64
+	for _, s := range track.samples {
+		mediaDuration += uint64(s.duration)
+	}
+	movieDuration := mediaDuration
+	if mediaScale > 0 && movieScale > 0 {
+		movieDuration = mediaDuration * movieScale / mediaScale
+	}
+
+	var stblErr error
+	rebuilt := rebuildMP4Box(moov, trak, 0, func(child mp4Box) []byte {
+		switch child.typ {
+		case "tkhd":
+			out := mp4BoxBytes(moov, child)
+			setMP4Duration(out, movieDuration)
+			return out
+		case "edts":
+			return rebuildMP4Box(moov, child, 0, func(elst mp4Box) []byte {
+				out := mp4BoxBytes(moov, elst)
+				if elst.typ == "elst" {
+					fillOpenMP4Edit(out, movieDuration)
+				}
+				return out
+			})
+		case "mdia":
+			return rebuildMP4Box(moov, child, 0, func(c mp4Box) []byte {
+				switch c.typ {
+				case "mdhd":
+					out := mp4BoxBytes(moov, c)
+					setMP4Duration(out, mediaDuration)
+					return out
+				case "minf":
+					return rebuildMP4Box(moov, c, 0, func(m mp4Box) []byte {
+						if m.typ != "stbl" {
+							return mp4BoxBytes(moov, m)
+						}
+						stsd, ok := findChildMP4(moov, m.body(), m.end(), "stsd")
+						if !ok {
+							stblErr = fmt.Errorf("track %d has no stsd", track.id)
+							return nil
+						}
+						return buildM4AAtom("stbl", append(mp4BoxBytes(moov, stsd), buildDefragmentedSampleTables(track, useCo64)...))
+					})
+				}
+				return mp4BoxBytes(moov, c)
+			})
+		}
+		return mp4BoxBytes(moov, child)
+	})
+	return rebuilt, movieDuration, stblErr
+}
+
+// fillOpenMP4Edit sets the duration of a single edit left at zero, which
+// fragmented files use to mean "until the end of the fragments".
+func fillOpenMP4Edit(elst []byte, duration uint64) {
+	b, ok := readMP4Box(elst, 0)
+	if !ok || b.body()+8 > b.end() || binary.BigEndian.Uint32(elst[b.body()+4:]) != 1 {
+		return
+	}
+	pos := b.body() + 8
+	if elst[b.body()] == 1 {
+		if pos+8 <= b.end() && binary.BigEndian.Uint64(elst[pos:]) == 0 {
+			binary.BigEndian.PutUint64(elst[pos:], duration)
+		}
+		return
+	}
+	if pos+4 <= b.end() && binary.BigEndian.Uint32(elst[pos:]) == 0 {
+		binary.BigEndian.PutUint32(elst[pos:], uint32(min(duration, 0xFFFFFFFF)))
+	}
+}
+
+// buildDefragmentedSampleTables returns stts, ctts, stss, stsz, stsc and
+// stco/co64 boxes for a track.
+func buildDefragmentedSampleTables(track *defragTrack, useCo64 bool) []byte {
+	samples := track.samples
+	u32 := binary.BigEndian.AppendUint32
+
+	var sttsEntries []byte
+	sttsCount := uint32(0)
+	for i := 0; i < len(samples); {
+		j := i
+		for j < len(samples) && samples[j].duration == samples[i].duration {
+			j++
+		}
+		sttsEntries = u32(u32(sttsEntries, uint32(j-i)), samples[i].duration)
+		sttsCount++
+		i = j
+	}
+	out := buildM4AAtom("stts", append(u32([]byte{0, 0, 0, 0}, sttsCount), sttsEntries...))
+
+	hasCTO, negativeCTO := false, false
+	for _, s := range samples {
+		hasCTO = hasCTO || s.cto != 0
+		negativeCTO = negativeCTO || s.cto < 0
+	}
+	if hasCTO {
+		var entries []byte
+		count := uint32(0)
+		for i := 0; i < len(samples); {
+			j := i
+			for j < len(samples) && samples[j].cto == samples[i].cto {
+				j++
+			}
+			entries = u32(u32(entries, uint32(j-i)), uint32(samples[i].cto))
+			count++
+			i = j
+		}
+		header := []byte{0, 0, 0, 0}
+		if negativeCTO {
+			header[0] = 1
+		}
+		out = append(out, buildM4AAtom("ctts", append(u32(header, count), entries...))...)
+	}
+
+	var syncSamples []byte
+	syncCount, allSync := uint32(0), true
+	for i, s := range samples {
+		if s.flags&mp4SampleIsNonSync == 0 {
+			syncSamples = u32(syncSamples, uint32(i+1))
+			syncCount++
+		} else {
+			allSync = false
+		}
+	}
+	if !allSync {
+		out = append(out, buildM4AAtom("stss", append(u32([]byte{0, 0, 0, 0}, syncCount), syncSamples...))...)
+	}
+
+	fixedSize := uint32(0)
+	if len(samples) > 0 {
+		fixedSize = samples[0].size
+		for _, s := range samples {
+			if s.size != fixedSize {
+				fixedSize = 0
+				break
+			}
+		}
+	}
+	stsz := u32(u32([]byte{0, 0, 0, 0}, fixedSize), uint32(len(samples)))
+	if fixedSize == 0 {
+		for _, s := range samples {
+			stsz = u32(stsz, s.size)
+		}
+	}
+	out = append(out, buildM4AAtom("stsz", stsz)...)
+
+	var stscEntries []byte
+	stscCount := uint32(0)
+	for i, chunk := range track.chunks {
+		if i == 0 || chunk.count != track.chunks[i-1].count {
+			stscEntries = u32(u32(u32(stscEntries, uint32(i+1)), uint32(chunk.count)), 1)
+			stscCount++
+		}
+	}
+	out = append(out, buildM4AAtom("stsc", append(u32([]byte{0, 0, 0, 0}, stscCount), stscEntries...))...)
+
+	offsets := u32([]byte{0, 0, 0, 0}, uint32(len(track.chunks)))
+	for _, chunk := range track.chunks {
+		if useCo64 {
+			offsets = binary.BigEndian.AppendUint64(offsets, uint64(chunk.outOffset))
+		} else {
+			offsets = u32(offsets, uint32(chunk.outOffset))
+		}
+	}
+	if useCo64 {
+		return append(out, buildM4AAtom("co64", offsets)...)
+	}
+	return append(out, buildM4AAtom("stco", offsets)...)
+}
+
+// applyNativeDefragment turns a fragmented MP4 download into a progressive
+// one in place, so tagging, probing and scanning see a full sample table.
+func applyNativeDefragment(result *DownloadResult) {
+	if result.Decryption != nil || shouldSkipQualityProbe(result.FilePath) || !isFragmentedMP4(result.FilePath) {
+		return
+	}
+	if err := DefragmentMP4(result.FilePath, result.FilePath); err != nil {
+		GoLog("[Defrag] Failed to defragment %s: %v\n", result.FilePath, err)
+	}
+}
@@ -0,0 +1,155 @@
+package gobackend
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTestFragmentedMP4 wraps FLAC frames in a fragmented MP4 with one
+// fragment per frame. Sample durations come from trex, sizes from trun.
+func buildTestFragmentedMP4(streamInfo []byte, frames [][]byte, ilst []byte) []byte {
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[6:8], 1)
+	binary.BigEndian.PutUint16(entry[16:18], 2)
+	binary.BigEndian.PutUint16(entry[18:20], 16)
+	binary.BigEndian.PutUint32(entry[24:28], 44100<<16)
+	entry = append(entry, buildM4AAtom("dfLa", append([]byte{0, 0, 0, 0}, streamInfo...))...)
+	stsd := append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, buildM4AAtom("fLaC", entry)...)
+
+	timed := func(typ string) []byte {
+		body := make([]byte, 24)
+		binary.BigEndian.PutUint32(body[12:16], 44100)
+		return buildM4AAtom(typ, body)
+	}
+	empty := []byte{0, 0, 0, 0, 0, 0, 0, 0}
+	stbl := buildM4AAtom("stsd", stsd)
+	stbl = append(stbl, buildM4AAtom("stts", empty)...)
+	stbl = append(stbl, buildM4AAtom("stsz", make([]byte, 12))...)
+	stbl = append(stbl, buildM4AAtom("stsc", empty)...)
+	stbl = append(stbl, buildM4AAtom("stco", empty)...)
+	mdia := append(timed("mdhd"), buildM4AAtom("minf", buildM4AAtom("stbl", stbl))...)
+	tkhd := make([]byte, 84)
+	binary.BigEndian.PutUint32(tkhd[12:16], 1)
+	trak := buildM4AAtom("trak", append(buildM4AAtom("tkhd", tkhd), buildM4AAtom("mdia", mdia)...))
+
+	trex := make([]byte, 24)
+	binary.BigEndian.PutUint32(trex[4:8], 1)
+	binary.BigEndian.PutUint32(trex[12:16], 500)
+	moov := append(timed("mvhd"), trak...)
+	moov = append(moov, buildM4AAtom("mvex", buildM4AAtom("trex", trex))...)
+	meta := buildM4AAtom("meta", append([]byte{0, 0, 0, 0}, buildM4AAtom("ilst", ilst)...))
+	moov = append(moov, buildM4AAtom("udta", meta)...)
+
+	out := buildM4AAtom("ftyp", []byte("iso6\x00\x00\x00\x00"))
+	out = append(out, buildM4AAtom("moov", moov)...)
+	for _, frame := range frames {
+		buildMoof := func(dataOffset uint32) []byte {
+			trun := binary.BigEndian.AppendUint32([]byte{0, 0, 2, 1}, 1)
+			trun = binary.BigEndian.AppendUint32(trun, dataOffset)
+			trun = binary.BigEndian.AppendUint32(trun, uint32(len(frame)))
+			traf := append(buildM4AAtom("tfhd", []byte{0, 2, 0, 0, 0, 0, 0, 1}), buildM4AAtom("trun", trun)...)
+			return buildM4AAtom("moof", append(buildM4AAtom("mfhd", make([]byte, 8)), buildM4AAtom("traf", traf)...))
+		}
+		out = append(out, buildMoof(uint32(len(buildMoof(0))+8))...)
+		out = append(out, buildM4AAtom("mdat", frame)...)
+	}
+	return out
+}
+
+func TestDefragmentMP4BuildsSampleTable(t *testing.T) {
+	dir := t.TempDir()
+	streamInfo, frames := testCENCFrames()
+	input := filepath.Join(dir, "frag.m4a")
+	ilst := buildM4ATextTag("\xa9nam", "Fragmented")
+	if err := os.WriteFile(input, buildTestFragmentedMP4(streamInfo, frames, ilst), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !isFragmentedMP4(input) {
+		t.Fatal("input not detected as fragmented")
+	}
+
+	output := filepath.Join(dir, "plain.m4a")
+	if err := DefragmentMP4(input, output); err != nil {
+		t.Fatalf("DefragmentMP4: %v", err)
+	}
+	if isFragmentedMP4(output) {
+		t.Fatal("output is still fragmented")
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moov, ok := findChildMP4(data, 0, int64(len(data)), "moov")
+	if !ok {
+		t.Fatal("moov not found")
+	}
+	moovBuf := data[moov.offset:moov.end()]
+	root, _ := readMP4Box(moovBuf, 0)
+	if _, ok := findChildMP4(moovBuf, root.body(), root.end(), "mvex"); ok {
+		t.Fatal("mvex left in moov")
+	}
+	mvhd, _ := findChildMP4(moovBuf, root.body(), root.end(), "mvhd")
+	if duration := binary.BigEndian.Uint32(moovBuf[mvhd.body()+16:]); duration != 1500 {
+		t.Fatalf("mvhd duration = %d", duration)
+	}
+	trak, _ := findChildMP4(moovBuf, root.body(), root.end(), "trak")
+	stbl, ok := findMP4Path(moovBuf, trak, "mdia", "minf", "stbl")
+	if !ok {
+		t.Fatal("stbl not found")
+	}
+	offsets, sizes, err := parseMP4SampleTable(moovBuf, stbl)
+	if err != nil || len(offsets) != len(frames) {
+		t.Fatalf("sample table = %v/%v", offsets, err)
+	}
+	for i, frame := range frames {
+		if got := data[offsets[i] : offsets[i]+int64(sizes[i])]; string(got) != string(frame) {
+			t.Fatalf("sample %d does not match", i)
+		}
+	}
+	_, tags := readTestTagsJSON(t, output)
+	assertTagValues(t, tags, "TITLE", "Fragmented")
+
+	// Remuxing and tag writing both accept the fragmented original.
+	flacPath := filepath.Join(dir, "frag.flac")
+	if err := RemuxMP4FLACToFLAC(input, flacPath); err != nil {
+		t.Fatalf("RemuxMP4FLACToFLAC: %v", err)
+	}
+	if r := VerifyFLACFile(flacPath); !r.Valid || r.FramesChecked != len(frames) {
+		t.Fatalf("remuxed file = %+v", r)
+	}
+	if err := WriteM4ATags(input, map[string]string{"title": "Retagged", "comment": string(make([]byte, 5000))}); err != nil {
+		t.Fatalf("WriteM4ATags: %v", err)
+	}
+	if isFragmentedMP4(input) {
+		t.Fatal("tag write left the file fragmented")
+	}
+	_, tags = readTestTagsJSON(t, input)
+	assertTagValues(t, tags, "TITLE", "Retagged")
+}
+
+func TestDefragmentMP4AfterDecryption(t *testing.T) {
+	dir := t.TempDir()
+	streamInfo, frames := testCENCFrames()
+	path := filepath.Join(dir, "song.m4a")
+	encrypted, _ := buildTestCENCFragmentedMP4(streamInfo, frames)
+	if err := os.WriteFile(path, encrypted, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := DefragmentMP4(path, path); err == nil {
+		t.Fatal("expected encrypted input to be refused")
+	}
+
+	result, _, _ := normalizeExtensionDownloadResult(&ExtDownloadResult{
+		FilePath:   path,
+		Decryption: &DownloadDecryptionInfo{Key: hex.EncodeToString(testCENCKey), OutputExtension: "flac"},
+	})
+	if result.FilePath != filepath.Join(dir, "song.flac") {
+		t.Fatalf("result = %+v", result)
+	}
+	if r := VerifyFLACFile(result.FilePath); !r.Valid || !r.MD5Checked {
+		t.Fatalf("decrypted file = %+v", r)
+	}
+}
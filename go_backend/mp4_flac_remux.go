@@ -0,0 +1,406 @@
+package gobackend
+
+// Native remux of FLAC-in-MP4 (an "fLaC" sample entry with a dfLa box) to a
+// plain .flac stream.
+//
+// MP4 FLAC samples are complete FLAC frames, so the remux only has to emit
+// "fLaC", the metadata blocks stored in dfLa, and the samples in decode
+// order. Sample positions come from the sample table (stsz for sizes, stsc
+// for samples per chunk, stco/co64 for chunk offsets). Tags and cover art
+// from the ilst are carried over as a Vorbis comment and a PICTURE block.
+// Fragmented files are defragmented to a temporary file first.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-flac/flacvorbis/v2"
+	"github.com/go-flac/go-flac/v2"
+)
+
+// mp4FLACTrack is what the remux needs from the FLAC track of a movie.
+type mp4FLACTrack struct {
+	metadataBlocks []byte  // dfLa metadata blocks, including their headers
+	sampleOffsets  []int64 // absolute file offset of every sample
+	sampleSizes    []uint32
+}
+
+// readMP4Moov loads the top-level moov box of an MP4 file into memory.
+func readMP4Moov(f *os.File, fileSize int64) ([]byte, error) {
+	for pos := int64(0); pos+8 <= fileSize; {
+		header, err := readAtomHeaderAt(f, pos, fileSize)
+		if err != nil {
+			return nil, err
+		}
+		if header.size == 0 {
+			header.size = fileSize - pos
+		}
+		if header.size < header.headerSize || pos+header.size > fileSize {
+			return nil, fmt.Errorf("invalid %q box at offset %d", header.typ, pos)
+		}
+		if header.typ == "moov" {
+			if header.size > 256*1024*1024 {
+				return nil, fmt.Errorf("moov box too large: %d bytes", header.size)
+			}
+			moov := make([]byte, header.size)
+			if _, err := f.ReadAt(moov, pos); err != nil {
+				return nil, err
+			}
+			return moov, nil
+		}
+		pos += header.size
+	}
+	return nil, fmt.Errorf("moov atom not found")
+}
+
+// findMP4FLACTrack locates the first track whose sample entry is fLaC and
+// resolves its sample table.
+func findMP4FLACTrack(moov []byte) (*mp4FLACTrack, error) {
+	root, ok := readMP4Box(moov, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid moov box")
+	}
+	if _, fragmented := findChildMP4(moov, root.body(), root.end(), "mvex"); fragmented {
+		return nil, fmt.Errorf("fragmented MP4 is not supported")
+	}
+
+	var track *mp4FLACTrack
+	var trackErr error
+	eachChildMP4(moov, root.body(), root.end(), "trak", func(trak mp4Box) bool {
+		stbl, ok := findMP4Path(moov, trak, "mdia", "minf", "stbl")
+		if !ok {
+			return true
+		}
+		stsd, ok := findChildMP4(moov, stbl.body(), stbl.end(), "stsd")
+		if !ok || stsd.body()+8 > stsd.end() {
+			return true
+		}
+		entry, ok := findChildMP4(moov, stsd.body()+8, stsd.end(), "fLaC")
+		if !ok {
+			return true
+		}
+		track, trackErr = parseMP4FLACTrack(moov, entry, stbl)
+		return false
+	})
+	if trackErr != nil {
+		return nil, trackErr
+	}
+	if track == nil {
+		return nil, fmt.Errorf("no FLAC track found")
+	}
+	return track, nil
+}
+
+func findMP4Path(data []byte, parent mp4Box, path ...string) (mp4Box, bool) {
+	box := parent
+	for _, typ := range path {
+		child, ok := findChildMP4(data, box.body(), box.end(), typ)
+		if !ok {
+			return mp4Box{}, false
+		}
+		box = child
+	}
+	return box, true
+}
+
+func parseMP4FLACTrack(moov []byte, entry, stbl mp4Box) (*mp4FLACTrack, error) {
+	hdrLen, ok := audioSampleEntryHeaderLen(moov, entry)
+	if !ok {
+		return nil, fmt.Errorf("invalid fLaC sample entry")
+	}
+	dfLa, ok := findChildMP4(moov, entry.body()+hdrLen, entry.end(), "dfLa")
+	if !ok || dfLa.body()+4 > dfLa.end() {
+		return nil, fmt.Errorf("dfLa box not found")
+	}
+	blocks := moov[dfLa.body()+4 : dfLa.end()] // skip version/flags
+	if _, _, _, ok := parseMP4FLACSpecificConfig(moov[dfLa.body():dfLa.end()]); !ok {
+		return nil, fmt.Errorf("dfLa box has no STREAMINFO")
+	}
+
+	offsets, sizes, err := parseMP4SampleTable(moov, stbl)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mp4FLACTrack{
+		metadataBlocks: blocks,
+		sampleOffsets:  offsets,
+		sampleSizes:    sizes,
+	}, nil
+}
+
+// parseMP4SampleTable resolves the absolute file offset and size of every
+// sample described by an stbl box.
+func parseMP4SampleTable(moov []byte, stbl mp4Box) ([]int64, []uint32, error) {
+	sizes, err := parseMP4SampleSizes(moov, stbl)
+	if err != nil {
+		return nil, nil, err
+	}
+	chunkOffsets, err := parseMP4ChunkOffsets(moov, stbl)
+	if err != nil {
+		return nil, nil, err
+	}
+	stsc, ok := findChildMP4(moov, stbl.body(), stbl.end(), "stsc")
+	if !ok || stsc.body()+8 > stsc.end() {
+		return nil, nil, fmt.Errorf("stsc box not found")
+	}
+	entryCount := int64(binary.BigEndian.Uint32(moov[stsc.body()+4:]))
+	if stsc.body()+8+entryCount*12 > stsc.end() {
+		return nil, nil, fmt.Errorf("truncated stsc box")
+	}
+
+	offsets := make([]int64, 0, len(sizes))
+	sample := 0
+	for i := int64(0); i < entryCount && sample < len(sizes); i++ {
+		e := moov[stsc.body()+8+i*12:]
+		firstChunk := int64(binary.BigEndian.Uint32(e[0:4]))
+		perChunk := int(binary.BigEndian.Uint32(e[4:8]))
+		lastChunk := int64(len(chunkOffsets)) // 1-based, inclusive
+		if i+1 < entryCount {
+			lastChunk = int64(binary.BigEndian.Uint32(moov[stsc.body()+8+(i+1)*12:])) - 1
+		}
+		if firstChunk < 1 || lastChunk > int64(len(chunkOffsets)) {
+			return nil, nil, fmt.Errorf("stsc references missing chunk")
+		}
+		for chunk := firstChunk; chunk <= lastChunk && sample < len(sizes); chunk++ {
+			pos := chunkOffsets[chunk-1]
+			for j := 0; j < perChunk && sample < len(sizes); j++ {
+				offsets = append(offsets, pos)
+				pos += int64(sizes[sample])
+				sample++
+			}
+		}
+	}
+	if len(offsets) != len(sizes) {
+		return nil, nil, fmt.Errorf("sample table covers %d of %d samples", len(offsets), len(sizes))
+	}
+	return offsets, sizes, nil
+}
+
+func parseMP4SampleSizes(moov []byte, stbl mp4Box) ([]uint32, error) {
+	stsz, ok := findChildMP4(moov, stbl.body(), stbl.end(), "stsz")
+	if !ok || stsz.body()+12 > stsz.end() {
+		return nil, fmt.Errorf("stsz box not found")
+	}
+	body := moov[stsz.body():stsz.end()]
+	fixed := binary.BigEndian.Uint32(body[4:8])
+	count := int64(binary.BigEndian.Uint32(body[8:12]))
+	if fixed == 0 && 12+count*4 > int64(len(body)) {
+		return nil, fmt.Errorf("truncated stsz box")
+	}
+	sizes := make([]uint32, count)
+	for i := range sizes {
+		if fixed != 0 {
+			sizes[i] = fixed
+		} else {
+			sizes[i] = binary.BigEndian.Uint32(body[12+i*4:])
+		}
+	}
+	return sizes, nil
+}
+
+func parseMP4ChunkOffsets(moov []byte, stbl mp4Box) ([]int64, error) {
+	box, ok := findChildMP4(moov, stbl.body(), stbl.end(), "stco")
+	width := int64(4)
+	if !ok {
+		box, ok = findChildMP4(moov, stbl.body(), stbl.end(), "co64")
+		width = 8
+	}
+	if !ok || box.body()+8 > box.end() {
+		return nil, fmt.Errorf("chunk offset box not found")
+	}
+	body := moov[box.body():box.end()]
+	count := int64(binary.BigEndian.Uint32(body[4:8]))
+	if 8+count*width > int64(len(body)) {
+		return nil, fmt.Errorf("truncated %s box", box.typ)
+	}
+	offsets := make([]int64, count)
+	for i := range offsets {
+		if width == 4 {
+			offsets[i] = int64(binary.BigEndian.Uint32(body[8+int64(i)*4:]))
+		} else {
+			offsets[i] = int64(binary.BigEndian.Uint64(body[8+int64(i)*8:]))
+		}
+	}
+	return offsets, nil
+}
+
+// buildRemuxFLACHeader returns "fLaC" followed by the dfLa metadata blocks
+// (minus any tag or picture blocks) and the supplied extra blocks, with the
+// last-block flag set on the final one.
+func buildRemuxFLACHeader(dfLaBlocks []byte, extra []flac.MetaDataBlock) ([]byte, error) {
+	var blocks []flac.MetaDataBlock
+	for pos := 0; pos < len(dfLaBlocks); {
+		if pos+4 > len(dfLaBlocks) {
+			return nil, fmt.Errorf("truncated dfLa metadata")
+		}
+		typ := flac.BlockType(dfLaBlocks[pos] & 0x7F)
+		length := int(dfLaBlocks[pos+1])<<16 | int(dfLaBlocks[pos+2])<<8 | int(dfLaBlocks[pos+3])
+		if pos+4+length > len(dfLaBlocks) {
+			return nil, fmt.Errorf("truncated dfLa metadata")
+		}
+		if typ != flac.VorbisComment && typ != flac.Picture && typ != flac.Padding {
+			blocks = append(blocks, flac.MetaDataBlock{Type: typ, Data: dfLaBlocks[pos+4 : pos+4+length]})
+		}
+		last := dfLaBlocks[pos]&0x80 != 0
+		pos += 4 + length
+		if last {
+			break
+		}
+	}
+	if len(blocks) == 0 || blocks[0].Type != flac.StreamInfo {
+		return nil, fmt.Errorf("dfLa metadata does not start with STREAMINFO")
+	}
+	blocks = append(blocks, extra...)
+
+	out := []byte("fLaC")
+	for i := range blocks {
+		out = append(out, blocks[i].Marshal(i == len(blocks)-1)...)
+	}
+	return out, nil
+}
+
+// mp4FLACRemuxTagBlocks converts the ilst tags and cover of an MP4 file to
+// FLAC VORBIS_COMMENT and PICTURE blocks.
+func mp4FLACRemuxTagBlocks(inputPath string) []flac.MetaDataBlock {
+	cmt := flacvorbis.New()
+	if tags, err := readM4ATagMap(inputPath); err == nil {
+		splitTagIndexPairs(tags)
+		applyVorbisTagMap(cmt, tags)
+	}
+	blocks := []flac.MetaDataBlock{cmt.Marshal()}
+	if cover, err := extractCoverFromM4A(inputPath); err == nil && len(cover) > 0 {
+		if picture, err := buildPictureBlock("", cover); err == nil {
+			blocks = append(blocks, picture)
+		}
+	}
+	// Leave room for later tag edits without rewriting the audio.
+	blocks = append(blocks, flac.MetaDataBlock{Type: flac.Padding, Data: make([]byte, 8192)})
+	return blocks
+}
+
+// RemuxMP4FLACToFLAC writes the FLAC track of an MP4/M4A file to outputPath
+// as a native FLAC stream, carrying over tags and cover art.
+func RemuxMP4FLACToFLAC(inputPath, outputPath string) error {
+	if isFragmentedMP4(inputPath) {
+		defragPath := outputPath + ".defrag.m4a"
+		if err := DefragmentMP4(inputPath, defragPath); err != nil {
+			return err
+		}
+		defer os.Remove(defragPath)
+		inputPath = defragPath
+	}
+
+	in, err := os.Open(inputPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	moov, err := readMP4Moov(in, info.Size())
+	if err != nil {
+		return err
+	}
+	track, err := findMP4FLACTrack(moov)
+	if err != nil {
+		return err
+	}
+	for i, offset := range track.sampleOffsets {
+		if offset < 0 || offset+int64(track.sampleSizes[i]) > info.Size() {
+			return fmt.Errorf("sample %d lies outside the file", i)
+		}
+	}
+	header, err := buildRemuxFLACHeader(track.metadataBlocks, mp4FLACRemuxTagBlocks(inputPath))
+	if err != nil {
+		return err
+	}
+
+	tmpPath := outputPath + ".remuxtmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(out, 256*1024)
+	writeErr := func() error {
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		for i, offset := range track.sampleOffsets {
+			if _, err := io.Copy(w, io.NewSectionReader(in, offset, int64(track.sampleSizes[i]))); err != nil {
+				return err
+			}
+		}
+		return w.Flush()
+	}()
+	if closeErr := out.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write FLAC: %w", writeErr)
+	}
+	if err := os.Rename(tmpPath, outputPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	GoLog("[Remux] %s -> %s: %d frames\n", filepath.Base(inputPath), filepath.Base(outputPath), len(track.sampleOffsets))
+	return nil
+}
+
+// isMP4FLACFile reports whether a file is an MP4 container with a FLAC track.
+func isMP4FLACFile(filePath string) bool {
+	quality, err := GetM4AQuality(filePath)
+	return err == nil && strings.EqualFold(quality.Codec, "flac")
+}
+
+// remuxDownloadedMP4FLAC converts a downloaded FLAC-in-MP4 file to .flac next
+// to it and removes the original. It returns the new path.
+func remuxDownloadedMP4FLAC(filePath string) (string, error) {
+	outputPath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".flac"
+	if err := RemuxMP4FLACToFLAC(filePath, outputPath); err != nil {
+		return "", err
+	}
+	if outputPath == filePath {
+		// An MP4 saved under a .flac name is replaced in place.
+		return outputPath, nil
+	}
+	if err := os.Remove(filePath); err != nil {
+		GoLog("[Remux] Warning: failed to remove %s: %v\n", filePath, err)
+	}
+	return outputPath, nil
+}
+
+// applyNativeFLACRemux replaces a FLAC-in-MP4 download that asks for
+// container conversion with a native .flac, so the app does not need FFmpeg
+// for it. Encrypted downloads are left alone; they must be decrypted first.
+func applyNativeFLACRemux(result *DownloadResult) {
+	if !result.RequiresContainerConversion || result.Decryption != nil || shouldSkipQualityProbe(result.FilePath) {
+		return
+	}
+	switch strings.ToLower(filepath.Ext(result.FilePath)) {
+	case ".m4a", ".mp4", ".flac":
+	default:
+		return
+	}
+	if !isMP4FLACFile(result.FilePath) {
+		return
+	}
+	outputPath, err := remuxDownloadedMP4FLAC(result.FilePath)
+	if err != nil {
+		GoLog("[Remux] Native FLAC remux failed, keeping %s: %v\n", result.FilePath, err)
+		return
+	}
+	result.FilePath = outputPath
+	result.ActualExtension = ".flac"
+	result.ActualContainer = "flac"
+	result.RequiresContainerConversion = false
+}
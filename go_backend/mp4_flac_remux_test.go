@@ -0,0 +1,119 @@
+package gobackend
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// buildTestMP4FLAC wraps the frames of stream (a native FLAC built by
+// buildTestVerifyFLAC) in an MP4 with an fLaC sample entry. The frames are
+// split over two chunks with a gap between them, so the remux has to follow
+// stsc/stco rather than copy mdat as is.
+func buildTestMP4FLAC(stream []byte, offsets []int, ilst []byte) []byte {
+	streamInfo := stream[4:offsets[0]]
+	var frames [][]byte
+	for i, start := range offsets {
+		end := len(stream)
+		if i+1 < len(offsets) {
+			end = offsets[i+1]
+		}
+		frames = append(frames, stream[start:end])
+	}
+
+	entry := make([]byte, 28)
+	binary.BigEndian.PutUint16(entry[6:8], 1)
+	binary.BigEndian.PutUint16(entry[16:18], 2)
+	binary.BigEndian.PutUint16(entry[18:20], 16)
+	binary.BigEndian.PutUint32(entry[24:28], 44100<<16)
+	entry = append(entry, buildM4AAtom("dfLa", append([]byte{0, 0, 0, 0}, streamInfo...))...)
+	stsd := append([]byte{0, 0, 0, 0, 0, 0, 0, 1}, buildM4AAtom("fLaC", entry)...)
+
+	stsz := make([]byte, 12, 12+4*len(frames))
+	binary.BigEndian.PutUint32(stsz[8:12], uint32(len(frames)))
+	for _, frame := range frames {
+		stsz = binary.BigEndian.AppendUint32(stsz, uint32(len(frame)))
+	}
+	// Chunk 1 holds two samples, chunk 2 the rest.
+	stsc := []byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 1}
+
+	gap := []byte("GAP!")
+	mdatPayload := append(append(append([]byte{}, frames[0]...), frames[1]...), gap...)
+	for _, frame := range frames[2:] {
+		mdatPayload = append(mdatPayload, frame...)
+	}
+
+	ftyp := buildM4AAtom("ftyp", []byte("M4A \x00\x00\x00\x00"))
+	buildMoov := func(mdatStart uint32) []byte {
+		stco := []byte{0, 0, 0, 0, 0, 0, 0, 2}
+		stco = binary.BigEndian.AppendUint32(stco, mdatStart)
+		stco = binary.BigEndian.AppendUint32(stco, mdatStart+uint32(len(frames[0])+len(frames[1])+len(gap)))
+		stbl := buildM4AAtom("stsd", stsd)
+		stbl = append(stbl, buildM4AAtom("stsz", stsz)...)
+		stbl = append(stbl, buildM4AAtom("stsc", stsc)...)
+		stbl = append(stbl, buildM4AAtom("stco", stco)...)
+		trak := buildM4AAtom("trak", buildM4AAtom("mdia", buildM4AAtom("minf", buildM4AAtom("stbl", stbl))))
+		meta := buildM4AAtom("meta", append([]byte{0, 0, 0, 0}, buildM4AAtom("ilst", ilst)...))
+		return buildM4AAtom("moov", append(trak, buildM4AAtom("udta", meta)...))
+	}
+	moovLen := len(buildMoov(0))
+	out := append(append([]byte{}, ftyp...), buildMoov(uint32(len(ftyp)+moovLen+8))...)
+	return append(out, buildM4AAtom("mdat", mdatPayload)...)
+}
+
+func TestRemuxMP4FLACToFLAC(t *testing.T) {
+	dir := t.TempDir()
+	stream, offsets := buildTestVerifyFLAC()
+	ilst := append(buildM4ATextTag("\xa9nam", "Remuxed"), buildM4AIndexTag("trkn", 3, 12)...)
+	input := filepath.Join(dir, "song.m4a")
+	if err := os.WriteFile(input, buildTestMP4FLAC(stream, offsets, ilst), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out.flac")
+	if err := RemuxMP4FLACToFLAC(input, output); err != nil {
+		t.Fatalf("RemuxMP4FLACToFLAC: %v", err)
+	}
+	if r := VerifyFLACFile(output); !r.Valid || !r.MD5Checked || r.FramesChecked != 3 {
+		t.Fatalf("remuxed file = %+v", r)
+	}
+	_, tags := readTestTagsJSON(t, output)
+	assertTagValues(t, tags, "TITLE", "Remuxed")
+	assertTagValues(t, tags, "TRACKNUMBER", "3")
+	assertTagValues(t, tags, "TRACKTOTAL", "12")
+
+	if err := RemuxMP4FLACToFLAC(output, filepath.Join(dir, "x.flac")); err == nil {
+		t.Fatal("expected an error for a non-MP4 input")
+	}
+}
+
+func TestNormalizeExtensionDownloadResultRemuxesMP4FLAC(t *testing.T) {
+	dir := t.TempDir()
+	stream, offsets := buildTestVerifyFLAC()
+	input := filepath.Join(dir, "song.m4a")
+	if err := os.WriteFile(input, buildTestMP4FLAC(stream, offsets, nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := &ExtDownloadResult{
+		FilePath:                    input,
+		ActualExtension:             ".m4a",
+		RequiresContainerConversion: true,
+	}
+	result, _, _ := normalizeExtensionDownloadResult(raw)
+	if result.FilePath != filepath.Join(dir, "song.flac") || result.RequiresContainerConversion || result.ActualExtension != ".flac" {
+		t.Fatalf("result = %+v", result)
+	}
+	var resp DownloadResponse
+	overlayExtensionDownloadMetadata(&resp, raw)
+	if resp.RequiresContainerConversion || resp.ActualExtension != ".flac" {
+		t.Fatalf("overlaid response = %+v", resp)
+	}
+	if _, err := os.Stat(input); !os.IsNotExist(err) {
+		t.Fatalf("source still present: %v", err)
+	}
+	if result.BitDepth != 16 || result.SampleRate != 44100 {
+		t.Fatalf("quality = %d/%d", result.BitDepth, result.SampleRate)
+	}
+}
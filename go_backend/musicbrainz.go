@@ -0,0 +1,314 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MusicBrainz allows one request per second per client.
+var musicBrainzRateLimiter = NewRateLimiter(1, time.Second)
+
+// musicBrainzContact goes in the User-Agent; MusicBrainz asks clients to
+// identify themselves with a name, version and contact.
+const musicBrainzContact = "https://github.com/zarzet/SpotiFLAC-Mobile"
+
+func musicBrainzUserAgent() string {
+	return appUserAgent() + " ( " + musicBrainzContact + " )"
+}
+
+// MusicBrainzMatch is the recording, release and release group an ISRC
+// resolved to, with the release details the standard tags carry.
+type MusicBrainzMatch struct {
+	RecordingID    string   `json:"recording_id"`
+	ReleaseID      string   `json:"release_id"`
+	ReleaseTrackID string   `json:"release_track_id,omitempty"`
+	ReleaseGroupID string   `json:"release_group_id,omitempty"`
+	ArtistIDs      []string `json:"artist_ids,omitempty"`
+	AlbumArtistIDs []string `json:"album_artist_ids,omitempty"`
+	ReleaseTitle   string   `json:"release_title,omitempty"`
+	ReleaseDate    string   `json:"release_date,omitempty"`
+	OriginalDate   string   `json:"original_date,omitempty"`
+	ReleaseCountry string   `json:"release_country,omitempty"`
+	ReleaseStatus  string   `json:"release_status,omitempty"`
+	ReleaseType    string   `json:"release_type,omitempty"`
+	Barcode        string   `json:"barcode,omitempty"`
+	CatalogNumber  string   `json:"catalog_number,omitempty"`
+	Media          string   `json:"media,omitempty"`
+}
+
+type musicBrainzReleaseGroup struct {
+	ID               string `json:"id"`
+	PrimaryType      string `json:"primary-type"`
+	FirstReleaseDate string `json:"first-release-date"`
+}
+
+type musicBrainzSearchRecording struct {
+	ID               string                    `json:"id"`
+	FirstReleaseDate string                    `json:"first-release-date"`
+	ArtistCredit     []musicBrainzArtistCredit `json:"artist-credit"`
+	Releases         []musicBrainzRelease      `json:"releases"`
+}
+
+type musicBrainzRecordingSearchResponse struct {
+	Recordings []musicBrainzSearchRecording `json:"recordings"`
+}
+
+type musicBrainzReleaseLookup struct {
+	ID           string                    `json:"id"`
+	Title        string                    `json:"title"`
+	Status       string                    `json:"status"`
+	Date         string                    `json:"date"`
+	Country      string                    `json:"country"`
+	Barcode      string                    `json:"barcode"`
+	ArtistCredit []musicBrainzArtistCredit `json:"artist-credit"`
+	ReleaseGroup musicBrainzReleaseGroup   `json:"release-group"`
+	LabelInfo    []struct {
+		CatalogNumber string `json:"catalog-number"`
+	} `json:"label-info"`
+	Media []struct {
+		Format string `json:"format"`
+		Tracks []struct {
+			ID        string `json:"id"`
+			Recording struct {
+				ID string `json:"id"`
+			} `json:"recording"`
+		} `json:"tracks"`
+	} `json:"media"`
+}
+
+// musicBrainzGetJSON fetches a MusicBrainz API path under the shared rate
+// limit, retrying transient failures.
+func musicBrainzGetJSON(path string, query url.Values, out interface{}) error {
+	query.Set("fmt", "json")
+	reqURL := fmt.Sprintf("%s/%s?%s", musicBrainzAPIBase, path, query.Encode())
+
+	req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent())
+
+	client := NewMetadataHTTPClient(10 * time.Second)
+	var resp *http.Response
+	var lastErr error
+	for attempt := 0; attempt < 3; attempt++ {
+		musicBrainzRateLimiter.WaitForSlot()
+		resp, lastErr = client.Do(req)
+		if lastErr == nil && resp.StatusCode == http.StatusOK {
+			break
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if attempt < 2 {
+			time.Sleep(2 * time.Second)
+		}
+	}
+
+	if lastErr != nil {
+		return lastErr
+	}
+	if resp == nil {
+		return fmt.Errorf("MusicBrainz request failed without response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return fmt.Errorf("MusicBrainz API returned status: %d", resp.StatusCode)
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+var fetchMusicBrainzMatchByISRC = FetchMusicBrainzMatchByISRC
+
+// FetchMusicBrainzMatchByISRC resolves an ISRC to a recording and picks the
+// release that best fits the album context, then looks that release up for
+// its group, labels and media.
+func FetchMusicBrainzMatchByISRC(isrc, albumName string, totalTracks int) (*MusicBrainzMatch, error) {
+	normalizedISRC := strings.ToUpper(strings.TrimSpace(isrc))
+	if normalizedISRC == "" {
+		return nil, fmt.Errorf("no ISRC provided")
+	}
+
+	var search musicBrainzRecordingSearchResponse
+	if err := musicBrainzGetJSON("recording", url.Values{"query": {"isrc:" + normalizedISRC}}, &search); err != nil {
+		return nil, err
+	}
+
+	recording, release := selectMusicBrainzRelease(search.Recordings, albumName, totalTracks)
+	if recording == nil || release == nil {
+		return nil, fmt.Errorf("no MusicBrainz release found for ISRC: %s", normalizedISRC)
+	}
+
+	var lookup musicBrainzReleaseLookup
+	query := url.Values{"inc": {"recordings labels artist-credits release-groups"}}
+	if err := musicBrainzGetJSON("release/"+url.PathEscape(release.ID), query, &lookup); err != nil {
+		return nil, err
+	}
+
+	match := buildMusicBrainzMatch(recording, &lookup)
+	GoLog("[MusicBrainz] ISRC %s matched recording %s on release %s (%s)\n", normalizedISRC, match.RecordingID, match.ReleaseID, match.ReleaseTitle)
+	return match, nil
+}
+
+// selectMusicBrainzRelease scores every release of every recording for the
+// ISRC: an exact album title counts most, then official status and a
+// matching track count. Ties go to the earliest release.
+func selectMusicBrainzRelease(recordings []musicBrainzSearchRecording, albumName string, totalTracks int) (*musicBrainzSearchRecording, *musicBrainzRelease) {
+	type candidate struct {
+		recording *musicBrainzSearchRecording
+		release   *musicBrainzRelease
+		score     int
+	}
+
+	album := strings.ToLower(strings.TrimSpace(albumName))
+	var candidates []candidate
+	for i := range recordings {
+		for j := range recordings[i].Releases {
+			release := &recordings[i].Releases[j]
+			if release.ID == "" {
+				continue
+			}
+			score := 0
+			title := strings.ToLower(strings.TrimSpace(release.Title))
+			switch {
+			case album == "":
+			case title == album:
+				score += 4
+			case strings.Contains(title, album) || strings.Contains(album, title):
+				score += 2
+			}
+			if strings.EqualFold(release.Status, "Official") {
+				score += 2
+			}
+			if totalTracks > 0 && release.TrackCount == totalTracks {
+				score++
+			}
+			candidates = append(candidates, candidate{recording: &recordings[i], release: release, score: score})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		di, dj := candidates[i].release.Date, candidates[j].release.Date
+		return di != "" && (dj == "" || di < dj)
+	})
+	return candidates[0].recording, candidates[0].release
+}
+
+func buildMusicBrainzMatch(recording *musicBrainzSearchRecording, release *musicBrainzReleaseLookup) *MusicBrainzMatch {
+	match := &MusicBrainzMatch{
+		RecordingID:    recording.ID,
+		ReleaseID:      release.ID,
+		ReleaseGroupID: release.ReleaseGroup.ID,
+		ArtistIDs:      musicBrainzArtistIDs(recording.ArtistCredit),
+		AlbumArtistIDs: musicBrainzArtistIDs(release.ArtistCredit),
+		ReleaseTitle:   release.Title,
+		ReleaseDate:    release.Date,
+		OriginalDate:   firstNonEmptyString(release.ReleaseGroup.FirstReleaseDate, recording.FirstReleaseDate),
+		ReleaseCountry: release.Country,
+		ReleaseStatus:  strings.ToLower(release.Status),
+		ReleaseType:    strings.ToLower(release.ReleaseGroup.PrimaryType),
+		Barcode:        strings.TrimSpace(release.Barcode),
+	}
+	for _, info := range release.LabelInfo {
+		if catalog := strings.TrimSpace(info.CatalogNumber); catalog != "" {
+			match.CatalogNumber = catalog
+			break
+		}
+	}
+	for _, medium := range release.Media {
+		for _, track := range medium.Tracks {
+			if track.Recording.ID == recording.ID {
+				match.ReleaseTrackID = track.ID
+				match.Media = medium.Format
+				break
+			}
+		}
+		if match.ReleaseTrackID != "" {
+			break
+		}
+	}
+	if match.Media == "" && len(release.Media) > 0 {
+		match.Media = release.Media[0].Format
+	}
+	return match
+}
+
+func musicBrainzArtistIDs(credits []musicBrainzArtistCredit) []string {
+	var ids []string
+	for _, credit := range credits {
+		if id := strings.TrimSpace(credit.Artist.ID); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// musicBrainzTagEdits lists the standard tags for a match under their
+// canonical names; writeTagMap maps them per container.
+func musicBrainzTagEdits(match *MusicBrainzMatch) tagMap {
+	edits := tagMap{}
+	if match == nil {
+		return edits
+	}
+	edits.add("MUSICBRAINZ_TRACKID", match.RecordingID)
+	edits.add("MUSICBRAINZ_RELEASETRACKID", match.ReleaseTrackID)
+	edits.add("MUSICBRAINZ_ALBUMID", match.ReleaseID)
+	edits.add("MUSICBRAINZ_RELEASEGROUPID", match.ReleaseGroupID)
+	edits.add("MUSICBRAINZ_ARTISTID", match.ArtistIDs...)
+	edits.add("MUSICBRAINZ_ALBUMARTISTID", match.AlbumArtistIDs...)
+	edits.add("ORIGINALDATE", match.OriginalDate)
+	edits.add("RELEASECOUNTRY", match.ReleaseCountry)
+	edits.add("RELEASESTATUS", match.ReleaseStatus)
+	edits.add("RELEASETYPE", match.ReleaseType)
+	edits.add("BARCODE", match.Barcode)
+	edits.add("CATALOGNUMBER", match.CatalogNumber)
+	edits.add("MEDIA", match.Media)
+	return edits
+}
+
+// embedMusicBrainzTags resolves the ISRC and writes the MusicBrainz tags
+// into filePath, leaving every other tag alone.
+func embedMusicBrainzTags(logPrefix, filePath, isrc, albumName string, totalTracks int) (*MusicBrainzMatch, error) {
+	match, err := fetchMusicBrainzMatchByISRC(isrc, albumName, totalTracks)
+	if err != nil {
+		return nil, err
+	}
+	return match, writeMusicBrainzTags(logPrefix, filePath, match)
+}
+
+func writeMusicBrainzTags(logPrefix, filePath string, match *MusicBrainzMatch) error {
+	if _, err := writeTagMap(filePath, musicBrainzTagEdits(match)); err != nil {
+		return fmt.Errorf("failed to write MusicBrainz tags: %w", err)
+	}
+	GoLog("[%s] Embedded MusicBrainz IDs (release %s)\n", logPrefix, match.ReleaseID)
+	return nil
+}
+
+// musicBrainzFFmpegMetadata names the tags the way FFmpeg writes them to
+// MP3 as TXXX frames, for the re-enrich path that hands MP3 to Dart.
+func musicBrainzFFmpegMetadata(match *MusicBrainzMatch) map[string]string {
+	metadata := map[string]string{}
+	for name, values := range musicBrainzTagEdits(match) {
+		key := name
+		if desc := tagMappingByName[name].id3Desc; desc != "" {
+			key = desc
+		} else if name == "MUSICBRAINZ_TRACKID" {
+			key = "MusicBrainz Track Id"
+		}
+		metadata[key] = strings.Join(values, "; ")
+	}
+	return metadata
+}
@@ -0,0 +1,164 @@
+package gobackend
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectMusicBrainzReleasePrefersAlbumContext(t *testing.T) {
+	recordings := []musicBrainzSearchRecording{{
+		ID: "rec-1",
+		Releases: []musicBrainzRelease{
+			{ID: "compilation", Title: "Greatest Hits", Status: "Official", Date: "1999-01-01", TrackCount: 20},
+			{ID: "bootleg", Title: "The Album", Status: "Bootleg", Date: "1990-01-01", TrackCount: 10},
+			{ID: "reissue", Title: "The Album", Status: "Official", Date: "2010-05-05", TrackCount: 12},
+			{ID: "original", Title: "The Album", Status: "Official", Date: "1995-03-01", TrackCount: 10},
+		},
+	}}
+
+	_, release := selectMusicBrainzRelease(recordings, "the album", 10)
+	if release == nil || release.ID != "original" {
+		t.Fatalf("expected original release, got %+v", release)
+	}
+
+	_, release = selectMusicBrainzRelease(recordings, "", 0)
+	if release == nil || release.ID != "original" {
+		t.Fatalf("without context the earliest official release should win, got %+v", release)
+	}
+
+	if recording, release := selectMusicBrainzRelease(nil, "x", 0); recording != nil || release != nil {
+		t.Fatal("expected no match for empty recordings")
+	}
+}
+
+func TestFetchMusicBrainzMatchByISRC(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+"?"+r.URL.RawQuery)
+		if ua := r.Header.Get("User-Agent"); !strings.HasPrefix(ua, "SpotiFLAC-Mobile") || !strings.Contains(ua, musicBrainzContact) {
+			t.Errorf("User-Agent = %q", ua)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/recording":
+			if got := r.URL.Query().Get("query"); got != "isrc:USRC17607839" {
+				t.Errorf("unexpected query %q", got)
+			}
+			fmt.Fprint(w, `{"recordings":[{"id":"rec-1","first-release-date":"1994-02-01",
+				"artist-credit":[{"name":"A","artist":{"id":"artist-a"}},{"name":"B","artist":{"id":"artist-b"}}],
+				"releases":[{"id":"rel-1","title":"Album","status":"Official","date":"1995-01-01","track-count":9}]}]}`)
+		case r.URL.Path == "/release/rel-1":
+			if inc := r.URL.Query().Get("inc"); !strings.Contains(inc, "labels") || !strings.Contains(inc, "release-groups") {
+				t.Errorf("unexpected inc %q", inc)
+			}
+			fmt.Fprint(w, `{"id":"rel-1","title":"Album","status":"Official","date":"1995-01-01","country":"GB","barcode":"0123456789012",
+				"artist-credit":[{"name":"A","artist":{"id":"artist-a"}}],
+				"release-group":{"id":"rg-1","primary-type":"Album","first-release-date":"1994-02-01"},
+				"label-info":[{"catalog-number":""},{"catalog-number":"CAT 001"}],
+				"media":[{"format":"CD","tracks":[{"id":"trk-0","recording":{"id":"rec-0"}}]},
+				         {"format":"Digital Media","tracks":[{"id":"trk-1","recording":{"id":"rec-1"}}]}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	origBase, origLimiter := musicBrainzAPIBase, musicBrainzRateLimiter
+	musicBrainzAPIBase = server.URL
+	musicBrainzRateLimiter = NewRateLimiter(100, time.Second)
+	defer func() {
+		musicBrainzAPIBase, musicBrainzRateLimiter = origBase, origLimiter
+	}()
+
+	match, err := FetchMusicBrainzMatchByISRC(" usrc17607839 ", "Album", 9)
+	if err != nil {
+		t.Fatalf("FetchMusicBrainzMatchByISRC: %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected a search and a release lookup, got %v", requests)
+	}
+
+	want := MusicBrainzMatch{
+		RecordingID:    "rec-1",
+		ReleaseID:      "rel-1",
+		ReleaseTrackID: "trk-1",
+		ReleaseGroupID: "rg-1",
+		ArtistIDs:      []string{"artist-a", "artist-b"},
+		AlbumArtistIDs: []string{"artist-a"},
+		ReleaseTitle:   "Album",
+		ReleaseDate:    "1995-01-01",
+		OriginalDate:   "1994-02-01",
+		ReleaseCountry: "GB",
+		ReleaseStatus:  "official",
+		ReleaseType:    "album",
+		Barcode:        "0123456789012",
+		CatalogNumber:  "CAT 001",
+		Media:          "Digital Media",
+	}
+	if fmt.Sprintf("%+v", *match) != fmt.Sprintf("%+v", want) {
+		t.Fatalf("match = %+v\nwant    %+v", *match, want)
+	}
+}
+
+func TestEmbedMusicBrainzTagsWritesStandardFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "track.flac")
+	if err := os.WriteFile(path, buildTestFLAC("TITLE=Song", "ISRC=USRC17607839"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	origFetch := fetchMusicBrainzMatchByISRC
+	defer func() { fetchMusicBrainzMatchByISRC = origFetch }()
+	fetchMusicBrainzMatchByISRC = func(isrc, albumName string, totalTracks int) (*MusicBrainzMatch, error) {
+		return &MusicBrainzMatch{
+			RecordingID:    "rec-1",
+			ReleaseID:      "rel-1",
+			ReleaseGroupID: "rg-1",
+			ArtistIDs:      []string{"artist-a", "artist-b"},
+			OriginalDate:   "1994-02-01",
+			ReleaseCountry: "GB",
+			Barcode:        "0123456789012",
+			CatalogNumber:  "CAT 001",
+			Media:          "CD",
+		}, nil
+	}
+
+	if _, err := embedMusicBrainzTags("test", path, "USRC17607839", "Album", 9); err != nil {
+		t.Fatalf("embedMusicBrainzTags: %v", err)
+	}
+
+	_, tags := readTestTagsJSON(t, path)
+	assertTagValues(t, tags, "TITLE", "Song")
+	assertTagValues(t, tags, "MUSICBRAINZ_TRACKID", "rec-1")
+	assertTagValues(t, tags, "MUSICBRAINZ_ALBUMID", "rel-1")
+	assertTagValues(t, tags, "MUSICBRAINZ_RELEASEGROUPID", "rg-1")
+	assertTagValues(t, tags, "MUSICBRAINZ_ARTISTID", "artist-a", "artist-b")
+	assertTagValues(t, tags, "ORIGINALDATE", "1994-02-01")
+	assertTagValues(t, tags, "RELEASECOUNTRY", "GB")
+	assertTagValues(t, tags, "BARCODE", "0123456789012")
+	assertTagValues(t, tags, "CATALOGNUMBER", "CAT 001")
+	assertTagValues(t, tags, "MEDIA", "CD")
+}
+
+func TestMusicBrainzFFmpegMetadataUsesTXXXNames(t *testing.T) {
+	metadata := musicBrainzFFmpegMetadata(&MusicBrainzMatch{
+		RecordingID: "rec-1",
+		ReleaseID:   "rel-1",
+		ArtistIDs:   []string{"a", "b"},
+		Barcode:     "123",
+	})
+	want := map[string]string{
+		"MusicBrainz Track Id":  "rec-1",
+		"MusicBrainz Album Id":  "rel-1",
+		"MusicBrainz Artist Id": "a; b",
+		"BARCODE":               "123",
+	}
+	if fmt.Sprint(metadata) != fmt.Sprint(want) {
+		t.Fatalf("metadata = %v, want %v", metadata, want)
+	}
+}
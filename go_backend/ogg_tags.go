@@ -0,0 +1,512 @@
+package gobackend
+
+// Native Vorbis-comment writing for Ogg Vorbis and Ogg Opus files.
+//
+// The header packets of the first logical stream are reassembled, the comment
+// packet (Vorbis type 3 / OpusTags) is rebuilt from the edited comment list,
+// and the header packets are repaginated with fresh CRCs. Audio pages are
+// copied untouched; they only get new sequence numbers (and CRCs) when the
+// number of header pages changed.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-flac/flacvorbis/v2"
+)
+
+const (
+	oggHeaderContinued = 0x01
+	oggHeaderBOS       = 0x02
+
+	// oggNoGranule marks a page on which no packet ends.
+	oggNoGranule = ^uint64(0)
+)
+
+var oggCRCTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+// oggCRC32 computes the Ogg page checksum (CRC-32, poly 0x04C11DB7, no
+// reflection, zero init, no final xor).
+func oggCRC32(data []byte) uint32 {
+	var crc uint32
+	for _, b := range data {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+// oggRawPage is a complete Ogg page kept with enough header fields to be
+// re-serialised after its sequence number changes.
+type oggRawPage struct {
+	version    byte
+	headerType byte
+	granule    uint64
+	serial     uint32
+	sequence   uint32
+	segments   []byte
+	body       []byte
+}
+
+func readOggRawPage(r io.Reader) (*oggRawPage, error) {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "OggS" {
+		return nil, fmt.Errorf("not an Ogg page")
+	}
+
+	page := &oggRawPage{
+		version:    header[4],
+		headerType: header[5],
+		granule:    binary.LittleEndian.Uint64(header[6:14]),
+		serial:     binary.LittleEndian.Uint32(header[14:18]),
+		sequence:   binary.LittleEndian.Uint32(header[18:22]),
+		segments:   make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, page.segments); err != nil {
+		return nil, err
+	}
+	bodySize := 0
+	for _, seg := range page.segments {
+		bodySize += int(seg)
+	}
+	page.body = make([]byte, bodySize)
+	if _, err := io.ReadFull(r, page.body); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+func (p *oggRawPage) size() int {
+	return 27 + len(p.segments) + len(p.body)
+}
+
+func (p *oggRawPage) marshal() []byte {
+	out := make([]byte, 27, p.size())
+	copy(out[0:4], "OggS")
+	out[4] = p.version
+	out[5] = p.headerType
+	binary.LittleEndian.PutUint64(out[6:14], p.granule)
+	binary.LittleEndian.PutUint32(out[14:18], p.serial)
+	binary.LittleEndian.PutUint32(out[18:22], p.sequence)
+	out[26] = byte(len(p.segments))
+	out = append(out, p.segments...)
+	out = append(out, p.body...)
+	binary.LittleEndian.PutUint32(out[22:26], oggCRC32(out))
+	return out
+}
+
+// paginateOggPacket splits one packet into pages that start fresh and end
+// with the packet. Pages on which the packet does not finish carry no
+// granule position; the final page carries granule.
+func paginateOggPacket(packet []byte, serial, firstSeq uint32, headerType byte, granule uint64) []*oggRawPage {
+	var lacing []byte
+	for n := len(packet); ; n -= 255 {
+		if n >= 255 {
+			lacing = append(lacing, 255)
+			continue
+		}
+		lacing = append(lacing, byte(n))
+		break
+	}
+
+	var pages []*oggRawPage
+	offset := 0
+	for len(lacing) > 0 {
+		count := len(lacing)
+		if count > 255 {
+			count = 255
+		}
+		segments := lacing[:count]
+		lacing = lacing[count:]
+
+		bodySize := 0
+		for _, seg := range segments {
+			bodySize += int(seg)
+		}
+		page := &oggRawPage{
+			headerType: headerType,
+			granule:    oggNoGranule,
+			serial:     serial,
+			sequence:   firstSeq + uint32(len(pages)),
+			segments:   append([]byte{}, segments...),
+			body:       packet[offset : offset+bodySize],
+		}
+		if len(lacing) == 0 {
+			page.granule = granule
+		}
+		offset += bodySize
+		pages = append(pages, page)
+		headerType = oggHeaderContinued
+	}
+	return pages
+}
+
+// oggHeaderLayout describes the header pages of the first logical stream.
+type oggHeaderLayout struct {
+	streamType oggStreamType
+	serial     uint32
+	version    byte
+	packets    [][]byte
+	pageCount  int
+	endOffset  int64
+}
+
+// readOggHeaderLayout reassembles the header packets (2 for Opus, 3 for
+// Vorbis) of the first logical stream and records where they end.
+func readOggHeaderLayout(file *os.File) (*oggHeaderLayout, error) {
+	const maxHeaderPages = 512
+
+	layout := &oggHeaderLayout{}
+	needed := 0
+	var cur []byte
+	var offset int64
+
+	for layout.pageCount < maxHeaderPages {
+		page, err := readOggRawPage(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Ogg header pages: %w", err)
+		}
+		offset += int64(page.size())
+
+		if layout.pageCount == 0 {
+			if page.headerType&oggHeaderBOS == 0 {
+				return nil, fmt.Errorf("first Ogg page is not a stream start")
+			}
+			layout.serial = page.serial
+			layout.version = page.version
+		} else if page.serial != layout.serial {
+			return nil, fmt.Errorf("multiplexed Ogg streams are not supported")
+		}
+		layout.pageCount++
+
+		pos := 0
+		for i, seg := range page.segments {
+			cur = append(cur, page.body[pos:pos+int(seg)]...)
+			pos += int(seg)
+			if seg == 255 {
+				continue
+			}
+
+			layout.packets = append(layout.packets, cur)
+			cur = nil
+			if len(layout.packets) == 1 {
+				layout.streamType = detectOggStreamType(layout.packets)
+				switch layout.streamType {
+				case oggStreamOpus:
+					needed = 2
+				case oggStreamVorbis:
+					needed = 3
+				default:
+					return nil, fmt.Errorf("unsupported Ogg codec")
+				}
+			}
+			if len(layout.packets) == needed {
+				if i != len(page.segments)-1 {
+					return nil, fmt.Errorf("audio data shares a page with the Ogg headers")
+				}
+				layout.endOffset = offset
+				return layout, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("ogg headers span too many pages")
+}
+
+// parseVorbisCommentList splits a raw comment structure (after the codec
+// magic) into vendor, comment list and any trailing bytes.
+func parseVorbisCommentList(data []byte) (string, []string, []byte, error) {
+	if len(data) < 8 {
+		return "", nil, nil, fmt.Errorf("comment header too short")
+	}
+	vendorLen := int(binary.LittleEndian.Uint32(data[0:4]))
+	if vendorLen < 0 || 4+vendorLen+4 > len(data) {
+		return "", nil, nil, fmt.Errorf("invalid vendor length")
+	}
+	vendor := string(data[4 : 4+vendorLen])
+	pos := 4 + vendorLen
+	count := int(binary.LittleEndian.Uint32(data[pos : pos+4]))
+	pos += 4
+
+	if count < 0 || count > len(data)/4 {
+		return "", nil, nil, fmt.Errorf("invalid comment count")
+	}
+	comments := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if pos+4 > len(data) {
+			return "", nil, nil, fmt.Errorf("truncated comment list")
+		}
+		n := int(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if n < 0 || pos+n > len(data) {
+			return "", nil, nil, fmt.Errorf("truncated comment list")
+		}
+		comments = append(comments, string(data[pos:pos+n]))
+		pos += n
+	}
+	return vendor, comments, data[pos:], nil
+}
+
+func buildVorbisCommentList(vendor string, comments []string) []byte {
+	var buf bytes.Buffer
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(vendor)))
+	buf.Write(n[:])
+	buf.WriteString(vendor)
+	binary.LittleEndian.PutUint32(n[:], uint32(len(comments)))
+	buf.Write(n[:])
+	for _, c := range comments {
+		binary.LittleEndian.PutUint32(n[:], uint32(len(c)))
+		buf.Write(n[:])
+		buf.WriteString(c)
+	}
+	return buf.Bytes()
+}
+
+// rebuildOggCommentPacket runs edit on the comments of an OpusTags or Vorbis
+// comment packet and returns the re-encoded packet.
+func rebuildOggCommentPacket(packet []byte, streamType oggStreamType, edit func(cmt *flacvorbis.MetaDataBlockVorbisComment)) ([]byte, error) {
+	var prefix []byte
+	switch streamType {
+	case oggStreamOpus:
+		if len(packet) < 8 || string(packet[0:8]) != "OpusTags" {
+			return nil, fmt.Errorf("missing OpusTags header")
+		}
+		prefix = packet[0:8]
+	case oggStreamVorbis:
+		if len(packet) < 7 || packet[0] != 0x03 || string(packet[1:7]) != "vorbis" {
+			return nil, fmt.Errorf("missing Vorbis comment header")
+		}
+		prefix = packet[0:7]
+	}
+
+	vendor, comments, rest, err := parseVorbisCommentList(packet[len(prefix):])
+	if err != nil {
+		return nil, err
+	}
+
+	cmt := &flacvorbis.MetaDataBlockVorbisComment{Vendor: vendor, Comments: comments}
+	edit(cmt)
+
+	out := append([]byte{}, prefix...)
+	out = append(out, buildVorbisCommentList(cmt.Vendor, cmt.Comments)...)
+	if streamType == oggStreamVorbis {
+		// Framing bit; anything after it is not part of the header.
+		return append(out, 0x01), nil
+	}
+	// OpusTags may carry binary extension data after the comments when its
+	// first byte has the LSB set; keep it verbatim.
+	if len(rest) > 0 && rest[0]&0x01 != 0 {
+		out = append(out, rest...)
+	}
+	return out, nil
+}
+
+// applyOggEditFields applies the edit fields, including R128 gains for Opus
+// and a METADATA_BLOCK_PICTURE cover, to an Ogg comment list.
+func applyOggEditFields(cmt *flacvorbis.MetaDataBlockVorbisComment, streamType oggStreamType, fields map[string]string) {
+	applyVorbisEditFields(cmt, fields)
+	if streamType == oggStreamOpus {
+		applyOpusR128Fields(cmt, fields)
+	}
+
+	if coverData, _ := loadCoverForTag(fields); len(coverData) > 0 {
+		picBlock, err := buildTypedPictureBlock(coverTargetOgg, fields["cover_path"], coverData, pictureTypeFrontCover, "Front Cover")
+		if err != nil {
+			GoLog("[OggTags] Skipping cover: %v\n", err)
+			return
+		}
+		dropVorbisPictures(cmt, frontCoverPictureTypes)
+		cmt.Comments = append(cmt.Comments, "METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(picBlock.Data))
+	}
+}
+
+// applyOpusR128Fields mirrors edited ReplayGain gains into the Opus
+// R128_TRACK_GAIN / R128_ALBUM_GAIN tags (RFC 7845 §5.2.1), which are Q7.8
+// integers referenced to -23 LUFS instead of ReplayGain's -18 LUFS.
+func applyOpusR128Fields(cmt *flacvorbis.MetaDataBlockVorbisComment, fields map[string]string) {
+	for _, pair := range [][2]string{
+		{"replaygain_track_gain", "R128_TRACK_GAIN"},
+		{"replaygain_album_gain", "R128_ALBUM_GAIN"},
+	} {
+		v, ok := fields[pair[0]]
+		if !ok {
+			continue
+		}
+		if q78, ok := replayGainToR128(v); ok {
+			setOrClearComment(cmt, pair[1], strconv.Itoa(q78))
+		} else {
+			removeCommentKey(cmt, pair[1])
+		}
+	}
+}
+
+func replayGainToR128(value string) (int, bool) {
+	db, ok := parseReplayGainDb(value)
+	if !ok {
+		return 0, false
+	}
+	q78 := int(math.Round((db - 5) * 256))
+	if q78 < math.MinInt16 {
+		q78 = math.MinInt16
+	} else if q78 > math.MaxInt16 {
+		q78 = math.MaxInt16
+	}
+	return q78, true
+}
+
+// r128ToReplayGain converts an Opus R128 gain back to a ReplayGain string.
+func r128ToReplayGain(value string) string {
+	q78, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%.2f dB", float64(q78)/256+5)
+}
+
+func isOggFile(filePath string) bool {
+	lower := strings.ToLower(filePath)
+	return strings.HasSuffix(lower, ".ogg") || strings.HasSuffix(lower, ".opus") || strings.HasSuffix(lower, ".oga")
+}
+
+// WriteOggTags writes/merges edit fields into the comment header of an Ogg
+// Vorbis or Ogg Opus file.
+func WriteOggTags(filePath string, fields map[string]string) error {
+	return rewriteOggComments(filePath, func(cmt *flacvorbis.MetaDataBlockVorbisComment, streamType oggStreamType) {
+		applyOggEditFields(cmt, streamType, fields)
+	})
+}
+
+// rewriteOggComments rewrites the comment header of an Ogg Vorbis or Opus
+// file after running edit on its comments. The header pages are rewritten in
+// place when their size and page count are unchanged.
+func rewriteOggComments(filePath string, edit func(cmt *flacvorbis.MetaDataBlockVorbisComment, streamType oggStreamType)) error {
+	in, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	layout, err := readOggHeaderLayout(in)
+	if err != nil {
+		return err
+	}
+
+	comment, err := rebuildOggCommentPacket(layout.packets[1], layout.streamType, func(cmt *flacvorbis.MetaDataBlockVorbisComment) {
+		edit(cmt, layout.streamType)
+	})
+	if err != nil {
+		return err
+	}
+	packets := append([][]byte{}, layout.packets...)
+	packets[1] = comment
+
+	var header bytes.Buffer
+	seq := uint32(0)
+	for i, pkt := range packets {
+		headerType := byte(0)
+		if i == 0 {
+			headerType = oggHeaderBOS
+		}
+		for _, page := range paginateOggPacket(pkt, layout.serial, seq, headerType, 0) {
+			page.version = layout.version
+			header.Write(page.marshal())
+			seq++
+		}
+	}
+	seqDelta := int64(seq) - int64(layout.pageCount)
+
+	if seqDelta == 0 && int64(header.Len()) == layout.endOffset {
+		in.Close()
+		out, err := os.OpenFile(filePath, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		if _, err := out.WriteAt(header.Bytes(), 0); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+
+	if _, err := in.Seek(layout.endOffset, io.SeekStart); err != nil {
+		return err
+	}
+
+	tmpPath := filePath + ".tagtmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		out.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if _, err := out.Write(header.Bytes()); err != nil {
+		return fail(err)
+	}
+	if seqDelta == 0 {
+		if _, err := io.Copy(out, in); err != nil {
+			return fail(err)
+		}
+	} else if err := copyOggPagesRenumbered(out, in, layout.serial, seqDelta); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	in.Close()
+
+	return os.Rename(tmpPath, filePath)
+}
+
+// copyOggPagesRenumbered copies the remaining pages, shifting the sequence
+// numbers of the given stream by delta. Trailing non-Ogg bytes are copied
+// unchanged.
+func copyOggPagesRenumbered(out io.Writer, in io.ReadSeeker, serial uint32, delta int64) error {
+	for {
+		start, err := in.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		page, err := readOggRawPage(in)
+		if err != nil {
+			if _, seekErr := in.Seek(start, io.SeekStart); seekErr != nil {
+				return seekErr
+			}
+			_, copyErr := io.Copy(out, in)
+			return copyErr
+		}
+		if page.serial == serial {
+			page.sequence = uint32(int64(page.sequence) + delta)
+		}
+		if _, err := out.Write(page.marshal()); err != nil {
+			return err
+		}
+	}
+}
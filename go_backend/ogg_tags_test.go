@@ -0,0 +1,211 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testOggSerial = 0x1234
+
+func buildTestOggStream(headerPackets [][]byte, audioPackets [][]byte) []byte {
+	var out bytes.Buffer
+	seq := uint32(0)
+	for i, pkt := range headerPackets {
+		headerType := byte(0)
+		if i == 0 {
+			headerType = oggHeaderBOS
+		}
+		for _, page := range paginateOggPacket(pkt, testOggSerial, seq, headerType, 0) {
+			out.Write(page.marshal())
+			seq++
+		}
+	}
+	for i, pkt := range audioPackets {
+		for _, page := range paginateOggPacket(pkt, testOggSerial, seq, 0, uint64(960*(i+1))) {
+			out.Write(page.marshal())
+			seq++
+		}
+	}
+	return out.Bytes()
+}
+
+func testOpusHeaders(comments ...string) [][]byte {
+	head := append([]byte("OpusHead"), 1, 2, 0x38, 0x01, 0x80, 0xBB, 0, 0, 0, 0, 0)
+	tags := append([]byte("OpusTags"), buildVorbisCommentList("test vendor", comments)...)
+	return [][]byte{head, tags}
+}
+
+// readTestOggPages parses every page, checking CRCs and sequence numbers.
+func readTestOggPages(t *testing.T, data []byte) []*oggRawPage {
+	t.Helper()
+	var pages []*oggRawPage
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		start := len(data) - r.Len()
+		page, err := readOggRawPage(r)
+		if err != nil {
+			t.Fatalf("read page at %d: %v", start, err)
+		}
+		raw := append([]byte{}, data[start:start+page.size()]...)
+		want := binary.LittleEndian.Uint32(raw[22:26])
+		binary.LittleEndian.PutUint32(raw[22:26], 0)
+		if got := oggCRC32(raw); got != want {
+			t.Fatalf("page %d CRC = %08x, want %08x", len(pages), got, want)
+		}
+		if page.sequence != uint32(len(pages)) {
+			t.Fatalf("page %d has sequence %d", len(pages), page.sequence)
+		}
+		pages = append(pages, page)
+	}
+	return pages
+}
+
+func TestWriteOggTagsOpusRepaginatesWithCover(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.opus")
+	audio := [][]byte{bytes.Repeat([]byte{1}, 300), bytes.Repeat([]byte{2}, 40), bytes.Repeat([]byte{3}, 70)}
+	if err := os.WriteFile(path, buildTestOggStream(testOpusHeaders("TITLE=Old", "CUSTOM=keep"), audio), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	coverPath := filepath.Join(dir, "cover.png")
+	cover := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0x42}, 80000)...)
+	if err := os.WriteFile(coverPath, cover, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WriteOggTags(path, map[string]string{
+		"title":                 "New Title",
+		"artist":                "Artist",
+		"replaygain_track_gain": "-3.00 dB",
+		"cover_path":            coverPath,
+	})
+	if err != nil {
+		t.Fatalf("WriteOggTags: %v", err)
+	}
+
+	data := mustReadFile(t, path)
+	pages := readTestOggPages(t, data)
+	if len(pages) <= 2+len(audio) {
+		t.Fatalf("expected the cover to span several header pages, got %d pages", len(pages))
+	}
+	audioPages := pages[len(pages)-len(audio):]
+	for i, page := range audioPages {
+		if !bytes.Equal(page.body, audio[i]) || page.granule != uint64(960*(i+1)) {
+			t.Fatalf("audio page %d was modified", i)
+		}
+	}
+	for _, page := range pages[:len(pages)-len(audio)] {
+		if page.granule != 0 && page.granule != oggNoGranule {
+			t.Fatalf("header page granule = %d", page.granule)
+		}
+	}
+
+	meta, err := ReadOggVorbisComments(path)
+	if err != nil {
+		t.Fatalf("ReadOggVorbisComments: %v", err)
+	}
+	if meta.Title != "New Title" || meta.Artist != "Artist" || meta.ReplayGainTrackGain != "-3.00 dB" {
+		t.Fatalf("metadata = %+v", meta)
+	}
+
+	layout, err := func() (*oggHeaderLayout, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return readOggHeaderLayout(f)
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, comments, _, err := parseVorbisCommentList(layout.packets[1][8:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(comments, "\n")
+	if !strings.Contains(joined, "CUSTOM=keep") || !strings.Contains(joined, "R128_TRACK_GAIN=-2048") {
+		t.Fatalf("comments = %q", joined)
+	}
+
+	gotCover, mime, err := extractOggCoverArt(path)
+	if err != nil || !bytes.Equal(gotCover, cover) || mime != "image/png" {
+		t.Fatalf("extractOggCoverArt = %d bytes, %q, %v", len(gotCover), mime, err)
+	}
+}
+
+func TestWriteOggTagsVorbisKeepsSetupHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.ogg")
+	ident := append([]byte{0x01}, "vorbis"...)
+	ident = append(ident, make([]byte, 23)...)
+	comment := append([]byte{0x03}, "vorbis"...)
+	comment = append(comment, buildVorbisCommentList("vendor", []string{"TITLE=Song", "ARTIST=Someone"})...)
+	comment = append(comment, 0x01)
+	setup := append([]byte{0x05}, "vorbis"...)
+	setup = append(setup, bytes.Repeat([]byte{0x7A}, 600)...)
+	audio := [][]byte{bytes.Repeat([]byte{9}, 100)}
+	if err := os.WriteFile(path, buildTestOggStream([][]byte{ident, comment, setup}, audio), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EmbedLyrics(path, "[00:01.00]Hello"); err != nil {
+		t.Fatalf("EmbedLyrics: %v", err)
+	}
+
+	data := mustReadFile(t, path)
+	readTestOggPages(t, data)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	layout, err := readOggHeaderLayout(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(layout.packets[2], setup) {
+		t.Fatal("setup header changed")
+	}
+	packet := layout.packets[1]
+	if packet[len(packet)-1] != 0x01 {
+		t.Fatal("missing Vorbis framing bit")
+	}
+
+	meta, err := ReadOggVorbisComments(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Song" || meta.Artist != "Someone" || meta.Lyrics != "[00:01.00]Hello" {
+		t.Fatalf("metadata = %+v", meta)
+	}
+}
+
+func TestEditFileMetadataUsesNativeOggWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edit.opus")
+	if err := os.WriteFile(path, buildTestOggStream(testOpusHeaders("TITLE=Old", "ARTIST=A"), [][]byte{{1, 2, 3}}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	before := len(mustReadFile(t, path))
+
+	response, err := EditFileMetadata(path, `{"title":"Nu"}`)
+	if err != nil || !strings.Contains(response, "native_ogg") {
+		t.Fatalf("EditFileMetadata = %q/%v", response, err)
+	}
+	// Only the comment packet changes size; audio pages are copied as-is.
+	if after := len(mustReadFile(t, path)); after != before-1 {
+		t.Fatalf("file size %d -> %d", before, after)
+	}
+	meta, err := ReadOggVorbisComments(path)
+	if err != nil || meta.Title != "Nu" || meta.Artist != "A" {
+		t.Fatalf("ReadOggVorbisComments = %+v/%v", meta, err)
+	}
+	if replayGainFromR128 := r128ToReplayGain("-2048"); replayGainFromR128 != "-3.00 dB" {
+		t.Fatalf("r128ToReplayGain = %q", replayGainFromR128)
+	}
+}
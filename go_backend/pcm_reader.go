@@ -0,0 +1,369 @@
+package gobackend
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pcmBlockFrames is how many sample frames a pcmSource returns per block.
+const pcmBlockFrames = 4096
+
+// pcmSource yields decoded audio as float samples in [-1, 1), one slice per
+// channel. ReadBlock returns io.EOF once the stream is exhausted.
+type pcmSource interface {
+	SampleRate() int
+	Channels() int
+	BitsPerSample() int // declared integer bit depth; 0 for float data
+	ReadBlock() ([][]float64, error)
+	Close() error
+}
+
+// openPCMSource opens a FLAC, WAV or AIFF/AIFC file for decoding.
+func openPCMSource(filePath string) (pcmSource, error) {
+	return openPCMSourceAs(filePath, filepath.Ext(filePath))
+}
+
+// openPCMSourceAs is openPCMSource for paths whose extension is not the real
+// one (e.g. SAF file descriptors); ext selects the decoder.
+func openPCMSourceAs(filePath, ext string) (pcmSource, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var src pcmSource
+	switch strings.ToLower(ext) {
+	case ".flac":
+		src, err = newFLACPCMSource(f)
+	case ".wav":
+		src, err = newWAVPCMSource(f)
+	case ".aiff", ".aif", ".aifc":
+		src, err = newAIFFPCMSource(f)
+	default:
+		err = fmt.Errorf("unsupported file type for decoding: %s", ext)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return src, nil
+}
+
+type flacPCMSource struct {
+	f   *os.File
+	dec *flacDecoder
+}
+
+func newFLACPCMSource(f *os.File) (*flacPCMSource, error) {
+	dec, err := newFLACDecoder(f)
+	if err != nil {
+		return nil, err
+	}
+	if dec.Info.SampleRate == 0 || dec.Info.Channels == 0 {
+		return nil, fmt.Errorf("invalid FLAC STREAMINFO")
+	}
+	return &flacPCMSource{f: f, dec: dec}, nil
+}
+
+func (s *flacPCMSource) SampleRate() int    { return s.dec.Info.SampleRate }
+func (s *flacPCMSource) Channels() int      { return s.dec.Info.Channels }
+func (s *flacPCMSource) BitsPerSample() int { return s.dec.Info.BitsPerSample }
+func (s *flacPCMSource) Close() error       { return s.f.Close() }
+
+func (s *flacPCMSource) ReadBlock() ([][]float64, error) {
+	frame, err := s.dec.nextFrame()
+	if err != nil {
+		return nil, err
+	}
+	if len(frame.Samples) != s.dec.Info.Channels {
+		return nil, &flacFrameError{frame.Offset, "channel count differs from STREAMINFO"}
+	}
+	scale := 1 / float64(int64(1)<<(frame.BitsPerSample-1))
+	out := make([][]float64, len(frame.Samples))
+	for ch, samples := range frame.Samples {
+		out[ch] = make([]float64, len(samples))
+		for i, v := range samples {
+			out[ch][i] = float64(v) * scale
+		}
+	}
+	return out, nil
+}
+
+// rawPCMEncoding describes interleaved PCM sample data in a WAV/AIFF file.
+type rawPCMEncoding struct {
+	bytesPerSample int
+	littleEndian   bool
+	float          bool
+	unsigned       bool // 8-bit WAV
+}
+
+type rawPCMSource struct {
+	f          *os.File
+	r          *bufio.Reader
+	sampleRate int
+	channels   int
+	bitDepth   int
+	enc        rawPCMEncoding
+	buf        []byte
+}
+
+func (s *rawPCMSource) SampleRate() int { return s.sampleRate }
+func (s *rawPCMSource) Channels() int   { return s.channels }
+func (s *rawPCMSource) Close() error    { return s.f.Close() }
+
+func (s *rawPCMSource) BitsPerSample() int {
+	if s.enc.float {
+		return 0
+	}
+	return s.bitDepth
+}
+
+func (s *rawPCMSource) ReadBlock() ([][]float64, error) {
+	frameSize := s.enc.bytesPerSample * s.channels
+	n, err := io.ReadFull(s.r, s.buf)
+	frames := n / frameSize
+	if frames == 0 {
+		if err == nil || err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return nil, err
+	}
+	out := make([][]float64, s.channels)
+	for ch := range out {
+		out[ch] = make([]float64, frames)
+	}
+	for i := 0; i < frames; i++ {
+		for ch := 0; ch < s.channels; ch++ {
+			off := (i*s.channels + ch) * s.enc.bytesPerSample
+			out[ch][i] = s.enc.decode(s.buf[off : off+s.enc.bytesPerSample])
+		}
+	}
+	return out, nil
+}
+
+func (e rawPCMEncoding) decode(b []byte) float64 {
+	if e.float {
+		if e.bytesPerSample == 8 {
+			if e.littleEndian {
+				return math.Float64frombits(binary.LittleEndian.Uint64(b))
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+		return float64(math.Float32frombits(readUint32(b, e.littleEndian)))
+	}
+	if e.unsigned {
+		return float64(int(b[0])-128) / 128
+	}
+	var v uint32
+	for i := 0; i < e.bytesPerSample; i++ {
+		idx := i
+		if e.littleEndian {
+			idx = e.bytesPerSample - 1 - i
+		}
+		v = v<<8 | uint32(b[idx])
+	}
+	shift := uint(32 - 8*e.bytesPerSample)
+	return float64(int32(v<<shift)) / (1 << 31)
+}
+
+func newRawPCMSource(f *os.File, dataOffset, dataSize int64, sampleRate, channels, bitDepth int, enc rawPCMEncoding) (*rawPCMSource, error) {
+	if sampleRate <= 0 || channels <= 0 || enc.bytesPerSample <= 0 || (!enc.float && enc.bytesPerSample > 4) {
+		return nil, fmt.Errorf("unsupported PCM format")
+	}
+	if _, err := f.Seek(dataOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	if dataSize > 0 {
+		r = io.LimitReader(f, dataSize)
+	}
+	return &rawPCMSource{
+		f:          f,
+		r:          bufio.NewReaderSize(r, 64*1024),
+		sampleRate: sampleRate,
+		channels:   channels,
+		bitDepth:   bitDepth,
+		enc:        enc,
+		buf:        make([]byte, pcmBlockFrames*channels*enc.bytesPerSample),
+	}, nil
+}
+
+// newWAVPCMSource locates the fmt and data chunks of a RIFF/WAVE file.
+// Integer PCM, IEEE float and WAVE_FORMAT_EXTENSIBLE with either subformat
+// are supported.
+func newWAVPCMSource(f *os.File) (*rawPCMSource, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, err
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, fmt.Errorf("not a WAVE file")
+	}
+
+	var format uint16
+	var channels, sampleRate, bitDepth, validBits, blockAlign int
+	hdr := make([]byte, 8)
+	pos := int64(12)
+	for {
+		if _, err := io.ReadFull(f, hdr); err != nil {
+			return nil, fmt.Errorf("WAVE file has no data chunk")
+		}
+		id := string(hdr[0:4])
+		size := int64(readUint32(hdr[4:8], true))
+		pos += 8
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > wavMaxMetaChunk {
+				return nil, fmt.Errorf("invalid fmt chunk")
+			}
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(f, buf); err != nil {
+				return nil, err
+			}
+			format = binary.LittleEndian.Uint16(buf[0:2])
+			channels = int(binary.LittleEndian.Uint16(buf[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(buf[4:8]))
+			blockAlign = int(binary.LittleEndian.Uint16(buf[12:14]))
+			bitDepth = int(binary.LittleEndian.Uint16(buf[14:16]))
+			validBits = bitDepth
+			if format == wavFormatExtensn && len(buf) >= 26 {
+				if vb := int(binary.LittleEndian.Uint16(buf[18:20])); vb > 0 && vb <= bitDepth {
+					validBits = vb
+				}
+				format = binary.LittleEndian.Uint16(buf[24:26]) // subformat GUID
+			}
+		case "data":
+			if channels == 0 {
+				return nil, fmt.Errorf("WAVE data chunk precedes fmt chunk")
+			}
+			enc := rawPCMEncoding{littleEndian: true}
+			switch format {
+			case wavFormatPCM:
+				enc.bytesPerSample = (bitDepth + 7) / 8
+				enc.unsigned = enc.bytesPerSample == 1
+			case wavFormatFloat:
+				enc.bytesPerSample = bitDepth / 8
+				enc.float = enc.bytesPerSample == 4 || enc.bytesPerSample == 8
+				if !enc.float {
+					return nil, fmt.Errorf("unsupported WAVE float width: %d", bitDepth)
+				}
+			default:
+				return nil, fmt.Errorf("unsupported WAVE format tag: 0x%04x", format)
+			}
+			if blockAlign > 0 && blockAlign != enc.bytesPerSample*channels {
+				return nil, fmt.Errorf("unsupported WAVE block alignment: %d", blockAlign)
+			}
+			if size == 0xFFFFFFFF {
+				size = 0 // streamed WAV: read to the end of the file
+			}
+			return newRawPCMSource(f, pos, size, sampleRate, channels, validBits, enc)
+		default:
+			if _, err := f.Seek(size+size&1, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			pos += size + size&1
+			continue
+		}
+		if size&1 == 1 {
+			if _, err := f.Seek(1, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+		pos += size + size&1
+	}
+}
+
+// newAIFFPCMSource locates the COMM and SSND chunks of an AIFF or AIFC file.
+// AIFC is limited to uncompressed ("NONE"/"twos"), byte-swapped ("sowt") and
+// float ("fl32"/"fl64") sample data.
+func newAIFFPCMSource(f *os.File) (*rawPCMSource, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return nil, err
+	}
+	form := string(header[8:12])
+	if string(header[0:4]) != "FORM" || (form != "AIFF" && form != "AIFC") {
+		return nil, fmt.Errorf("not an AIFF file")
+	}
+
+	var channels, sampleRate, bitDepth int
+	var numFrames int64
+	compression := "NONE"
+	haveCOMM := false
+	hdr := make([]byte, 8)
+	pos := int64(12)
+	for {
+		if _, err := io.ReadFull(f, hdr); err != nil {
+			return nil, fmt.Errorf("AIFF file has no SSND chunk")
+		}
+		id := string(hdr[0:4])
+		size := int64(readUint32(hdr[4:8], false))
+		pos += 8
+
+		switch id {
+		case "COMM":
+			if size < 18 || size > wavMaxMetaChunk {
+				return nil, fmt.Errorf("invalid COMM chunk")
+			}
+			buf := make([]byte, size)
+			if _, err := io.ReadFull(f, buf); err != nil {
+				return nil, err
+			}
+			channels = int(binary.BigEndian.Uint16(buf[0:2]))
+			numFrames = int64(binary.BigEndian.Uint32(buf[2:6]))
+			bitDepth = int(binary.BigEndian.Uint16(buf[6:8]))
+			sampleRate = int(parseExtendedFloat80(buf[8:18]) + 0.5)
+			if form == "AIFC" && len(buf) >= 22 {
+				compression = string(buf[18:22])
+			}
+			haveCOMM = true
+		case "SSND":
+			if !haveCOMM {
+				return nil, fmt.Errorf("AIFF SSND chunk precedes COMM chunk")
+			}
+			offsets := make([]byte, 8)
+			if _, err := io.ReadFull(f, offsets); err != nil {
+				return nil, err
+			}
+			enc := rawPCMEncoding{bytesPerSample: (bitDepth + 7) / 8}
+			switch compression {
+			case "NONE", "twos":
+			case "sowt":
+				enc.littleEndian = true
+			case "fl32", "FL32":
+				enc.float, enc.bytesPerSample = true, 4
+			case "fl64", "FL64":
+				enc.float, enc.bytesPerSample = true, 8
+			default:
+				return nil, fmt.Errorf("unsupported AIFC compression: %q", compression)
+			}
+			dataOffset := pos + 8 + int64(binary.BigEndian.Uint32(offsets[0:4]))
+			dataSize := numFrames * int64(channels*enc.bytesPerSample)
+			if avail := size - 8 - int64(binary.BigEndian.Uint32(offsets[0:4])); dataSize > avail {
+				dataSize = avail
+			}
+			if dataSize <= 0 {
+				return nil, fmt.Errorf("AIFF file has no sample data")
+			}
+			return newRawPCMSource(f, dataOffset, dataSize, sampleRate, channels, bitDepth, enc)
+		default:
+			if _, err := f.Seek(size+size&1, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+			pos += size + size&1
+			continue
+		}
+		if size&1 == 1 {
+			if _, err := f.Seek(1, io.SeekCurrent); err != nil {
+				return nil, err
+			}
+		}
+		pos += size + size&1
+	}
+}
@@ -0,0 +1,577 @@
+package gobackend
+
+// Embedded pictures by type, across every container.
+//
+// FLAC and Ogg store FLAC picture blocks, MP3 and the WAV/AIFF id3 chunk
+// store APIC frames and APEv2 keeps one "Cover Art (...)" binary item per
+// type, so all of them carry the 21 ID3 picture types. The MP4 covr atom has
+// no type field, so only a front cover can be written there; its first
+// image reads as the front cover and any further images other tools left
+// behind as type 0 (other).
+//
+// A write names the picture types it owns. Every existing picture of those
+// types is replaced, so the pages of a booklet are written in one call, and
+// pictures of any other type are carried over.
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	stdimage "image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-flac/flacpicture/v2"
+	"github.com/go-flac/flacvorbis/v2"
+	"github.com/go-flac/go-flac/v2"
+)
+
+const (
+	pictureTypeOther      = 0
+	pictureTypeFrontCover = 3
+	pictureTypeBackCover  = 4
+	pictureTypeLeaflet    = 5
+	pictureTypeMedia      = 6
+	maxPictureType        = 20
+)
+
+// pictureTypeNames are the ID3v2 APIC picture types in order.
+var pictureTypeNames = [maxPictureType + 1]string{
+	"other", "file_icon", "other_file_icon", "front_cover", "back_cover",
+	"leaflet", "media", "lead_artist", "artist", "conductor", "band",
+	"composer", "lyricist", "recording_location", "during_recording",
+	"during_performance", "screen_capture", "bright_fish", "illustration",
+	"band_logo", "publisher_logo",
+}
+
+// apePictureKeys are the APEv2 item keys foobar2000 and Mp3tag use for each
+// picture type.
+var apePictureKeys = [maxPictureType + 1]string{
+	"Cover Art (Other)", "Cover Art (Icon)", "Cover Art (Other Icon)",
+	"Cover Art (Front)", "Cover Art (Back)", "Cover Art (Leaflet)",
+	"Cover Art (Media)", "Cover Art (Lead Artist)", "Cover Art (Artist)",
+	"Cover Art (Conductor)", "Cover Art (Band)", "Cover Art (Composer)",
+	"Cover Art (Lyricist)", "Cover Art (Recording Location)",
+	"Cover Art (During Recording)", "Cover Art (During Performance)",
+	"Cover Art (Video Capture)", "Cover Art (Fish)", "Cover Art (Illustration)",
+	"Cover Art (Band Logotype)", "Cover Art (Publisher Logotype)",
+}
+
+var pictureTypeAliases = map[string]int{
+	"front":    pictureTypeFrontCover,
+	"cover":    pictureTypeFrontCover,
+	"back":     pictureTypeBackCover,
+	"booklet":  pictureTypeLeaflet,
+	"disc":     pictureTypeMedia,
+	"disc_art": pictureTypeMedia,
+}
+
+// frontCoverPictureTypes is what the single-cover writers replace, so a new
+// cover leaves booklet scans and other artwork in place.
+var frontCoverPictureTypes = map[int]bool{pictureTypeFrontCover: true}
+
+func pictureTypeName(pictureType int) string {
+	if pictureType < 0 || pictureType > maxPictureType {
+		return ""
+	}
+	return pictureTypeNames[pictureType]
+}
+
+// parsePictureType accepts a type number, a name from pictureTypeNames or a
+// short alias such as "booklet" or "disc".
+func parsePictureType(value string) (int, error) {
+	key := strings.ToLower(strings.TrimSpace(value))
+	if n, err := strconv.Atoi(key); err == nil {
+		if n < 0 || n > maxPictureType {
+			return 0, fmt.Errorf("picture type out of range: %d", n)
+		}
+		return n, nil
+	}
+	key = strings.NewReplacer(" ", "_", "-", "_").Replace(key)
+	if t, ok := pictureTypeAliases[key]; ok {
+		return t, nil
+	}
+	for t, name := range pictureTypeNames {
+		if name == key {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown picture type: %q", value)
+}
+
+// pictureTypeValue decodes a picture type given in JSON as a number or a name.
+type pictureTypeValue int
+
+func (v *pictureTypeValue) UnmarshalJSON(data []byte) error {
+	t, err := parsePictureType(strings.Trim(string(data), `"`))
+	if err != nil {
+		return err
+	}
+	*v = pictureTypeValue(t)
+	return nil
+}
+
+// EmbeddedPicture is one picture stored in an audio file.
+type EmbeddedPicture struct {
+	Type        int    `json:"type"`
+	TypeName    string `json:"type_name"`
+	MIME        string `json:"mime"`
+	Description string `json:"description,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	Size        int    `json:"size"`
+	Data        []byte `json:"-"`
+}
+
+func newEmbeddedPicture(pictureType int, mime, description string, data []byte) EmbeddedPicture {
+	if !strings.Contains(mime, "/") {
+		mime = detectCoverMIME("", data)
+	}
+	pic := EmbeddedPicture{
+		Type:        pictureType,
+		TypeName:    pictureTypeName(pictureType),
+		MIME:        strings.ToLower(strings.TrimSpace(mime)),
+		Description: strings.TrimSpace(description),
+		Size:        len(data),
+		Data:        data,
+	}
+	if cfg, _, err := stdimage.DecodeConfig(bytes.NewReader(data)); err == nil {
+		pic.Width, pic.Height = cfg.Width, cfg.Height
+	}
+	return pic
+}
+
+// selectCoverPicture returns the front cover, or the first picture when none
+// is marked as one.
+func selectCoverPicture(pictures []EmbeddedPicture) (EmbeddedPicture, bool) {
+	for _, pic := range pictures {
+		if pic.Type == pictureTypeFrontCover {
+			return pic, true
+		}
+	}
+	if len(pictures) > 0 {
+		return pictures[0], true
+	}
+	return EmbeddedPicture{}, false
+}
+
+func picturesOfType(pictures []EmbeddedPicture, pictureType int) []EmbeddedPicture {
+	var out []EmbeddedPicture
+	for _, pic := range pictures {
+		if pic.Type == pictureType {
+			out = append(out, pic)
+		}
+	}
+	return out
+}
+
+// readEmbeddedPictures lists every picture in a file, in stored order.
+func readEmbeddedPictures(filePath string) ([]EmbeddedPicture, error) {
+	switch format := tagFormatForFile(filePath); format {
+	case "flac":
+		return readFLACPictures(filePath)
+	case "ogg":
+		return readOggPictures(filePath)
+	case "mp3":
+		return readMP3Pictures(filePath)
+	case "wav", "aiff":
+		magic := "RIFF"
+		if format == "aiff" {
+			magic = "FORM"
+		}
+		tag, err := readID3Chunk(filePath, magic)
+		if err != nil {
+			return nil, err
+		}
+		return id3TagPictures(tag), nil
+	case "mp4":
+		return readM4APictures(filePath)
+	case "ape":
+		return readAPEPictures(filePath)
+	}
+	return nil, fmt.Errorf("unsupported file type for pictures: %s", filepath.Ext(filePath))
+}
+
+// writeEmbeddedPictures replaces every picture whose type appears in pictures
+// or removeTypes with pictures, leaving other types untouched.
+func writeEmbeddedPictures(filePath string, pictures []EmbeddedPicture, removeTypes ...int) error {
+	types := map[int]bool{}
+	for _, pic := range pictures {
+		types[pic.Type] = true
+	}
+	for _, t := range removeTypes {
+		types[t] = true
+	}
+	if len(types) == 0 {
+		return nil
+	}
+
+	switch format := tagFormatForFile(filePath); format {
+	case "flac":
+		return writeFLACPictures(filePath, pictures, types)
+	case "ogg":
+		return rewriteOggComments(filePath, func(cmt *flacvorbis.MetaDataBlockVorbisComment, _ oggStreamType) {
+			dropVorbisPictures(cmt, types)
+			for _, pic := range pictures {
+				block, err := buildTypedPictureBlock(coverTargetOgg, "", pic.Data, pic.Type, pic.Description)
+				if err != nil {
+					GoLog("[Pictures] Skipping %s picture: %v\n", pic.TypeName, err)
+					continue
+				}
+				cmt.Comments = append(cmt.Comments, "METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(block.Data))
+			}
+		})
+	case "mp3":
+		return rewriteMP3Frames(filePath, func(frames []id3Frame) []id3Frame {
+			return replaceID3Pictures(frames, pictures, types)
+		})
+	case "wav":
+		return rewriteID3ChunkFrames(filePath, "RIFF", id3ChunkWAV, true, func(frames []id3Frame) []id3Frame {
+			return replaceID3Pictures(frames, pictures, types)
+		})
+	case "aiff":
+		return rewriteID3ChunkFrames(filePath, "FORM", id3ChunkAIFF, false, func(frames []id3Frame) []id3Frame {
+			return replaceID3Pictures(frames, pictures, types)
+		})
+	case "mp4":
+		for _, pic := range pictures {
+			if pic.Type != pictureTypeFrontCover {
+				return fmt.Errorf("MP4 covr has no picture types and only takes a front cover, not %s", pic.TypeName)
+			}
+		}
+		return rewriteM4AIlst(filePath, true, func(buf []byte, items []mp4Box) []byte {
+			return replaceM4APictures(buf, items, pictures, types)
+		})
+	case "ape":
+		return writeAPEPictures(filePath, pictures, types)
+	}
+	return fmt.Errorf("unsupported file type for pictures: %s", filepath.Ext(filePath))
+}
+
+// --- FLAC picture blocks (FLAC, and base64 in Ogg comments) ---
+
+func parseFLACPicture(data []byte) (EmbeddedPicture, bool) {
+	pic, err := flacpicture.ParseFromMetaDataBlock(flac.MetaDataBlock{Type: flac.Picture, Data: data})
+	if err != nil || len(pic.ImageData) == 0 {
+		return EmbeddedPicture{}, false
+	}
+	out := newEmbeddedPicture(int(pic.PictureType), pic.MIME, pic.Description, pic.ImageData)
+	if out.Width == 0 && out.Height == 0 {
+		out.Width, out.Height = int(pic.Width), int(pic.Height)
+	}
+	return out, true
+}
+
+func readFLACPictures(filePath string) ([]EmbeddedPicture, error) {
+	f, err := flac.ParseFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse FLAC file: %w", err)
+	}
+	var pictures []EmbeddedPicture
+	for _, meta := range f.Meta {
+		if meta.Type != flac.Picture {
+			continue
+		}
+		if pic, ok := parseFLACPicture(meta.Data); ok {
+			pictures = append(pictures, pic)
+		}
+	}
+	return pictures, nil
+}
+
+// dropFLACPictures removes the picture blocks of the given types. Blocks that
+// cannot be parsed are kept.
+func dropFLACPictures(meta []*flac.MetaDataBlock, types map[int]bool) []*flac.MetaDataBlock {
+	out := meta[:0]
+	for _, block := range meta {
+		if block.Type == flac.Picture {
+			if pic, err := flacpicture.ParseFromMetaDataBlock(*block); err == nil && types[int(pic.PictureType)] {
+				continue
+			}
+		}
+		out = append(out, block)
+	}
+	return out
+}
+
+func writeFLACPictures(filePath string, pictures []EmbeddedPicture, types map[int]bool) error {
+	f, err := flac.ParseFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse FLAC file: %w", err)
+	}
+	f.Meta = dropFLACPictures(f.Meta, types)
+	for _, pic := range pictures {
+		block, err := buildTypedPictureBlock(coverTargetFLAC, "", pic.Data, pic.Type, pic.Description)
+		if err != nil {
+			GoLog("[Pictures] Skipping %s picture: %v\n", pic.TypeName, err)
+			continue
+		}
+		f.Meta = append(f.Meta, &block)
+	}
+	return f.Save(filePath)
+}
+
+func vorbisCommentPicture(comment string) (EmbeddedPicture, bool) {
+	eq := strings.IndexByte(comment, '=')
+	if eq <= 0 || !strings.EqualFold(comment[:eq], "METADATA_BLOCK_PICTURE") {
+		return EmbeddedPicture{}, false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(comment[eq+1:]))
+	if err != nil {
+		return EmbeddedPicture{}, false
+	}
+	return parseFLACPicture(data)
+}
+
+func picturesFromVorbisComments(comments []string) []EmbeddedPicture {
+	var pictures []EmbeddedPicture
+	for _, comment := range comments {
+		if pic, ok := vorbisCommentPicture(comment); ok {
+			pictures = append(pictures, pic)
+		}
+	}
+	return pictures
+}
+
+// dropVorbisPictures removes METADATA_BLOCK_PICTURE comments of the given
+// types. Replacing the front cover also drops the legacy COVERART pair, which
+// has no type and always meant the front cover.
+func dropVorbisPictures(cmt *flacvorbis.MetaDataBlockVorbisComment, types map[int]bool) {
+	out := cmt.Comments[:0]
+	for _, comment := range cmt.Comments {
+		if pic, ok := vorbisCommentPicture(comment); ok && types[pic.Type] {
+			continue
+		}
+		out = append(out, comment)
+	}
+	cmt.Comments = out
+	if types[pictureTypeFrontCover] {
+		removeCommentKey(cmt, "COVERART")
+		removeCommentKey(cmt, "COVERARTMIME")
+	}
+}
+
+func readOggPictures(filePath string) ([]EmbeddedPicture, error) {
+	comments, err := readOggComments(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return picturesFromVorbisComments(comments), nil
+}
+
+// --- ID3 APIC frames (MP3, and the WAV/AIFF id3 chunk) ---
+
+// id3FramePicture decodes a v2.4 APIC frame; v2.2 PIC frames have already
+// been converted by the frame parser.
+func id3FramePicture(fr id3Frame) (EmbeddedPicture, bool) {
+	if fr.id != "APIC" || len(fr.data) < 4 {
+		return EmbeddedPicture{}, false
+	}
+	encoding := fr.data[0]
+	mime, rest, ok := splitID3Terminated(0, fr.data[1:])
+	if !ok || len(rest) < 1 || string(mime) == "-->" {
+		return EmbeddedPicture{}, false
+	}
+	pictureType := int(rest[0])
+	desc, image, ok := splitID3Terminated(encoding, rest[1:])
+	if !ok || len(image) == 0 {
+		return EmbeddedPicture{}, false
+	}
+	return newEmbeddedPicture(pictureType, string(mime), decodeID3String(encoding, desc), image), true
+}
+
+func picturesFromID3Frames(frames []id3Frame) []EmbeddedPicture {
+	var pictures []EmbeddedPicture
+	for _, fr := range frames {
+		if pic, ok := id3FramePicture(fr); ok {
+			pictures = append(pictures, pic)
+		}
+	}
+	return pictures
+}
+
+// id3TagPictures lists the pictures of a raw ID3v2 tag.
+func id3TagPictures(tag []byte) []EmbeddedPicture {
+	if len(tag) == 0 {
+		return nil
+	}
+	frames, err := parseID3v2FramesForRewrite(tag)
+	if err != nil {
+		return nil
+	}
+	return picturesFromID3Frames(frames)
+}
+
+func readMP3Pictures(filePath string) ([]EmbeddedPicture, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tag, _, err := readID3v2TagRegion(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return id3TagPictures(tag), nil
+}
+
+func replaceID3Pictures(frames []id3Frame, pictures []EmbeddedPicture, types map[int]bool) []id3Frame {
+	replacement := make([]id3Frame, 0, len(pictures))
+	for _, pic := range pictures {
+		replacement = append(replacement, newID3PictureFrame(pic.MIME, byte(pic.Type), pic.Description, pic.Data))
+	}
+	return replaceID3Frames(frames, func(fr id3Frame) bool {
+		return types[id3PictureType(fr)]
+	}, replacement...)
+}
+
+// --- MP4 covr ---
+
+func m4aCoverImages(buf []byte, items []mp4Box) [][]byte {
+	for _, item := range items {
+		if item.typ == "covr" {
+			return m4aItemValues(buf, item)
+		}
+	}
+	return nil
+}
+
+func readM4APictures(filePath string) ([]EmbeddedPicture, error) {
+	buf, items, err := readM4AIlstItems(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var pictures []EmbeddedPicture
+	for i, image := range m4aCoverImages(buf, items) {
+		if len(image) == 0 {
+			continue
+		}
+		pictureType := pictureTypeOther
+		if i == 0 {
+			pictureType = pictureTypeFrontCover
+		}
+		pictures = append(pictures, newEmbeddedPicture(pictureType, "", "", image))
+	}
+	return pictures, nil
+}
+
+// itunesCoverImagesTag builds a covr item with one data atom per image.
+func itunesCoverImagesTag(images [][]byte) []byte {
+	var payload []byte
+	for _, image := range images {
+		typeCode := uint32(13) // JPEG
+		if detectCoverMIME("", image) == "image/png" {
+			typeCode = 14
+		}
+		data := make([]byte, 8+len(image))
+		data[3] = byte(typeCode)
+		copy(data[8:], image)
+		payload = append(payload, buildM4AAtom("data", data)...)
+	}
+	return buildM4AAtom("covr", payload)
+}
+
+// replaceM4APictures rebuilds covr. pictures holds at most a front cover,
+// which replaces the first image; the images after it are kept unless type 0
+// is being replaced.
+func replaceM4APictures(buf []byte, items []mp4Box, pictures []EmbeddedPicture, types map[int]bool) []byte {
+	existing := m4aCoverImages(buf, items)
+	var images [][]byte
+	if len(pictures) > 0 {
+		data, _, _ := processCover(coverTargetMP4, pictures[0].MIME, pictures[0].Data)
+		images = append(images, data)
+	} else if len(existing) > 0 && !types[pictureTypeFrontCover] {
+		images = append(images, existing[0])
+	}
+	if len(existing) > 1 && !types[pictureTypeOther] {
+		images = append(images, existing[1:]...)
+	}
+
+	out := make([]byte, 0, len(buf))
+	inserted := false
+	for _, item := range items {
+		if item.typ == "covr" {
+			if !inserted && len(images) > 0 {
+				out = append(out, itunesCoverImagesTag(images)...)
+			}
+			inserted = true
+			continue
+		}
+		out = append(out, buf[item.offset:item.end()]...)
+	}
+	if !inserted && len(images) > 0 {
+		out = append(out, itunesCoverImagesTag(images)...)
+	}
+	return out
+}
+
+// --- APEv2 binary items ---
+
+func apePictureType(key string) int {
+	for t, name := range apePictureKeys {
+		if strings.EqualFold(key, name) {
+			return t
+		}
+	}
+	return -1
+}
+
+func readAPEPictures(filePath string) ([]EmbeddedPicture, error) {
+	tag, err := ReadAPETags(filePath)
+	if errors.Is(err, errNoAPETag) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pictures []EmbeddedPicture
+	for _, item := range tag.Items {
+		pictureType := apePictureType(item.Key)
+		if pictureType < 0 || item.Flags&(3<<1) != apeItemFlagBinary {
+			continue
+		}
+		// The value is a file name, a NUL, then the image.
+		name, image, ok := strings.Cut(item.Value, "\x00")
+		if !ok || image == "" {
+			continue
+		}
+		pictures = append(pictures, newEmbeddedPicture(pictureType, detectCoverMIME(name, []byte(image)), "", []byte(image)))
+	}
+	return pictures, nil
+}
+
+// writeAPEPictures stores one item per type; APEv2 keys are unique, so only
+// the first picture of each type is kept.
+func writeAPEPictures(filePath string, pictures []EmbeddedPicture, types map[int]bool) error {
+	tag, err := ReadAPETags(filePath)
+	if err != nil {
+		tag = &APETag{Version: apeTagVersion2}
+	}
+	items := tag.Items[:0]
+	for _, item := range tag.Items {
+		if t := apePictureType(item.Key); t >= 0 && types[t] {
+			continue
+		}
+		items = append(items, item)
+	}
+	written := map[int]bool{}
+	for _, pic := range pictures {
+		if written[pic.Type] {
+			GoLog("[Pictures] APE holds one %s picture; skipping the rest\n", pic.TypeName)
+			continue
+		}
+		written[pic.Type] = true
+		pic.Data, pic.MIME, _ = processCover(coverTargetAPE, pic.MIME, pic.Data)
+		name := pic.TypeName + ".jpg"
+		if pic.MIME == "image/png" {
+			name = pic.TypeName + ".png"
+		}
+		items = append(items, APETagItem{Key: apePictureKeys[pic.Type], Value: name + "\x00" + string(pic.Data), Flags: apeItemFlagBinary})
+	}
+	tag.Items = items
+	tag.Version = apeTagVersion2
+	return WriteAPETags(filePath, tag)
+}
@@ -0,0 +1,244 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTestPicture(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func listTestPictures(t *testing.T, path string) []EmbeddedPicture {
+	t.Helper()
+	response, err := ListPicturesJSON(path)
+	if err != nil {
+		t.Fatalf("ListPicturesJSON: %v", err)
+	}
+	var decoded struct {
+		Pictures []EmbeddedPicture `json:"pictures"`
+	}
+	if err := json.Unmarshal([]byte(response), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded.Pictures
+}
+
+func pictureSummary(pictures []EmbeddedPicture) string {
+	var out []string
+	for _, pic := range pictures {
+		out = append(out, fmt.Sprintf("%d:%dx%d", pic.Type, pic.Width, pic.Height))
+	}
+	return fmt.Sprint(out)
+}
+
+func TestParsePictureType(t *testing.T) {
+	cases := map[string]int{
+		"3":              pictureTypeFrontCover,
+		"back_cover":     pictureTypeBackCover,
+		"Back Cover":     pictureTypeBackCover,
+		"booklet":        pictureTypeLeaflet,
+		"disc":           pictureTypeMedia,
+		"band-logo":      19,
+		"publisher_logo": maxPictureType,
+	}
+	for input, want := range cases {
+		if got, err := parsePictureType(input); err != nil || got != want {
+			t.Errorf("parsePictureType(%q) = %d, %v; want %d", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"21", "-1", "poster", ""} {
+		if _, err := parsePictureType(input); err == nil {
+			t.Errorf("parsePictureType(%q) should fail", input)
+		}
+	}
+}
+
+func TestPicturesRoundTripEveryContainer(t *testing.T) {
+	dir := t.TempDir()
+	front := writeTestPicture(t, dir, "front.png", testPNG(t, 4, 4))
+	back := writeTestPicture(t, dir, "back.png", testPNG(t, 5, 4))
+	page1 := writeTestPicture(t, dir, "page1.png", testPNG(t, 3, 6))
+	page2 := writeTestPicture(t, dir, "page2.png", testPNG(t, 3, 7))
+	request := fmt.Sprintf(`{"pictures":[
+		{"type":"front_cover","path":%q},
+		{"type":4,"path":%q},
+		{"type":"booklet","path":%q,"description":"Page 1"},
+		{"type":"booklet","path":%q,"description":"Page 2"}]}`, front, back, page1, page2)
+
+	cases := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"song.flac", buildTestFLAC("TITLE=Song"), "[3:4x4 4:5x4 5:3x6 5:3x7]"},
+		{"song.opus", buildTestOggStream(testOpusHeaders("TITLE=Song"), [][]byte{{1, 2, 3}}), "[3:4x4 4:5x4 5:3x6 5:3x7]"},
+		{"song.mp3", append(buildID3v23Tag(id3TextFrame("TIT2", "Song")), testMP3Audio...), "[3:4x4 4:5x4 5:3x6 5:3x7]"},
+		{"song.wav", buildTestWAV(), "[3:4x4 4:5x4 5:3x6 5:3x7]"},
+		{"song.aiff", buildTestAIFF(), "[3:4x4 4:5x4 5:3x6 5:3x7]"},
+		{"song.ape", []byte("audio-data"), "[3:4x4 4:5x4 5:3x6]"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestPicture(t, t.TempDir(), tc.name, tc.data)
+
+			if _, err := WritePicturesJSON(path, request); err != nil {
+				t.Fatalf("WritePicturesJSON: %v", err)
+			}
+			if got := pictureSummary(listTestPictures(t, path)); got != tc.want {
+				t.Fatalf("pictures = %s, want %s", got, tc.want)
+			}
+
+			// Replacing only the front cover keeps the rest.
+			newFront := testPNG(t, 8, 8)
+			if err := writeEmbeddedPictures(path, []EmbeddedPicture{newEmbeddedPicture(pictureTypeFrontCover, "", "", newFront)}); err != nil {
+				t.Fatalf("writeEmbeddedPictures: %v", err)
+			}
+			pictures := listTestPictures(t, path)
+			if len(picturesOfType(pictures, pictureTypeFrontCover)) != 1 || len(pictures) != strings.Count(tc.want, ":") {
+				t.Fatalf("after cover replace: %s", pictureSummary(pictures))
+			}
+			if data, _, err := extractAnyCoverArt(path); tc.name != "song.ape" && (err != nil || !bytes.Equal(data, newFront)) {
+				t.Fatalf("extractAnyCoverArt = %d bytes, %v", len(data), err)
+			}
+
+			out := filepath.Join(t.TempDir(), "back.png")
+			if err := ExtractPictureToFile(path, out, "back_cover", 0); err != nil {
+				t.Fatalf("ExtractPictureToFile: %v", err)
+			}
+			if !bytes.Equal(mustReadFile(t, out), mustReadFile(t, back)) {
+				t.Fatal("extracted back cover does not match")
+			}
+			if err := ExtractPictureToFile(path, out, "back_cover", 1); err == nil {
+				t.Fatal("expected an error for a missing index")
+			}
+
+			if _, err := WritePicturesJSON(path, `{"remove_types":["back_cover"]}`); err != nil {
+				t.Fatalf("WritePicturesJSON remove: %v", err)
+			}
+			if pictures := listTestPictures(t, path); len(picturesOfType(pictures, pictureTypeBackCover)) != 0 {
+				t.Fatalf("back cover not removed: %s", pictureSummary(pictures))
+			}
+		})
+	}
+}
+
+func TestCoverWritersKeepOtherPictures(t *testing.T) {
+	dir := t.TempDir()
+	cover := testPNG(t, 6, 6)
+	coverPath := writeTestPicture(t, dir, "cover.png", cover)
+	booklet := []EmbeddedPicture{newEmbeddedPicture(pictureTypeLeaflet, "", "Page 1", testPNG(t, 3, 6))}
+
+	cases := []struct {
+		name  string
+		data  []byte
+		write func(path string) error
+	}{
+		{"song.flac", buildTestFLAC("TITLE=Song"), func(path string) error {
+			return EmbedMetadataWithCoverData(path, Metadata{Title: "New"}, cover)
+		}},
+		{"song.opus", buildTestOggStream(testOpusHeaders("TITLE=Song"), [][]byte{{1, 2, 3}}), func(path string) error {
+			return WriteOggTags(path, map[string]string{"title": "New", "cover_path": coverPath})
+		}},
+		{"song.wav", buildTestWAV(), func(path string) error {
+			return WriteWAVTags(path, map[string]string{"title": "New", "cover_path": coverPath})
+		}},
+		{"song.aiff", buildTestAIFF(), func(path string) error {
+			return WriteAIFFTags(path, map[string]string{"title": "New"})
+		}},
+		// covr cannot be given a booklet page, so this one comes from
+		// another tagger as a second image.
+		{"song.m4a", buildTestM4AWithChunks(append(buildM4ATextTag("\xa9nam", "Song"), itunesCoverImagesTag([][]byte{testPNG(t, 2, 2), testPNG(t, 3, 6)})...), false), func(path string) error {
+			return WriteM4ATags(path, map[string]string{"title": "New", "cover_path": coverPath})
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestPicture(t, t.TempDir(), tc.name, tc.data)
+			if tc.name != "song.m4a" {
+				if err := writeEmbeddedPictures(path, booklet); err != nil {
+					t.Fatalf("writeEmbeddedPictures: %v", err)
+				}
+			}
+
+			if err := tc.write(path); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+
+			pictures, err := readEmbeddedPictures(path)
+			if err != nil {
+				t.Fatalf("readEmbeddedPictures: %v", err)
+			}
+			kept := false
+			for _, pic := range pictures {
+				if pic.Width == 3 && pic.Height == 6 {
+					kept = true
+				}
+			}
+			if !kept {
+				t.Fatalf("booklet page lost on re-tag: %s", pictureSummary(pictures))
+			}
+		})
+	}
+}
+
+func TestM4APicturesOnlyTakeFrontCover(t *testing.T) {
+	dir := t.TempDir()
+	back := writeTestPicture(t, dir, "back.png", testPNG(t, 5, 4))
+	extra := testPNG(t, 3, 6)
+	data := buildTestM4AWithChunks(append(buildM4ATextTag("\xa9nam", "Song"), itunesCoverImagesTag([][]byte{testPNG(t, 2, 2), extra})...), false)
+	path := writeTestPicture(t, dir, "song.m4a", data)
+
+	if _, err := WritePicturesJSON(path, fmt.Sprintf(`{"pictures":[{"type":"back_cover","path":%q}]}`, back)); err == nil {
+		t.Fatal("expected MP4 to reject a back cover")
+	}
+	if !bytes.Equal(mustReadFile(t, path), data) {
+		t.Fatal("rejected write changed the file")
+	}
+
+	if err := writeEmbeddedPictures(path, []EmbeddedPicture{newEmbeddedPicture(pictureTypeFrontCover, "", "", testPNG(t, 4, 4))}); err != nil {
+		t.Fatalf("writeEmbeddedPictures: %v", err)
+	}
+	if got := pictureSummary(listTestPictures(t, path)); got != "[3:4x4 0:3x6]" {
+		t.Fatalf("pictures = %s", got)
+	}
+	if _, err := WritePicturesJSON(path, `{"remove_types":["other"]}`); err != nil {
+		t.Fatalf("WritePicturesJSON remove: %v", err)
+	}
+	if got := pictureSummary(listTestPictures(t, path)); got != "[3:4x4]" {
+		t.Fatalf("pictures after removing extras = %s", got)
+	}
+}
+
+func TestReadAPEPicturesReportsReadErrors(t *testing.T) {
+	if _, err := readAPEPictures(filepath.Join(t.TempDir(), "missing.ape")); err == nil {
+		t.Fatal("expected an error for an unreadable file")
+	}
+	path := writeTestPicture(t, t.TempDir(), "plain.ape", bytes.Repeat([]byte("audio"), 40))
+	if pictures, err := readAPEPictures(path); err != nil || len(pictures) != 0 {
+		t.Fatalf("untagged file = %v, %v; want no pictures", pictures, err)
+	}
+}
@@ -25,7 +25,13 @@ type ItemProgress struct {
 	SpeedMBps     float64 `json:"speed_mbps"`
 	IsDownloading bool    `json:"is_downloading"`
 	Status        string  `json:"status"`
-	revision      int64
+	// SmoothedSpeedMBps averages SpeedMBps over recent updates and drives
+	// ETASeconds, which is 0 while unknown.
+	SmoothedSpeedMBps float64 `json:"smoothed_speed_mbps,omitempty"`
+	ETASeconds        int64   `json:"eta_seconds,omitempty"`
+	revision          int64
+	// pausedFrom is the status to restore when a paused item resumes.
+	pausedFrom string
 }
 
 const (
@@ -33,6 +39,7 @@ const (
 	itemProgressStatusDownloading = "downloading"
 	itemProgressStatusCompleted   = "completed"
 	itemProgressStatusFinalizing  = "finalizing"
+	itemProgressStatusPaused      = "paused"
 )
 
 type MultiProgress struct {
@@ -65,8 +72,20 @@ var (
 	removedProgressSeq  = make(map[string]int64)
 )
 
+// speedSmoothingFactor weighs the newest sample in SmoothedSpeedMBps.
+const speedSmoothingFactor = 0.3
+
+func itemETASeconds(item *ItemProgress) int64 {
+	remaining := item.BytesTotal - item.BytesReceived
+	if remaining <= 0 || item.SmoothedSpeedMBps <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(float64(remaining) / (item.SmoothedSpeedMBps * 1024 * 1024)))
+}
+
 func markMultiProgressDirtyLocked() {
 	multiProgressDirty = true
+	wakeProgressListener()
 }
 
 func nextMultiProgressSeqLocked() int64 {
@@ -100,6 +119,7 @@ func itemProgressBridgeState(item *ItemProgress) progressBridgeState {
 func markMultiProgressDirtyIfChangedLocked(item *ItemProgress, before progressBridgeState) {
 	if itemProgressBridgeState(item) != before {
 		item.revision = nextMultiProgressSeqLocked()
+		queueProgressStatusEvent(item.ItemID, before.status, item.Status, item.revision)
 		markMultiProgressDirtyLocked()
 	}
 }
@@ -146,11 +166,26 @@ func GetMultiProgress() string {
 }
 
 func GetMultiProgressDelta(sinceSeq int64) string {
+	delta, ok := buildMultiProgressDelta(sinceSeq)
+	if !ok {
+		return ""
+	}
+	jsonBytes, err := json.Marshal(delta)
+	if err != nil {
+		return ""
+	}
+	return string(jsonBytes)
+}
+
+// buildMultiProgressDelta collects the changes after sinceSeq; ok is false
+// when nothing changed.
+func buildMultiProgressDelta(sinceSeq int64) (MultiProgressDelta, bool) {
 	multiMu.RLock()
+	defer multiMu.RUnlock()
+
 	currentSeq := multiProgressSeq
 	if sinceSeq >= currentSeq {
-		multiMu.RUnlock()
-		return ""
+		return MultiProgressDelta{}, false
 	}
 
 	reset := sinceSeq <= 0 || sinceSeq < multiProgressReset
@@ -184,13 +219,7 @@ func GetMultiProgressDelta(sinceSeq int64) string {
 			}
 		}
 	}
-	multiMu.RUnlock()
-
-	jsonBytes, err := json.Marshal(delta)
-	if err != nil {
-		return ""
-	}
-	return string(jsonBytes)
+	return delta, true
 }
 
 func GetItemProgress(itemID string) string {
@@ -204,10 +233,28 @@ func GetItemProgress(itemID string) string {
 	return "{}"
 }
 
+// snapshotItemProgress copies the progress of the given items that exist.
+func snapshotItemProgress(itemIDs []string) map[string]ItemProgress {
+	multiMu.RLock()
+	defer multiMu.RUnlock()
+
+	items := make(map[string]ItemProgress, len(itemIDs))
+	for _, id := range itemIDs {
+		if item, ok := multiProgress.Items[id]; ok {
+			items[id] = *item
+		}
+	}
+	return items
+}
+
 func StartItemProgress(itemID string) {
 	multiMu.Lock()
 	defer multiMu.Unlock()
 
+	var previous string
+	if item, ok := multiProgress.Items[itemID]; ok {
+		previous = item.Status
+	}
 	multiProgress.Items[itemID] = &ItemProgress{
 		ItemID:        itemID,
 		BytesTotal:    0,
@@ -217,10 +264,37 @@ func StartItemProgress(itemID string) {
 		Status:        itemProgressStatusPreparing,
 		revision:      nextMultiProgressSeqLocked(),
 	}
+	queueProgressStatusEvent(itemID, previous, itemProgressStatusPreparing, multiProgress.Items[itemID].revision)
 	delete(removedProgressSeq, itemID)
 	markMultiProgressDirtyLocked()
 }
 
+// SetItemProgressStatus sets the status of an item, creating its record if
+// needed. The download queue uses it for items that are not downloading.
+func SetItemProgressStatus(itemID, status string) {
+	multiMu.Lock()
+	defer multiMu.Unlock()
+
+	item, ok := multiProgress.Items[itemID]
+	if !ok {
+		item = &ItemProgress{ItemID: itemID}
+		multiProgress.Items[itemID] = item
+		delete(removedProgressSeq, itemID)
+	}
+	if !ok || item.Status != status {
+		previous := item.Status
+		item.Status = status
+		item.IsDownloading = false
+		item.SpeedMBps = 0
+		if status == itemProgressStatusCompleted {
+			item.Progress = 1.0
+		}
+		item.revision = nextMultiProgressSeqLocked()
+		queueProgressStatusEvent(itemID, previous, status, item.revision)
+		markMultiProgressDirtyLocked()
+	}
+}
+
 func SetItemPreparing(itemID string) {
 	multiMu.Lock()
 	defer multiMu.Unlock()
@@ -241,7 +315,7 @@ func SetItemDownloading(itemID string) {
 	multiMu.Lock()
 	defer multiMu.Unlock()
 
-	if item, ok := multiProgress.Items[itemID]; ok {
+	if item, ok := multiProgress.Items[itemID]; ok && item.Status != itemProgressStatusPaused {
 		before := itemProgressBridgeState(item)
 		item.IsDownloading = true
 		item.Status = itemProgressStatusDownloading
@@ -249,6 +323,38 @@ func SetItemDownloading(itemID string) {
 	}
 }
 
+// SetItemPaused marks a downloading item as paused until ResumeItemProgress.
+func SetItemPaused(itemID string) {
+	multiMu.Lock()
+	defer multiMu.Unlock()
+
+	if item, ok := multiProgress.Items[itemID]; ok && item.Status != itemProgressStatusPaused {
+		before := itemProgressBridgeState(item)
+		item.pausedFrom = item.Status
+		item.IsDownloading = false
+		item.SpeedMBps = 0
+		item.ETASeconds = 0
+		item.Status = itemProgressStatusPaused
+		markMultiProgressDirtyIfChangedLocked(item, before)
+	}
+}
+
+func ResumeItemProgress(itemID string) {
+	multiMu.Lock()
+	defer multiMu.Unlock()
+
+	if item, ok := multiProgress.Items[itemID]; ok && item.Status == itemProgressStatusPaused {
+		before := itemProgressBridgeState(item)
+		item.Status = item.pausedFrom
+		if item.Status == "" {
+			item.Status = itemProgressStatusDownloading
+		}
+		item.IsDownloading = item.Status == itemProgressStatusDownloading || item.Status == itemProgressStatusPreparing
+		item.pausedFrom = ""
+		markMultiProgressDirtyIfChangedLocked(item, before)
+	}
+}
+
 func SetItemBytesTotal(itemID string, total int64) {
 	multiMu.Lock()
 	defer multiMu.Unlock()
@@ -270,7 +376,7 @@ func SetItemBytesReceived(itemID string, received int64) {
 		if item.BytesTotal > 0 {
 			item.Progress = float64(received) / float64(item.BytesTotal)
 		}
-		if received > 0 {
+		if received > 0 && item.Status != itemProgressStatusPaused {
 			item.IsDownloading = true
 			item.Status = itemProgressStatusDownloading
 		}
@@ -289,7 +395,15 @@ func SetItemBytesReceivedWithSpeed(itemID string, received int64, speedMBps floa
 		if item.BytesTotal > 0 {
 			item.Progress = float64(received) / float64(item.BytesTotal)
 		}
-		if received > 0 {
+		if speedMBps > 0 && !math.IsNaN(speedMBps) {
+			if item.SmoothedSpeedMBps <= 0 {
+				item.SmoothedSpeedMBps = speedMBps
+			} else {
+				item.SmoothedSpeedMBps += speedSmoothingFactor * (speedMBps - item.SmoothedSpeedMBps)
+			}
+		}
+		item.ETASeconds = itemETASeconds(item)
+		if received > 0 && item.Status != itemProgressStatusPaused {
 			item.IsDownloading = true
 			item.Status = itemProgressStatusDownloading
 		}
@@ -305,6 +419,7 @@ func CompleteItemProgress(itemID string) {
 		before := itemProgressBridgeState(item)
 		item.Progress = 1.0
 		item.IsDownloading = false
+		item.ETASeconds = 0
 		item.Status = itemProgressStatusCompleted
 		markMultiProgressDirtyIfChangedLocked(item, before)
 	}
@@ -398,10 +513,26 @@ func NewItemProgressWriter(w interface{ Write([]byte) (int, error) }, itemID str
 	}
 }
 
+// newResumedItemProgressWriter reports progress for a download that
+// continues at offset bytes into the file.
+func newResumedItemProgressWriter(w interface{ Write([]byte) (int, error) }, itemID string, offset int64) *ItemProgressWriter {
+	pw := NewItemProgressWriter(w, itemID)
+	pw.current = offset
+	pw.lastBytes = offset
+	return pw
+}
+
 func (pw *ItemProgressWriter) Write(p []byte) (int, error) {
 	if pw.itemID != "" && isDownloadCancelled(pw.itemID) {
 		return 0, ErrDownloadCancelled
 	}
+	if waited, err := waitWhileDownloadPaused(pw.itemID); err != nil {
+		return 0, err
+	} else if waited {
+		// Time spent paused does not count towards the speed.
+		pw.lastTime = time.Now()
+		pw.lastBytes = pw.current
+	}
 	n, err := pw.writer.Write(p)
 	if err != nil {
 		return n, err
@@ -424,3 +555,17 @@ func (pw *ItemProgressWriter) Write(p []byte) (int, error) {
 	}
 	return n, nil
 }
+
+// pausableWriter holds writes while its item is paused, for downloads that
+// do not report bytes through ItemProgressWriter.
+type pausableWriter struct {
+	writer interface{ Write([]byte) (int, error) }
+	itemID string
+}
+
+func (w *pausableWriter) Write(p []byte) (int, error) {
+	if _, err := waitWhileDownloadPaused(w.itemID); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
@@ -0,0 +1,195 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// ProgressListener receives progress pushed by the backend instead of the
+// app polling GetAllDownloadProgressDelta and GetLibraryScanProgressJSON.
+// gomobile turns it into a Java/Objective-C interface the app implements.
+//
+// OnProgress gets the same JSON as GetAllDownloadProgressDelta, coalesced
+// so it fires at most once per interval. OnEvent gets discrete events:
+//
+//	{"type":"status","item_id":"...","status":"downloading","previous_status":"preparing","seq":12}
+//	{"type":"library_scan","progress":{...LibraryScanProgress...}}
+//
+// Both are called from a single backend goroutine, never concurrently.
+type ProgressListener interface {
+	OnProgress(deltaJSON string)
+	OnEvent(eventJSON string)
+}
+
+const (
+	defaultProgressListenerInterval = 250 * time.Millisecond
+	minProgressListenerInterval     = 50 * time.Millisecond
+	// maxPendingProgressEvents bounds the event backlog when the listener
+	// falls behind; the oldest events are dropped first.
+	maxPendingProgressEvents = 512
+)
+
+type progressStatusEvent struct {
+	Type           string `json:"type"`
+	ItemID         string `json:"item_id"`
+	Status         string `json:"status"`
+	PreviousStatus string `json:"previous_status,omitempty"`
+	Seq            int64  `json:"seq"`
+}
+
+type libraryScanEvent struct {
+	Type     string              `json:"type"`
+	Progress LibraryScanProgress `json:"progress"`
+}
+
+var (
+	progressListenerSetMu    sync.Mutex
+	progressListenerMu       sync.Mutex
+	progressListener         ProgressListener
+	progressListenerInterval = defaultProgressListenerInterval
+	progressListenerStop     chan struct{}
+	progressListenerDone     chan struct{}
+	pendingProgressEvents    []progressStatusEvent
+	libraryScanProgressDirty bool
+
+	// progressListenerWake is poked on every progress change; it holds at
+	// most one pending wake-up so the senders never block.
+	progressListenerWake = make(chan struct{}, 1)
+)
+
+// SetProgressListener starts pushing progress to listener, replacing any
+// previous one. The first OnProgress carries a full reset delta. Passing nil
+// stops the pushes.
+//
+// It returns once the previous listener has received its last callback, so
+// it must not be called from inside OnProgress or OnEvent: that would wait
+// on the very goroutine making the call.
+func SetProgressListener(listener ProgressListener) {
+	progressListenerSetMu.Lock()
+	defer progressListenerSetMu.Unlock()
+
+	progressListenerMu.Lock()
+	stop, done := progressListenerStop, progressListenerDone
+	progressListener = nil
+	progressListenerMu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	progressListenerMu.Lock()
+	defer progressListenerMu.Unlock()
+	progressListener = listener
+	progressListenerStop, progressListenerDone = nil, nil
+	pendingProgressEvents = nil
+	libraryScanProgressDirty = false
+	if listener != nil {
+		progressListenerStop = make(chan struct{})
+		progressListenerDone = make(chan struct{})
+		go runProgressListener(listener, progressListenerStop, progressListenerDone)
+	}
+}
+
+// SetProgressListenerInterval sets the minimum time between pushes.
+func SetProgressListenerInterval(intervalMs int) {
+	interval := time.Duration(intervalMs) * time.Millisecond
+	if interval <= 0 {
+		interval = defaultProgressListenerInterval
+	}
+	progressListenerMu.Lock()
+	progressListenerInterval = max(interval, minProgressListenerInterval)
+	progressListenerMu.Unlock()
+}
+
+func wakeProgressListener() {
+	select {
+	case progressListenerWake <- struct{}{}:
+	default:
+	}
+}
+
+// queueProgressStatusEvent is called with multiMu held whenever an item
+// moves to another stage. Events are encoded by the listener goroutine, so
+// nothing is marshalled while multiMu is held.
+func queueProgressStatusEvent(itemID, previous, status string, seq int64) {
+	if previous == status {
+		return
+	}
+
+	progressListenerMu.Lock()
+	if progressListener != nil {
+		if len(pendingProgressEvents) >= maxPendingProgressEvents {
+			pendingProgressEvents = pendingProgressEvents[1:]
+		}
+		pendingProgressEvents = append(pendingProgressEvents, progressStatusEvent{
+			Type:           "status",
+			ItemID:         itemID,
+			Status:         status,
+			PreviousStatus: previous,
+			Seq:            seq,
+		})
+	}
+	progressListenerMu.Unlock()
+}
+
+func markLibraryScanProgressChanged() {
+	progressListenerMu.Lock()
+	active := progressListener != nil
+	if active {
+		libraryScanProgressDirty = true
+	}
+	progressListenerMu.Unlock()
+	if active {
+		wakeProgressListener()
+	}
+}
+
+func runProgressListener(listener ProgressListener, stop, done chan struct{}) {
+	defer close(done)
+
+	var lastSeq int64
+	wakeProgressListener()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-progressListenerWake:
+		}
+
+		progressListenerMu.Lock()
+		events := pendingProgressEvents
+		pendingProgressEvents = nil
+		scanDirty := libraryScanProgressDirty
+		libraryScanProgressDirty = false
+		interval := progressListenerInterval
+		progressListenerMu.Unlock()
+
+		for _, event := range events {
+			if data, err := json.Marshal(event); err == nil {
+				listener.OnEvent(string(data))
+			}
+		}
+		if scanDirty {
+			libraryScanProgressMu.RLock()
+			event := libraryScanEvent{Type: "library_scan", Progress: libraryScanProgress}
+			libraryScanProgressMu.RUnlock()
+			if data, err := json.Marshal(event); err == nil {
+				listener.OnEvent(string(data))
+			}
+		}
+		if delta, ok := buildMultiProgressDelta(lastSeq); ok {
+			if data, err := json.Marshal(delta); err == nil {
+				listener.OnProgress(string(data))
+			}
+			lastSeq = delta.Seq
+		}
+
+		// Changes that arrive meanwhile are coalesced into the next push.
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
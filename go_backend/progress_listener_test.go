@@ -0,0 +1,104 @@
+package gobackend
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingProgressListener struct {
+	mu       sync.Mutex
+	progress []MultiProgressDelta
+	events   []map[string]any
+}
+
+func (l *recordingProgressListener) OnProgress(deltaJSON string) {
+	var delta MultiProgressDelta
+	json.Unmarshal([]byte(deltaJSON), &delta)
+	l.mu.Lock()
+	l.progress = append(l.progress, delta)
+	l.mu.Unlock()
+}
+
+func (l *recordingProgressListener) OnEvent(eventJSON string) {
+	var event map[string]any
+	json.Unmarshal([]byte(eventJSON), &event)
+	l.mu.Lock()
+	l.events = append(l.events, event)
+	l.mu.Unlock()
+}
+
+func (l *recordingProgressListener) statuses(itemID string) []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var statuses []string
+	for _, event := range l.events {
+		if event["type"] == "status" && event["item_id"] == itemID {
+			statuses = append(statuses, event["status"].(string))
+		}
+	}
+	return statuses
+}
+
+func TestProgressListenerPushesCoalescedDeltasAndEvents(t *testing.T) {
+	const itemID = "listener-item"
+	SetProgressListenerInterval(50)
+	defer SetProgressListenerInterval(0)
+	listener := &recordingProgressListener{}
+	SetProgressListener(listener)
+	defer SetProgressListener(nil)
+	defer RemoveItemProgress(itemID)
+
+	StartItemProgress(itemID)
+	SetItemDownloading(itemID)
+	SetItemBytesTotal(itemID, 100<<20)
+	for i := 1; i <= 200; i++ {
+		SetItemBytesReceivedWithSpeed(itemID, int64(i)<<19, 2)
+	}
+	SetItemFinalizing(itemID)
+	CompleteItemProgress(itemID)
+	updateLibraryScanProgress(1, 2, "/music/a.flac")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(listener.statuses(itemID)) < 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("statuses = %v", listener.statuses(itemID))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(120 * time.Millisecond)
+
+	want := []string{itemProgressStatusPreparing, itemProgressStatusDownloading, itemProgressStatusFinalizing, itemProgressStatusCompleted}
+	if got := listener.statuses(itemID); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] || got[3] != want[3] {
+		t.Fatalf("statuses = %v, want %v", got, want)
+	}
+
+	listener.mu.Lock()
+	defer listener.mu.Unlock()
+	// 200 byte updates within one interval collapse into a few pushes.
+	if len(listener.progress) == 0 || len(listener.progress) > 5 {
+		t.Fatalf("got %d progress pushes", len(listener.progress))
+	}
+	if !listener.progress[0].Reset {
+		t.Fatal("first push is not a reset delta")
+	}
+	last := listener.progress[len(listener.progress)-1]
+	if item := last.Items[itemID]; item == nil || item.Status != itemProgressStatusCompleted {
+		t.Fatalf("last delta = %+v", last)
+	}
+	for i := 1; i < len(listener.progress); i++ {
+		if listener.progress[i].Seq <= listener.progress[i-1].Seq {
+			t.Fatalf("sequence went from %d to %d", listener.progress[i-1].Seq, listener.progress[i].Seq)
+		}
+	}
+	scanEvent := false
+	for _, event := range listener.events {
+		if event["type"] == "library_scan" {
+			scanEvent = event["progress"].(map[string]any)["current_file"] == "a.flac"
+		}
+	}
+	if !scanEvent {
+		t.Fatalf("no library scan event in %v", listener.events)
+	}
+}
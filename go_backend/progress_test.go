@@ -2,6 +2,8 @@ package gobackend
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"testing"
 )
 
@@ -118,3 +120,58 @@ func TestMultiProgressDeltaResetChangedAndRemoved(t *testing.T) {
 		t.Fatalf("removed = %#v, want item-a", removed.Removed)
 	}
 }
+
+func TestItemProgressPausedStatus(t *testing.T) {
+	const itemID = "progress-paused-item"
+	RemoveItemProgress(itemID)
+	defer RemoveItemProgress(itemID)
+
+	StartItemProgress(itemID)
+	SetItemBytesTotal(itemID, 1000)
+	SetItemBytesReceivedWithSpeed(itemID, 200, 1.5)
+	SetItemPaused(itemID)
+
+	// Late progress updates from other connections keep the paused status.
+	SetItemBytesReceivedWithSpeed(itemID, 300, 0)
+	SetItemDownloading(itemID)
+	if item := multiProgress.Items[itemID]; item.Status != itemProgressStatusPaused || item.IsDownloading || item.BytesReceived != 300 {
+		t.Fatalf("paused item = %+v", item)
+	}
+
+	ResumeItemProgress(itemID)
+	if item := multiProgress.Items[itemID]; item.Status != itemProgressStatusDownloading || !item.IsDownloading {
+		t.Fatalf("resumed item = %+v", item)
+	}
+
+	// Cancelling a paused download wakes its writer with an error.
+	defer clearDownloadCancel(itemID)
+	pauseDownload(itemID)
+	go cancelDownload(itemID)
+	if _, err := (&pausableWriter{writer: io.Discard, itemID: itemID}).Write([]byte("x")); !errors.Is(err, ErrDownloadCancelled) {
+		t.Fatalf("write after cancel while paused = %v", err)
+	}
+}
+
+func TestItemProgressSmoothedSpeedAndETA(t *testing.T) {
+	const itemID = "progress-eta-item"
+	RemoveItemProgress(itemID)
+	defer RemoveItemProgress(itemID)
+
+	StartItemProgress(itemID)
+	SetItemBytesTotal(itemID, 10<<20)
+	SetItemBytesReceivedWithSpeed(itemID, 2<<20, 2)
+	if item := multiProgress.Items[itemID]; item.SmoothedSpeedMBps != 2 || item.ETASeconds != 4 {
+		t.Fatalf("after first sample = %+v", item)
+	}
+
+	// A single spike only moves the smoothed speed part of the way.
+	SetItemBytesReceivedWithSpeed(itemID, 4<<20, 12)
+	if item := multiProgress.Items[itemID]; item.SmoothedSpeedMBps != 5 || item.ETASeconds != 2 {
+		t.Fatalf("after spike = %+v", item)
+	}
+
+	CompleteItemProgress(itemID)
+	if item := multiProgress.Items[itemID]; item.ETASeconds != 0 {
+		t.Fatalf("ETA after completion = %d", item.ETASeconds)
+	}
+}
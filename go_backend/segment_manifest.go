@@ -0,0 +1,745 @@
+package gobackend
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HLS and DASH manifests are reduced to a segmentPlan: an ordered list of
+// segment URLs (init section first) that concatenate into one playable file.
+
+type segmentByteRange struct {
+	offset int64
+	length int64
+}
+
+func (br *segmentByteRange) header() string {
+	return fmt.Sprintf("bytes=%d-%d", br.offset, br.offset+br.length-1)
+}
+
+// hlsSegmentKey describes an EXT-X-KEY with METHOD=AES-128.
+type hlsSegmentKey struct {
+	uri string
+	iv  []byte
+}
+
+type mediaSegment struct {
+	url       string
+	byteRange *segmentByteRange
+	key       *hlsSegmentKey
+}
+
+type segmentVariant struct {
+	url       string
+	bandwidth int64
+	codecs    string
+	mimeType  string
+	// audioGroup is the HLS AUDIO rendition group referenced by the variant.
+	audioGroup string
+}
+
+type segmentPlan struct {
+	segments  []mediaSegment
+	bandwidth int64
+	codecs    string
+}
+
+type segmentVariantPreference struct {
+	maxBandwidth int64
+	codecs       []string
+}
+
+// selectSegmentVariant picks the highest bandwidth variant matching the codec
+// preference that fits under maxBandwidth and returns its index. When every
+// variant is above the cap the lowest one is used rather than failing.
+func selectSegmentVariant(variants []segmentVariant, pref segmentVariantPreference) (int, error) {
+	if len(variants) == 0 {
+		return -1, fmt.Errorf("manifest has no variants")
+	}
+
+	var candidates []int
+	for i, v := range variants {
+		if len(pref.codecs) == 0 || segmentCodecsMatch(v.codecs, pref.codecs) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return -1, fmt.Errorf("no variant matches codecs %s", strings.Join(pref.codecs, ", "))
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return variants[candidates[i]].bandwidth > variants[candidates[j]].bandwidth
+	})
+	if pref.maxBandwidth > 0 {
+		for _, i := range candidates {
+			if variants[i].bandwidth <= pref.maxBandwidth {
+				return i, nil
+			}
+		}
+		return candidates[len(candidates)-1], nil
+	}
+	return candidates[0], nil
+}
+
+func segmentCodecsMatch(codecs string, wanted []string) bool {
+	for _, codec := range strings.Split(codecs, ",") {
+		codec = strings.ToLower(strings.TrimSpace(codec))
+		if codec == "" {
+			continue
+		}
+		for _, w := range wanted {
+			if strings.HasPrefix(codec, strings.ToLower(strings.TrimSpace(w))) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func resolveSegmentURL(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return "", fmt.Errorf("invalid segment URL %q: %w", ref, err)
+	}
+	if base == nil {
+		return u.String(), nil
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+// hlsMasterPlaylist holds the variants and audio renditions of a multivariant
+// playlist.
+type hlsMasterPlaylist struct {
+	variants   []segmentVariant
+	renditions map[string][]hlsRendition
+}
+
+type hlsRendition struct {
+	url       string
+	isDefault bool
+}
+
+func isHLSMasterPlaylist(body string) bool {
+	return strings.Contains(body, "#EXT-X-STREAM-INF")
+}
+
+// parseHLSAttributes splits an HLS attribute list, keeping commas inside
+// quoted strings.
+func parseHLSAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for len(s) > 0 {
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		name := strings.ToUpper(strings.TrimSpace(s[:eq]))
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+			if comma := strings.IndexByte(s, ','); comma >= 0 {
+				s = s[comma+1:]
+			} else {
+				s = ""
+			}
+		} else if comma := strings.IndexByte(s, ','); comma >= 0 {
+			value, s = s[:comma], s[comma+1:]
+		} else {
+			value, s = s, ""
+		}
+		attrs[name] = strings.TrimSpace(value)
+	}
+	return attrs
+}
+
+func parseHLSMasterPlaylist(base *url.URL, body string) (*hlsMasterPlaylist, error) {
+	master := &hlsMasterPlaylist{renditions: make(map[string][]hlsRendition)}
+	var pending map[string]string
+	for _, line := range splitPlaylistLines(body) {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			pending = parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MEDIA:"))
+			if attrs["TYPE"] != "AUDIO" || attrs["URI"] == "" {
+				continue
+			}
+			u, err := resolveSegmentURL(base, attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+			group := attrs["GROUP-ID"]
+			master.renditions[group] = append(master.renditions[group], hlsRendition{
+				url:       u,
+				isDefault: attrs["DEFAULT"] == "YES",
+			})
+		case strings.HasPrefix(line, "#"):
+			continue
+		default:
+			if pending == nil {
+				continue
+			}
+			u, err := resolveSegmentURL(base, line)
+			if err != nil {
+				return nil, err
+			}
+			bandwidth, _ := strconv.ParseInt(pending["BANDWIDTH"], 10, 64)
+			if avg, err := strconv.ParseInt(pending["AVERAGE-BANDWIDTH"], 10, 64); err == nil && bandwidth == 0 {
+				bandwidth = avg
+			}
+			master.variants = append(master.variants, segmentVariant{
+				url:        u,
+				bandwidth:  bandwidth,
+				codecs:     pending["CODECS"],
+				audioGroup: pending["AUDIO"],
+			})
+			pending = nil
+		}
+	}
+	if len(master.variants) == 0 {
+		return nil, fmt.Errorf("HLS master playlist has no variants")
+	}
+	return master, nil
+}
+
+// audioPlaylistURL returns the playlist to fetch for a variant: the default
+// rendition of its AUDIO group when the group carries its own URI, otherwise
+// the variant itself.
+func (m *hlsMasterPlaylist) audioPlaylistURL(v segmentVariant) string {
+	renditions := m.renditions[v.audioGroup]
+	if v.audioGroup == "" || len(renditions) == 0 {
+		return v.url
+	}
+	for _, r := range renditions {
+		if r.isDefault {
+			return r.url
+		}
+	}
+	return renditions[0].url
+}
+
+// parseHLSMediaPlaylist lists the segments of a VOD media playlist. Live
+// playlists are rejected because the result would be silently truncated.
+func parseHLSMediaPlaylist(base *url.URL, body string) ([]mediaSegment, error) {
+	var (
+		segments  []mediaSegment
+		sequence  int64
+		key       *hlsSegmentKey
+		keyIV     []byte
+		nextRange string
+		lastURL   string
+		lastEnd   int64
+		haveEnd   bool
+		initMap   string
+	)
+
+	segmentKey := func() *hlsSegmentKey {
+		if key == nil {
+			return nil
+		}
+		iv := keyIV
+		if iv == nil {
+			iv = make([]byte, 16)
+			binary.BigEndian.PutUint64(iv[8:], uint64(sequence))
+		}
+		return &hlsSegmentKey{uri: key.uri, iv: iv}
+	}
+
+	// byteRange resolves an EXT-X-BYTERANGE value, continuing from the end of
+	// the previous range on the same resource when no offset is given.
+	byteRange := func(value, target string) (*segmentByteRange, error) {
+		lengthStr, offsetStr, hasOffset := strings.Cut(value, "@")
+		length, err := strconv.ParseInt(strings.TrimSpace(lengthStr), 10, 64)
+		if err != nil || length <= 0 {
+			return nil, fmt.Errorf("invalid HLS byte range %q", value)
+		}
+		br := &segmentByteRange{length: length}
+		if hasOffset {
+			if br.offset, err = strconv.ParseInt(strings.TrimSpace(offsetStr), 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid HLS byte range %q", value)
+			}
+		} else if target == lastURL {
+			br.offset = lastEnd
+		} else {
+			return nil, fmt.Errorf("HLS byte range %q has no offset", value)
+		}
+		lastURL, lastEnd = target, br.offset+br.length
+		return br, nil
+	}
+
+	lines := splitPlaylistLines(body)
+	if len(lines) == 0 || lines[0] != "#EXTM3U" {
+		return nil, fmt.Errorf("not an HLS playlist")
+	}
+	for _, line := range lines[1:] {
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			n, err := strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid media sequence: %w", err)
+			}
+			sequence = n
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			switch attrs["METHOD"] {
+			case "NONE":
+				key, keyIV = nil, nil
+			case "AES-128":
+				if attrs["URI"] == "" {
+					return nil, fmt.Errorf("AES-128 key has no URI")
+				}
+				u, err := resolveSegmentURL(base, attrs["URI"])
+				if err != nil {
+					return nil, err
+				}
+				key, keyIV = &hlsSegmentKey{uri: u}, nil
+				if ivStr := attrs["IV"]; ivStr != "" {
+					iv, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(ivStr, "0x"), "0X"))
+					if err != nil || len(iv) != 16 {
+						return nil, fmt.Errorf("invalid AES-128 IV %q", ivStr)
+					}
+					keyIV = iv
+				}
+			default:
+				return nil, fmt.Errorf("unsupported HLS encryption method %q", attrs["METHOD"])
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			attrs := parseHLSAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+			u, err := resolveSegmentURL(base, attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+			mapID := u + "@" + attrs["BYTERANGE"]
+			if initMap != "" {
+				// Repeating the same init section (e.g. after a discontinuity)
+				// is harmless; switching to another one cannot be concatenated.
+				if mapID != initMap {
+					return nil, fmt.Errorf("HLS playlists with several init sections are not supported")
+				}
+				continue
+			}
+			if len(segments) > 0 {
+				return nil, fmt.Errorf("HLS init section must precede the media segments")
+			}
+			initMap = mapID
+			init := mediaSegment{url: u, key: segmentKey()}
+			if value := attrs["BYTERANGE"]; value != "" {
+				if init.byteRange, err = byteRange(value, u); err != nil {
+					return nil, err
+				}
+			}
+			segments = append(segments, init)
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			// Resolved once the segment URI is known.
+			nextRange = strings.TrimPrefix(line, "#EXT-X-BYTERANGE:")
+		case line == "#EXT-X-ENDLIST":
+			haveEnd = true
+		case strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:"):
+			if strings.TrimPrefix(line, "#EXT-X-PLAYLIST-TYPE:") == "VOD" {
+				haveEnd = true
+			}
+		case strings.HasPrefix(line, "#"):
+			continue
+		default:
+			u, err := resolveSegmentURL(base, line)
+			if err != nil {
+				return nil, err
+			}
+			seg := mediaSegment{url: u, key: segmentKey()}
+			if nextRange != "" {
+				if seg.byteRange, err = byteRange(nextRange, u); err != nil {
+					return nil, err
+				}
+				nextRange = ""
+			}
+			segments = append(segments, seg)
+			sequence++
+		}
+	}
+	if !haveEnd {
+		return nil, fmt.Errorf("live HLS playlists are not supported")
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("HLS playlist has no segments")
+	}
+	return segments, nil
+}
+
+func splitPlaylistLines(body string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimPrefix(body, "\ufeff"), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// dashMPD is the part of an MPEG-DASH manifest the parser reads.
+type dashMPD struct {
+	Type                      string       `xml:"type,attr"`
+	MediaPresentationDuration string       `xml:"mediaPresentationDuration,attr"`
+	BaseURL                   string       `xml:"BaseURL"`
+	Periods                   []dashPeriod `xml:"Period"`
+}
+
+type dashPeriod struct {
+	Duration        string               `xml:"duration,attr"`
+	BaseURL         string               `xml:"BaseURL"`
+	SegmentTemplate *dashSegmentTemplate `xml:"SegmentTemplate"`
+	AdaptationSets  []dashAdaptationSet  `xml:"AdaptationSet"`
+}
+
+type dashAdaptationSet struct {
+	MimeType        string               `xml:"mimeType,attr"`
+	ContentType     string               `xml:"contentType,attr"`
+	Codecs          string               `xml:"codecs,attr"`
+	BaseURL         string               `xml:"BaseURL"`
+	SegmentTemplate *dashSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *dashSegmentList     `xml:"SegmentList"`
+	Representations []dashRepresentation `xml:"Representation"`
+}
+
+type dashRepresentation struct {
+	ID              string               `xml:"id,attr"`
+	Bandwidth       int64                `xml:"bandwidth,attr"`
+	Codecs          string               `xml:"codecs,attr"`
+	MimeType        string               `xml:"mimeType,attr"`
+	BaseURL         string               `xml:"BaseURL"`
+	SegmentTemplate *dashSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *dashSegmentList     `xml:"SegmentList"`
+	SegmentBase     *struct{}            `xml:"SegmentBase"`
+}
+
+// dashSegmentTemplate keeps its numeric attributes as strings so templates
+// from the Period, AdaptationSet and Representation levels can be merged.
+type dashSegmentTemplate struct {
+	Initialization string               `xml:"initialization,attr"`
+	Media          string               `xml:"media,attr"`
+	StartNumber    string               `xml:"startNumber,attr"`
+	Timescale      string               `xml:"timescale,attr"`
+	Duration       string               `xml:"duration,attr"`
+	Timeline       *dashSegmentTimeline `xml:"SegmentTimeline"`
+}
+
+type dashSegmentTimeline struct {
+	S []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"S"`
+}
+
+type dashSegmentList struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+		Range     string `xml:"range,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media      string `xml:"media,attr"`
+		MediaRange string `xml:"mediaRange,attr"`
+	} `xml:"SegmentURL"`
+}
+
+var dashTemplateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Time|Bandwidth|)(?:%0(\d+)d)?\$`)
+
+// parseDASHManifest selects a representation from a static MPD and lists its
+// segments. Audio representations are preferred when the MPD also carries
+// video or text.
+func parseDASHManifest(manifestURL *url.URL, body []byte, pref segmentVariantPreference) (*segmentPlan, error) {
+	var mpd dashMPD
+	if err := xml.Unmarshal(body, &mpd); err != nil {
+		return nil, fmt.Errorf("failed to parse MPD: %w", err)
+	}
+	if mpd.Type == "dynamic" {
+		return nil, fmt.Errorf("live DASH manifests are not supported")
+	}
+	if len(mpd.Periods) != 1 {
+		return nil, fmt.Errorf("DASH manifests with %d periods are not supported", len(mpd.Periods))
+	}
+	period := &mpd.Periods[0]
+
+	type candidate struct {
+		set *dashAdaptationSet
+		rep *dashRepresentation
+	}
+	var variants []segmentVariant
+	var candidates []candidate
+	hasAudio := false
+	for i := range period.AdaptationSets {
+		set := &period.AdaptationSets[i]
+		for j := range set.Representations {
+			rep := &set.Representations[j]
+			v := segmentVariant{
+				bandwidth: rep.Bandwidth,
+				codecs:    firstNonEmptyString(rep.Codecs, set.Codecs),
+				mimeType:  firstNonEmptyString(rep.MimeType, set.MimeType, set.ContentType),
+			}
+			hasAudio = hasAudio || strings.HasPrefix(v.mimeType, "audio")
+			variants = append(variants, v)
+			candidates = append(candidates, candidate{set, rep})
+		}
+	}
+	if hasAudio {
+		var audioVariants []segmentVariant
+		var audioCandidates []candidate
+		for i, v := range variants {
+			if strings.HasPrefix(v.mimeType, "audio") {
+				audioVariants = append(audioVariants, v)
+				audioCandidates = append(audioCandidates, candidates[i])
+			}
+		}
+		variants, candidates = audioVariants, audioCandidates
+	}
+	index, err := selectSegmentVariant(variants, pref)
+	if err != nil {
+		return nil, err
+	}
+	set, rep := candidates[index].set, candidates[index].rep
+
+	base := manifestURL
+	for _, ref := range []string{mpd.BaseURL, period.BaseURL, set.BaseURL, rep.BaseURL} {
+		if strings.TrimSpace(ref) == "" {
+			continue
+		}
+		resolved, err := resolveSegmentURL(base, ref)
+		if err != nil {
+			return nil, err
+		}
+		if base, err = url.Parse(resolved); err != nil {
+			return nil, err
+		}
+	}
+
+	periodSeconds, err := parseISO8601Duration(firstNonEmptyString(period.Duration, mpd.MediaPresentationDuration))
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &segmentPlan{bandwidth: rep.Bandwidth, codecs: variants[index].codecs}
+	switch {
+	case rep.SegmentList != nil || set.SegmentList != nil:
+		list := rep.SegmentList
+		if list == nil {
+			list = set.SegmentList
+		}
+		plan.segments, err = dashSegmentListSegments(base, list)
+	case rep.SegmentTemplate != nil || set.SegmentTemplate != nil || period.SegmentTemplate != nil:
+		tmpl := mergeDASHSegmentTemplates(period.SegmentTemplate, set.SegmentTemplate, rep.SegmentTemplate)
+		plan.segments, err = dashTemplateSegments(base, tmpl, rep, periodSeconds)
+	default:
+		// SegmentBase, or a bare BaseURL: the representation is one file
+		// that already starts with its init section.
+		plan.segments = []mediaSegment{{url: base.String()}}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.segments) == 0 {
+		return nil, fmt.Errorf("DASH representation %q has no segments", rep.ID)
+	}
+	return plan, nil
+}
+
+func mergeDASHSegmentTemplates(templates ...*dashSegmentTemplate) *dashSegmentTemplate {
+	merged := &dashSegmentTemplate{}
+	for _, t := range templates {
+		if t == nil {
+			continue
+		}
+		merged.Initialization = firstNonEmptyString(t.Initialization, merged.Initialization)
+		merged.Media = firstNonEmptyString(t.Media, merged.Media)
+		merged.StartNumber = firstNonEmptyString(t.StartNumber, merged.StartNumber)
+		merged.Timescale = firstNonEmptyString(t.Timescale, merged.Timescale)
+		merged.Duration = firstNonEmptyString(t.Duration, merged.Duration)
+		if t.Timeline != nil {
+			merged.Timeline = t.Timeline
+		}
+	}
+	return merged
+}
+
+func dashTemplateSegments(base *url.URL, tmpl *dashSegmentTemplate, rep *dashRepresentation, periodSeconds float64) ([]mediaSegment, error) {
+	if tmpl.Media == "" {
+		return nil, fmt.Errorf("DASH SegmentTemplate has no media attribute")
+	}
+	parseInt := func(value string, fallback int64) (int64, error) {
+		if value == "" {
+			return fallback, nil
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid SegmentTemplate value %q", value)
+		}
+		return n, nil
+	}
+	startNumber, err := parseInt(tmpl.StartNumber, 1)
+	if err != nil {
+		return nil, err
+	}
+	timescale, err := parseInt(tmpl.Timescale, 1)
+	if err != nil || timescale <= 0 {
+		return nil, fmt.Errorf("invalid SegmentTemplate timescale %q", tmpl.Timescale)
+	}
+
+	var segments []mediaSegment
+	add := func(tmplStr string, number, t int64) error {
+		u, err := resolveSegmentURL(base, expandDASHTemplate(tmplStr, rep.ID, rep.Bandwidth, number, t))
+		if err != nil {
+			return err
+		}
+		segments = append(segments, mediaSegment{url: u})
+		return nil
+	}
+	if tmpl.Initialization != "" {
+		if err := add(tmpl.Initialization, 0, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	if tmpl.Timeline != nil {
+		periodEnd := int64(math.Round(periodSeconds * float64(timescale)))
+		number, t := startNumber, int64(0)
+		for i, s := range tmpl.Timeline.S {
+			if s.T != nil {
+				t = *s.T
+			}
+			if s.D <= 0 {
+				return nil, fmt.Errorf("invalid SegmentTimeline duration %d", s.D)
+			}
+			repeat := s.R
+			if repeat < 0 {
+				// Repeat until the next S element or the end of the period.
+				end := periodEnd
+				if i+1 < len(tmpl.Timeline.S) && tmpl.Timeline.S[i+1].T != nil {
+					end = *tmpl.Timeline.S[i+1].T
+				}
+				if end <= t {
+					return nil, fmt.Errorf("open-ended SegmentTimeline needs a period duration")
+				}
+				repeat = (end-t+s.D-1)/s.D - 1
+			}
+			for n := int64(0); n <= repeat; n++ {
+				if err := add(tmpl.Media, number, t); err != nil {
+					return nil, err
+				}
+				number++
+				t += s.D
+			}
+		}
+		return segments, nil
+	}
+
+	duration, err := parseInt(tmpl.Duration, 0)
+	if err != nil || duration <= 0 {
+		return nil, fmt.Errorf("DASH SegmentTemplate needs a duration or a SegmentTimeline")
+	}
+	if periodSeconds <= 0 {
+		return nil, fmt.Errorf("DASH SegmentTemplate needs a period duration")
+	}
+	count := int64(math.Ceil(periodSeconds * float64(timescale) / float64(duration)))
+	for n := int64(0); n < count; n++ {
+		if err := add(tmpl.Media, startNumber+n, n*duration); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+func dashSegmentListSegments(base *url.URL, list *dashSegmentList) ([]mediaSegment, error) {
+	var segments []mediaSegment
+	add := func(ref, rangeStr string) error {
+		u := base.String()
+		if ref != "" {
+			var err error
+			if u, err = resolveSegmentURL(base, ref); err != nil {
+				return err
+			}
+		}
+		seg := mediaSegment{url: u}
+		if rangeStr != "" {
+			start, end, ok := strings.Cut(rangeStr, "-")
+			first, err1 := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+			last, err2 := strconv.ParseInt(strings.TrimSpace(end), 10, 64)
+			if !ok || err1 != nil || err2 != nil || last < first {
+				return fmt.Errorf("invalid DASH byte range %q", rangeStr)
+			}
+			seg.byteRange = &segmentByteRange{offset: first, length: last - first + 1}
+		}
+		segments = append(segments, seg)
+		return nil
+	}
+	if init := list.Initialization; init != nil {
+		if err := add(init.SourceURL, init.Range); err != nil {
+			return nil, err
+		}
+	}
+	for _, su := range list.SegmentURLs {
+		if err := add(su.Media, su.MediaRange); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+// expandDASHTemplate substitutes the identifiers of a SegmentTemplate URL,
+// including printf-style widths such as $Number%05d$.
+func expandDASHTemplate(tmpl, repID string, bandwidth, number, t int64) string {
+	return dashTemplateIdentifier.ReplaceAllStringFunc(tmpl, func(match string) string {
+		parts := dashTemplateIdentifier.FindStringSubmatch(match)
+		var value int64
+		switch parts[1] {
+		case "":
+			return "$"
+		case "RepresentationID":
+			return repID
+		case "Number":
+			value = number
+		case "Time":
+			value = t
+		case "Bandwidth":
+			value = bandwidth
+		}
+		if parts[2] != "" {
+			return fmt.Sprintf("%0"+parts[2]+"d", value)
+		}
+		return strconv.FormatInt(value, 10)
+	})
+}
+
+var iso8601DurationPattern = regexp.MustCompile(`^P(?:(\d+(?:\.\d+)?)D)?(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// parseISO8601Duration converts an xs:duration such as PT3M25.4S to seconds.
+// An empty value is zero.
+func parseISO8601Duration(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	parts := iso8601DurationPattern.FindStringSubmatch(value)
+	if parts == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	var seconds float64
+	for i, scale := range []float64{86400, 3600, 60, 1} {
+		if parts[i+1] == "" {
+			continue
+		}
+		n, _ := strconv.ParseFloat(parts[i+1], 64)
+		seconds += n * scale
+	}
+	return seconds, nil
+}
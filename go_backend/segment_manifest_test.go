@@ -0,0 +1,168 @@
+package gobackend
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseHLSPlaylists(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/hls/master.m3u8")
+	master, err := parseHLSMasterPlaylist(base, strings.Join([]string{
+		"#EXTM3U",
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="hi",NAME="Main",DEFAULT=YES,URI="audio/hi.m3u8"`,
+		`#EXT-X-STREAM-INF:BANDWIDTH=256000,CODECS="mp4a.40.2"`,
+		"aac/index.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=1411000,CODECS="fLaC",AUDIO="hi"`,
+		"flac/index.m3u8",
+		`#EXT-X-STREAM-INF:BANDWIDTH=768000,CODECS="ec-3,avc1.64001f"`,
+		"https://other.example.com/ec3.m3u8",
+	}, "\n"))
+	if err != nil {
+		t.Fatalf("parseHLSMasterPlaylist: %v", err)
+	}
+
+	cases := []struct {
+		pref segmentVariantPreference
+		want string
+	}{
+		{segmentVariantPreference{}, "https://cdn.example.com/hls/audio/hi.m3u8"},
+		{segmentVariantPreference{maxBandwidth: 800000}, "https://other.example.com/ec3.m3u8"},
+		{segmentVariantPreference{maxBandwidth: 1000}, "https://cdn.example.com/hls/aac/index.m3u8"},
+		{segmentVariantPreference{codecs: []string{"mp4a"}}, "https://cdn.example.com/hls/aac/index.m3u8"},
+	}
+	for _, c := range cases {
+		index, err := selectSegmentVariant(master.variants, c.pref)
+		if err != nil {
+			t.Fatalf("selectSegmentVariant(%+v): %v", c.pref, err)
+		}
+		if got := master.audioPlaylistURL(master.variants[index]); got != c.want {
+			t.Fatalf("selectSegmentVariant(%+v) = %s, want %s", c.pref, got, c.want)
+		}
+	}
+	if _, err := selectSegmentVariant(master.variants, segmentVariantPreference{codecs: []string{"opus"}}); err == nil {
+		t.Fatal("expected no variant for opus")
+	}
+
+	mediaBase, _ := url.Parse("https://cdn.example.com/hls/flac/index.m3u8")
+	segments, err := parseHLSMediaPlaylist(mediaBase, strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-MEDIA-SEQUENCE:7",
+		`#EXT-X-MAP:URI="media.mp4",BYTERANGE="100@0"`,
+		`#EXT-X-KEY:METHOD=AES-128,URI="key.bin"`,
+		"#EXTINF:4.0,",
+		"#EXT-X-BYTERANGE:50",
+		"media.mp4",
+		"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x000102030405060708090a0b0c0d0e0f",
+		"#EXTINF:4.0,",
+		"#EXT-X-BYTERANGE:60@150",
+		"media.mp4",
+		"#EXT-X-KEY:METHOD=NONE",
+		"#EXT-X-DISCONTINUITY",
+		`#EXT-X-MAP:URI="media.mp4",BYTERANGE="100@0"`,
+		"#EXTINF:2.0,",
+		"tail.mp4",
+		"#EXT-X-ENDLIST",
+	}, "\n"))
+	if err != nil {
+		t.Fatalf("parseHLSMediaPlaylist: %v", err)
+	}
+	if len(segments) != 4 {
+		t.Fatalf("segments = %+v", segments)
+	}
+	if segments[0].byteRange.header() != "bytes=0-99" || segments[0].key != nil {
+		t.Fatalf("init segment = %+v", segments[0])
+	}
+	if segments[1].byteRange.header() != "bytes=100-149" || segments[1].key.iv[15] != 7 {
+		t.Fatalf("first segment = %+v", segments[1])
+	}
+	if segments[2].byteRange.header() != "bytes=150-209" || segments[2].key.iv[15] != 0x0f {
+		t.Fatalf("second segment = %+v", segments[2])
+	}
+	if segments[3].key != nil || segments[3].url != "https://cdn.example.com/hls/flac/tail.mp4" {
+		t.Fatalf("third segment = %+v", segments[3])
+	}
+
+	if _, err := parseHLSMediaPlaylist(mediaBase, "#EXTM3U\n#EXTINF:4,\na.ts\n"); err == nil {
+		t.Fatal("expected live playlist to be rejected")
+	}
+	if _, err := parseHLSMediaPlaylist(mediaBase, "#EXTM3U\n#EXT-X-KEY:METHOD=SAMPLE-AES,URI=\"k\"\n#EXTINF:4,\na.ts\n#EXT-X-ENDLIST\n"); err == nil {
+		t.Fatal("expected SAMPLE-AES to be rejected")
+	}
+}
+
+func TestParseDASHManifest(t *testing.T) {
+	base, _ := url.Parse("https://cdn.example.com/dash/track.mpd")
+	mpd := `<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT0M10.5S">
+  <BaseURL>media/</BaseURL>
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <Representation id="v1" bandwidth="5000000" codecs="avc1.640028"/>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <SegmentTemplate timescale="48000" initialization="$RepresentationID$/init.mp4" media="$RepresentationID$/$Number%03d$.m4s" duration="192000"/>
+      <Representation id="aac" bandwidth="128000" codecs="mp4a.40.2"/>
+      <Representation id="flac" bandwidth="900000" codecs="fLaC"/>
+      <Representation id="tl" bandwidth="96000" codecs="mp4a.40.5">
+        <SegmentTemplate media="tl/$Time$.m4s" startNumber="0">
+          <SegmentTimeline><S t="0" d="96000" r="1"/><S d="48000" r="-1"/></SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+      <Representation id="list" bandwidth="64000" codecs="mp4a.40.2">
+        <BaseURL>list.mp4</BaseURL>
+        <SegmentList>
+          <Initialization range="0-99"/>
+          <SegmentURL mediaRange="100-199"/>
+          <SegmentURL media="other.mp4" mediaRange="0-9"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	plan, err := parseDASHManifest(base, []byte(mpd), segmentVariantPreference{})
+	if err != nil {
+		t.Fatalf("parseDASHManifest: %v", err)
+	}
+	// 10.5s at 4s per segment is three segments plus the init section.
+	want := []string{"flac/init.mp4", "flac/001.m4s", "flac/002.m4s", "flac/003.m4s"}
+	if plan.codecs != "fLaC" || plan.bandwidth != 900000 || len(plan.segments) != len(want) {
+		t.Fatalf("plan = %+v", plan)
+	}
+	for i, seg := range plan.segments {
+		if seg.url != "https://cdn.example.com/dash/media/"+want[i] {
+			t.Fatalf("segment %d = %s", i, seg.url)
+		}
+	}
+
+	plan, err = parseDASHManifest(base, []byte(mpd), segmentVariantPreference{maxBandwidth: 100000})
+	if err != nil {
+		t.Fatalf("parseDASHManifest timeline: %v", err)
+	}
+	var got []string
+	for _, seg := range plan.segments {
+		got = append(got, strings.TrimPrefix(seg.url, "https://cdn.example.com/dash/media/"))
+	}
+	wantTimeline := "tl/init.mp4 tl/0.m4s tl/96000.m4s tl/192000.m4s tl/240000.m4s tl/288000.m4s tl/336000.m4s tl/384000.m4s tl/432000.m4s tl/480000.m4s"
+	if strings.Join(got, " ") != wantTimeline {
+		t.Fatalf("timeline segments = %v", got)
+	}
+
+	plan, err = parseDASHManifest(base, []byte(mpd), segmentVariantPreference{maxBandwidth: 70000})
+	if err != nil {
+		t.Fatalf("parseDASHManifest list: %v", err)
+	}
+	if len(plan.segments) != 3 ||
+		plan.segments[0].url != "https://cdn.example.com/dash/media/list.mp4" || plan.segments[0].byteRange.header() != "bytes=0-99" ||
+		plan.segments[2].url != "https://cdn.example.com/dash/media/other.mp4" || plan.segments[2].byteRange.header() != "bytes=0-9" {
+		t.Fatalf("segment list = %+v", plan.segments)
+	}
+
+	if _, err := parseDASHManifest(base, []byte(`<MPD type="dynamic"><Period/></MPD>`), segmentVariantPreference{}); err == nil {
+		t.Fatal("expected dynamic MPD to be rejected")
+	}
+	if seconds, err := parseISO8601Duration("P1DT1H2M3.5S"); err != nil || seconds != 86400+3723.5 {
+		t.Fatalf("parseISO8601Duration = %v/%v", seconds, err)
+	}
+}
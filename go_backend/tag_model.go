@@ -0,0 +1,1118 @@
+package gobackend
+
+// Unified tag model.
+//
+// Every container is read into a tagMap keyed by canonical field names (the
+// upper-case Vorbis comment names: TITLE, ALBUMARTIST, TRACKNUMBER,
+// MUSICBRAINZ_ALBUMID, ...), each holding one or more values in file order.
+// tagFieldMappings translates a canonical name to its native key in every
+// container. Fields without a mapping (custom TXXX frames, iTunes freeform
+// atoms, unknown Vorbis and APE keys) keep their own upper-cased name, so they
+// survive a read/edit/write round trip. Writes only touch the fields being
+// edited; every other frame, atom or item is carried over verbatim. Cover art
+// stays with the dedicated cover functions.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-flac/flacvorbis/v2"
+	"github.com/go-flac/go-flac/v2"
+)
+
+// tagMap holds tag values by canonical field name.
+type tagMap map[string][]string
+
+// add appends the non-empty values of a field, skipping exact duplicates
+// (e.g. the same text stored under both LYRICS and UNSYNCEDLYRICS).
+func (t tagMap) add(name string, values ...string) {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		duplicate := false
+		for _, existing := range t[name] {
+			if existing == v {
+				duplicate = true
+				break
+			}
+		}
+		if !duplicate {
+			t[name] = append(t[name], v)
+		}
+	}
+}
+
+func (t tagMap) first(name string) string {
+	if values := t[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// sortedNames returns the field names in a stable order for writing.
+func (t tagMap) sortedNames() []string {
+	names := make([]string, 0, len(t))
+	for name := range t {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// tagFieldMapping ties a canonical field name to its native key per format.
+// An empty id3 or mp4 key means the field is stored as a TXXX frame or an
+// iTunes freeform atom named id3Desc / mp4Name (the canonical name when
+// those are empty too). aliases are other native spellings read as this
+// field and removed when it is written.
+type tagFieldMapping struct {
+	name    string
+	id3     string
+	id3Desc string
+	mp4     string
+	mp4Name string
+	ape     string
+	riff    string
+	aliases []string
+}
+
+var tagFieldMappings = []tagFieldMapping{
+	{name: "TITLE", id3: "TIT2", mp4: "\xa9nam", ape: "Title", riff: "INAM"},
+	{name: "SUBTITLE", id3: "TIT3", ape: "Subtitle"},
+	{name: "ARTIST", id3: "TPE1", mp4: "\xa9ART", ape: "Artist", riff: "IART"},
+	{name: "ALBUM", id3: "TALB", mp4: "\xa9alb", ape: "Album", riff: "IPRD"},
+	{name: "ALBUMARTIST", id3: "TPE2", mp4: "aART", ape: "Album Artist", aliases: []string{"ALBUM ARTIST", "ALBUM_ARTIST"}},
+	{name: "DATE", id3: "TDRC", mp4: "\xa9day", ape: "Year", riff: "ICRD", aliases: []string{"YEAR"}},
+	{name: "ORIGINALDATE", id3: "TDOR", ape: "OriginalDate"},
+	{name: "TRACKNUMBER", id3: "TRCK", mp4: "trkn", ape: "Track", riff: "ITRK", aliases: []string{"TRACK"}},
+	{name: "TRACKTOTAL", id3: "TRCK", mp4: "trkn", ape: "Track", aliases: []string{"TOTALTRACKS"}},
+	{name: "DISCNUMBER", id3: "TPOS", mp4: "disk", ape: "Disc", aliases: []string{"DISC"}},
+	{name: "DISCTOTAL", id3: "TPOS", mp4: "disk", ape: "Disc", aliases: []string{"TOTALDISCS"}},
+	{name: "GENRE", id3: "TCON", mp4: "\xa9gen", ape: "Genre", riff: "IGNR"},
+	{name: "COMPOSER", id3: "TCOM", mp4: "\xa9wrt", ape: "Composer", riff: "IMUS"},
+	{name: "LYRICIST", id3: "TEXT", ape: "Lyricist"},
+	{name: "CONDUCTOR", id3: "TPE3", ape: "Conductor"},
+	{name: "REMIXER", id3: "TPE4", ape: "MixArtist"},
+	{name: "GROUPING", id3: "TIT1", mp4: "\xa9grp", ape: "Grouping"},
+	{name: "BPM", id3: "TBPM", mp4: "tmpo", ape: "BPM"},
+	{name: "KEY", id3: "TKEY"},
+	{name: "MOOD", id3: "TMOO", ape: "Mood"},
+	{name: "LANGUAGE", id3: "TLAN", ape: "Language", riff: "ILNG"},
+	{name: "MEDIA", id3: "TMED", ape: "Media", riff: "IMED"},
+	{name: "ISRC", id3: "TSRC", ape: "ISRC"},
+	{name: "LABEL", id3: "TPUB", ape: "Label", aliases: []string{"ORGANIZATION", "PUBLISHER"}},
+	{name: "COPYRIGHT", id3: "TCOP", mp4: "cprt", ape: "Copyright", riff: "ICOP"},
+	{name: "ENCODEDBY", id3: "TENC", ape: "EncodedBy", riff: "ITCH"},
+	{name: "ENCODER", id3: "TSSE", mp4: "\xa9too", ape: "Encoder", riff: "ISFT"},
+	{name: "COMMENT", id3: "COMM", mp4: "\xa9cmt", ape: "Comment", riff: "ICMT"},
+	{name: "LYRICS", id3: "USLT", mp4: "\xa9lyr", ape: "Lyrics", aliases: []string{"UNSYNCEDLYRICS"}},
+	{name: "COMPILATION", id3: "TCMP", mp4: "cpil", ape: "Compilation"},
+	{name: "TITLESORT", id3: "TSOT", mp4: "sonm"},
+	{name: "ARTISTSORT", id3: "TSOP", mp4: "soar"},
+	{name: "ALBUMSORT", id3: "TSOA", mp4: "soal"},
+	{name: "ALBUMARTISTSORT", id3: "TSO2", mp4: "soaa"},
+	{name: "COMPOSERSORT", id3: "TSOC", mp4: "soco"},
+	{name: "REPLAYGAIN_TRACK_GAIN", mp4Name: "replaygain_track_gain"},
+	{name: "REPLAYGAIN_TRACK_PEAK", mp4Name: "replaygain_track_peak"},
+	{name: "REPLAYGAIN_ALBUM_GAIN", mp4Name: "replaygain_album_gain"},
+	{name: "REPLAYGAIN_ALBUM_PEAK", mp4Name: "replaygain_album_peak"},
+	{name: "MUSICBRAINZ_TRACKID", id3: "UFID", mp4Name: "MusicBrainz Track Id"},
+	{name: "MUSICBRAINZ_RELEASETRACKID", id3Desc: "MusicBrainz Release Track Id", mp4Name: "MusicBrainz Release Track Id"},
+	{name: "MUSICBRAINZ_ALBUMID", id3Desc: "MusicBrainz Album Id", mp4Name: "MusicBrainz Album Id"},
+	{name: "MUSICBRAINZ_ARTISTID", id3Desc: "MusicBrainz Artist Id", mp4Name: "MusicBrainz Artist Id"},
+	{name: "MUSICBRAINZ_ALBUMARTISTID", id3Desc: "MusicBrainz Album Artist Id", mp4Name: "MusicBrainz Album Artist Id"},
+	{name: "MUSICBRAINZ_RELEASEGROUPID", id3Desc: "MusicBrainz Release Group Id", mp4Name: "MusicBrainz Release Group Id"},
+	{name: "MUSICBRAINZ_WORKID", id3Desc: "MusicBrainz Work Id", mp4Name: "MusicBrainz Work Id"},
+	{name: "RELEASECOUNTRY", id3Desc: "MusicBrainz Album Release Country", mp4Name: "MusicBrainz Album Release Country"},
+	{name: "RELEASETYPE", id3Desc: "MusicBrainz Album Type", mp4Name: "MusicBrainz Album Type"},
+	{name: "RELEASESTATUS", id3Desc: "MusicBrainz Album Status", mp4Name: "MusicBrainz Album Status"},
+	{name: "BARCODE", ape: "Barcode"},
+	{name: "CATALOGNUMBER", ape: "CatalogNumber"},
+}
+
+// musicBrainzUFIDOwner is the UFID owner MusicBrainz recording IDs use.
+const musicBrainzUFIDOwner = "http://musicbrainz.org"
+
+var (
+	tagMappingByName   = map[string]tagFieldMapping{}
+	tagNameByAlias     = map[string]string{}
+	tagNameByID3Frame  = map[string]string{}
+	tagNameByMP4Atom   = map[string]string{}
+	tagNameByRIFFChunk = map[string]string{}
+)
+
+func init() {
+	for _, m := range tagFieldMappings {
+		tagMappingByName[m.name] = m
+		for _, alias := range append([]string{m.id3Desc, m.mp4Name, m.ape}, m.aliases...) {
+			// The first mapping wins, so "Track" stays TRACKNUMBER even though
+			// TRACKTOTAL shares the APE key.
+			if upper := strings.ToUpper(alias); upper != "" && tagNameByAlias[upper] == "" {
+				tagNameByAlias[upper] = m.name
+			}
+		}
+		// TRCK/TPOS/trkn/disk carry both the number and the total; they are
+		// split explicitly by the readers.
+		if m.id3 != "" && tagNameByID3Frame[m.id3] == "" {
+			tagNameByID3Frame[m.id3] = m.name
+		}
+		if m.mp4 != "" && tagNameByMP4Atom[m.mp4] == "" {
+			tagNameByMP4Atom[m.mp4] = m.name
+		}
+		if m.riff != "" {
+			tagNameByRIFFChunk[m.riff] = m.name
+		}
+	}
+	tagNameByMP4Atom["gnre"] = "GENRE"
+}
+
+// canonicalTagName maps a native key (Vorbis/APE key, TXXX description or
+// freeform name) to its canonical field name. Unknown keys are upper-cased.
+func canonicalTagName(key string) string {
+	upper := strings.ToUpper(strings.TrimSpace(key))
+	if _, ok := tagMappingByName[upper]; ok {
+		return upper
+	}
+	if name, ok := tagNameByAlias[upper]; ok {
+		return name
+	}
+	return upper
+}
+
+// canonicalizeTagEdits folds alias spellings in an edit set onto their
+// canonical names.
+func canonicalizeTagEdits(edits tagMap) tagMap {
+	out := make(tagMap, len(edits))
+	for key, values := range edits {
+		name := canonicalTagName(key)
+		if name == "" {
+			continue
+		}
+		if _, ok := out[name]; !ok {
+			out[name] = []string{}
+		}
+		out.add(name, values...)
+	}
+	return out
+}
+
+// splitTagIndexPairs splits "3/12" style TRACKNUMBER/DISCNUMBER values and
+// fills the matching total when the file does not store it separately.
+func splitTagIndexPairs(tags tagMap) {
+	for _, pair := range [][2]string{{"TRACKNUMBER", "TRACKTOTAL"}, {"DISCNUMBER", "DISCTOTAL"}} {
+		value := tags.first(pair[0])
+		if !strings.Contains(value, "/") {
+			continue
+		}
+		number, total := parseIndexPair(value)
+		delete(tags, pair[0])
+		if number > 0 {
+			tags.add(pair[0], strconv.Itoa(number))
+		}
+		if total > 0 && len(tags[pair[1]]) == 0 {
+			tags.add(pair[1], strconv.Itoa(total))
+		}
+	}
+}
+
+// tagFormatForFile returns the tag family used for a file: flac, ogg, mp3,
+// mp4, ape, wav or aiff ("" when unsupported).
+func tagFormatForFile(filePath string) string {
+	lower := strings.ToLower(filePath)
+	switch {
+	case strings.HasSuffix(lower, ".flac"):
+		return "flac"
+	case isOggFile(filePath):
+		return "ogg"
+	case strings.HasSuffix(lower, ".mp3"):
+		return "mp3"
+	case isM4AExtension(lower):
+		return "mp4"
+	case strings.HasSuffix(lower, ".ape") || strings.HasSuffix(lower, ".wv") || strings.HasSuffix(lower, ".mpc"):
+		return "ape"
+	case strings.HasSuffix(lower, ".wav"):
+		return "wav"
+	case strings.HasSuffix(lower, ".aiff") || strings.HasSuffix(lower, ".aif") || strings.HasSuffix(lower, ".aifc"):
+		return "aiff"
+	case isMP4ContainerFile(filePath):
+		return "mp4"
+	}
+	return ""
+}
+
+// readTagMap reads every text tag of a file into the unified model.
+func readTagMap(filePath string) (tagMap, string, error) {
+	format := tagFormatForFile(filePath)
+	var tags tagMap
+	var err error
+	switch format {
+	case "flac":
+		tags, err = readFLACTagMap(filePath)
+	case "ogg":
+		tags, err = readOggTagMap(filePath)
+	case "mp3":
+		tags, err = readMP3TagMap(filePath)
+	case "mp4":
+		tags, err = readM4ATagMap(filePath)
+	case "ape":
+		tags, err = readAPETagMap(filePath)
+	case "wav":
+		tags, err = readWAVTagMap(filePath)
+	case "aiff":
+		tags, err = readAIFFTagMap(filePath)
+	default:
+		return nil, "", fmt.Errorf("unsupported file type for tags: %s", filepath.Ext(filePath))
+	}
+	if err != nil {
+		return nil, format, err
+	}
+	splitTagIndexPairs(tags)
+	return tags, format, nil
+}
+
+// writeTagMap applies edits to a file. Every field in edits has all of its
+// values replaced (an empty list deletes it); other tags are left untouched.
+func writeTagMap(filePath string, edits tagMap) (string, error) {
+	edits = canonicalizeTagEdits(edits)
+	format := tagFormatForFile(filePath)
+	var err error
+	switch format {
+	case "flac":
+		err = writeFLACTagMap(filePath, edits)
+	case "ogg":
+		err = rewriteOggComments(filePath, func(cmt *flacvorbis.MetaDataBlockVorbisComment, streamType oggStreamType) {
+			applyVorbisTagMap(cmt, edits)
+			if streamType == oggStreamOpus {
+				applyOpusR128Fields(cmt, replayGainEditFields(edits))
+			}
+		})
+	case "mp3":
+		err = rewriteMP3Frames(filePath, func(frames []id3Frame) []id3Frame {
+			return applyID3TagMap(frames, edits)
+		})
+	case "mp4":
+		err = rewriteM4AIlst(filePath, true, func(buf []byte, items []mp4Box) []byte {
+			return applyM4ATagMap(buf, items, edits)
+		})
+	case "ape":
+		err = writeAPETagMap(filePath, edits)
+	case "wav":
+		err = writeID3ChunkTagMap(filePath, "RIFF", id3ChunkWAV, true, edits)
+	case "aiff":
+		err = writeID3ChunkTagMap(filePath, "FORM", id3ChunkAIFF, false, edits)
+	default:
+		return "", fmt.Errorf("unsupported file type for tags: %s", filepath.Ext(filePath))
+	}
+	return format, err
+}
+
+// replayGainEditFields converts edited REPLAYGAIN_*_GAIN fields to edit-field
+// keys so the Opus R128 tags can follow them.
+func replayGainEditFields(edits tagMap) map[string]string {
+	fields := map[string]string{}
+	for _, key := range []string{"REPLAYGAIN_TRACK_GAIN", "REPLAYGAIN_ALBUM_GAIN"} {
+		if values, ok := edits[key]; ok {
+			value := ""
+			if len(values) > 0 {
+				value = values[0]
+			}
+			fields[strings.ToLower(key)] = value
+		}
+	}
+	return fields
+}
+
+// editedIndexPair merges an edited number/total pair with the existing one.
+// edited is false when neither half is part of the edit set.
+func editedIndexPair(edits tagMap, numberKey, totalKey string, number, total int) (int, int, bool) {
+	numberValues, numberEdited := edits[numberKey]
+	totalValues, totalEdited := edits[totalKey]
+	if numberEdited {
+		number = 0
+		if len(numberValues) > 0 {
+			number, _ = parseIndexPair(numberValues[0])
+		}
+	}
+	if totalEdited {
+		total = 0
+		if len(totalValues) > 0 {
+			total = parsePositiveInt(totalValues[0])
+		}
+	}
+	return number, total, numberEdited || totalEdited
+}
+
+// --- Vorbis comments (FLAC, Ogg Vorbis, Opus) ---
+
+var vorbisPictureKeys = map[string]bool{
+	"METADATA_BLOCK_PICTURE": true,
+	"COVERART":               true,
+	"COVERARTMIME":           true,
+}
+
+func tagMapFromVorbisComments(comments []string) tagMap {
+	tags := tagMap{}
+	for _, comment := range comments {
+		key, value, ok := strings.Cut(comment, "=")
+		if !ok || vorbisPictureKeys[strings.ToUpper(key)] {
+			continue
+		}
+		tags.add(canonicalTagName(key), value)
+	}
+	return tags
+}
+
+func applyVorbisTagMap(cmt *flacvorbis.MetaDataBlockVorbisComment, edits tagMap) {
+	kept := cmt.Comments[:0]
+	for _, comment := range cmt.Comments {
+		if key, _, ok := strings.Cut(comment, "="); ok {
+			if _, edited := edits[canonicalTagName(key)]; edited {
+				continue
+			}
+		}
+		kept = append(kept, comment)
+	}
+	cmt.Comments = kept
+	for _, name := range edits.sortedNames() {
+		for _, value := range edits[name] {
+			cmt.Comments = append(cmt.Comments, name+"="+value)
+		}
+	}
+}
+
+func readFLACVorbisComment(filePath string) (*flac.File, int, *flacvorbis.MetaDataBlockVorbisComment, error) {
+	f, err := flac.ParseFile(filePath)
+	if err != nil {
+		return nil, -1, nil, fmt.Errorf("failed to parse FLAC file: %w", err)
+	}
+	for idx, meta := range f.Meta {
+		if meta.Type == flac.VorbisComment {
+			cmt, err := flacvorbis.ParseFromMetaDataBlock(*meta)
+			if err != nil {
+				return nil, -1, nil, fmt.Errorf("failed to parse vorbis comment: %w", err)
+			}
+			return f, idx, cmt, nil
+		}
+	}
+	return f, -1, flacvorbis.New(), nil
+}
+
+func readFLACTagMap(filePath string) (tagMap, error) {
+	_, _, cmt, err := readFLACVorbisComment(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return tagMapFromVorbisComments(cmt.Comments), nil
+}
+
+func writeFLACTagMap(filePath string, edits tagMap) error {
+	f, cmtIdx, cmt, err := readFLACVorbisComment(filePath)
+	if err != nil {
+		return err
+	}
+	applyVorbisTagMap(cmt, edits)
+	cmtBlock := cmt.Marshal()
+	if cmtIdx >= 0 {
+		f.Meta[cmtIdx] = &cmtBlock
+	} else {
+		f.Meta = append(f.Meta, &cmtBlock)
+	}
+	return f.Save(filePath)
+}
+
+func readOggTagMap(filePath string) (tagMap, error) {
+	comments, err := readOggComments(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return tagMapFromVorbisComments(comments), nil
+}
+
+// readOggComments returns the raw comment list of an Ogg Vorbis/Opus file.
+func readOggComments(filePath string) ([]string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	layout, err := readOggHeaderLayout(f)
+	if err != nil {
+		return nil, err
+	}
+	packet := layout.packets[1]
+	prefix := 7
+	if layout.streamType == oggStreamOpus {
+		prefix = 8
+	}
+	if len(packet) < prefix {
+		return nil, fmt.Errorf("comment header too short")
+	}
+	_, comments, _, err := parseVorbisCommentList(packet[prefix:])
+	return comments, err
+}
+
+// --- ID3v2 (MP3, and the id3 chunk of WAV/AIFF) ---
+
+// id3TextValues returns the strings of a text frame (multiple values are NUL
+// separated in ID3v2.4).
+func id3TextValues(encoding byte, payload []byte) []string {
+	var values []string
+	for len(payload) > 0 {
+		head, rest, ok := splitID3Terminated(encoding, payload)
+		if v := decodeID3String(encoding, head); v != "" {
+			values = append(values, v)
+		}
+		if !ok {
+			break
+		}
+		payload = rest
+	}
+	return values
+}
+
+func tagMapFromID3Frames(frames []id3Frame) tagMap {
+	tags := tagMap{}
+	for _, fr := range frames {
+		if len(fr.data) < 1 {
+			continue
+		}
+		encoding := fr.data[0]
+		switch fr.id {
+		case "TXXX":
+			desc, rest, _ := splitID3Terminated(encoding, fr.data[1:])
+			tags.add(canonicalTagName(decodeID3String(encoding, desc)), id3TextValues(encoding, rest)...)
+		case "COMM":
+			if id3FrameDescription(fr) == "" {
+				tags.add("COMMENT", extractCommentFrame(fr.data))
+			}
+		case "USLT":
+			tags.add("LYRICS", extractLyricsFrame(fr.data))
+		case "UFID":
+			if owner, id, ok := splitID3Terminated(0, fr.data); ok && string(owner) == musicBrainzUFIDOwner {
+				tags.add("MUSICBRAINZ_TRACKID", string(id))
+			}
+		case "TRCK", "TPOS":
+			prefix := "TRACK"
+			if fr.id == "TPOS" {
+				prefix = "DISC"
+			}
+			number, total := parseIndexPair(firstTextValue(extractTextFrame(fr.data)))
+			if number > 0 {
+				tags.add(prefix+"NUMBER", strconv.Itoa(number))
+			}
+			if total > 0 {
+				tags.add(prefix+"TOTAL", strconv.Itoa(total))
+			}
+		case "TCON":
+			for _, genre := range id3TextValues(encoding, fr.data[1:]) {
+				tags.add("GENRE", cleanGenre(genre))
+			}
+		default:
+			if name := id3FrameTagName(fr); name != "" && fr.id[0] == 'T' {
+				tags.add(name, id3TextValues(encoding, fr.data[1:])...)
+			}
+		}
+	}
+	return tags
+}
+
+// id3FrameTagName returns the canonical field an existing frame stores.
+func id3FrameTagName(fr id3Frame) string {
+	switch fr.id {
+	case "TXXX":
+		desc := id3FrameDescription(fr)
+		if isLyricsDescription(desc) {
+			return "LYRICS"
+		}
+		return canonicalTagName(desc)
+	case "COMM":
+		if id3FrameDescription(fr) == "" {
+			return "COMMENT"
+		}
+		return ""
+	case "USLT", "SYLT":
+		return "LYRICS"
+	case "UFID":
+		if owner, _, ok := splitID3Terminated(0, fr.data); ok && string(owner) == musicBrainzUFIDOwner {
+			return "MUSICBRAINZ_TRACKID"
+		}
+		return ""
+	}
+	if fr.id[0] == 'T' {
+		// Text frames without a mapping pass through under their frame ID.
+		if name, ok := tagNameByID3Frame[fr.id]; ok {
+			return name
+		}
+		return fr.id
+	}
+	return ""
+}
+
+func applyID3TagMap(frames []id3Frame, edits tagMap) []id3Frame {
+	trackNum, trackTotal := parseIndexPair(findID3TextValue(frames, "TRCK"))
+	discNum, discTotal := parseIndexPair(findID3TextValue(frames, "TPOS"))
+
+	// Unmapped text frames already in the tag are written back as themselves;
+	// other unknown names become TXXX frames.
+	rawFrames := map[string]bool{}
+	kept := make([]id3Frame, 0, len(frames))
+	for _, fr := range frames {
+		name := id3FrameTagName(fr)
+		if name == fr.id {
+			rawFrames[name] = true
+		}
+		if fr.id == "TRCK" || fr.id == "TPOS" {
+			name = strings.TrimSuffix(name, "NUMBER")
+			if _, ok := edits[name+"NUMBER"]; ok {
+				continue
+			}
+			if _, ok := edits[name+"TOTAL"]; ok {
+				continue
+			}
+		} else if _, edited := edits[name]; edited && name != "" {
+			continue
+		}
+		kept = append(kept, fr)
+	}
+
+	if n, t, ok := editedIndexPair(edits, "TRACKNUMBER", "TRACKTOTAL", trackNum, trackTotal); ok {
+		if v := formatIndexValue(n, t); v != "" {
+			kept = append(kept, newID3TextFrame("TRCK", v))
+		}
+	}
+	if n, t, ok := editedIndexPair(edits, "DISCNUMBER", "DISCTOTAL", discNum, discTotal); ok {
+		if v := formatIndexValue(n, t); v != "" {
+			kept = append(kept, newID3TextFrame("TPOS", v))
+		}
+	}
+
+	for _, name := range edits.sortedNames() {
+		values := edits[name]
+		if len(values) == 0 {
+			continue
+		}
+		m, mapped := tagMappingByName[name]
+		switch {
+		case strings.HasPrefix(name, "TRACK") && m.id3 == "TRCK", strings.HasPrefix(name, "DISC") && m.id3 == "TPOS":
+		case name == "LYRICS":
+			kept = append(kept, id3LyricsFrames(values[0])...)
+		case name == "COMMENT":
+			kept = append(kept, newID3LangTextFrame("COMM", "eng", "", values[0]))
+		case name == "MUSICBRAINZ_TRACKID":
+			kept = append(kept, id3Frame{id: "UFID", data: append([]byte(musicBrainzUFIDOwner+"\x00"), values[0]...)})
+		case mapped && m.id3 != "":
+			kept = append(kept, newID3TextFrame(m.id3, strings.Join(values, "\x00")))
+		case rawFrames[name]:
+			kept = append(kept, newID3TextFrame(name, strings.Join(values, "\x00")))
+		default:
+			desc := name
+			if mapped && m.id3Desc != "" {
+				desc = m.id3Desc
+			}
+			kept = append(kept, newID3UserTextFrame(desc, strings.Join(values, "\x00")))
+		}
+	}
+	return kept
+}
+
+func readMP3TagMap(filePath string) (tagMap, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	tag, _, err := readID3v2TagRegion(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(tag) == 0 {
+		return tagMap{}, nil
+	}
+	frames, err := parseID3v2FramesForRewrite(tag)
+	if err != nil {
+		return nil, err
+	}
+	return tagMapFromID3Frames(frames), nil
+}
+
+// --- RIFF INFO and the WAV/AIFF id3 chunk ---
+
+// readWAVTagMap reads the WAV id3 chunk, with RIFF INFO values filling any
+// field the id3 chunk lacks. Edits are always written to the id3 chunk,
+// which readers prefer over INFO.
+func readWAVTagMap(filePath string) (tagMap, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := streamProbeWAV(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback := tagMap{}
+	for id, value := range p.info {
+		name, ok := tagNameByRIFFChunk[id]
+		if !ok {
+			name = id
+		}
+		if name == "GENRE" {
+			value = cleanGenre(value)
+		}
+		fallback.add(name, value)
+	}
+	return mergeID3ChunkTagMap(p.id3, fallback)
+}
+
+// readAIFFTagMap reads the AIFF id3 chunk, with the NAME/AUTH/ANNO/(c) text
+// chunks filling any field it lacks.
+func readAIFFTagMap(filePath string) (tagMap, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := streamProbeAIFF(f)
+	if err != nil {
+		return nil, err
+	}
+
+	fallback := tagMap{}
+	fallback.add("TITLE", p.nameChunk)
+	fallback.add("ARTIST", p.authChunk)
+	fallback.add("COMMENT", p.annoChunk)
+	fallback.add("COPYRIGHT", p.copyrightChunk)
+	return mergeID3ChunkTagMap(p.id3, fallback)
+}
+
+func mergeID3ChunkTagMap(id3 []byte, fallback tagMap) (tagMap, error) {
+	tags := tagMap{}
+	if len(id3) > 0 {
+		frames, err := parseID3v2FramesForRewrite(id3)
+		if err != nil {
+			return nil, err
+		}
+		tags = tagMapFromID3Frames(frames)
+	}
+	for name, values := range fallback {
+		if len(tags[name]) == 0 {
+			tags[name] = values
+		}
+	}
+	return tags, nil
+}
+
+func writeID3ChunkTagMap(filePath, magic, chunkID string, le bool, edits tagMap) error {
+	return rewriteID3ChunkFrames(filePath, magic, chunkID, le, func(frames []id3Frame) []id3Frame {
+		return applyID3TagMap(frames, edits)
+	})
+}
+
+// readID3Chunk returns the raw id3 chunk of a WAV (magic "RIFF") or AIFF
+// file, or nil when it has none.
+func readID3Chunk(filePath, magic string) ([]byte, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if magic == "RIFF" {
+		p, err := streamProbeWAV(f)
+		if err != nil {
+			return nil, err
+		}
+		return p.id3, nil
+	}
+	p, err := streamProbeAIFF(f)
+	if err != nil {
+		return nil, err
+	}
+	return p.id3, nil
+}
+
+// rewriteID3ChunkFrames replaces the frames of a WAV/AIFF id3 chunk with
+// edit(frames).
+func rewriteID3ChunkFrames(filePath, magic, chunkID string, le bool, edit func([]id3Frame) []id3Frame) error {
+	existing, err := readID3Chunk(filePath, magic)
+	if err != nil {
+		return err
+	}
+
+	var frames []id3Frame
+	if len(existing) > 0 {
+		if frames, err = parseID3v2FramesForRewrite(existing); err != nil {
+			GoLog("[Tags] Discarding unreadable id3 chunk: %v\n", err)
+			frames = nil
+		}
+	}
+	frames = dropTagAlterDiscardFrames(edit(frames))
+	return writeID3Chunk(filePath, magic, chunkID, le, serializeID3v24Tag(serializeID3v24Frames(frames), 0))
+}
+
+// --- iTunes ilst (MP4/M4A) ---
+
+// m4aItemValues returns the payloads of every data atom of an ilst item.
+func m4aItemValues(buf []byte, item mp4Box) [][]byte {
+	var values [][]byte
+	eachChildMP4(buf, item.body(), item.end(), "data", func(data mp4Box) bool {
+		if data.body()+8 <= data.end() {
+			values = append(values, buf[data.body()+8:data.end()])
+		}
+		return true
+	})
+	return values
+}
+
+// m4aItemTagName returns the canonical field an ilst item stores. Atoms
+// without a mapping pass through under their upper-cased atom type, with the
+// Latin-1 © spelled as UTF-8; covr is handled by the picture code.
+func m4aItemTagName(buf []byte, item mp4Box) string {
+	if item.typ == "----" {
+		return canonicalTagName(m4aFreeformName(buf, item))
+	}
+	if name, ok := tagNameByMP4Atom[item.typ]; ok {
+		return name
+	}
+	if item.typ == "covr" {
+		return ""
+	}
+	runes := make([]rune, 0, len(item.typ))
+	for i := 0; i < len(item.typ); i++ {
+		runes = append(runes, rune(item.typ[i]))
+	}
+	return strings.ToUpper(string(runes))
+}
+
+// m4aRawItemValue formats the payload of an unmapped atom: UTF-8 text as is
+// and integer types as decimal. Other types are not exposed.
+func m4aRawItemValue(data []byte) (string, bool) {
+	if len(data) < 8 {
+		return "", false
+	}
+	payload := data[8:]
+	switch binary.BigEndian.Uint32(data[0:4]) {
+	case 1:
+		return string(payload), true
+	case 0, 21, 22:
+		if len(payload) == 0 || len(payload) > 8 {
+			return "", false
+		}
+		var n uint64
+		for _, b := range payload {
+			n = n<<8 | uint64(b)
+		}
+		return strconv.FormatUint(n, 10), true
+	}
+	return "", false
+}
+
+// m4aRawItem rebuilds an unmapped atom with the type code and width of the
+// original, so integer atoms such as stik stay integers.
+func m4aRawItem(original []byte, atomType string, values []string) []byte {
+	if len(original) >= 8 && binary.BigEndian.Uint32(original[0:4]) != 1 {
+		var payload []byte
+		for _, value := range values {
+			n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				continue
+			}
+			data := append([]byte{}, original[:8]...)
+			width := len(original) - 8
+			for i := width - 1; i >= 0; i-- {
+				data = append(data, byte(n>>(8*uint(i))))
+			}
+			payload = append(payload, buildM4AAtom("data", data)...)
+		}
+		if len(payload) == 0 {
+			return nil
+		}
+		return buildM4AAtom(atomType, payload)
+	}
+	return buildM4AItemValues(atomType, "", values)
+}
+
+// m4aItemDataAtoms returns every data atom of an ilst item, type header
+// included.
+func m4aItemDataAtoms(buf []byte, item mp4Box) [][]byte {
+	var atoms [][]byte
+	eachChildMP4(buf, item.body(), item.end(), "data", func(data mp4Box) bool {
+		atoms = append(atoms, buf[data.body():data.end()])
+		return true
+	})
+	return atoms
+}
+
+func tagMapFromM4AItems(buf []byte, items []mp4Box) tagMap {
+	tags := tagMap{}
+	for _, item := range items {
+		name := m4aItemTagName(buf, item)
+		if name == "" {
+			continue
+		}
+		if _, mapped := tagNameByMP4Atom[item.typ]; !mapped && item.typ != "----" {
+			for _, data := range m4aItemDataAtoms(buf, item) {
+				if value, ok := m4aRawItemValue(data); ok {
+					tags.add(name, value)
+				}
+			}
+			continue
+		}
+		for _, payload := range m4aItemValues(buf, item) {
+			switch item.typ {
+			case "trkn", "disk":
+				if len(payload) < 6 {
+					continue
+				}
+				prefix := "TRACK"
+				if item.typ == "disk" {
+					prefix = "DISC"
+				}
+				if n := binary.BigEndian.Uint16(payload[2:4]); n > 0 {
+					tags.add(prefix+"NUMBER", strconv.Itoa(int(n)))
+				}
+				if t := binary.BigEndian.Uint16(payload[4:6]); t > 0 {
+					tags.add(prefix+"TOTAL", strconv.Itoa(int(t)))
+				}
+			case "tmpo":
+				if len(payload) >= 2 {
+					tags.add(name, strconv.Itoa(int(binary.BigEndian.Uint16(payload[:2]))))
+				}
+			case "cpil":
+				if len(payload) >= 1 {
+					tags.add(name, strconv.Itoa(int(payload[0])))
+				}
+			case "gnre":
+				if len(payload) >= 2 {
+					if idx := int(binary.BigEndian.Uint16(payload[:2])) - 1; idx >= 0 && idx < len(id3v1Genres) {
+						tags.add(name, id3v1Genres[idx])
+					}
+				}
+			default:
+				tags.add(name, string(payload))
+			}
+		}
+	}
+	return tags
+}
+
+func readM4ATagMap(filePath string) (tagMap, error) {
+	buf, items, err := readM4AIlstItems(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return tagMapFromM4AItems(buf, items), nil
+}
+
+// readM4AIlstItems reads the ilst atom of an MP4 file and splits it into
+// items. A file without an ilst yields no items and no error.
+func readM4AIlstItems(filePath string) ([]byte, []mp4Box, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ilst, err := findM4AIlstAtom(f, info.Size())
+	if err != nil {
+		return nil, nil, nil
+	}
+	buf := make([]byte, ilst.size)
+	if _, err := f.ReadAt(buf, ilst.offset); err != nil {
+		return nil, nil, err
+	}
+	var items []mp4Box
+	for pos := ilst.headerSize; pos+8 <= int64(len(buf)); {
+		item, ok := readMP4Box(buf, pos)
+		if !ok {
+			break
+		}
+		items = append(items, item)
+		pos = item.end()
+	}
+	return buf, items, nil
+}
+
+// buildM4AItemValues builds an ilst item holding one UTF-8 data atom per
+// value; freeform items get the com.apple.iTunes mean and the given name.
+func buildM4AItemValues(atomType, freeformName string, values []string) []byte {
+	var payload []byte
+	if atomType == "----" {
+		payload = append(payload, buildM4AAtom("mean", append([]byte{0, 0, 0, 0}, "com.apple.iTunes"...))...)
+		payload = append(payload, buildM4AAtom("name", append([]byte{0, 0, 0, 0}, freeformName...))...)
+	}
+	for _, value := range values {
+		data := make([]byte, 8, 8+len(value))
+		binary.BigEndian.PutUint32(data[0:4], 1) // well-known type 1 = UTF-8
+		payload = append(payload, buildM4AAtom("data", append(data, value...))...)
+	}
+	return buildM4AAtom(atomType, payload)
+}
+
+func applyM4ATagMap(buf []byte, items []mp4Box, edits tagMap) []byte {
+	var trackNum, trackTotal, discNum, discTotal int
+	// Unmapped atoms are written back as themselves; other unknown names
+	// become freeform items.
+	type rawAtom struct {
+		typ  string
+		data []byte
+	}
+	rawAtoms := map[string]rawAtom{}
+	out := make([]byte, 0, 1024)
+	for _, item := range items {
+		name := m4aItemTagName(buf, item)
+		if _, mapped := tagNameByMP4Atom[item.typ]; !mapped && name != "" && item.typ != "----" {
+			if _, seen := rawAtoms[name]; !seen {
+				var first []byte
+				if atoms := m4aItemDataAtoms(buf, item); len(atoms) > 0 {
+					first = atoms[0]
+				}
+				rawAtoms[name] = rawAtom{typ: item.typ, data: first}
+			}
+		}
+		switch item.typ {
+		case "trkn", "disk":
+			if payload := m4aItemData(buf, item); len(payload) >= 6 {
+				n, t := int(binary.BigEndian.Uint16(payload[2:4])), int(binary.BigEndian.Uint16(payload[4:6]))
+				if item.typ == "trkn" {
+					trackNum, trackTotal = n, t
+				} else {
+					discNum, discTotal = n, t
+				}
+			}
+			prefix := strings.TrimSuffix(name, "NUMBER")
+			_, numberEdited := edits[prefix+"NUMBER"]
+			_, totalEdited := edits[prefix+"TOTAL"]
+			if numberEdited || totalEdited {
+				continue
+			}
+		default:
+			if _, edited := edits[name]; edited && name != "" {
+				continue
+			}
+		}
+		out = append(out, buf[item.offset:item.end()]...)
+	}
+
+	if n, t, ok := editedIndexPair(edits, "TRACKNUMBER", "TRACKTOTAL", trackNum, trackTotal); ok && n > 0 {
+		out = append(out, itunesNumberPairTag("trkn", n, t)...)
+	}
+	if n, t, ok := editedIndexPair(edits, "DISCNUMBER", "DISCTOTAL", discNum, discTotal); ok && n > 0 {
+		out = append(out, itunesNumberPairTag("disk", n, t)...)
+	}
+
+	for _, name := range edits.sortedNames() {
+		values := edits[name]
+		if len(values) == 0 {
+			continue
+		}
+		m, mapped := tagMappingByName[name]
+		switch {
+		case mapped && (m.mp4 == "trkn" || m.mp4 == "disk"):
+		case mapped && m.mp4 == "tmpo":
+			if bpm := parsePositiveInt(values[0]); bpm > 0 && bpm <= 0xFFFF {
+				data := make([]byte, 10)
+				binary.BigEndian.PutUint32(data[0:4], 21)
+				binary.BigEndian.PutUint16(data[8:10], uint16(bpm))
+				out = append(out, buildM4AAtom("tmpo", buildM4AAtom("data", data))...)
+			}
+		case mapped && m.mp4 == "cpil":
+			if parseBoolField(values[0]) {
+				out = append(out, itunesUint8Tag("cpil", 1)...)
+			}
+		case mapped && m.mp4 != "":
+			out = append(out, buildM4AItemValues(m.mp4, "", values)...)
+		case rawAtoms[name].typ != "":
+			out = append(out, m4aRawItem(rawAtoms[name].data, rawAtoms[name].typ, values)...)
+		default:
+			freeformName := name
+			if mapped && m.mp4Name != "" {
+				freeformName = m.mp4Name
+			}
+			out = append(out, buildM4AItemValues("----", freeformName, values)...)
+		}
+	}
+	return out
+}
+
+// --- APEv2 ---
+
+func readAPETagMap(filePath string) (tagMap, error) {
+	tag, err := ReadAPETags(filePath)
+	if err != nil {
+		return tagMap{}, nil
+	}
+	tags := tagMap{}
+	for _, item := range tag.Items {
+		if item.Flags&(3<<1) != apeItemFlagUTF8 {
+			continue
+		}
+		tags.add(canonicalTagName(item.Key), strings.Split(item.Value, "\x00")...)
+	}
+	return tags, nil
+}
+
+func writeAPETagMap(filePath string, edits tagMap) error {
+	tag, err := ReadAPETags(filePath)
+	if err != nil {
+		tag = &APETag{Version: apeTagVersion2}
+	}
+
+	var trackNum, trackTotal, discNum, discTotal int
+	items := make([]APETagItem, 0, len(tag.Items)+len(edits))
+	for _, item := range tag.Items {
+		name := canonicalTagName(item.Key)
+		switch name {
+		case "TRACKNUMBER":
+			trackNum, trackTotal = parseIndexPair(item.Value)
+		case "DISCNUMBER":
+			discNum, discTotal = parseIndexPair(item.Value)
+		}
+		if item.Flags&(3<<1) == apeItemFlagUTF8 {
+			if _, edited := edits[name]; edited {
+				continue
+			}
+			if name == "TRACKNUMBER" || name == "DISCNUMBER" {
+				prefix := strings.TrimSuffix(name, "NUMBER")
+				if _, edited := edits[prefix+"TOTAL"]; edited {
+					continue
+				}
+			}
+		}
+		items = append(items, item)
+	}
+
+	if n, t, ok := editedIndexPair(edits, "TRACKNUMBER", "TRACKTOTAL", trackNum, trackTotal); ok && n > 0 {
+		items = append(items, APETagItem{Key: "Track", Value: formatIndexValue(n, t)})
+	}
+	if n, t, ok := editedIndexPair(edits, "DISCNUMBER", "DISCTOTAL", discNum, discTotal); ok && n > 0 {
+		items = append(items, APETagItem{Key: "Disc", Value: formatIndexValue(n, t)})
+	}
+	for _, name := range edits.sortedNames() {
+		values := edits[name]
+		m, mapped := tagMappingByName[name]
+		if len(values) == 0 || (mapped && (m.ape == "Track" || m.ape == "Disc")) {
+			continue
+		}
+		key := name
+		if mapped && m.ape != "" {
+			key = m.ape
+		}
+		items = append(items, APETagItem{Key: key, Value: strings.Join(values, "\x00")})
+	}
+
+	tag.Items = items
+	tag.Version = apeTagVersion2
+	return WriteAPETags(filePath, tag)
+}
@@ -0,0 +1,289 @@
+package gobackend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// buildTestFLAC returns a FLAC stream with a zeroed STREAMINFO block, a
+// Vorbis comment block and a few bytes standing in for audio frames.
+func buildTestFLAC(comments ...string) []byte {
+	out := []byte("fLaC")
+	out = append(out, 0x00, 0x00, 0x00, 34)
+	out = append(out, make([]byte, 34)...)
+	block := buildVorbisCommentList("test vendor", comments)
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(block)))
+	header[0] = 0x80 | 4 // last block, VORBIS_COMMENT
+	out = append(out, header...)
+	out = append(out, block...)
+	return append(out, 0xFF, 0xF8, 0x69, 0x08, 0x00, 0x00)
+}
+
+func readTestTagsJSON(t *testing.T, path string) (string, map[string][]string) {
+	t.Helper()
+	response, err := ReadTagsJSON(path)
+	if err != nil {
+		t.Fatalf("ReadTagsJSON: %v", err)
+	}
+	var decoded struct {
+		Format string              `json:"format"`
+		Tags   map[string][]string `json:"tags"`
+	}
+	if err := json.Unmarshal([]byte(response), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	return decoded.Format, decoded.Tags
+}
+
+func writeTestTagsJSON(t *testing.T, path, tagsJSON string) {
+	t.Helper()
+	if _, err := WriteTagsJSON(path, tagsJSON); err != nil {
+		t.Fatalf("WriteTagsJSON: %v", err)
+	}
+}
+
+func assertTagValues(t *testing.T, tags map[string][]string, name string, want ...string) {
+	t.Helper()
+	if len(want) == 0 {
+		if _, ok := tags[name]; ok {
+			t.Fatalf("%s = %q, want it removed", name, tags[name])
+		}
+		return
+	}
+	if !reflect.DeepEqual(tags[name], want) {
+		t.Fatalf("%s = %q, want %q", name, tags[name], want)
+	}
+}
+
+func TestTagModelMP3RoundTripKeepsCustomFrames(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	tag := buildID3v23Tag(
+		id3TextFrame("TIT2", "Song"),
+		id3TextFrame("TRCK", "3/12"),
+		id3UserTextFrame("TXXX", "MusicBrainz Album Id", "mb-album"),
+		id3UserTextFrame("TXXX", "DISCOGS_RELEASE_ID", "123"),
+		id3v23Frame("UFID", []byte("http://musicbrainz.org\x00mb-track")),
+		id3v23Frame("PRIV", []byte("owner\x00secret")),
+	)
+	if err := os.WriteFile(path, append(tag, testMP3Audio...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	format, tags := readTestTagsJSON(t, path)
+	if format != "mp3" {
+		t.Fatalf("format = %q", format)
+	}
+	assertTagValues(t, tags, "TITLE", "Song")
+	assertTagValues(t, tags, "TRACKNUMBER", "3")
+	assertTagValues(t, tags, "TRACKTOTAL", "12")
+	assertTagValues(t, tags, "MUSICBRAINZ_ALBUMID", "mb-album")
+	assertTagValues(t, tags, "MUSICBRAINZ_TRACKID", "mb-track")
+	assertTagValues(t, tags, "DISCOGS_RELEASE_ID", "123")
+
+	writeTestTagsJSON(t, path, `{"ARTIST":["A","B"],"TRACKTOTAL":"14","DISCOGS_RELEASE_ID":[],"discogs_label_id":["77"],"MUSICBRAINZ_TRACKID":["mb-new"]}`)
+
+	_, tags = readTestTagsJSON(t, path)
+	assertTagValues(t, tags, "TITLE", "Song")
+	assertTagValues(t, tags, "ARTIST", "A", "B")
+	assertTagValues(t, tags, "TRACKNUMBER", "3")
+	assertTagValues(t, tags, "TRACKTOTAL", "14")
+	assertTagValues(t, tags, "DISCOGS_RELEASE_ID")
+	assertTagValues(t, tags, "DISCOGS_LABEL_ID", "77")
+	assertTagValues(t, tags, "MUSICBRAINZ_TRACKID", "mb-new")
+	assertTagValues(t, tags, "MUSICBRAINZ_ALBUMID", "mb-album")
+	if !bytes.Contains(mustReadFile(t, path), []byte("secret")) {
+		t.Fatal("PRIV frame was dropped")
+	}
+	if meta, err := ReadID3Tags(path); err != nil || meta.Artist != "A" || meta.TotalTracks != 14 {
+		t.Fatalf("ReadID3Tags = %+v/%v", meta, err)
+	}
+}
+
+func TestTagModelVorbisAndOggRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	flacPath := filepath.Join(dir, "song.flac")
+	if err := os.WriteFile(flacPath, buildTestFLAC("TITLE=Song", "ARTIST=A", "ARTIST=B", "ALBUM ARTIST=Various", "TRACKNUMBER=2/9", "MUSICBRAINZ_ALBUMID=mb"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opusPath := filepath.Join(dir, "song.opus")
+	if err := os.WriteFile(opusPath, buildTestOggStream(testOpusHeaders("TITLE=Song", "DISCOGS_ARTIST_ID=5"), [][]byte{{1, 2, 3}}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, tags := readTestTagsJSON(t, flacPath)
+	assertTagValues(t, tags, "ARTIST", "A", "B")
+	assertTagValues(t, tags, "ALBUMARTIST", "Various")
+	assertTagValues(t, tags, "TRACKNUMBER", "2")
+	assertTagValues(t, tags, "TRACKTOTAL", "9")
+	assertTagValues(t, tags, "MUSICBRAINZ_ALBUMID", "mb")
+
+	writeTestTagsJSON(t, flacPath, `{"tags":{"ALBUMARTIST":["VA"],"MUSICBRAINZ_ALBUMID":[],"CUSTOM":["x","y"]}}`)
+	_, tags = readTestTagsJSON(t, flacPath)
+	assertTagValues(t, tags, "ARTIST", "A", "B")
+	assertTagValues(t, tags, "ALBUMARTIST", "VA")
+	assertTagValues(t, tags, "MUSICBRAINZ_ALBUMID")
+	assertTagValues(t, tags, "CUSTOM", "x", "y")
+	if meta, err := ReadMetadata(flacPath); err != nil || meta.AlbumArtist != "VA" {
+		t.Fatalf("ReadMetadata = %+v/%v", meta, err)
+	}
+
+	writeTestTagsJSON(t, opusPath, `{"REPLAYGAIN_TRACK_GAIN":"-3.00 dB","ARTIST":["A"]}`)
+	format, tags := readTestTagsJSON(t, opusPath)
+	if format != "ogg" {
+		t.Fatalf("format = %q", format)
+	}
+	assertTagValues(t, tags, "DISCOGS_ARTIST_ID", "5")
+	assertTagValues(t, tags, "REPLAYGAIN_TRACK_GAIN", "-3.00 dB")
+	assertTagValues(t, tags, "R128_TRACK_GAIN", "-2048")
+}
+
+func TestTagModelM4AFreeformAndMultiValue(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.m4a")
+	ilst := append(buildM4ATextTag("\xa9nam", "Song"), buildM4AIndexTag("disk", 1, 2)...)
+	ilst = append(ilst, buildM4AFreeformAtom("MusicBrainz Album Id", "mb")...)
+	ilst = append(ilst, buildM4AFreeformAtom("DISCOGS_RELEASE_ID", "9")...)
+	if err := os.WriteFile(path, buildTestM4AWithChunks(ilst, false), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, tags := readTestTagsJSON(t, path)
+	assertTagValues(t, tags, "DISCNUMBER", "1")
+	assertTagValues(t, tags, "DISCTOTAL", "2")
+	assertTagValues(t, tags, "MUSICBRAINZ_ALBUMID", "mb")
+	assertTagValues(t, tags, "DISCOGS_RELEASE_ID", "9")
+
+	writeTestTagsJSON(t, path, `{"GENRE":["Rock","Pop"],"DISCNUMBER":"2","BPM":"128","COMPILATION":"1","DISCOGS_RELEASE_ID":["10"],"MOOD":["calm"]}`)
+	assertChunkOffsetValid(t, mustReadFile(t, path))
+
+	_, tags = readTestTagsJSON(t, path)
+	assertTagValues(t, tags, "TITLE", "Song")
+	assertTagValues(t, tags, "GENRE", "Rock", "Pop")
+	assertTagValues(t, tags, "DISCNUMBER", "2")
+	assertTagValues(t, tags, "DISCTOTAL", "2")
+	assertTagValues(t, tags, "BPM", "128")
+	assertTagValues(t, tags, "COMPILATION", "1")
+	assertTagValues(t, tags, "DISCOGS_RELEASE_ID", "10")
+	assertTagValues(t, tags, "MOOD", "calm")
+	assertTagValues(t, tags, "MUSICBRAINZ_ALBUMID", "mb")
+	if meta, err := ReadM4ATags(path); err != nil || meta.Genre != "Rock" || meta.DiscNumber != 2 {
+		t.Fatalf("ReadM4ATags = %+v/%v", meta, err)
+	}
+}
+
+func TestTagModelPassesUnmappedFramesAndAtoms(t *testing.T) {
+	dir := t.TempDir()
+	mp3Path := filepath.Join(dir, "song.mp3")
+	tag := buildID3v23Tag(id3TextFrame("TIT2", "Song"), id3TextFrame("TOWN", "Owner"), id3TextFrame("TOPE", "Original"))
+	if err := os.WriteFile(mp3Path, append(tag, testMP3Audio...), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, tags := readTestTagsJSON(t, mp3Path)
+	assertTagValues(t, tags, "TOWN", "Owner")
+	assertTagValues(t, tags, "TOPE", "Original")
+
+	writeTestTagsJSON(t, mp3Path, `{"TOWN":["New Owner"],"TOPE":[]}`)
+	_, tags = readTestTagsJSON(t, mp3Path)
+	assertTagValues(t, tags, "TOWN", "New Owner")
+	assertTagValues(t, tags, "TOPE")
+	assertTagValues(t, tags, "TITLE", "Song")
+	if findID3TextValue(mustParseID3Frames(t, mp3Path), "TOWN") != "New Owner" {
+		t.Fatal("TOWN was not written back as its own frame")
+	}
+
+	m4aPath := filepath.Join(dir, "song.m4a")
+	ilst := append(buildM4ATextTag("\xa9nam", "Song"), buildM4ATextTag("\xa9mvn", "Movement")...)
+	ilst = append(ilst, itunesUint8Tag("stik", 1)...)
+	if err := os.WriteFile(m4aPath, buildTestM4AWithChunks(ilst, false), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, tags = readTestTagsJSON(t, m4aPath)
+	assertTagValues(t, tags, "©MVN", "Movement")
+	assertTagValues(t, tags, "STIK", "1")
+
+	writeTestTagsJSON(t, m4aPath, `{"©MVN":["Finale"],"STIK":["6"]}`)
+	_, tags = readTestTagsJSON(t, m4aPath)
+	assertTagValues(t, tags, "©MVN", "Finale")
+	assertTagValues(t, tags, "STIK", "6")
+	assertTagValues(t, tags, "TITLE", "Song")
+	if data := mustReadFile(t, m4aPath); !bytes.Contains(data, []byte("\xa9mvn")) || bytes.Contains(data, []byte("----")) {
+		t.Fatal("unmapped atoms were not written back as themselves")
+	}
+}
+
+func mustParseID3Frames(t *testing.T, path string) []id3Frame {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tag, _, err := readID3v2TagRegion(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frames, err := parseID3v2FramesForRewrite(tag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frames
+}
+
+func TestTagModelAPEAndRIFFInfo(t *testing.T) {
+	dir := t.TempDir()
+	apePath := filepath.Join(dir, "song.ape")
+	if err := os.WriteFile(apePath, []byte("MAC audio"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteAPETags(apePath, &APETag{Version: apeTagVersion2, Items: []APETagItem{
+		{Key: "Title", Value: "Song"},
+		{Key: "Track", Value: "4/10"},
+		{Key: "Artist", Value: "A\x00B"},
+		{Key: "Cover Art (Front)", Value: "cover.jpg\x00img", Flags: apeItemFlagBinary},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, tags := readTestTagsJSON(t, apePath)
+	assertTagValues(t, tags, "ARTIST", "A", "B")
+	assertTagValues(t, tags, "TRACKNUMBER", "4")
+	assertTagValues(t, tags, "TRACKTOTAL", "10")
+
+	writeTestTagsJSON(t, apePath, `{"TRACKNUMBER":"5","MUSICBRAINZ_ALBUMID":"mb"}`)
+	_, tags = readTestTagsJSON(t, apePath)
+	assertTagValues(t, tags, "TRACKNUMBER", "5")
+	assertTagValues(t, tags, "TRACKTOTAL", "10")
+	assertTagValues(t, tags, "MUSICBRAINZ_ALBUMID", "mb")
+	tag, err := ReadAPETags(apePath)
+	if err != nil || len(tag.Items) != 5 {
+		t.Fatalf("ReadAPETags = %+v/%v", tag, err)
+	}
+
+	// WAV: RIFF INFO values show up until the id3 chunk overrides them.
+	info := append([]byte("INFO"), buildTestRIFFChunk("INAM", []byte("Info Title\x00"), false)...)
+	info = append(info, buildTestRIFFChunk("ISBJ", []byte("Subject\x00"), false)...)
+	wav := buildTestWAV()
+	wav = append(wav, buildTestRIFFChunk("LIST", info, false)...)
+	binary.LittleEndian.PutUint32(wav[4:8], uint32(len(wav)-8))
+	wavPath := filepath.Join(dir, "song.wav")
+	if err := os.WriteFile(wavPath, wav, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, tags = readTestTagsJSON(t, wavPath)
+	assertTagValues(t, tags, "TITLE", "Info Title")
+	assertTagValues(t, tags, "ISBJ", "Subject")
+
+	writeTestTagsJSON(t, wavPath, `{"TITLE":"Tagged","ALBUM":"Album"}`)
+	_, tags = readTestTagsJSON(t, wavPath)
+	assertTagValues(t, tags, "TITLE", "Tagged")
+	assertTagValues(t, tags, "ALBUM", "Album")
+	assertTagValues(t, tags, "ISBJ", "Subject")
+}
@@ -13,7 +13,6 @@ package gobackend
 // that carry only RIFF INFO tags (common from other taggers).
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -210,7 +209,7 @@ func wavMetadataFromProbe(p *wavProbe) *AudioMetadata {
 	}
 	if len(p.id3) > 0 {
 		if meta, err := readID3v2FromBytes(p.id3); err == nil && meta != nil &&
-			(meta.Title != "" || meta.Artist != "" || meta.Album != "") {
+			(meta.Title != "" || meta.Artist != "" || meta.Album != "" || meta.Lyrics != "") {
 			return meta
 		}
 	}
@@ -379,7 +378,7 @@ func aiffMetadataFromProbe(p *aiffProbe) *AudioMetadata {
 	}
 	if len(p.id3) > 0 {
 		if meta, err := readID3v2FromBytes(p.id3); err == nil && meta != nil &&
-			(meta.Title != "" || meta.Artist != "" || meta.Album != "") {
+			(meta.Title != "" || meta.Artist != "" || meta.Album != "" || meta.Lyrics != "") {
 			return meta
 		}
 	}
@@ -476,162 +475,15 @@ func readID3v2FromBytes(data []byte) (*AudioMetadata, error) {
 	return metadata, nil
 }
 
-// extractAPICFromID3 returns the first embedded picture (APIC/PIC) and its MIME.
+// extractAPICFromID3 returns the front cover (or else the first picture) of
+// a raw ID3v2 tag and its MIME.
 func extractAPICFromID3(tag []byte) ([]byte, string) {
-	if len(tag) < 10 || string(tag[0:3]) != "ID3" {
-		return nil, ""
-	}
-	ver := tag[3]
-	size := synchsafeDecode(tag[6:10])
-	if size <= 0 || 10+size > len(tag) {
-		size = len(tag) - 10
-	}
-	data := tag[10 : 10+size]
-
-	pos := 0
-	for {
-		if ver == 2 {
-			if pos+6 > len(data) || data[pos] == 0 {
-				break
-			}
-			id := string(data[pos : pos+3])
-			fsz := int(data[pos+3])<<16 | int(data[pos+4])<<8 | int(data[pos+5])
-			if fsz <= 0 || pos+6+fsz > len(data) {
-				break
-			}
-			if id == "PIC" {
-				return parseAPICFrame(data[pos+6:pos+6+fsz], ver)
-			}
-			pos += 6 + fsz
-			continue
-		}
-
-		if pos+10 > len(data) || data[pos] == 0 {
-			break
-		}
-		id := string(data[pos : pos+4])
-		var fsz int
-		if ver == 4 {
-			fsz = synchsafeDecode(data[pos+4 : pos+8])
-		} else {
-			fsz = int(binary.BigEndian.Uint32(data[pos+4 : pos+8]))
-		}
-		if fsz <= 0 || pos+10+fsz > len(data) {
-			break
-		}
-		if id == "APIC" {
-			return parseAPICFrame(data[pos+10:pos+10+fsz], ver)
-		}
-		pos += 10 + fsz
+	if pic, ok := selectCoverPicture(id3TagPictures(tag)); ok {
+		return pic.Data, pic.MIME
 	}
 	return nil, ""
 }
 
-// buildID3v24Tag builds a UTF-8 ID3v2.4 tag from metadata plus optional cover.
-func buildID3v24Tag(meta *AudioMetadata, coverData []byte, coverMIME string) []byte {
-	var frames bytes.Buffer
-
-	writeFrame := func(id string, payload []byte) {
-		frames.WriteString(id)
-		frames.Write(synchsafeEncode(len(payload)))
-		frames.Write([]byte{0, 0})
-		frames.Write(payload)
-	}
-	writeText := func(id, val string) {
-		if strings.TrimSpace(val) == "" {
-			return
-		}
-		payload := append([]byte{0x03}, []byte(val)...)
-		writeFrame(id, payload)
-	}
-
-	writeText("TIT2", meta.Title)
-	writeText("TPE1", meta.Artist)
-	writeText("TALB", meta.Album)
-	writeText("TPE2", meta.AlbumArtist)
-	writeText("TCON", meta.Genre)
-	writeText("TCOM", meta.Composer)
-	writeText("TPUB", meta.Label)
-	writeText("TCOP", meta.Copyright)
-	writeText("TSRC", meta.ISRC)
-
-	date := meta.Date
-	if date == "" {
-		date = meta.Year
-	}
-	writeText("TDRC", date)
-
-	if meta.TrackNumber > 0 {
-		if meta.TotalTracks > 0 {
-			writeText("TRCK", fmt.Sprintf("%d/%d", meta.TrackNumber, meta.TotalTracks))
-		} else {
-			writeText("TRCK", strconv.Itoa(meta.TrackNumber))
-		}
-	}
-	if meta.DiscNumber > 0 {
-		if meta.TotalDiscs > 0 {
-			writeText("TPOS", fmt.Sprintf("%d/%d", meta.DiscNumber, meta.TotalDiscs))
-		} else {
-			writeText("TPOS", strconv.Itoa(meta.DiscNumber))
-		}
-	}
-
-	if strings.TrimSpace(meta.Comment) != "" {
-		// COMM: encoding + language(3) + short desc(null) + text
-		payload := []byte{0x03}
-		payload = append(payload, []byte("eng")...)
-		payload = append(payload, 0x00) // empty description
-		payload = append(payload, []byte(meta.Comment)...)
-		writeFrame("COMM", payload)
-	}
-	if strings.TrimSpace(meta.Lyrics) != "" {
-		payload := []byte{0x03}
-		payload = append(payload, []byte("eng")...)
-		payload = append(payload, 0x00)
-		payload = append(payload, []byte(meta.Lyrics)...)
-		writeFrame("USLT", payload)
-	}
-
-	// ReplayGain as TXXX (description\0value), UTF-8.
-	writeTXXX := func(desc, val string) {
-		if strings.TrimSpace(val) == "" {
-			return
-		}
-		payload := []byte{0x03}
-		payload = append(payload, []byte(desc)...)
-		payload = append(payload, 0x00)
-		payload = append(payload, []byte(val)...)
-		writeFrame("TXXX", payload)
-	}
-	writeTXXX("REPLAYGAIN_TRACK_GAIN", meta.ReplayGainTrackGain)
-	writeTXXX("REPLAYGAIN_TRACK_PEAK", meta.ReplayGainTrackPeak)
-	writeTXXX("REPLAYGAIN_ALBUM_GAIN", meta.ReplayGainAlbumGain)
-	writeTXXX("REPLAYGAIN_ALBUM_PEAK", meta.ReplayGainAlbumPeak)
-
-	if len(coverData) > 0 {
-		if strings.TrimSpace(coverMIME) == "" {
-			coverMIME = "image/jpeg"
-		}
-		// APIC: encoding + mime(null) + picture-type(0x03 front) + desc(null) + data
-		payload := []byte{0x03}
-		payload = append(payload, []byte(coverMIME)...)
-		payload = append(payload, 0x00)
-		payload = append(payload, 0x03)
-		payload = append(payload, 0x00)
-		payload = append(payload, coverData...)
-		writeFrame("APIC", payload)
-	}
-
-	body := frames.Bytes()
-	var out bytes.Buffer
-	out.WriteString("ID3")
-	out.Write([]byte{0x04, 0x00}) // v2.4.0
-	out.WriteByte(0x00)           // flags
-	out.Write(synchsafeEncode(len(body)))
-	out.Write(body)
-	return out.Bytes()
-}
-
 // writeID3Chunk rewrites filePath, replacing any existing tag chunk (chunkID,
 // matched case-insensitively) with a fresh ID3v2.4 chunk appended at the end.
 // The audio data and all other chunks are preserved; container size is patched.
@@ -843,17 +695,7 @@ func WriteWAVTags(filePath string, fields map[string]string) error {
 	meta := mergeEditFieldsOntoExisting(existing, fields)
 
 	coverData, coverMIME := loadCoverForTag(fields)
-	if coverData == nil {
-		// Preserve an existing embedded cover when no new one is supplied.
-		if f, err := os.Open(filePath); err == nil {
-			if p, perr := streamProbeWAV(f); perr == nil && len(p.id3) > 0 {
-				coverData, coverMIME = extractAPICFromID3(p.id3)
-			}
-			f.Close()
-		}
-	}
-
-	tag := buildID3v24Tag(meta, coverData, coverMIME)
+	tag := buildID3v24TagKeepingPictures(filePath, "RIFF", meta, coverData, coverMIME)
 	return writeID3Chunk(filePath, "RIFF", id3ChunkWAV, true, tag)
 }
 
@@ -863,17 +705,26 @@ func WriteAIFFTags(filePath string, fields map[string]string) error {
 	meta := mergeEditFieldsOntoExisting(existing, fields)
 
 	coverData, coverMIME := loadCoverForTag(fields)
-	if coverData == nil {
-		if f, err := os.Open(filePath); err == nil {
-			if p, perr := streamProbeAIFF(f); perr == nil && len(p.id3) > 0 {
-				coverData, coverMIME = extractAPICFromID3(p.id3)
+	tag := buildID3v24TagKeepingPictures(filePath, "FORM", meta, coverData, coverMIME)
+	return writeID3Chunk(filePath, "FORM", id3ChunkAIFF, false, tag)
+}
+
+// buildID3v24TagKeepingPictures builds the id3 chunk for the WAV/AIFF writers
+// and carries over the existing pictures: all of them when no new cover is
+// supplied, and every one but the front cover otherwise.
+func buildID3v24TagKeepingPictures(filePath, magic string, meta *AudioMetadata, coverData []byte, coverMIME string) []byte {
+	frames := id3FramesFromMetadata(meta, coverData, coverMIME)
+	existing, _ := readID3Chunk(filePath, magic)
+	if len(existing) > 0 {
+		if old, err := parseID3v2FramesForRewrite(existing); err == nil {
+			for _, fr := range old {
+				if t := id3PictureType(fr); t >= 0 && (coverData == nil || t != pictureTypeFrontCover) {
+					frames = append(frames, fr)
+				}
 			}
-			f.Close()
 		}
 	}
-
-	tag := buildID3v24Tag(meta, coverData, coverMIME)
-	return writeID3Chunk(filePath, "FORM", id3ChunkAIFF, false, tag)
+	return serializeID3v24Tag(serializeID3v24Frames(frames), 0)
 }
 
 func scanWAVFile(filePath string, result *LibraryScanResult, displayNameHint string) (*LibraryScanResult, error) {
@@ -887,6 +738,7 @@ func scanWAVFile(filePath string, result *LibraryScanResult, displayNameHint str
 	}
 	result.Bitrate = 0 // lossless PCM
 	result.Format = "wav"
+	applyLibraryScanAuthenticity(filePath, ".wav", result)
 	applyDefaultLibraryMetadata(filePath, displayNameHint, result)
 	return result, nil
 }
@@ -902,6 +754,7 @@ func scanAIFFFile(filePath string, result *LibraryScanResult, displayNameHint st
 	}
 	result.Bitrate = 0 // lossless PCM
 	result.Format = "aiff"
+	applyLibraryScanAuthenticity(filePath, ".aiff", result)
 	applyDefaultLibraryMetadata(filePath, displayNameHint, result)
 	return result, nil
 }